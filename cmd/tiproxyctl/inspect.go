@@ -0,0 +1,95 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/inspect"
+	"github.com/spf13/cobra"
+)
+
+// addTrafficInspectCmd adds the inspect command to the traffic command. Unlike other traffic commands, it reads the
+// traffic files offline instead of requesting TiProxy, so it depends on pkg and can't be put in lib.
+func addTrafficInspectCmd(rootCmd *cobra.Command) {
+	for _, command := range rootCmd.Commands() {
+		if command.Name() == "traffic" {
+			command.AddCommand(getTrafficInspectCmd())
+			return
+		}
+	}
+}
+
+func getTrafficInspectCmd() *cobra.Command {
+	inspectCmd := &cobra.Command{
+		Use:   "inspect [flags]",
+		Short: "print, summarize or export the traffic files offline",
+	}
+	input := inspectCmd.PersistentFlags().String("input", "", "directory for traffic files, or s3://bucket/prefix/<tiproxy-addr>?endpoint=...")
+	keyFile := inspectCmd.PersistentFlags().String("key-file", "", "the key to decrypt the traffic files and to encrypt the exported files")
+	startTime := inspectCmd.PersistentFlags().String("start-time", "", "only inspect the commands since this time, in RFC3339 format")
+	endTime := inspectCmd.PersistentFlags().String("end-time", "", "only inspect the commands before this time, in RFC3339 format")
+	connIDs := inspectCmd.PersistentFlags().UintSlice("conn-id", nil, "only inspect the commands of these connection IDs")
+	summary := inspectCmd.PersistentFlags().Bool("summary", false, "summarize the commands by types, connections and digests instead of printing them")
+	output := inspectCmd.PersistentFlags().String("output", "", "export the commands to this directory instead of printing them")
+	encrypt := inspectCmd.PersistentFlags().String("encrypt-method", "", "the encryption method of the exported files, empty means plaintext")
+	compress := inspectCmd.PersistentFlags().Bool("compress", true, "whether compress the exported files")
+	inspectCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		cfg := inspect.InspectConfig{
+			Input:   *input,
+			KeyFile: *keyFile,
+		}
+		var err error
+		if *startTime != "" {
+			if cfg.StartTime, err = time.Parse(time.RFC3339Nano, *startTime); err != nil {
+				return errors.Wrapf(err, "invalid start-time")
+			}
+		}
+		if *endTime != "" {
+			if cfg.EndTime, err = time.Parse(time.RFC3339Nano, *endTime); err != nil {
+				return errors.Wrapf(err, "invalid end-time")
+			}
+		}
+		for _, connID := range *connIDs {
+			cfg.ConnIDs = append(cfg.ConnIDs, uint64(connID))
+		}
+		logEncoder, _ := cmd.Flags().GetString("log_encoder")
+		logLevel, _ := cmd.Flags().GetString("log_level")
+		lg, _, _, err := logger.BuildLogger(&config.Log{
+			Encoder: logEncoder,
+			LogOnline: config.LogOnline{
+				Level: logLevel,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		inspector, err := inspect.NewInspector(lg.Named("inspect"), cfg)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case *output != "":
+			cmds, err := inspector.Export(inspect.ExportConfig{
+				Output:        *output,
+				EncryptMethod: *encrypt,
+				Compress:      *compress,
+			})
+			if err != nil {
+				return err
+			}
+			cmd.Printf("%d commands exported\n", cmds)
+			return nil
+		case *summary:
+			return inspector.Summarize(cmd.OutOrStdout())
+		default:
+			return inspector.Print(cmd.OutOrStdout())
+		}
+	}
+	return inspectCmd
+}
@@ -17,5 +17,6 @@ func main() {
 	rootCmd := cli.GetRootCmd(nil)
 	rootCmd.Version = fmt.Sprintf("%s, commit %s", versioninfo.TiProxyVersion, versioninfo.TiProxyGitHash)
 	rootCmd.Use = strings.Replace(rootCmd.Use, "tiproxyctl", os.Args[0], 1)
+	addTrafficInspectCmd(rootCmd)
 	cmd.RunRootCommand(rootCmd)
 }
@@ -0,0 +1,74 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	firewallPrefix = "/api/firewall/allowlist"
+)
+
+func GetFirewallCmd(ctx *Context) *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "",
+	}
+
+	// export allowlists
+	{
+		exportCmd := &cobra.Command{
+			Use: "export",
+		}
+		exportCmd.RunE = func(cmd *cobra.Command, args []string) error {
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, firewallPrefix, nil)
+			if err != nil {
+				return err
+			}
+
+			cmd.Println(resp)
+			return nil
+		}
+		rootCmd.AddCommand(exportCmd)
+	}
+
+	// import allowlists
+	{
+		importCmd := &cobra.Command{
+			Use: "import",
+		}
+		input := importCmd.Flags().String("input", "", "specify the input json file for allowlists")
+		replace := importCmd.Flags().Bool("replace", false, "replace the current allowlists instead of merging into them")
+		importCmd.RunE = func(cmd *cobra.Command, args []string) error {
+			b := cmd.InOrStdin()
+			if *input != "" {
+				f, err := os.Open(*input)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				b = f
+			}
+
+			url := firewallPrefix
+			if *replace {
+				url += "?replace=true"
+			}
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodPut, url, b)
+			if err != nil {
+				return err
+			}
+
+			cmd.Println(resp)
+			return nil
+		}
+		rootCmd.AddCommand(importCmd)
+	}
+
+	return rootCmd
+}
@@ -87,5 +87,8 @@ func GetRootCmd(tlsConfig *tls.Config) *cobra.Command {
 	rootCmd.AddCommand(GetConfigCmd(ctx))
 	rootCmd.AddCommand(GetHealthCmd(ctx))
 	rootCmd.AddCommand(GetTrafficCmd(ctx))
+	rootCmd.AddCommand(GetFirewallCmd(ctx))
+	rootCmd.AddCommand(GetResultCacheCmd(ctx))
+	rootCmd.AddCommand(GetStatementsCmd(ctx))
 	return rootCmd
 }
@@ -217,5 +217,91 @@ func GetNamespaceCmd(ctx *Context) *cobra.Command {
 		rootCmd.AddCommand(delNamespace)
 	}
 
+	rootCmd.AddCommand(getMaintenanceCmd(ctx))
 	return rootCmd
 }
+
+func getMaintenanceCmd(ctx *Context) *cobra.Command {
+	maintenanceCmd := &cobra.Command{
+		Use:   "maintenance",
+		Short: "",
+	}
+
+	// get the maintenance mode
+	{
+		getMaintenance := &cobra.Command{
+			Use: "get nsName",
+		}
+		getMaintenance.RunE = func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return cmd.Help()
+			}
+
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, fmt.Sprintf("%s/%s/maintenance", namespacePrefix, args[0]), nil)
+			if err != nil {
+				return err
+			}
+
+			cmd.Println(resp)
+			return nil
+		}
+		maintenanceCmd.AddCommand(getMaintenance)
+	}
+
+	// start the maintenance
+	{
+		setMaintenance := &cobra.Command{
+			Use: "set nsName",
+		}
+		var m config.Maintenance
+		setMaintenance.Flags().StringVar(&m.Mode, "mode", config.MaintenanceModeError, "error: reply new connections with an error; hold: hold new connections until a backend is available")
+		setMaintenance.Flags().StringVar(&m.Sessions, "sessions", config.MaintenanceSessionsKeep, "empty: keep existing sessions; hold: hold their commands; close: close them gracefully")
+		setMaintenance.Flags().Uint16Var(&m.ErrorCode, "error-code", 0, "the MySQL error code sent to the clients")
+		setMaintenance.Flags().StringVar(&m.ErrorMessage, "error-message", "", "the MySQL error message sent to the clients")
+		setMaintenance.Flags().IntVar(&m.HoldTimeout, "hold-timeout", 0, "the maximum seconds to hold connections and commands")
+		setMaintenance.RunE = func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return cmd.Help()
+			}
+			if err := m.Check(); err != nil {
+				return err
+			}
+			b, err := json.Marshal(&m)
+			if err != nil {
+				return err
+			}
+
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodPut, fmt.Sprintf("%s/%s/maintenance", namespacePrefix, args[0]), bytes.NewReader(b))
+			if err != nil {
+				return err
+			}
+
+			cmd.Println(resp)
+			return nil
+		}
+		maintenanceCmd.AddCommand(setMaintenance)
+	}
+
+	// end the maintenance
+	{
+		endMaintenance := &cobra.Command{
+			Use: "end nsName",
+		}
+		endMaintenance.RunE = func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return cmd.Help()
+			}
+
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodPut, fmt.Sprintf("%s/%s/maintenance", namespacePrefix, args[0]), strings.NewReader("{}"))
+			if err != nil {
+				return err
+			}
+
+			cmd.Println(resp)
+			return nil
+		}
+		maintenanceCmd.AddCommand(endMaintenance)
+	}
+
+	return maintenanceCmd
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	resultCachePrefix = "/api/resultcache"
+)
+
+func GetResultCacheCmd(ctx *Context) *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "resultcache",
+		Short: "",
+	}
+
+	// flush result sets
+	{
+		flushCmd := &cobra.Command{
+			Use: "flush",
+		}
+		rule := flushCmd.Flags().String("rule", "", "only flush the result sets cached by the query rule")
+		flushCmd.RunE = func(cmd *cobra.Command, args []string) error {
+			path := resultCachePrefix + "/flush"
+			if *rule != "" {
+				path += "?rule=" + url.QueryEscape(*rule)
+			}
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, path, nil)
+			if err != nil {
+				return err
+			}
+
+			cmd.Println(resp)
+			return nil
+		}
+		rootCmd.AddCommand(flushCmd)
+	}
+
+	return rootCmd
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cli
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	statementsPrefix = "/api/statements"
+)
+
+func GetStatementsCmd(ctx *Context) *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:   "statements",
+		Short: "",
+	}
+
+	// top statements
+	{
+		topCmd := &cobra.Command{
+			Use: "top",
+		}
+		limit := topCmd.Flags().Int("limit", 10, "the max number of statements in each window, 0 means no limit")
+		orderBy := topCmd.Flags().String("order-by", "sum_latency", "sort by sum_latency, avg_latency, max_latency, p99_latency, exec_count, err_count, sum_rows, sum_in_bytes, sum_out_bytes, or migration_count")
+		history := topCmd.Flags().Bool("history", false, "also show the finished windows")
+		topCmd.RunE = func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			query.Set("limit", strconv.Itoa(*limit))
+			query.Set("order-by", *orderBy)
+			query.Set("history", strconv.FormatBool(*history))
+			resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, statementsPrefix+"?"+query.Encode(), nil)
+			if err != nil {
+				return err
+			}
+
+			cmd.Println(resp)
+			return nil
+		}
+		rootCmd.AddCommand(topCmd)
+	}
+
+	return rootCmd
+}
@@ -6,6 +6,7 @@ package cli
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -17,6 +18,7 @@ func GetTrafficCmd(ctx *Context) *cobra.Command {
 	}
 	trafficCmd.AddCommand(GetTrafficCaptureCmd(ctx))
 	trafficCmd.AddCommand(GetTrafficReplayCmd(ctx))
+	trafficCmd.AddCommand(GetTrafficSpeedCmd(ctx))
 	trafficCmd.AddCommand(GetTrafficCancelCmd(ctx))
 	trafficCmd.AddCommand(GetTrafficShowCmd(ctx))
 	return trafficCmd
@@ -27,16 +29,38 @@ func GetTrafficCaptureCmd(ctx *Context) *cobra.Command {
 		Use:   "capture [flags]",
 		Short: "",
 	}
-	output := captureCmd.PersistentFlags().String("output", "", "output directory for traffic files")
+	output := captureCmd.PersistentFlags().String("output", "", "output directory for traffic files, or s3://bucket/prefix?endpoint=...&region=... where each TiProxy writes to a sub-directory named by its address")
 	duration := captureCmd.PersistentFlags().String("duration", "", "the duration of traffic capture")
 	encrypt := captureCmd.PersistentFlags().String("encrypt-method", "", "the encryption method used for encrypting traffic files")
 	compress := captureCmd.PersistentFlags().Bool("compress", true, "whether compress the traffic files")
+	loadData := captureCmd.PersistentFlags().Bool("load-data", false, "whether record LOAD DATA LOCAL INFILE statements with the hash of the uploaded files")
+	recordResult := captureCmd.PersistentFlags().Bool("record-result", false, "whether record the affected rows and the result digests so that replay can compare the results")
+	recordLatency := captureCmd.PersistentFlags().Bool("record-latency", false, "whether record the execution duration of each command so that replay can compare the latencies")
+	cluster := captureCmd.PersistentFlags().Bool("cluster", false, "capture on all TiProxy instances, each of which writes to a sub-directory named by its address")
+	users := captureCmd.PersistentFlags().StringSlice("user", nil, "only capture the connections of these users")
+	dbs := captureCmd.PersistentFlags().StringSlice("db", nil, "only capture the connections whose current databases are these ones when they are captured for the first time")
+	namespaces := captureCmd.PersistentFlags().StringSlice("namespace", nil, "only capture the connections of these namespaces")
+	clientCIDRs := captureCmd.PersistentFlags().StringSlice("client-cidr", nil, "only capture the connections from these client CIDRs, e.g. 10.0.0.0/8")
+	cmdTypes := captureCmd.PersistentFlags().StringSlice("cmd-type", nil, "only capture these command types, e.g. Query,StmtExecute")
+	readOnly := captureCmd.PersistentFlags().Bool("read-only", false, "only capture read-only COM_QUERY statements")
+	sampleRate := captureCmd.PersistentFlags().Float64("sample-rate", 0, "the ratio of captured connections in (0, 1], 0 means capturing all connections")
 	captureCmd.RunE = func(cmd *cobra.Command, args []string) error {
 		reader := GetFormReader(map[string]string{
 			"output":         *output,
 			"duration":       *duration,
 			"encrypt-method": *encrypt,
 			"compress":       strconv.FormatBool(*compress),
+			"load-data":      strconv.FormatBool(*loadData),
+			"record-result":  strconv.FormatBool(*recordResult),
+			"record-latency": strconv.FormatBool(*recordLatency),
+			"cluster":        strconv.FormatBool(*cluster),
+			"user":           strings.Join(*users, ","),
+			"db":             strings.Join(*dbs, ","),
+			"namespace":      strings.Join(*namespaces, ","),
+			"client-cidr":    strings.Join(*clientCIDRs, ","),
+			"cmd-type":       strings.Join(*cmdTypes, ","),
+			"read-only":      strconv.FormatBool(*readOnly),
+			"sample-rate":    strconv.FormatFloat(*sampleRate, 'f', -1, 64),
 		})
 		resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, "/api/traffic/capture", reader)
 		if err != nil {
@@ -54,18 +78,26 @@ func GetTrafficReplayCmd(ctx *Context) *cobra.Command {
 		Use:   "replay [flags]",
 		Short: "",
 	}
-	input := replayCmd.PersistentFlags().String("input", "", "directory for traffic files")
+	input := replayCmd.PersistentFlags().String("input", "", "directory for traffic files, or s3://bucket/prefix/<tiproxy-addr>?endpoint=...; with --cluster, the parent directory or s3://bucket/prefix?endpoint=...")
 	speed := replayCmd.PersistentFlags().Float64("speed", 1, "replay speed")
 	username := replayCmd.PersistentFlags().String("username", "", "the username to connect to TiDB for replay")
 	password := replayCmd.PersistentFlags().String("password", "", "the password to connect to TiDB for replay")
 	readonly := replayCmd.PersistentFlags().Bool("readonly", false, "only replay read-only queries, default is false")
+	rampStep := replayCmd.PersistentFlags().Float64("ramp-step", 0, "increase the speed by this step every ramp-interval to find the capacity of the cluster")
+	rampInterval := replayCmd.PersistentFlags().String("ramp-interval", "", "the duration of each speed in the ramp mode")
+	regressionThreshold := replayCmd.PersistentFlags().Float64("regression-threshold", 0, "report a digest as a latency regression if its replay latency exceeds the captured one by this ratio, 0 means 0.2")
+	cluster := replayCmd.PersistentFlags().Bool("cluster", false, "replay on all TiProxy instances, the input should contain the sub-directories captured by all instances")
 	replayCmd.RunE = func(cmd *cobra.Command, args []string) error {
 		reader := GetFormReader(map[string]string{
-			"input":    *input,
-			"speed":    strconv.FormatFloat(*speed, 'f', -1, 64),
-			"username": *username,
-			"password": *password,
-			"readonly": strconv.FormatBool(*readonly),
+			"input":                *input,
+			"speed":                strconv.FormatFloat(*speed, 'f', -1, 64),
+			"username":             *username,
+			"password":             *password,
+			"readonly":             strconv.FormatBool(*readonly),
+			"ramp-step":            strconv.FormatFloat(*rampStep, 'f', -1, 64),
+			"ramp-interval":        *rampInterval,
+			"cluster":              strconv.FormatBool(*cluster),
+			"regression-threshold": strconv.FormatFloat(*regressionThreshold, 'f', -1, 64),
 		})
 		resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, "/api/traffic/replay", reader)
 		if err != nil {
@@ -78,13 +110,38 @@ func GetTrafficReplayCmd(ctx *Context) *cobra.Command {
 	return replayCmd
 }
 
+func GetTrafficSpeedCmd(ctx *Context) *cobra.Command {
+	speedCmd := &cobra.Command{
+		Use:   "speed [flags]",
+		Short: "",
+	}
+	speed := speedCmd.PersistentFlags().Float64("speed", 1, "the new speed of the running replay")
+	speedCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		reader := GetFormReader(map[string]string{
+			"speed": strconv.FormatFloat(*speed, 'f', -1, 64),
+		})
+		resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, "/api/traffic/replay/speed", reader)
+		if err != nil {
+			return err
+		}
+
+		cmd.Println(resp)
+		return nil
+	}
+	return speedCmd
+}
+
 func GetTrafficCancelCmd(ctx *Context) *cobra.Command {
 	cancelCmd := &cobra.Command{
 		Use:   "cancel",
 		Short: "",
 	}
+	cluster := cancelCmd.PersistentFlags().Bool("cluster", false, "cancel the jobs on all TiProxy instances")
 	cancelCmd.RunE = func(cmd *cobra.Command, args []string) error {
-		resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, "/api/traffic/cancel", nil)
+		reader := GetFormReader(map[string]string{
+			"cluster": strconv.FormatBool(*cluster),
+		})
+		resp, err := doRequest(cmd.Context(), ctx, http.MethodPost, "/api/traffic/cancel", reader)
 		if err != nil {
 			return err
 		}
@@ -100,8 +157,13 @@ func GetTrafficShowCmd(ctx *Context) *cobra.Command {
 		Use:   "show",
 		Short: "",
 	}
+	cluster := showCmd.PersistentFlags().Bool("cluster", false, "show the jobs on all TiProxy instances")
 	showCmd.RunE = func(cmd *cobra.Command, args []string) error {
-		resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, "/api/traffic/show", nil)
+		path := "/api/traffic/show"
+		if *cluster {
+			path += "?cluster=true"
+		}
+		resp, err := doRequest(cmd.Context(), ctx, http.MethodGet, path, nil)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,31 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "github.com/pingcap/tiproxy/lib/util/errors"
+
+// Audit is the sink of the audit log. The audit log is disabled if the filename is empty.
+// Which sessions and statements are recorded is configured in each namespace.
+type Audit struct {
+	LogFile LogFile `yaml:"log-file,omitempty" toml:"log-file,omitempty" json:"log-file,omitempty"`
+}
+
+// NamespaceAudit decides which sessions and statements of the namespace are recorded in the audit log.
+type NamespaceAudit struct {
+	Enable bool `yaml:"enable,omitempty" json:"enable,omitempty" toml:"enable,omitempty"`
+	// Users limits the audited users. Empty means all users.
+	Users []string `yaml:"users,omitempty" json:"users,omitempty" toml:"users,omitempty"`
+	// SampleRate is the ratio of statements to be recorded, which is in (0, 1]. 0 means recording all statements.
+	// Connection and migration events are always recorded.
+	SampleRate float64 `yaml:"sample-rate,omitempty" json:"sample-rate,omitempty" toml:"sample-rate,omitempty"`
+	// LogText records the statement text besides the digest. Sensitive statements are always redacted.
+	LogText bool `yaml:"log-text,omitempty" json:"log-text,omitempty" toml:"log-text,omitempty"`
+}
+
+func (a *NamespaceAudit) Check() error {
+	if a.SampleRate < 0 || a.SampleRate > 1 {
+		return errors.Wrapf(ErrInvalidConfigValue, "audit.sample-rate must be in [0, 1]")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"path"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+)
+
+// ProxyAuth makes TiProxy authenticate the clients with its local user store instead of forwarding the
+// authentication to TiDB. TiProxy then logs into TiDB with the backend credentials mapped from the user.
+type ProxyAuth struct {
+	Enable bool `yaml:"enable,omitempty" toml:"enable,omitempty" json:"enable,omitempty"`
+	// UserFile is a JSON file of the users. If it's empty, the users are read from etcd.
+	UserFile string `yaml:"user-file,omitempty" toml:"user-file,omitempty" json:"user-file,omitempty"`
+}
+
+// ReplayAuth decides how traffic replay logs in as the captured users. The files are on the TiProxy host and are only
+// configured here rather than in the replay API, because they grant the access of any user.
+type ReplayAuth struct {
+	// CredentialFile is a JSON file that maps the captured users to the users and passwords to replay with.
+	CredentialFile string `yaml:"credential-file,omitempty" toml:"credential-file,omitempty" json:"credential-file,omitempty"`
+	// SessionTokenSigningCert and SessionTokenSigningKey are the same as the ones of TiDB so that the connections
+	// whose users are not mapped can log in as the captured users with session tokens.
+	SessionTokenSigningCert string `yaml:"session-token-signing-cert,omitempty" toml:"session-token-signing-cert,omitempty" json:"session-token-signing-cert,omitempty"`
+	SessionTokenSigningKey  string `yaml:"session-token-signing-key,omitempty" toml:"session-token-signing-key,omitempty" json:"session-token-signing-key,omitempty"`
+}
+
+func (ra *ReplayAuth) Check() error {
+	if (ra.SessionTokenSigningCert == "") != (ra.SessionTokenSigningKey == "") {
+		return errors.Wrapf(ErrInvalidConfigValue, "security.replay-auth.session-token-signing-cert and session-token-signing-key should be both set")
+	}
+	return nil
+}
+
+const (
+	// ForwardIdentityNone doesn't forward the certificate identity to TiDB.
+	ForwardIdentityNone = ""
+	// ForwardIdentityProxyProtocol forwards the certificate identity in the SSL TLVs of the PROXY protocol header.
+	// It only takes effect when the PROXY protocol to TiDB is enabled.
+	ForwardIdentityProxyProtocol = "proxy-protocol"
+	// ForwardIdentityConnAttrs forwards the certificate identity in the connection attributes.
+	ForwardIdentityConnAttrs = "conn-attrs"
+)
+
+// CertAuth maps the identities in the verified client certificates to MySQL users and namespaces.
+type CertAuth struct {
+	// Rules are matched in order and the first matched rule takes effect.
+	Rules []CertAuthRule `yaml:"rules,omitempty" toml:"rules,omitempty" json:"rules,omitempty"`
+	// RequireMatch rejects the clients whose certificates match none of the rules, including the clients without certificates.
+	RequireMatch    bool   `yaml:"require-match,omitempty" toml:"require-match,omitempty" json:"require-match,omitempty"`
+	ForwardIdentity string `yaml:"forward-identity,omitempty" toml:"forward-identity,omitempty" json:"forward-identity,omitempty"`
+}
+
+// CertAuthRule matches the subject of a client certificate. The patterns follow path.Match, so '*' doesn't match '/',
+// and empty patterns match anything. SAN matches any DNS name, email address, IP address or URI in the certificate,
+// where '*' matches a single label of DNS names and a single segment of URIs, e.g. "*.example.com" doesn't match "a.b.example.com".
+type CertAuthRule struct {
+	CN  string `yaml:"cn,omitempty" toml:"cn,omitempty" json:"cn,omitempty"`
+	SAN string `yaml:"san,omitempty" toml:"san,omitempty" json:"san,omitempty"`
+	OU  string `yaml:"ou,omitempty" toml:"ou,omitempty" json:"ou,omitempty"`
+	// User is the MySQL user that the certificate maps to. Empty means the handshake user.
+	User string `yaml:"user,omitempty" toml:"user,omitempty" json:"user,omitempty"`
+	// Namespace is the namespace that the connection is routed to. Empty means routing by the user.
+	Namespace string `yaml:"namespace,omitempty" toml:"namespace,omitempty" json:"namespace,omitempty"`
+	// RequireUserMatch rejects the client if the handshake user differs from User.
+	// Otherwise, the handshake user is replaced with User.
+	RequireUserMatch bool `yaml:"require-user-match,omitempty" toml:"require-user-match,omitempty" json:"require-user-match,omitempty"`
+}
+
+func (c *CertAuth) Check() error {
+	switch c.ForwardIdentity {
+	case ForwardIdentityNone, ForwardIdentityProxyProtocol, ForwardIdentityConnAttrs:
+	default:
+		return errors.Wrapf(ErrInvalidConfigValue, "invalid security.cert-auth.forward-identity")
+	}
+	for i, rule := range c.Rules {
+		for _, pattern := range []string{rule.CN, rule.SAN, rule.OU} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Wrapf(ErrInvalidConfigValue, "invalid pattern %s in security.cert-auth.rules[%d]", pattern, i)
+			}
+		}
+		if rule.CN == "" && rule.SAN == "" && rule.OU == "" {
+			return errors.Wrapf(ErrInvalidConfigValue, "security.cert-auth.rules[%d] must set cn, san or ou", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"strings"
+
+	"github.com/pingcap/tiproxy/lib/util/cron"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+)
+
+// AutoCapture starts capturing traffic when a rule is triggered, so that the traffic of an incident is captured before
+// anyone starts a capture manually. It's disabled if there are no rules.
+type AutoCapture struct {
+	// Output is the local directory that stores the triggered captures. Each capture is in a sub-directory.
+	Output string `yaml:"output,omitempty" toml:"output,omitempty" json:"output,omitempty"`
+	// MaxCount is the max number of triggered captures that are kept. The oldest ones are removed.
+	MaxCount int `yaml:"max-count,omitempty" toml:"max-count,omitempty" json:"max-count,omitempty"`
+	// MaxSize is the max disk usage of the triggered captures in MB, including the running one. 0 means unlimited.
+	MaxSize int `yaml:"max-size,omitempty" toml:"max-size,omitempty" json:"max-size,omitempty"`
+	// EncryptMethod is the encryption method of the triggered captures, either `plaintext` or `aes256-ctr`.
+	// The key is read from security.encryption.key-path.
+	EncryptMethod string `yaml:"encrypt-method,omitempty" toml:"encrypt-method,omitempty" json:"encrypt-method,omitempty"`
+	// Cooldown is the minimum interval in minutes between two captures triggered by the same rule.
+	Cooldown int           `yaml:"cooldown,omitempty" toml:"cooldown,omitempty" json:"cooldown,omitempty"`
+	Rules    []CaptureRule `yaml:"rules,omitempty" toml:"rules,omitempty" json:"rules,omitempty"`
+}
+
+// CaptureRule triggers a capture when any of its conditions is met.
+type CaptureRule struct {
+	Name string `yaml:"name,omitempty" toml:"name,omitempty" json:"name,omitempty"`
+	// Duration is the capture duration in minutes.
+	Duration int `yaml:"duration,omitempty" toml:"duration,omitempty" json:"duration,omitempty"`
+	// CPUUsage is the threshold of the max CPU usage of backends in percentage.
+	CPUUsage float64 `yaml:"cpu-usage,omitempty" toml:"cpu-usage,omitempty" json:"cpu-usage,omitempty"`
+	// ErrorRate is the threshold of the ratio of failed queries observed by the proxy in percentage.
+	ErrorRate float64 `yaml:"error-rate,omitempty" toml:"error-rate,omitempty" json:"error-rate,omitempty"`
+	// P99Latency is the threshold of the 99th percentile query latency observed by the proxy in milliseconds.
+	P99Latency int `yaml:"p99-latency,omitempty" toml:"p99-latency,omitempty" json:"p99-latency,omitempty"`
+	// Cron is a 5-field cron expression in the local time zone, e.g. `0 9 * * 1-5`.
+	Cron string `yaml:"cron,omitempty" toml:"cron,omitempty" json:"cron,omitempty"`
+}
+
+func (ac *AutoCapture) Check() error {
+	if len(ac.Rules) == 0 {
+		return nil
+	}
+	if ac.Output == "" {
+		return errors.Wrapf(ErrInvalidConfigValue, "auto-capture.output is required")
+	}
+	// Old captures are removed to limit the disk usage, which is unsupported by object storages.
+	if strings.Contains(ac.Output, "://") {
+		return errors.Wrapf(ErrInvalidConfigValue, "auto-capture.output should be a local directory")
+	}
+	if ac.MaxCount < 0 || ac.MaxSize < 0 || ac.Cooldown < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "invalid auto-capture")
+	}
+	switch strings.ToLower(ac.EncryptMethod) {
+	case "", "plaintext", "aes256-ctr":
+	default:
+		return errors.Wrapf(ErrInvalidConfigValue, "unsupported auto-capture.encrypt-method %s", ac.EncryptMethod)
+	}
+	if ac.MaxCount == 0 {
+		ac.MaxCount = 10
+	}
+	if ac.Cooldown == 0 {
+		ac.Cooldown = 30
+	}
+	names := make(map[string]struct{}, len(ac.Rules))
+	for i := range ac.Rules {
+		rule := &ac.Rules[i]
+		if rule.Name == "" {
+			return errors.Wrapf(ErrInvalidConfigValue, "auto-capture rule name is required")
+		}
+		if _, ok := names[rule.Name]; ok {
+			return errors.Wrapf(ErrInvalidConfigValue, "duplicate auto-capture rule %s", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		if rule.Duration < 0 || rule.CPUUsage < 0 || rule.ErrorRate < 0 || rule.P99Latency < 0 {
+			return errors.Wrapf(ErrInvalidConfigValue, "invalid auto-capture rule %s", rule.Name)
+		}
+		if rule.CPUUsage == 0 && rule.ErrorRate == 0 && rule.P99Latency == 0 && rule.Cron == "" {
+			return errors.Wrapf(ErrInvalidConfigValue, "auto-capture rule %s has no conditions", rule.Name)
+		}
+		if rule.Cron != "" {
+			if _, err := cron.Parse(rule.Cron); err != nil {
+				return errors.Wrapf(ErrInvalidConfigValue, "auto-capture rule %s: %s", rule.Name, err.Error())
+			}
+		}
+		if rule.Duration == 0 {
+			rule.Duration = 10
+		}
+	}
+	return nil
+}
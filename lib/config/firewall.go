@@ -0,0 +1,33 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "github.com/pingcap/tiproxy/lib/util/errors"
+
+const (
+	// FirewallModeOff disables the firewall.
+	FirewallModeOff = ""
+	// FirewallModeLearning records the statement digests of each user into the allowlist.
+	FirewallModeLearning = "learning"
+	// FirewallModeAlerting logs the statements that are not in the allowlist.
+	FirewallModeAlerting = "alerting"
+	// FirewallModeEnforcing rejects the statements that are not in the allowlist.
+	FirewallModeEnforcing = "enforcing"
+)
+
+// Firewall checks the statements of each user against an allowlist of statement digests.
+type Firewall struct {
+	Mode string `yaml:"mode,omitempty" toml:"mode,omitempty" json:"mode,omitempty"`
+	// Users are the users that the firewall applies to. Empty means all users.
+	Users []string `yaml:"users,omitempty" toml:"users,omitempty" json:"users,omitempty"`
+}
+
+func (f *Firewall) Check() error {
+	switch f.Mode {
+	case FirewallModeOff, FirewallModeLearning, FirewallModeAlerting, FirewallModeEnforcing:
+		return nil
+	default:
+		return errors.Wrapf(ErrInvalidConfigValue, "invalid security.firewall.mode")
+	}
+}
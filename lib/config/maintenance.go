@@ -0,0 +1,59 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "github.com/pingcap/tiproxy/lib/util/errors"
+
+const (
+	// MaintenanceModeOff means the namespace is not under maintenance.
+	MaintenanceModeOff = ""
+	// MaintenanceModeError completes the handshake of new connections and then replies with the maintenance error.
+	MaintenanceModeError = "error"
+	// MaintenanceModeHold holds new connections until a backend is available. If no backend is available within the
+	// hold timeout, the connections get the maintenance error.
+	MaintenanceModeHold = "hold"
+)
+
+const (
+	// MaintenanceSessionsKeep doesn't affect existing sessions.
+	MaintenanceSessionsKeep = ""
+	// MaintenanceSessionsHold holds the commands of existing sessions until the maintenance ends. If it doesn't end
+	// within the hold timeout, the command gets the maintenance error.
+	MaintenanceSessionsHold = "hold"
+	// MaintenanceSessionsClose closes existing sessions after their transactions finish.
+	MaintenanceSessionsClose = "close"
+)
+
+// Maintenance is the maintenance mode of a namespace. It's set at runtime through the API and is not persisted.
+type Maintenance struct {
+	Mode     string `yaml:"mode,omitempty" toml:"mode,omitempty" json:"mode,omitempty"`
+	Sessions string `yaml:"sessions,omitempty" toml:"sessions,omitempty" json:"sessions,omitempty"`
+	// ErrorCode and ErrorMessage are the MySQL error sent to the clients. Empty means the default one.
+	ErrorCode    uint16 `yaml:"error-code,omitempty" toml:"error-code,omitempty" json:"error-code,omitempty"`
+	ErrorMessage string `yaml:"error-message,omitempty" toml:"error-message,omitempty" json:"error-message,omitempty"`
+	// HoldTimeout is the maximum seconds to hold connections and commands. 0 means the default value.
+	HoldTimeout int `yaml:"hold-timeout,omitempty" toml:"hold-timeout,omitempty" json:"hold-timeout,omitempty"`
+}
+
+// Enabled returns whether the namespace is under maintenance.
+func (m *Maintenance) Enabled() bool {
+	return m != nil && m.Mode != MaintenanceModeOff
+}
+
+func (m *Maintenance) Check() error {
+	switch m.Mode {
+	case MaintenanceModeOff, MaintenanceModeError, MaintenanceModeHold:
+	default:
+		return errors.Wrapf(ErrInvalidConfigValue, "invalid maintenance mode %s", m.Mode)
+	}
+	switch m.Sessions {
+	case MaintenanceSessionsKeep, MaintenanceSessionsHold, MaintenanceSessionsClose:
+	default:
+		return errors.Wrapf(ErrInvalidConfigValue, "invalid maintenance sessions %s", m.Sessions)
+	}
+	if m.HoldTimeout < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "maintenance hold-timeout must be non-negative")
+	}
+	return nil
+}
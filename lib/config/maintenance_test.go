@@ -0,0 +1,37 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceCheck(t *testing.T) {
+	tests := []struct {
+		m     Maintenance
+		valid bool
+	}{
+		{Maintenance{}, true},
+		{Maintenance{Mode: MaintenanceModeError, Sessions: MaintenanceSessionsClose, ErrorCode: 1105}, true},
+		{Maintenance{Mode: MaintenanceModeHold, Sessions: MaintenanceSessionsHold, HoldTimeout: 30}, true},
+		{Maintenance{Mode: "reject"}, false},
+		{Maintenance{Mode: MaintenanceModeError, Sessions: "kill"}, false},
+		{Maintenance{Mode: MaintenanceModeHold, HoldTimeout: -1}, false},
+	}
+	for i, test := range tests {
+		err := test.m.Check()
+		if test.valid {
+			require.NoError(t, err, "case %d", i)
+		} else {
+			require.ErrorIs(t, err, ErrInvalidConfigValue, "case %d", i)
+		}
+	}
+
+	var m *Maintenance
+	require.False(t, m.Enabled())
+	require.False(t, (&Maintenance{}).Enabled())
+	require.True(t, (&Maintenance{Mode: MaintenanceModeError}).Enabled())
+}
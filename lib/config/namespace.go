@@ -7,22 +7,128 @@ import (
 	"bytes"
 
 	"github.com/BurntSushi/toml"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+)
+
+const (
+	// CompressionNone disables compression on the backend connections.
+	CompressionNone = "none"
+	// CompressionZlib compresses the backend connections with zlib.
+	CompressionZlib = "zlib"
+	// CompressionZstd compresses the backend connections with zstd.
+	CompressionZstd = "zstd"
+)
+
+const (
+	// LoadDataLocalAllow allows LOAD DATA LOCAL INFILE, which is the default.
+	LoadDataLocalAllow = "allow"
+	// LoadDataLocalDeny rejects LOAD DATA LOCAL INFILE with a MySQL error.
+	LoadDataLocalDeny = "deny"
 )
 
 type Namespace struct {
 	Namespace string            `yaml:"namespace" json:"namespace" toml:"namespace"`
 	Frontend  FrontendNamespace `yaml:"frontend" json:"frontend" toml:"frontend"`
 	Backend   BackendNamespace  `yaml:"backend" json:"backend" toml:"backend"`
+	Audit     *NamespaceAudit   `yaml:"audit,omitempty" json:"audit,omitempty" toml:"audit,omitempty"`
 }
 
 type FrontendNamespace struct {
 	User     string    `yaml:"user" json:"user" toml:"user"`
 	Security TLSConfig `yaml:"security" json:"security" toml:"security"`
+	// IdleTimeout closes the client connection after it's idle for so many seconds. 0 means no timeout.
+	IdleTimeout int `yaml:"idle-timeout,omitempty" json:"idle-timeout,omitempty" toml:"idle-timeout,omitempty"`
+	// MaxLifetime closes the client connection after it lives for so many seconds. 0 means no limit.
+	// The connection is closed only when it's not in a transaction.
+	MaxLifetime int `yaml:"max-lifetime,omitempty" json:"max-lifetime,omitempty" toml:"max-lifetime,omitempty"`
+	// MaxExecutionTime kills the statement after it runs for so many seconds. 0 means no limit.
+	// It requires backend.admin-user to kill the statement.
+	MaxExecutionTime int `yaml:"max-execution-time,omitempty" json:"max-execution-time,omitempty" toml:"max-execution-time,omitempty"`
+	// MaxResultRows and MaxResultBytes abort the result set once it exceeds so many rows or bytes. 0 means no limit.
+	// The statement is killed by backend.admin-user, or the backend connection is closed if it's not set.
+	MaxResultRows  int64 `yaml:"max-result-rows,omitempty" json:"max-result-rows,omitempty" toml:"max-result-rows,omitempty"`
+	MaxResultBytes int64 `yaml:"max-result-bytes,omitempty" json:"max-result-bytes,omitempty" toml:"max-result-bytes,omitempty"`
+	// LoadDataLocal is the policy of LOAD DATA LOCAL INFILE: "allow" or "deny". Empty means allow.
+	LoadDataLocal string `yaml:"load-data-local,omitempty" json:"load-data-local,omitempty" toml:"load-data-local,omitempty"`
+	// MaxLoadDataBytes aborts LOAD DATA LOCAL INFILE once the uploaded file exceeds so many bytes. 0 means no limit.
+	// The file is streamed to the backend, so the session is closed to abort the statement once it exceeds the limit.
+	MaxLoadDataBytes int64 `yaml:"max-load-data-bytes,omitempty" json:"max-load-data-bytes,omitempty" toml:"max-load-data-bytes,omitempty"`
 }
 
 type BackendNamespace struct {
 	Instances []string  `yaml:"instances" json:"instances" toml:"instances"`
 	Security  TLSConfig `yaml:"security" json:"security" toml:"security"`
+	// AdminUser and AdminPassword are used by the side connections that kill statements.
+	// AdminPassword is redacted when the namespace is shown to users.
+	// The user needs the privilege to kill the statements of other users.
+	AdminUser     string `yaml:"admin-user,omitempty" json:"admin-user,omitempty" toml:"admin-user,omitempty"`
+	AdminPassword string `yaml:"admin-password,omitempty" json:"admin-password,omitempty" toml:"admin-password,omitempty"`
+	// Compression is the compression algorithm of the backend connections, which can be different from the client's.
+	// Empty means following the client. TiProxy transcodes the packets if the two sides differ.
+	Compression string `yaml:"compression,omitempty" json:"compression,omitempty" toml:"compression,omitempty"`
+	// ZstdLevel is the zstd compression level when Compression is zstd. 0 means the default level.
+	ZstdLevel int `yaml:"zstd-level,omitempty" json:"zstd-level,omitempty" toml:"zstd-level,omitempty"`
+	// Failover reconnects the session to another backend when the backend connection breaks outside a transaction.
+	// The failed statement is retried if it's a plain SELECT or SHOW without side effects, such as INTO, locking reads
+	// and sequence functions. Otherwise, the client receives an error but stays connected.
+	Failover bool `yaml:"failover,omitempty" json:"failover,omitempty" toml:"failover,omitempty"`
+	// CheckpointInterval refreshes the saved session states for failover every so many seconds, which also renews
+	// the session token. The states are also refreshed in the background shortly after statements change them, and
+	// failover is unavailable until then. 0 means no periodic refresh.
+	CheckpointInterval int `yaml:"checkpoint-interval,omitempty" json:"checkpoint-interval,omitempty" toml:"checkpoint-interval,omitempty"`
+}
+
+// RedactedPassword replaces the passwords when the namespace is shown to users.
+const RedactedPassword = "******"
+
+// Redact returns a copy of the namespace whose passwords are replaced, which is shown to users.
+func (cfg *Namespace) Redact() *Namespace {
+	redacted := *cfg
+	if len(redacted.Backend.AdminPassword) > 0 {
+		redacted.Backend.AdminPassword = RedactedPassword
+	}
+	return &redacted
+}
+
+// Check validates the namespace config.
+func (cfg *Namespace) Check() error {
+	switch cfg.Backend.Compression {
+	case "", CompressionNone, CompressionZlib, CompressionZstd:
+	default:
+		return errors.Wrapf(ErrInvalidConfigValue, "invalid backend.compression %q", cfg.Backend.Compression)
+	}
+	if cfg.Backend.ZstdLevel < 0 || cfg.Backend.ZstdLevel > 22 {
+		return errors.Wrapf(ErrInvalidConfigValue, "backend.zstd-level must be in [0, 22]")
+	}
+	if cfg.Backend.CheckpointInterval < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "backend.checkpoint-interval must be non-negative")
+	}
+	switch cfg.Frontend.LoadDataLocal {
+	case "", LoadDataLocalAllow, LoadDataLocalDeny:
+	default:
+		return errors.Wrapf(ErrInvalidConfigValue, "invalid frontend.load-data-local %q", cfg.Frontend.LoadDataLocal)
+	}
+	if cfg.Frontend.MaxLoadDataBytes < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "frontend.max-load-data-bytes must be non-negative")
+	}
+	if cfg.Frontend.IdleTimeout < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "frontend.idle-timeout must be non-negative")
+	}
+	if cfg.Frontend.MaxLifetime < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "frontend.max-lifetime must be non-negative")
+	}
+	if cfg.Frontend.MaxExecutionTime < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "frontend.max-execution-time must be non-negative")
+	}
+	if cfg.Frontend.MaxResultRows < 0 || cfg.Frontend.MaxResultBytes < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "frontend.max-result-rows and frontend.max-result-bytes must be non-negative")
+	}
+	if cfg.Audit != nil {
+		if err := cfg.Audit.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func NewNamespace(data []byte) (*Namespace, error) {
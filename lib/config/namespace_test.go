@@ -19,6 +19,11 @@ var testNamespaceConfig = Namespace{
 			Key:       "t",
 			AutoCerts: true,
 		},
+		IdleTimeout:      600,
+		MaxLifetime:      3600,
+		MaxExecutionTime: 60,
+		MaxResultRows:    10000,
+		MaxResultBytes:   1 << 30,
 	},
 	Backend: BackendNamespace{
 		Instances: []string{"127.0.0.1:4000", "127.0.0.1:4001"},
@@ -28,6 +33,14 @@ var testNamespaceConfig = Namespace{
 			Key:    "t",
 			SkipCA: true,
 		},
+		AdminUser:     "admin",
+		AdminPassword: "123456",
+	},
+	Audit: &NamespaceAudit{
+		Enable:     true,
+		Users:      []string{"u1", "u2"},
+		SampleRate: 0.5,
+		LogText:    true,
 	},
 }
 
@@ -40,3 +53,114 @@ func TestNamespaceConfig(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, data1, data2)
 }
+
+func TestNamespaceCheck(t *testing.T) {
+	tests := []struct {
+		pre func(*testing.T, *Namespace)
+		err error
+	}{
+		{
+			pre: func(t *testing.T, c *Namespace) {},
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Audit = nil
+			},
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Audit.SampleRate = -0.1
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Audit.SampleRate = 1.5
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Backend.Compression = CompressionZstd
+				c.Backend.ZstdLevel = 22
+			},
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Backend.Compression = "gzip"
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Backend.ZstdLevel = 23
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Backend.CheckpointInterval = -1
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Frontend.LoadDataLocal = LoadDataLocalDeny
+				c.Frontend.MaxLoadDataBytes = 1 << 20
+			},
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Frontend.LoadDataLocal = "disable"
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Frontend.MaxLoadDataBytes = -1
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Frontend.IdleTimeout = -1
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Frontend.MaxLifetime = -1
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Frontend.MaxExecutionTime = -1
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Frontend.MaxResultRows = -1
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Namespace) {
+				c.Frontend.MaxResultBytes = -1
+			},
+			err: ErrInvalidConfigValue,
+		},
+	}
+	for i, tc := range tests {
+		cfg := testNamespaceConfig
+		audit := *cfg.Audit
+		cfg.Audit = &audit
+		tc.pre(t, &cfg)
+		if tc.err != nil {
+			require.ErrorIs(t, cfg.Check(), tc.err, "case %d", i)
+		} else {
+			require.NoError(t, cfg.Check(), "case %d", i)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -31,6 +32,11 @@ type Config struct {
 	Balance  Balance           `yaml:"balance,omitempty" toml:"balance,omitempty" json:"balance,omitempty"`
 	Labels   map[string]string `yaml:"labels,omitempty" toml:"labels,omitempty" json:"labels,omitempty"`
 	HA       HA                `yaml:"ha,omitempty" toml:"ha,omitempty" json:"ha,omitempty"`
+	// QueryRules are matched in order and only the first matched rule takes effect.
+	QueryRules  []QueryRule `yaml:"query-rules,omitempty" toml:"query-rules,omitempty" json:"query-rules,omitempty"`
+	Audit       Audit       `yaml:"audit,omitempty" toml:"audit,omitempty" json:"audit,omitempty"`
+	StmtSummary StmtSummary `yaml:"stmt-summary,omitempty" toml:"stmt-summary,omitempty" json:"stmt-summary,omitempty"`
+	AutoCapture AutoCapture `yaml:"auto-capture,omitempty" toml:"auto-capture,omitempty" json:"auto-capture,omitempty"`
 }
 
 type KeepAlive struct {
@@ -70,6 +76,27 @@ type ProxyServer struct {
 type API struct {
 	Addr          string `yaml:"addr,omitempty" toml:"addr,omitempty" json:"addr,omitempty"`
 	ProxyProtocol string `yaml:"proxy-protocol,omitempty" toml:"proxy-protocol,omitempty" json:"proxy-protocol,omitempty"`
+	// MySQLAddr is the address of the admin port that accepts MySQL clients. Empty means disabled.
+	// It requires TLS if security.server-tls is set.
+	MySQLAddr string `yaml:"mysql-addr,omitempty" toml:"mysql-addr,omitempty" json:"mysql-addr,omitempty"`
+	// MySQLUser and MySQLAuthString are the credentials of the admin port.
+	// MySQLAuthString is the mysql_native_password hash, which is the same as mysql.user.authentication_string.
+	MySQLUser       string `yaml:"mysql-user,omitempty" toml:"mysql-user,omitempty" json:"mysql-user,omitempty"`
+	MySQLAuthString string `yaml:"mysql-auth-string,omitempty" toml:"mysql-auth-string,omitempty" json:"mysql-auth-string,omitempty"`
+}
+
+func (a *API) Check() error {
+	if len(a.MySQLAddr) == 0 {
+		return nil
+	}
+	if len(a.MySQLUser) == 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "api.mysql-user must be set if api.mysql-addr is set")
+	}
+	// The admin port can change the config, so an empty password is not allowed.
+	if len(a.MySQLAuthString) != 41 || a.MySQLAuthString[0] != '*' {
+		return errors.Wrapf(ErrInvalidConfigValue, "api.mysql-auth-string must be a mysql_native_password hash")
+	}
+	return nil
 }
 
 type Advance struct {
@@ -136,6 +163,7 @@ func NewConfig() *Config {
 	cfg.Security.ClusterTLS.MinTLSVersion = "1.2"
 
 	cfg.Balance = DefaultBalance()
+	cfg.StmtSummary = DefaultStmtSummary()
 
 	return &cfg
 }
@@ -143,6 +171,9 @@ func NewConfig() *Config {
 func (cfg *Config) Clone() *Config {
 	newCfg := *cfg
 	newCfg.Labels = maps.Clone(cfg.Labels)
+	newCfg.QueryRules = slices.Clone(cfg.QueryRules)
+	newCfg.Security.Firewall.Users = slices.Clone(cfg.Security.Firewall.Users)
+	newCfg.AutoCapture.Rules = slices.Clone(cfg.AutoCapture.Rules)
 	return &newCfg
 }
 
@@ -166,9 +197,36 @@ func (cfg *Config) Check() error {
 		return errors.Wrapf(ErrInvalidConfigValue, "conn-buffer-size must be between 1K and 16M")
 	}
 
+	if err := cfg.API.Check(); err != nil {
+		return err
+	}
 	if err := cfg.Balance.Check(); err != nil {
 		return err
 	}
+	if err := cfg.Security.Firewall.Check(); err != nil {
+		return err
+	}
+	if err := cfg.Security.CertAuth.Check(); err != nil {
+		return err
+	}
+	if err := cfg.Security.ReplayAuth.Check(); err != nil {
+		return err
+	}
+	if err := cfg.StmtSummary.Check(); err != nil {
+		return err
+	}
+	if err := cfg.AutoCapture.Check(); err != nil {
+		return err
+	}
+	if len(cfg.AutoCapture.Rules) > 0 && strings.EqualFold(cfg.AutoCapture.EncryptMethod, "aes256-ctr") && cfg.Security.Encryption.KeyPath == "" {
+		return errors.Wrapf(ErrInvalidConfigValue, "security.encryption.key-path is required to encrypt auto-captures")
+	}
+
+	for i := range cfg.QueryRules {
+		if err := cfg.QueryRules[i].Check(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -31,7 +31,10 @@ var testProxyConfig = Config{
 		},
 	},
 	API: API{
-		Addr: "0.0.0.0:3080",
+		Addr:            "0.0.0.0:3080",
+		MySQLAddr:       "0.0.0.0:3081",
+		MySQLUser:       "admin",
+		MySQLAuthString: "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9",
 	},
 	Log: Log{
 		Encoder: "tidb",
@@ -72,8 +75,77 @@ var testProxyConfig = Config{
 			Cert:               "b",
 			Key:                "c",
 		},
+		Firewall: Firewall{
+			Mode:  FirewallModeLearning,
+			Users: []string{"app"},
+		},
+		ProxyAuth: ProxyAuth{
+			Enable:   true,
+			UserFile: "users.json",
+		},
+		CertAuth: CertAuth{
+			Rules: []CertAuthRule{
+				{CN: "app-*", OU: "db", User: "app", Namespace: "ns1", RequireUserMatch: true},
+			},
+			RequireMatch:    true,
+			ForwardIdentity: ForwardIdentityConnAttrs,
+		},
+		ReplayAuth: ReplayAuth{
+			CredentialFile:          "credentials.json",
+			SessionTokenSigningCert: "cert.pem",
+			SessionTokenSigningKey:  "key.pem",
+		},
 		RequireBackendTLS: true,
 	},
+	QueryRules: []QueryRule{
+		{
+			Name:     "reject_delete",
+			Users:    []string{"app"},
+			Keywords: []string{"DELETE"},
+			Action:   RuleActionReject,
+			ErrCode:  1105,
+			ErrMsg:   "delete is not allowed",
+		},
+		{
+			Name:          "cache_dashboard",
+			Keywords:      []string{"SELECT"},
+			Action:        RuleActionCache,
+			CacheTTL:      10,
+			CacheMaxBytes: 1 << 20,
+		},
+	},
+	Audit: Audit{
+		LogFile: LogFile{
+			Filename:   "audit.log",
+			MaxSize:    100,
+			MaxBackups: 10,
+		},
+	},
+	StmtSummary: StmtSummary{
+		Enable:          true,
+		RefreshInterval: 600,
+		HistorySize:     6,
+		MaxStmtCount:    100,
+	},
+	AutoCapture: AutoCapture{
+		Output:   "/tmp/traffic",
+		MaxCount: 5,
+		MaxSize:  1024,
+		Cooldown: 60,
+		Rules: []CaptureRule{
+			{
+				Name:       "slow",
+				Duration:   5,
+				CPUUsage:   80,
+				ErrorRate:  5,
+				P99Latency: 500,
+			},
+			{
+				Name: "daily",
+				Cron: "0 9 * * 1-5",
+			},
+		},
+	},
 }
 
 func TestProxyConfig(t *testing.T) {
@@ -115,6 +187,149 @@ func TestProxyCheck(t *testing.T) {
 			},
 			err: ErrInvalidConfigValue,
 		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.API.MySQLAddr = "0.0.0.0:3081"
+				c.API.MySQLUser = ""
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.API.MySQLAddr = "0.0.0.0:3081"
+				c.API.MySQLUser = "admin"
+				c.API.MySQLAuthString = ""
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Security.Firewall.Mode = "on"
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Security.CertAuth.ForwardIdentity = "header"
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Security.CertAuth.Rules = []CertAuthRule{{User: "app"}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Security.CertAuth.Rules = []CertAuthRule{{CN: "[app"}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.QueryRules = []QueryRule{{Name: "r", Keywords: []string{"SELECT"}, Action: "drop"}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.QueryRules = []QueryRule{{Name: "r", Regex: "(", Action: RuleActionReject}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.QueryRules = []QueryRule{{Name: "r", Action: RuleActionReject}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.QueryRules = []QueryRule{{Name: "r", Regex: "^SELECT", Action: RuleActionRewrite}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.QueryRules = []QueryRule{{Name: "r", Digest: "abc", Action: RuleActionDelay}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.QueryRules = []QueryRule{{Name: "r", Digest: "abc", Action: RuleActionCache, CacheTTL: 10}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.StmtSummary.HistorySize = -1
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.StmtSummary = StmtSummary{Enable: true}
+			},
+			post: func(t *testing.T, c *Config) {
+				require.Equal(t, DefaultStmtSummary().RefreshInterval, c.StmtSummary.RefreshInterval)
+				require.Equal(t, DefaultStmtSummary().MaxStmtCount, c.StmtSummary.MaxStmtCount)
+			},
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.AutoCapture = AutoCapture{Output: "s3://bucket/prefix", Rules: []CaptureRule{{Name: "r", CPUUsage: 80}}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.AutoCapture = AutoCapture{Output: "/tmp/traffic", Rules: []CaptureRule{{Name: "r"}}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.AutoCapture = AutoCapture{Output: "/tmp/traffic", Rules: []CaptureRule{{Name: "r", Cron: "* * *"}}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.AutoCapture = AutoCapture{Output: "/tmp/traffic", Rules: []CaptureRule{{Name: "r", CPUUsage: 80}, {Name: "r", ErrorRate: 1}}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.AutoCapture = AutoCapture{Output: "/tmp/traffic", EncryptMethod: "aes", Rules: []CaptureRule{{Name: "r", CPUUsage: 80}}}
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.AutoCapture = AutoCapture{Output: "/tmp/traffic", EncryptMethod: "aes256-ctr", Rules: []CaptureRule{{Name: "r", CPUUsage: 80}}}
+				c.Security.Encryption.KeyPath = ""
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.Security.ReplayAuth.SessionTokenSigningKey = ""
+			},
+			err: ErrInvalidConfigValue,
+		},
+		{
+			pre: func(t *testing.T, c *Config) {
+				c.AutoCapture = AutoCapture{Output: "/tmp/traffic", Rules: []CaptureRule{{Name: "r", CPUUsage: 80}}}
+			},
+			post: func(t *testing.T, c *Config) {
+				require.Equal(t, 10, c.AutoCapture.MaxCount)
+				require.Equal(t, 30, c.AutoCapture.Cooldown)
+				require.Equal(t, 10, c.AutoCapture.Rules[0].Duration)
+			},
+		},
 	}
 	for _, tc := range testcases {
 		cfg := testProxyConfig
@@ -0,0 +1,82 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"regexp"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+)
+
+const (
+	// RuleActionReject rejects the statement and returns an error to the client.
+	RuleActionReject = "reject"
+	// RuleActionRewrite rewrites the statement before sending it to the backend.
+	RuleActionRewrite = "rewrite"
+	// RuleActionDelay delays the statement for a while before sending it to the backend.
+	RuleActionDelay = "delay"
+	// RuleActionCache caches the result sets of deterministic reads and serves them without hitting the backend.
+	RuleActionCache = "cache"
+)
+
+// QueryRule matches COM_QUERY and COM_STMT_PREPARE statements and applies an action on them.
+// All the specified conditions must be satisfied for a statement to match the rule.
+type QueryRule struct {
+	Name string `yaml:"name,omitempty" toml:"name,omitempty" json:"name,omitempty"`
+	// Namespaces and Users limit the scope of the rule. Empty means all.
+	Namespaces []string `yaml:"namespaces,omitempty" toml:"namespaces,omitempty" json:"namespaces,omitempty"`
+	Users      []string `yaml:"users,omitempty" toml:"users,omitempty" json:"users,omitempty"`
+	// Digest is the SQL digest of the normalized statement.
+	Digest string `yaml:"digest,omitempty" toml:"digest,omitempty" json:"digest,omitempty"`
+	// Regex is matched against the original statement text.
+	Regex string `yaml:"regex,omitempty" toml:"regex,omitempty" json:"regex,omitempty"`
+	// Keywords are the leading keywords of the statement, such as ["DELETE", "FROM"]. Comments are ignored.
+	Keywords []string `yaml:"keywords,omitempty" toml:"keywords,omitempty" json:"keywords,omitempty"`
+	Action   string   `yaml:"action,omitempty" toml:"action,omitempty" json:"action,omitempty"`
+	// ErrCode and ErrMsg are returned to the client for the reject action.
+	ErrCode uint16 `yaml:"err-code,omitempty" toml:"err-code,omitempty" json:"err-code,omitempty"`
+	ErrMsg  string `yaml:"err-msg,omitempty" toml:"err-msg,omitempty" json:"err-msg,omitempty"`
+	// Rewrite is the new statement for the rewrite action. If Regex is set, it's the replacement of the regex,
+	// so `$1` can be used to reference the submatches. Otherwise, it replaces the whole statement.
+	Rewrite string `yaml:"rewrite,omitempty" toml:"rewrite,omitempty" json:"rewrite,omitempty"`
+	// Delay is the delay in milliseconds for the delay action.
+	Delay int `yaml:"delay,omitempty" toml:"delay,omitempty" json:"delay,omitempty"`
+	// CacheTTL is the time to live in seconds of the cached result sets for the cache action.
+	CacheTTL int `yaml:"cache-ttl,omitempty" toml:"cache-ttl,omitempty" json:"cache-ttl,omitempty"`
+	// CacheMaxBytes is the max total size of the result sets cached by the rule for the cache action.
+	// The oldest result sets are evicted once it exceeds.
+	CacheMaxBytes int64 `yaml:"cache-max-bytes,omitempty" toml:"cache-max-bytes,omitempty" json:"cache-max-bytes,omitempty"`
+}
+
+func (r *QueryRule) Check() error {
+	if len(r.Name) == 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "query rule name is empty")
+	}
+	if len(r.Digest) == 0 && len(r.Regex) == 0 && len(r.Keywords) == 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "query rule %s has no match condition", r.Name)
+	}
+	if len(r.Regex) > 0 {
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return errors.Wrapf(ErrInvalidConfigValue, "query rule %s has invalid regex: %s", r.Name, err.Error())
+		}
+	}
+	switch r.Action {
+	case RuleActionReject:
+	case RuleActionRewrite:
+		if len(r.Rewrite) == 0 {
+			return errors.Wrapf(ErrInvalidConfigValue, "query rule %s has no rewrite statement", r.Name)
+		}
+	case RuleActionDelay:
+		if r.Delay <= 0 {
+			return errors.Wrapf(ErrInvalidConfigValue, "query rule %s must have a positive delay", r.Name)
+		}
+	case RuleActionCache:
+		if r.CacheTTL <= 0 || r.CacheMaxBytes <= 0 {
+			return errors.Wrapf(ErrInvalidConfigValue, "query rule %s must have a positive cache-ttl and cache-max-bytes", r.Name)
+		}
+	default:
+		return errors.Wrapf(ErrInvalidConfigValue, "query rule %s has invalid action %s", r.Name, r.Action)
+	}
+	return nil
+}
@@ -28,6 +28,10 @@ type Security struct {
 	ClusterTLS        TLSConfig  `yaml:"cluster-tls,omitempty" toml:"cluster-tls,omitempty" json:"cluster-tls,omitempty"`
 	SQLTLS            TLSConfig  `yaml:"sql-tls,omitempty" toml:"sql-tls,omitempty" json:"sql-tls,omitempty"`
 	Encryption        Encryption `yaml:"encryption,omitempty" toml:"encryption,omitempty" json:"encryption,omitempty"`
+	Firewall          Firewall   `yaml:"firewall,omitempty" toml:"firewall,omitempty" json:"firewall,omitempty"`
+	ProxyAuth         ProxyAuth  `yaml:"proxy-auth,omitempty" toml:"proxy-auth,omitempty" json:"proxy-auth,omitempty"`
+	CertAuth          CertAuth   `yaml:"cert-auth,omitempty" toml:"cert-auth,omitempty" json:"cert-auth,omitempty"`
+	ReplayAuth        ReplayAuth `yaml:"replay-auth,omitempty" toml:"replay-auth,omitempty" json:"replay-auth,omitempty"`
 	RequireBackendTLS bool       `yaml:"require-backend-tls,omitempty" toml:"require-backend-tls,omitempty" json:"require-backend-tls,omitempty"`
 }
 
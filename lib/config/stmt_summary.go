@@ -0,0 +1,41 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import "github.com/pingcap/tiproxy/lib/util/errors"
+
+// StmtSummary aggregates the statistics of statements by digest, user, namespace, and backend in each time window.
+type StmtSummary struct {
+	Enable bool `yaml:"enable,omitempty" toml:"enable,omitempty" json:"enable,omitempty"`
+	// RefreshInterval is the length of each time window in seconds.
+	RefreshInterval int `yaml:"refresh-interval,omitempty" toml:"refresh-interval,omitempty" json:"refresh-interval,omitempty"`
+	// HistorySize is the number of finished windows that are kept in memory.
+	HistorySize int `yaml:"history-size,omitempty" toml:"history-size,omitempty" json:"history-size,omitempty"`
+	// MaxStmtCount limits the statements in each window. The exceeded statements are aggregated into one entry.
+	MaxStmtCount int `yaml:"max-stmt-count,omitempty" toml:"max-stmt-count,omitempty" json:"max-stmt-count,omitempty"`
+}
+
+func (s *StmtSummary) Check() error {
+	if s.RefreshInterval < 0 || s.HistorySize < 0 || s.MaxStmtCount < 0 {
+		return errors.Wrapf(ErrInvalidConfigValue, "invalid stmt-summary")
+	}
+	if s.RefreshInterval == 0 {
+		s.RefreshInterval = 1800
+	}
+	if s.HistorySize == 0 {
+		s.HistorySize = 24
+	}
+	if s.MaxStmtCount == 0 {
+		s.MaxStmtCount = 3000
+	}
+	return nil
+}
+
+func DefaultStmtSummary() StmtSummary {
+	return StmtSummary{
+		RefreshInterval: 1800,
+		HistorySize:     24,
+		MaxStmtCount:    3000,
+	}
+}
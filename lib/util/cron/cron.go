@@ -0,0 +1,117 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+)
+
+type fieldRange struct {
+	name string
+	min  int
+	max  int
+}
+
+// The day of week can be 0 or 7 for Sunday.
+var fieldRanges = [5]fieldRange{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// Schedule is a parsed cron expression with 5 fields: minute, hour, day of month, month and day of week.
+// Each field supports `*`, numbers, ranges like `1-5`, lists like `1,3,5` and steps like `*/15`.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// If both the day of month and day of week are restricted, the time matches when either matches.
+	domStar, dowStar bool
+}
+
+// Parse parses a 5-field cron expression.
+func Parse(expr string) (*Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fieldRanges) {
+		return nil, errors.Errorf("cron expression `%s` should have %d fields", expr, len(fieldRanges))
+	}
+	var bits [len(fieldRanges)]uint64
+	for i, part := range parts {
+		b, err := parseField(part, fieldRanges[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cron expression `%s`", expr)
+		}
+		bits[i] = b
+	}
+	if bits[4]&(1<<7) > 0 {
+		bits[4] |= 1
+	}
+	return &Schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}, nil
+}
+
+func parseField(field string, fr fieldRange) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if idx := strings.IndexByte(item, '/'); idx >= 0 {
+			rng = item[:idx]
+			var err error
+			if step, err = strconv.Atoi(item[idx+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step `%s` in %s", item, fr.name)
+			}
+		}
+		lo, hi := fr.min, fr.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, errors.Errorf("invalid range `%s` in %s", item, fr.name)
+			}
+		default:
+			var err error
+			if lo, err = strconv.Atoi(rng); err != nil {
+				return 0, errors.Errorf("invalid value `%s` in %s", item, fr.name)
+			}
+			// `5/10` means starting from 5 every 10.
+			if step == 1 {
+				hi = lo
+			}
+		}
+		if lo < fr.min || hi > fr.max || lo > hi {
+			return 0, errors.Errorf("`%s` is out of range [%d, %d] in %s", item, fr.min, fr.max, fr.name)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Match returns whether the minute of the time matches the schedule.
+func (s *Schedule) Match(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	domMatch, dowMatch := s.dom&(1<<t.Day()) > 0, s.dow&(1<<int(t.Weekday())) > 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
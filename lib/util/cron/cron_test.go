@@ -0,0 +1,64 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+	} {
+		_, err := Parse(expr)
+		require.Error(t, err, expr)
+	}
+}
+
+func TestMatchCron(t *testing.T) {
+	// 2024-01-01 is Monday.
+	monday := time.Date(2024, 1, 1, 9, 30, 0, 0, time.Local)
+	sunday := time.Date(2024, 1, 7, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		expr    string
+		time    time.Time
+		matched bool
+	}{
+		{"* * * * *", monday, true},
+		{"30 9 * * *", monday, true},
+		{"31 9 * * *", monday, false},
+		{"*/15 * * * *", monday, true},
+		{"*/20 * * * *", monday, false},
+		{"10/20 * * * *", monday, true},
+		{"0,30 8-10 * * *", monday, true},
+		{"30 9 * * 1-5", monday, true},
+		{"30 9 * * 0,6", monday, false},
+		{"0 0 * * 7", sunday, true},
+		{"0 0 * * 0", sunday, true},
+		{"30 9 1 * *", monday, true},
+		{"30 9 2 * *", monday, false},
+		// Either the day of month or the day of week matches.
+		{"30 9 2 * 1", monday, true},
+		{"30 9 1 2 *", monday, false},
+	}
+	for _, test := range tests {
+		schedule, err := Parse(test.expr)
+		require.NoError(t, err, test.expr)
+		require.Equal(t, test.matched, schedule.Match(test.time), test.expr)
+	}
+}
@@ -79,6 +79,9 @@ func (e *ConfigManager) SetNamespace(ctx context.Context, ns string, nsc *config
 	if ns == "" || nsc.Namespace == "" {
 		return errors.New("namespace name can not be empty string")
 	}
+	if err := nsc.Check(); err != nil {
+		return err
+	}
 	r, err := json.Marshal(nsc)
 	if err != nil {
 		return err
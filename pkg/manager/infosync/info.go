@@ -243,23 +243,33 @@ func (is *InfoSyncer) removeTopology(ctx context.Context) error {
 }
 
 func (is *InfoSyncer) GetTiDBTopology(ctx context.Context) (map[string]*TiDBTopologyInfo, error) {
+	return getTopology[TiDBTopologyInfo](ctx, is, tidbTopologyInformationPath)
+}
+
+// GetTiProxyTopology returns the alive TiProxy instances, including this one. The key is the SQL address.
+func (is *InfoSyncer) GetTiProxyTopology(ctx context.Context) (map[string]*TopologyInfo, error) {
+	return getTopology[TopologyInfo](ctx, is, tiproxyTopologyPath)
+}
+
+// getTopology reads `{prefix}/{addr}/info` and `{prefix}/{addr}/ttl` and returns the infos that have TTL.
+func getTopology[T any](ctx context.Context, is *InfoSyncer, prefix string) (map[string]*T, error) {
 	// etcdCli.Get will retry infinitely internally.
-	res, err := is.etcdCli.Get(ctx, tidbTopologyInformationPath, clientv3.WithPrefix())
+	res, err := is.etcdCli.Get(ctx, prefix+"/", clientv3.WithPrefix())
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	infos := make(map[string]*TiDBTopologyInfo, len(res.Kvs)/2)
+	infos := make(map[string]*T, len(res.Kvs)/2)
 	ttls := make(map[string]struct{}, len(res.Kvs)/2)
 	for _, kv := range res.Kvs {
 		key := hack.String(kv.Key)
 		switch {
 		case strings.HasSuffix(key, ttlSuffix):
-			addr := key[len(tidbTopologyInformationPath)+1 : len(key)-len(ttlSuffix)-1]
+			addr := key[len(prefix)+1 : len(key)-len(ttlSuffix)-1]
 			ttls[addr] = struct{}{}
 		case strings.HasSuffix(key, infoSuffix):
-			var topology *TiDBTopologyInfo
-			addr := key[len(tidbTopologyInformationPath)+1 : len(key)-len(infoSuffix)-1]
+			var topology *T
+			addr := key[len(prefix)+1 : len(key)-len(infoSuffix)-1]
 			if err = json.Unmarshal(kv.Value, &topology); err != nil {
 				is.lg.Error("unmarshal topology info failed", zap.String("key", key),
 					zap.String("value", hack.String(kv.Value)), zap.Error(err))
@@ -270,7 +280,7 @@ func (is *InfoSyncer) GetTiDBTopology(ctx context.Context) (map[string]*TiDBTopo
 	}
 
 	for addr := range infos {
-		// If ttl is empty, maybe the backend is down.
+		// If ttl is empty, maybe the instance is down.
 		if _, ok := ttls[addr]; !ok {
 			delete(infos, addr)
 		}
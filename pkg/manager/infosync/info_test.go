@@ -170,6 +170,39 @@ func TestFetchTiDBTopology(t *testing.T) {
 	}
 }
 
+func TestFetchTiProxyTopology(t *testing.T) {
+	ts := newEtcdTestSuite(t)
+	t.Cleanup(ts.close)
+
+	// The info syncer registers itself.
+	var self string
+	require.Eventually(t, func() bool {
+		info, err := ts.is.GetTiProxyTopology(context.Background())
+		require.NoError(t, err)
+		for addr := range info {
+			self = addr
+		}
+		return len(info) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Another TiProxy without TTL is not returned.
+	data, err := json.Marshal(&TopologyInfo{IP: "1.1.1.1", Port: "6000", StatusPort: "3080"})
+	require.NoError(t, err)
+	_, err = ts.kv.Put(context.Background(), path.Join(tiproxyTopologyPath, "1.1.1.1:6000", infoSuffix), string(data))
+	require.NoError(t, err)
+	info, err := ts.is.GetTiProxyTopology(context.Background())
+	require.NoError(t, err)
+	require.Len(t, info, 1)
+	require.Contains(t, info, self)
+
+	_, err = ts.kv.Put(context.Background(), path.Join(tiproxyTopologyPath, "1.1.1.1:6000", ttlSuffix), "123456789")
+	require.NoError(t, err)
+	info, err = ts.is.GetTiProxyTopology(context.Background())
+	require.NoError(t, err)
+	require.Len(t, info, 2)
+	require.Equal(t, "3080", info["1.1.1.1:6000"].StatusPort)
+}
+
 func TestGetTopology(t *testing.T) {
 	ts := newEtcdTestSuite(t)
 	t.Cleanup(ts.close)
@@ -0,0 +1,45 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"sync"
+
+	"github.com/pingcap/tiproxy/lib/config"
+)
+
+// MaintenanceState is the maintenance mode of a namespace. It's kept by the manager so that the connections routed
+// by previous versions of the namespace also see the latest mode.
+type MaintenanceState struct {
+	sync.Mutex
+	cfg *config.Maintenance
+	// changed is closed and replaced once the mode changes.
+	changed chan struct{}
+}
+
+func NewMaintenanceState() *MaintenanceState {
+	return &MaintenanceState{
+		changed: make(chan struct{}),
+	}
+}
+
+// Get returns the current maintenance mode and a channel that is closed when the mode changes.
+// The mode is nil if the namespace is not under maintenance. The caller should not modify it.
+func (s *MaintenanceState) Get() (*config.Maintenance, <-chan struct{}) {
+	s.Lock()
+	defer s.Unlock()
+	return s.cfg, s.changed
+}
+
+// Set updates the maintenance mode. A nil or disabled mode ends the maintenance.
+func (s *MaintenanceState) Set(cfg *config.Maintenance) {
+	if !cfg.Enabled() {
+		cfg = nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.cfg = cfg
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
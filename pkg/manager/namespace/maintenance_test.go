@@ -0,0 +1,63 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package namespace
+
+import (
+	"testing"
+
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceState(t *testing.T) {
+	state := NewMaintenanceState()
+	cfg, changed := state.Get()
+	require.Nil(t, cfg)
+
+	m := &config.Maintenance{Mode: config.MaintenanceModeHold}
+	state.Set(m)
+	<-changed
+	cfg, changed = state.Get()
+	require.Equal(t, m, cfg)
+
+	// A disabled mode ends the maintenance.
+	state.Set(&config.Maintenance{Sessions: config.MaintenanceSessionsClose})
+	<-changed
+	cfg, _ = state.Get()
+	require.Nil(t, cfg)
+}
+
+func TestMaintenanceKeptAfterRebuild(t *testing.T) {
+	nsMgr := NewNamespaceManager()
+	_, ok := nsMgr.Maintenance("test")
+	require.False(t, ok)
+
+	state := nsMgr.maintenanceState("test")
+	nsMgr.nsm = map[string]*Namespace{
+		"test": {maintenance: state},
+	}
+	got, ok := nsMgr.Maintenance("test")
+	require.True(t, ok)
+	require.Same(t, state, got)
+	// The rebuilt namespace shares the same state.
+	require.Same(t, state, nsMgr.maintenanceState("test"))
+}
+
+func TestMaintenanceRemovedWithNamespace(t *testing.T) {
+	nsMgr := NewNamespaceManager()
+	state := nsMgr.maintenanceState("test")
+	state.Set(&config.Maintenance{Mode: config.MaintenanceModeHold})
+	_, changed := state.Get()
+	nsMgr.nsm = map[string]*Namespace{
+		"test": {maintenance: state},
+	}
+	require.NoError(t, nsMgr.CommitNamespaces([]*config.Namespace{{Namespace: "test"}}, []bool{true}))
+	_, ok := nsMgr.Maintenance("test")
+	require.False(t, ok)
+	// The held connections are released.
+	<-changed
+	cfg, _ := state.Get()
+	require.Nil(t, cfg)
+	require.NotSame(t, state, nsMgr.maintenanceState("test"))
+}
@@ -29,6 +29,8 @@ type NamespaceManager interface {
 	CommitNamespaces(nss []*config.Namespace, nssDelete []bool) error
 	GetNamespace(nm string) (*Namespace, bool)
 	GetNamespaceByUser(user string) (*Namespace, bool)
+	// Maintenance returns the maintenance state of the namespace.
+	Maintenance(nm string) (*MaintenanceState, bool)
 	RedirectConnections() []error
 	Ready() bool
 	Close() error
@@ -37,6 +39,7 @@ type NamespaceManager interface {
 type namespaceManager struct {
 	sync.RWMutex
 	nsm           map[string]*Namespace
+	maintenance   map[string]*MaintenanceState
 	tpFetcher     observer.TopologyFetcher
 	promFetcher   metricsreader.PromInfoFetcher
 	metricsReader metricsreader.MetricsReader
@@ -46,7 +49,9 @@ type namespaceManager struct {
 }
 
 func NewNamespaceManager() *namespaceManager {
-	return &namespaceManager{}
+	return &namespaceManager{
+		maintenance: make(map[string]*MaintenanceState),
+	}
 }
 
 func (mgr *namespaceManager) buildNamespace(cfg *config.Namespace) (*Namespace, error) {
@@ -70,13 +75,27 @@ func (mgr *namespaceManager) buildNamespace(cfg *config.Namespace) (*Namespace,
 	rt.Init(context.Background(), bo, balancePolicy, mgr.cfgMgr.GetConfig(), mgr.cfgMgr.WatchConfig())
 
 	return &Namespace{
-		name:   cfg.Namespace,
-		user:   cfg.Frontend.User,
-		bo:     bo,
-		router: rt,
+		name:        cfg.Namespace,
+		user:        cfg.Frontend.User,
+		bo:          bo,
+		router:      rt,
+		cfg:         cfg,
+		maintenance: mgr.maintenanceState(cfg.Namespace),
 	}, nil
 }
 
+// maintenanceState returns the maintenance state of the namespace and creates it if it doesn't exist.
+func (mgr *namespaceManager) maintenanceState(nm string) *MaintenanceState {
+	mgr.Lock()
+	defer mgr.Unlock()
+	state, ok := mgr.maintenance[nm]
+	if !ok {
+		state = NewMaintenanceState()
+		mgr.maintenance[nm] = state
+	}
+	return state
+}
+
 func (mgr *namespaceManager) CommitNamespaces(nss []*config.Namespace, nssDelete []bool) error {
 	nsm := make(map[string]*Namespace)
 	mgr.RLock()
@@ -85,9 +104,11 @@ func (mgr *namespaceManager) CommitNamespaces(nss []*config.Namespace, nssDelete
 	}
 	mgr.RUnlock()
 
+	var deleted []string
 	for i, nsc := range nss {
 		if nssDelete != nil && nssDelete[i] {
 			delete(nsm, nsc.Namespace)
+			deleted = append(deleted, nsc.Namespace)
 			continue
 		}
 
@@ -100,6 +121,13 @@ func (mgr *namespaceManager) CommitNamespaces(nss []*config.Namespace, nssDelete
 
 	mgr.Lock()
 	mgr.nsm = nsm
+	for _, nm := range deleted {
+		// End the maintenance so that the held connections of the deleted namespace are released.
+		if state, ok := mgr.maintenance[nm]; ok {
+			state.Set(nil)
+			delete(mgr.maintenance, nm)
+		}
+	}
 	mgr.Unlock()
 	return nil
 }
@@ -138,6 +166,17 @@ func (mgr *namespaceManager) GetNamespaceByUser(user string) (*Namespace, bool)
 	return nil, false
 }
 
+func (mgr *namespaceManager) Maintenance(nm string) (*MaintenanceState, bool) {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	ns, ok := mgr.nsm[nm]
+	if !ok {
+		return nil, false
+	}
+	return ns.maintenance, true
+}
+
 func (mgr *namespaceManager) RedirectConnections() []error {
 	mgr.RLock()
 	defer mgr.RUnlock()
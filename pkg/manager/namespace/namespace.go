@@ -7,6 +7,7 @@
 package namespace
 
 import (
+	"github.com/pingcap/tiproxy/lib/config"
 	"github.com/pingcap/tiproxy/pkg/balance/observer"
 	"github.com/pingcap/tiproxy/pkg/balance/router"
 )
@@ -16,6 +17,9 @@ type Namespace struct {
 	user   string
 	bo     observer.BackendObserver
 	router router.Router
+	cfg    *config.Namespace
+	// maintenance is shared by all versions of the namespace.
+	maintenance *MaintenanceState
 }
 
 func (n *Namespace) Name() string {
@@ -26,6 +30,16 @@ func (n *Namespace) User() string {
 	return n.user
 }
 
+// Config returns the config that the namespace is built from. The caller should not modify it.
+func (n *Namespace) Config() *config.Namespace {
+	return n.cfg
+}
+
+// Maintenance returns the maintenance state of the namespace.
+func (n *Namespace) Maintenance() *MaintenanceState {
+	return n.maintenance
+}
+
 func (n *Namespace) GetRouter() router.Router {
 	return n.router
 }
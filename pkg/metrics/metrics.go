@@ -106,8 +106,19 @@ func init() {
 		TimeJumpBackCounter,
 		KeepAliveCounter,
 		QueryTotalCounter,
+		QueryErrorCounter,
 		QueryDurationHistogram,
 		HandshakeDurationHistogram,
+		QueryRuleCounter,
+		FirewallCounter,
+		StmtLimitCounter,
+		AuditDroppedCounter,
+		StmtSummaryDroppedCounter,
+		FailoverCounter,
+		LoadDataCounter,
+		LoadDataBytesCounter,
+		ResultCacheCounter,
+		ResultCacheBytesGauge,
 		BackendStatusGauge,
 		GetBackendHistogram,
 		GetBackendCounter,
@@ -121,6 +132,9 @@ func init() {
 		OutboundBytesCounter,
 		OutboundPacketsCounter,
 		CrossLocationBytesCounter,
+		CompressRawBytesCounter,
+		CompressWireBytesCounter,
+		CompressDurationCounter,
 		ReplayPendingCmdsGauge,
 		ReplayWaitTime,
 	}
@@ -11,6 +11,7 @@ import "github.com/prometheus/client_golang/prometheus"
 // LblCmdType is the label constant.
 const (
 	LblCmdType = "cmd_type"
+	LblRule    = "rule"
 )
 
 var (
@@ -22,6 +23,14 @@ var (
 			Help:      "Counter of queries.",
 		}, []string{LblBackend, LblCmdType})
 
+	QueryErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "query_error_total",
+			Help:      "Counter of queries that fail, including MySQL errors.",
+		}, []string{LblBackend, LblCmdType})
+
 	QueryDurationHistogram = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: ModuleProxy,
@@ -39,4 +48,84 @@ var (
 			Help:      "Bucketed histogram of processing time (s) of handshakes.",
 			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 29), // 0.5ms ~ 1.5days
 		}, []string{LblBackend})
+
+	QueryRuleCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "query_rule_total",
+			Help:      "Counter of statements that match query rules.",
+		}, []string{LblRule, LblType})
+
+	FirewallCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "firewall_total",
+			Help:      "Counter of statements that are not in the firewall allowlist.",
+		}, []string{LblType})
+
+	StmtLimitCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "stmt_limit_total",
+			Help:      "Counter of statements that exceed the execution time or result size limits.",
+		}, []string{LblType})
+
+	AuditDroppedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "audit_dropped_total",
+			Help:      "Counter of audit events that are dropped because the audit log is too busy.",
+		})
+
+	StmtSummaryDroppedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "stmt_summary_dropped_total",
+			Help:      "Counter of statements that are not aggregated into the statement summary because it's too busy.",
+		})
+
+	FailoverCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "failover_total",
+			Help:      "Counter of reconnecting sessions and retrying statements after the backend connection breaks.",
+		}, []string{LblType, LblRes})
+
+	LoadDataCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "load_data_total",
+			Help:      "Counter of LOAD DATA LOCAL INFILE statements.",
+		}, []string{LblRes})
+
+	LoadDataBytesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "load_data_bytes_total",
+			Help:      "Counter of bytes uploaded to backends by LOAD DATA LOCAL INFILE.",
+		})
+
+	ResultCacheCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "result_cache_total",
+			Help:      "Counter of hits and misses of the result cache.",
+		}, []string{LblRule, LblRes})
+
+	ResultCacheBytesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelSession,
+			Name:      "result_cache_bytes",
+			Help:      "Total size of the cached result sets.",
+		})
 )
@@ -5,6 +5,13 @@ package metrics
 
 import "github.com/prometheus/client_golang/prometheus"
 
+// Label constants of compression metrics.
+const (
+	LblLeg       = "leg"
+	LblAlgorithm = "algorithm"
+	LblOp        = "op"
+)
+
 var (
 	InboundBytesCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -45,4 +52,28 @@ var (
 			Name:      "cross_location_bytes",
 			Help:      "Counter of bytes between TiProxy and cross-location backends.",
 		})
+
+	CompressRawBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelTraffic,
+			Name:      "compress_raw_bytes",
+			Help:      "Counter of uncompressed bytes on the compressed connections.",
+		}, []string{LblLeg, LblAlgorithm, LblOp})
+
+	CompressWireBytesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelTraffic,
+			Name:      "compress_wire_bytes",
+			Help:      "Counter of compressed bytes on the compressed connections.",
+		}, []string{LblLeg, LblAlgorithm, LblOp})
+
+	CompressDurationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: ModuleProxy,
+			Subsystem: LabelTraffic,
+			Name:      "compress_seconds",
+			Help:      "Counter of time (s) spent on compression and decompression.",
+		}, []string{LblLeg, LblAlgorithm, LblOp})
 )
@@ -0,0 +1,270 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/waitgroup"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	EventConnect    = "connect"
+	EventDisconnect = "disconnect"
+	EventStatement  = "statement"
+	EventMigration  = "migration"
+)
+
+const (
+	maxPendingEvents = 1 << 14 // 16K
+	defaultMaxSize   = 300     // MB
+	redactedText     = "<redacted>"
+)
+
+// Event is one line in the audit log.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	ConnID     uint64    `json:"conn_id"`
+	Namespace  string    `json:"namespace,omitempty"`
+	User       string    `json:"user,omitempty"`
+	ClientAddr string    `json:"client_addr,omitempty"`
+	Backend    string    `json:"backend,omitempty"`
+	// TLSVersion and TLSCipher are only set in connect events.
+	TLSVersion string `json:"tls_version,omitempty"`
+	TLSCipher  string `json:"tls_cipher,omitempty"`
+	// The following fields are only set in statement events.
+	Cmd          string `json:"cmd,omitempty"`
+	StmtType     string `json:"stmt_type,omitempty"`
+	Digest       string `json:"digest,omitempty"`
+	SQL          string `json:"sql,omitempty"`
+	AffectedRows uint64 `json:"affected_rows,omitempty"`
+	ErrCode      uint16 `json:"err_code,omitempty"`
+	// DurationUs is the duration of the statement in microseconds.
+	DurationUs int64 `json:"duration_us,omitempty"`
+	// The following fields are only set in migration events.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Error is set in disconnect and migration events.
+	Error string `json:"error,omitempty"`
+	// logText is whether to keep the statement text.
+	logText bool
+}
+
+// SessionConfig is the audit config of one session.
+type SessionConfig struct {
+	sampleRate float64
+	logText    bool
+}
+
+// NewSessionConfig returns nil if the session is not audited.
+func NewSessionConfig(cfg *config.NamespaceAudit, user string) *SessionConfig {
+	if cfg == nil || !cfg.Enable {
+		return nil
+	}
+	if len(cfg.Users) > 0 && !slices.Contains(cfg.Users, user) {
+		return nil
+	}
+	return &SessionConfig{
+		sampleRate: cfg.SampleRate,
+		logText:    cfg.LogText,
+	}
+}
+
+// Sample returns whether the statement should be recorded.
+func (sc *SessionConfig) Sample() bool {
+	if sc.sampleRate <= 0 || sc.sampleRate >= 1 {
+		return true
+	}
+	return rand.Float64() < sc.sampleRate
+}
+
+// Auditor writes audit events into a JSONL file.
+// Events are sent to a buffered channel and written in the background so that the forwarding path is never blocked.
+// If the channel is full, the events are dropped.
+type Auditor struct {
+	sync.Mutex
+	cfg    config.LogFile
+	writer io.WriteCloser
+	buf    *bufio.Writer
+	// enabled is read without lock on the forwarding path.
+	enabled atomic.Bool
+	eventCh chan *Event
+	wg      waitgroup.WaitGroup
+	cancel  context.CancelFunc
+	lg      *zap.Logger
+}
+
+func NewAuditor(lg *zap.Logger) *Auditor {
+	return &Auditor{
+		eventCh: make(chan *Event, maxPendingEvents),
+		lg:      lg,
+	}
+}
+
+// Start starts writing events in the background.
+func (a *Auditor) Start(ctx context.Context) {
+	childCtx, cancel := context.WithCancel(ctx)
+	a.cancel = cancel
+	a.wg.RunWithRecover(func() {
+		a.run(childCtx)
+	}, nil, a.lg)
+}
+
+// Reset updates the audit log file. An empty filename disables the audit log.
+func (a *Auditor) Reset(cfg config.Audit) {
+	a.Lock()
+	defer a.Unlock()
+	if a.cfg == cfg.LogFile {
+		return
+	}
+	a.closeWriter()
+	a.cfg = cfg.LogFile
+	a.enabled.Store(len(cfg.LogFile.Filename) > 0)
+	if !a.enabled.Load() {
+		return
+	}
+	maxSize := cfg.LogFile.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultMaxSize
+	}
+	a.writer = &lumberjack.Logger{
+		Filename:   cfg.LogFile.Filename,
+		MaxSize:    maxSize,
+		MaxBackups: cfg.LogFile.MaxBackups,
+		MaxAge:     cfg.LogFile.MaxDays,
+		LocalTime:  true,
+	}
+	a.buf = bufio.NewWriter(a.writer)
+	a.lg.Info("audit log is enabled", zap.String("filename", cfg.LogFile.Filename))
+}
+
+// Enabled returns whether the audit log file is configured.
+func (a *Auditor) Enabled() bool {
+	return a.enabled.Load()
+}
+
+// Log sends the event to the background writer without blocking.
+func (a *Auditor) Log(event *Event) {
+	select {
+	case a.eventCh <- event:
+	default:
+		metrics.AuditDroppedCounter.Inc()
+	}
+}
+
+// LogStatement records a statement. The digest and the redaction are calculated in the background.
+func (a *Auditor) LogStatement(sc *SessionConfig, event *Event) {
+	event.Type = EventStatement
+	event.logText = sc.logText
+	a.Log(event)
+}
+
+func (a *Auditor) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			// Write the remaining events before exiting.
+			for {
+				select {
+				case event := <-a.eventCh:
+					a.write(event)
+				default:
+					a.Lock()
+					a.closeWriter()
+					a.Unlock()
+					return
+				}
+			}
+		case event := <-a.eventCh:
+			a.write(event)
+			// Flush the buffer only when there are no more pending events to reduce syscalls.
+			if len(a.eventCh) == 0 {
+				a.flush()
+			}
+		}
+	}
+}
+
+func (a *Auditor) write(event *Event) {
+	if event.Type == EventStatement && len(event.SQL) > 0 {
+		fillStatement(event)
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		a.lg.Warn("marshal audit event failed", zap.Error(err))
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.buf == nil {
+		return
+	}
+	data = append(data, '\n')
+	if _, err = a.buf.Write(data); err != nil {
+		a.lg.Warn("write audit log failed", zap.Error(err))
+	}
+}
+
+func (a *Auditor) flush() {
+	a.Lock()
+	defer a.Unlock()
+	if a.buf == nil {
+		return
+	}
+	if err := a.buf.Flush(); err != nil {
+		a.lg.Warn("flush audit log failed", zap.Error(err))
+	}
+}
+
+func (a *Auditor) closeWriter() {
+	if a.buf != nil {
+		if err := a.buf.Flush(); err != nil {
+			a.lg.Warn("flush audit log failed", zap.Error(err))
+		}
+		a.buf = nil
+	}
+	if a.writer != nil {
+		if err := a.writer.Close(); err != nil {
+			a.lg.Warn("close audit log failed", zap.Error(err))
+		}
+		a.writer = nil
+	}
+}
+
+// fillStatement calculates the statement type and digest, and redacts the text if necessary.
+func fillStatement(event *Event) {
+	sql := event.SQL
+	event.StmtType = lex.NewLexer(sql).NextToken()
+	_, digest := parser.NormalizeDigest(sql)
+	event.Digest = digest.String()
+	switch {
+	case !event.logText:
+		event.SQL = ""
+	case lex.IsSensitiveSQL(sql):
+		event.SQL = redactedText
+	}
+}
+
+// Close writes the pending events and closes the file.
+func (a *Auditor) Close() {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.wg.Wait()
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func readEvents(t *testing.T, filename string) []Event {
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestSessionConfig(t *testing.T) {
+	require.Nil(t, NewSessionConfig(nil, "u1"))
+	require.Nil(t, NewSessionConfig(&config.NamespaceAudit{}, "u1"))
+	require.Nil(t, NewSessionConfig(&config.NamespaceAudit{Enable: true, Users: []string{"u2"}}, "u1"))
+	sc := NewSessionConfig(&config.NamespaceAudit{Enable: true, Users: []string{"u1"}}, "u1")
+	require.NotNil(t, sc)
+	for i := 0; i < 10; i++ {
+		require.True(t, sc.Sample())
+	}
+
+	sc = NewSessionConfig(&config.NamespaceAudit{Enable: true, SampleRate: 0.5}, "u1")
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if sc.Sample() {
+			sampled++
+		}
+	}
+	require.Greater(t, sampled, 300)
+	require.Less(t, sampled, 700)
+}
+
+func TestWriteEvents(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	a := NewAuditor(lg)
+	require.False(t, a.Enabled())
+	a.Reset(config.Audit{LogFile: config.LogFile{Filename: filename}})
+	require.True(t, a.Enabled())
+	a.Start(context.Background())
+
+	now := time.Now()
+	a.Log(&Event{Time: now, Type: EventConnect, ConnID: 1, User: "u1", TLSVersion: "TLS 1.3"})
+	withText := NewSessionConfig(&config.NamespaceAudit{Enable: true, LogText: true}, "u1")
+	withoutText := NewSessionConfig(&config.NamespaceAudit{Enable: true}, "u1")
+	a.LogStatement(withText, &Event{Time: now, ConnID: 1, SQL: "select * from t where id = 1", AffectedRows: 0, DurationUs: 100})
+	a.LogStatement(withText, &Event{Time: now, ConnID: 1, SQL: "create user u identified by 'pwd'", ErrCode: 1105})
+	a.LogStatement(withoutText, &Event{Time: now, ConnID: 1, SQL: "delete from t", AffectedRows: 10})
+	a.Log(&Event{Time: now, Type: EventMigration, ConnID: 1, From: "addr1", To: "addr2"})
+	a.Log(&Event{Time: now, Type: EventDisconnect, ConnID: 1})
+	a.Close()
+
+	_, digest := parser.NormalizeDigest("select * from t where id = 1")
+	events := readEvents(t, filename)
+	require.Len(t, events, 6)
+	require.Equal(t, EventConnect, events[0].Type)
+	require.Equal(t, "TLS 1.3", events[0].TLSVersion)
+	require.Equal(t, EventStatement, events[1].Type)
+	require.Equal(t, "SELECT", events[1].StmtType)
+	require.Equal(t, digest.String(), events[1].Digest)
+	require.Equal(t, "select * from t where id = 1", events[1].SQL)
+	require.Equal(t, int64(100), events[1].DurationUs)
+	require.Equal(t, redactedText, events[2].SQL)
+	require.Equal(t, uint16(1105), events[2].ErrCode)
+	require.Equal(t, "", events[3].SQL)
+	require.Equal(t, "DELETE", events[3].StmtType)
+	require.Equal(t, uint64(10), events[3].AffectedRows)
+	require.Equal(t, "addr2", events[4].To)
+	require.Equal(t, EventDisconnect, events[5].Type)
+}
+
+func TestDisable(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	dir := t.TempDir()
+	a := NewAuditor(lg)
+	a.Start(context.Background())
+	a.Reset(config.Audit{LogFile: config.LogFile{Filename: filepath.Join(dir, "audit1.log")}})
+	a.Log(&Event{Type: EventConnect, ConnID: 1})
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "audit1.log"))
+		return err == nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Switch to another file.
+	a.Reset(config.Audit{LogFile: config.LogFile{Filename: filepath.Join(dir, "audit2.log")}})
+	a.Log(&Event{Type: EventConnect, ConnID: 2})
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "audit2.log"))
+		return err == nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	a.Reset(config.Audit{})
+	require.False(t, a.Enabled())
+	a.Close()
+	require.Len(t, readEvents(t, filepath.Join(dir, "audit1.log")), 1)
+	require.Len(t, readEvents(t, filepath.Join(dir, "audit2.log")), 1)
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/pkg/proxy/audit"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+)
+
+// initAudit decides whether the session is audited according to the namespace config and the current user.
+func (mgr *BackendConnManager) initAudit() {
+	mgr.auditCfg = nil
+	if mgr.config.Audit == nil {
+		return
+	}
+	if nsCfg, ok := mgr.Value(ConnContextKeyNamespace).(*config.Namespace); ok && nsCfg != nil {
+		mgr.auditCfg = audit.NewSessionConfig(nsCfg.Audit, mgr.authenticator.user)
+	}
+}
+
+func (mgr *BackendConnManager) auditEnabled() bool {
+	return mgr.auditCfg != nil && mgr.config.Audit.Enabled()
+}
+
+func (mgr *BackendConnManager) newAuditEvent(tp string, now time.Time) *audit.Event {
+	event := &audit.Event{
+		Time:      now,
+		Type:      tp,
+		ConnID:    mgr.connectionID,
+		Namespace: mgr.cmdProcessor.ruleScope.Namespace,
+		User:      mgr.authenticator.user,
+		Backend:   mgr.ServerAddr(),
+	}
+	if mgr.clientIO != nil {
+		event.ClientAddr = mgr.clientIO.RemoteAddr().String()
+	}
+	return event
+}
+
+func (mgr *BackendConnManager) auditConnect(now time.Time) {
+	if !mgr.auditEnabled() {
+		return
+	}
+	event := mgr.newAuditEvent(audit.EventConnect, now)
+	if state, ok := mgr.Value(ConnContextKeyTLSState).(tls.ConnectionState); ok && state.HandshakeComplete {
+		event.TLSVersion = tls.VersionName(state.Version)
+		event.TLSCipher = tls.CipherSuiteName(state.CipherSuite)
+	}
+	mgr.config.Audit.Log(event)
+}
+
+func (mgr *BackendConnManager) auditDisconnect(now time.Time) {
+	if !mgr.auditEnabled() {
+		return
+	}
+	event := mgr.newAuditEvent(audit.EventDisconnect, now)
+	if !mgr.quitSource.Normal() {
+		event.Error = mgr.quitSource.String()
+	}
+	mgr.config.Audit.Log(event)
+}
+
+func (mgr *BackendConnManager) auditStatement(request []byte, startTime, now time.Time, err error) {
+	if !mgr.auditEnabled() {
+		return
+	}
+	cmd := pnet.Command(request[0])
+	switch cmd {
+	case pnet.ComQuery, pnet.ComStmtPrepare, pnet.ComStmtExecute:
+	default:
+		return
+	}
+	if !mgr.auditCfg.Sample() {
+		return
+	}
+	event := mgr.newAuditEvent(audit.EventStatement, now)
+	event.Cmd = cmd.String()
+	if cmd != pnet.ComStmtExecute {
+		// Copy the statement because the request may be reused after the function returns.
+		event.SQL = string(pnet.ParseQueryPacket(request[1:]))
+	} else {
+		event.SQL, _ = mgr.preparedSQL(request)
+	}
+	event.AffectedRows = mgr.cmdProcessor.affectedRows
+	event.DurationUs = now.Sub(startTime).Microseconds()
+	var myErr *mysql.MyError
+	if errors.As(err, &myErr) {
+		event.ErrCode = myErr.Code
+	}
+	mgr.config.Audit.LogStatement(mgr.auditCfg, event)
+}
+
+func (mgr *BackendConnManager) auditMigration(rs *redirectResult) {
+	if !mgr.auditEnabled() {
+		return
+	}
+	event := mgr.newAuditEvent(audit.EventMigration, time.Now())
+	event.From, event.To = rs.from, rs.to
+	if rs.err != nil {
+		event.Error = rs.err.Error()
+	}
+	mgr.config.Audit.Log(event)
+}
@@ -12,9 +12,11 @@ import (
 	"strings"
 
 	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
 	"github.com/pingcap/tiproxy/lib/util/errors"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
 	"github.com/pingcap/tiproxy/pkg/proxy/proxyprotocol"
+	"github.com/pingcap/tiproxy/pkg/proxy/userstore"
 	"github.com/siddontang/go/hack"
 	"go.uber.org/zap"
 )
@@ -23,6 +25,10 @@ const unknownAuthPlugin = "auth_unknown_plugin"
 const requiredFrontendCaps = pnet.ClientProtocol41
 const defRequiredBackendCaps = pnet.ClientDeprecateEOF
 const ER_INVALID_SEQUENCE = 8052
+const compressCaps = pnet.ClientCompress | pnet.ClientZstdCompressionAlgorithm
+
+// defaultZstdLevel is the zstd level of the backend connections if the namespace doesn't specify it. MySQL is 3.
+const defaultZstdLevel = 3
 
 // SupportedServerCapabilities is the default supported capabilities. Other server capabilities are not supported.
 // TiDB supports ClientDeprecateEOF since v6.3.0.
@@ -43,12 +49,26 @@ type Authenticator struct {
 	collation         uint8
 	proxyProtocol     bool
 	requireBackendTLS bool
+	// userStore is used to authenticate the clients on TiProxy. It may be nil.
+	userStore *userstore.Store
+	// proxyAuth means the client is authenticated by the user store and the backend logs in with the mapped user.
+	proxyAuth bool
+	certAuth  config.CertAuth
+	// certIdentity is the identity in the client certificate. It's nil if the cert-auth is not used.
+	certIdentity *CertIdentity
+	// backendCompression and backendZstdLevel override the compression of the backend connection.
+	backendCompression string
+	backendZstdLevel   int
+	// backendConnID is the connection ID of the current backend connection.
+	backendConnID uint64
 }
 
 func NewAuthenticator(config *BCConfig) *Authenticator {
 	auth := &Authenticator{
 		proxyProtocol:     config.ProxyProtocol,
 		requireBackendTLS: config.RequireBackendTLS,
+		userStore:         config.UserStore,
+		certAuth:          config.CertAuth,
 	}
 	return auth
 }
@@ -63,6 +83,7 @@ func (auth *Authenticator) writeProxyProtocol(clientIO, backendIO pnet.PacketIO)
 				Version:    proxyprotocol.ProxyVersion2,
 			}
 		}
+		proxy = auth.identityProxy(proxy)
 		// either from another proxy or directly from clients, we are acting as a proxy
 		proxy.Command = proxyprotocol.ProxyCommandProxy
 		backendIO.EnableProxyClient(proxy)
@@ -155,15 +176,37 @@ func (auth *Authenticator) handshakeFirstTime(ctx context.Context, logger *zap.L
 	} else if err != nil {
 		return err
 	}
+	if auth.certIdentity, err = auth.mapCertIdentity(logger, clientIO, clientResp, isSSL); err != nil {
+		return err
+	}
+	if auth.certIdentity != nil {
+		cctx.SetValue(ConnContextKeyCertIdentity, auth.certIdentity)
+	}
 	if err = handshakeHandler.HandleHandshakeResp(cctx, clientResp); err != nil {
 		return errors.Wrap(err, ErrProxyErr)
 	}
 	auth.user = clientResp.User
 	auth.dbname = clientResp.DB
 	auth.collation = clientResp.Collation
-	auth.attrs = clientResp.Attrs
+	auth.attrs = auth.identityAttrs(clientResp.Attrs)
 	auth.zstdLevel = clientResp.ZstdLevel
 
+	// In the proxy-auth mode, the client is verified by TiProxy and TiProxy logs into the backend with the mapped user.
+	var localUser *userstore.User
+	if auth.userStore != nil && auth.userStore.Enabled() {
+		// The client certificate replaces the password if it binds a user in the store.
+		if auth.certIdentity != nil && auth.certIdentity.User != "" {
+			localUser, _ = auth.userStore.Get(auth.certIdentity.User)
+		}
+		if localUser == nil {
+			if localUser, err = authenticateLocally(logger, auth.userStore, clientIO, clientResp, salt[:], isSSL); err != nil {
+				return err
+			}
+		}
+		auth.user = localUser.BackendUser
+		auth.proxyAuth = true
+	}
+
 RECONNECT:
 
 	// In case of testing, backendIO is passed manually that we don't want to bother with the routing logic.
@@ -172,6 +215,10 @@ RECONNECT:
 		return err
 	}
 	backendIO.ResetSequence()
+	// The namespace is known after routing.
+	if nsCfg, ok := cctx.Value(ConnContextKeyNamespace).(*config.Namespace); ok && nsCfg != nil {
+		auth.backendCompression, auth.backendZstdLevel = nsCfg.Backend.Compression, nsCfg.Backend.ZstdLevel
+	}
 
 	// write proxy header
 	if err := auth.writeProxyProtocol(clientIO, backendIO); err != nil {
@@ -205,13 +252,19 @@ RECONNECT:
 		logger.Debug("backend does not support capabilities from proxy", zap.Stringer("common", common), zap.Stringer("proxy", proxyCapability^common), zap.Stringer("backend", backendCapability^common))
 	}
 
+	// Send an unknown auth plugin so that the backend will request the auth data again.
+	// Copy the auth data so that the backend can set correct `using password` in the error message.
+	authPlugin, authData := unknownAuthPlugin, clientResp.AuthData
+	if localUser != nil {
+		initialHandshake := pnet.ParseInitialHandshake(serverPkt)
+		authPlugin = initialHandshake.AuthPlugin
+		if authData, err = pnet.GenerateAuthResp(localUser.BackendPassword, authPlugin, initialHandshake.Salt[:]); err != nil {
+			return errors.Wrap(err, ErrBackendHandshake)
+		}
+	}
+
 	// forward client handshake resp
-	if err := auth.writeAuthHandshake(
-		backendIO, backendTLSConfig, backendCapability,
-		// Send an unknown auth plugin so that the backend will request the auth data again.
-		// Copy the auth data so that the backend can set correct `using password` in the error message.
-		unknownAuthPlugin, clientResp.AuthData, 0,
-	); err != nil {
+	if err := auth.writeAuthHandshake(backendIO, backendTLSConfig, backendCapability, authPlugin, authData, 0); err != nil {
 		return err
 	}
 
@@ -235,6 +288,13 @@ loop:
 				goto RECONNECT
 			}
 		}
+		// The backend auth requests are answered by TiProxy in the proxy-auth mode.
+		if localUser != nil && packetErr == nil && serverPkt[0] != pnet.OKHeader.Byte() {
+			if authData, err = respondBackendAuth(backendIO, serverPkt, localUser.BackendPassword, authData); err != nil {
+				return err
+			}
+			continue
+		}
 		err = clientIO.WritePacket(serverPkt, true)
 		if err != nil {
 			return err
@@ -249,7 +309,8 @@ loop:
 			if err := setCompress(clientIO, auth.capability, auth.zstdLevel); err != nil {
 				return errors.Wrap(err, ErrClientHandshake)
 			}
-			if err := setCompress(backendIO, auth.capability&backendCapability, auth.zstdLevel); err != nil {
+			compressCap, zstdLevel := auth.backendCompress(backendCapability)
+			if err := setCompress(backendIO, compressCap, zstdLevel); err != nil {
 				return errors.Wrap(err, ErrBackendHandshake)
 			}
 			return nil
@@ -276,10 +337,21 @@ func forwardMsg(srcIO, destIO pnet.PacketIO) (data []byte, err error) {
 	return
 }
 
+// Credential is used to log into the backend without a client, e.g. when replaying traffic.
+type Credential struct {
+	User     string
+	Password string
+	// SessionToken is a token signed with the session token signing cert of TiDB. If it's set, the backend is logged
+	// in with the tidb_session_token plugin instead of the password, so that the password of the user is not needed.
+	SessionToken string
+	// Attrs are the connection attributes sent to the backend.
+	Attrs map[string]string
+}
+
 // handshake with backend directly without the clientIO
 func (auth *Authenticator) handshakeWithBackend(ctx context.Context, logger *zap.Logger, cctx ConnContext, handshakeHandler HandshakeHandler,
-	username, password string, getBackendIO backendIOGetter, backendTLSConfig *tls.Config) error {
-	backendIO, err := getBackendIO(ctx, cctx, &pnet.HandshakeResp{User: username})
+	cred Credential, getBackendIO backendIOGetter, backendTLSConfig *tls.Config) error {
+	backendIO, err := getBackendIO(ctx, cctx, &pnet.HandshakeResp{User: cred.User, Attrs: cred.Attrs})
 	if err != nil {
 		return err
 	}
@@ -288,14 +360,19 @@ func (auth *Authenticator) handshakeWithBackend(ctx context.Context, logger *zap
 		return err
 	}
 	initialHandshake := pnet.ParseInitialHandshake(pkt)
-	authData, err := pnet.GenerateAuthResp(password, initialHandshake.AuthPlugin, initialHandshake.Salt[:])
-	if err != nil {
+	auth.backendConnID = initialHandshake.ConnID
+	authPlugin, authCap := initialHandshake.AuthPlugin, pnet.Capability(0)
+	var authData []byte
+	if len(cred.SessionToken) > 0 {
+		authPlugin, authData, authCap = pnet.AuthTiDBSessionToken, hack.Slice(cred.SessionToken), pnet.ClientPluginAuth
+	} else if authData, err = pnet.GenerateAuthResp(cred.Password, authPlugin, initialHandshake.Salt[:]); err != nil {
 		return err
 	}
-	auth.user = username
+	auth.user = cred.User
+	auth.attrs = cred.Attrs
 	auth.capability = handshakeHandler.GetCapability()
 	auth.collation = pnet.Collation
-	if err = auth.writeAuthHandshake(backendIO, backendTLSConfig, initialHandshake.Capability, initialHandshake.AuthPlugin, authData, 0); err != nil {
+	if err = auth.writeAuthHandshake(backendIO, backendTLSConfig, initialHandshake.Capability, authPlugin, authData, authCap); err != nil {
 		return err
 	}
 	for {
@@ -304,28 +381,14 @@ func (auth *Authenticator) handshakeWithBackend(ctx context.Context, logger *zap
 		}
 		switch pkt[0] {
 		case pnet.OKHeader.Byte():
-			return nil
-		case pnet.AuthSwitchHeader.Byte():
-			idx := bytes.IndexByte(pkt[1:], 0)
-			authPlugin := string(pkt[1 : idx+1])
-			salt := pkt[idx+2 : len(pkt)-1]
-			if len(salt) != 20 {
-				return mysql.ErrMalformPacket
-			}
-			if authData, err = pnet.GenerateAuthResp(password, authPlugin, salt); err != nil {
-				return err
-			}
-			if err = backendIO.WritePacket(authData, true); err != nil {
-				return err
-			}
-		case pnet.ShaCommand:
-			if err = backendIO.WritePacket(authData, true); err != nil {
-				return err
-			}
+			compressCap, zstdLevel := auth.backendCompress(initialHandshake.Capability)
+			return setCompress(backendIO, compressCap, zstdLevel)
 		case pnet.ErrHeader.Byte():
 			return pnet.ParseErrorPacket(pkt)
 		default:
-			return mysql.ErrMalformPacket
+			if authData, err = respondBackendAuth(backendIO, pkt, cred.Password, authData); err != nil {
+				return err
+			}
 		}
 	}
 }
@@ -357,7 +420,8 @@ func (auth *Authenticator) handshakeSecondTime(logger *zap.Logger, clientIO, bac
 	}
 
 	if err = auth.handleSecondAuthResult(backendIO); err == nil {
-		if err = setCompress(backendIO, auth.capability&backendCapability, auth.zstdLevel); err != nil {
+		compressCap, zstdLevel := auth.backendCompress(backendCapability)
+		if err = setCompress(backendIO, compressCap, zstdLevel); err != nil {
 			return errors.Wrap(err, ErrBackendHandshake)
 		}
 	}
@@ -375,6 +439,7 @@ func (auth *Authenticator) readInitialHandshake(backendIO pnet.PacketIO) (server
 	}
 	initialHandshake := pnet.ParseInitialHandshake(serverPkt)
 	capability = initialHandshake.Capability
+	auth.backendConnID = initialHandshake.ConnID
 	return
 }
 
@@ -387,15 +452,16 @@ func (auth *Authenticator) writeAuthHandshake(
 	authCap pnet.Capability,
 ) error {
 	// Always handshake with SSL enabled and enable auth_plugin.
+	compressCap, zstdLevel := auth.backendCompress(backendCapability)
 	resp := &pnet.HandshakeResp{
 		User:       auth.user,
 		DB:         auth.dbname,
 		Attrs:      auth.attrs,
 		Collation:  auth.collation,
 		AuthData:   authData,
-		Capability: auth.capability&backendCapability | authCap,
+		Capability: (auth.capability&^compressCaps)&backendCapability | compressCap | authCap,
 		AuthPlugin: authPlugin,
-		ZstdLevel:  auth.zstdLevel,
+		ZstdLevel:  zstdLevel,
 	}
 
 	if len(resp.Attrs) > 0 {
@@ -464,7 +530,7 @@ func (auth *Authenticator) handleSecondAuthResult(backendIO pnet.PacketIO) error
 func (auth *Authenticator) changeUser(req *pnet.ChangeUserReq) {
 	auth.user = req.User
 	auth.dbname = req.DB
-	auth.attrs = req.Attrs
+	auth.attrs = auth.identityAttrs(req.Attrs)
 }
 
 // updateCurrentDB is called once the client sends COM_INIT_DB or `use db`.
@@ -479,6 +545,24 @@ func (auth *Authenticator) ConnInfo() []zap.Field {
 	return fields
 }
 
+// backendCompress returns the compression capability and the zstd level of the backend connection.
+// It follows the client by default and can be overridden by the namespace.
+func (auth *Authenticator) backendCompress(backendCapability pnet.Capability) (pnet.Capability, int) {
+	capability, zstdLevel := auth.capability&compressCaps, auth.zstdLevel
+	switch auth.backendCompression {
+	case config.CompressionNone:
+		capability = 0
+	case config.CompressionZlib:
+		capability = pnet.ClientCompress
+	case config.CompressionZstd:
+		capability, zstdLevel = pnet.ClientZstdCompressionAlgorithm, auth.backendZstdLevel
+		if zstdLevel == 0 {
+			zstdLevel = defaultZstdLevel
+		}
+	}
+	return capability & backendCapability, zstdLevel
+}
+
 func setCompress(packetIO pnet.PacketIO, capability pnet.Capability, zstdLevel int) error {
 	algorithm := pnet.CompressionNone
 	if capability&pnet.ClientCompress > 0 {
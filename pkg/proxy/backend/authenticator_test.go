@@ -4,10 +4,19 @@
 package backend
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tidb/parser/auth"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/logger"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/userstore"
 	"github.com/stretchr/testify/require"
 )
 
@@ -229,6 +238,7 @@ func TestHandshakeWithBackend(t *testing.T) {
 	tests := []struct {
 		cfg    cfgOverrider
 		errMsg string
+		check  func(t *testing.T, ts *testSuite)
 	}{
 		{},
 		{
@@ -242,6 +252,16 @@ func TestHandshakeWithBackend(t *testing.T) {
 				cfg.backendConfig.authPlugin = pnet.AuthNativePassword
 			},
 		},
+		{
+			cfg: func(cfg *testConfig) {
+				cfg.proxyConfig.credential = &Credential{User: "u2", SessionToken: "mock_token", Attrs: map[string]string{"k": "v"}}
+			},
+			check: func(t *testing.T, ts *testSuite) {
+				require.Equal(t, "u2", ts.mb.username)
+				require.Equal(t, []byte("mock_token"), ts.mb.authData)
+				require.Equal(t, map[string]string{"k": "v"}, ts.mb.attrs)
+			},
+		},
 	}
 
 	tc := newTCPConnSuite(t)
@@ -262,6 +282,9 @@ func TestHandshakeWithBackend(t *testing.T) {
 		} else {
 			require.NoError(t, ts.mp.err, "case %d", i)
 		}
+		if test.check != nil {
+			test.check(t, ts)
+		}
 		clean()
 	}
 }
@@ -596,3 +619,300 @@ func TestUpgradeBackendCap(t *testing.T) {
 		clean()
 	}
 }
+
+// The backend leg can use a different compression algorithm from the client leg.
+func TestBackendCompressionPolicy(t *testing.T) {
+	tests := []struct {
+		clientCap   pnet.Capability
+		compression string
+		zstdLevel   int
+		expectedCap pnet.Capability
+		expectedLvl int
+	}{
+		{
+			clientCap:   pnet.ClientCompress | pnet.ClientZstdCompressionAlgorithm,
+			compression: config.CompressionNone,
+		},
+		{
+			clientCap:   0,
+			compression: config.CompressionZstd,
+			expectedCap: pnet.ClientZstdCompressionAlgorithm,
+			expectedLvl: defaultZstdLevel,
+		},
+		{
+			clientCap:   pnet.ClientCompress,
+			compression: config.CompressionZstd,
+			zstdLevel:   9,
+			expectedCap: pnet.ClientZstdCompressionAlgorithm,
+			expectedLvl: 9,
+		},
+		{
+			clientCap:   pnet.ClientCompress | pnet.ClientZstdCompressionAlgorithm,
+			compression: config.CompressionZlib,
+			expectedCap: pnet.ClientCompress,
+		},
+		{
+			clientCap:   pnet.ClientCompress,
+			expectedCap: pnet.ClientCompress,
+		},
+	}
+
+	tc := newTCPConnSuite(t)
+	for i, test := range tests {
+		nsCfg := &config.Namespace{
+			Backend: config.BackendNamespace{
+				Compression: test.compression,
+				ZstdLevel:   test.zstdLevel,
+			},
+		}
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.clientConfig.capability &^= compressCaps
+			cfg.clientConfig.capability |= test.clientCap
+			cfg.backendConfig.capability |= compressCaps
+			cfg.proxyConfig.handler.handleHandshakeResp = func(ctx ConnContext, resp *pnet.HandshakeResp) error {
+				ctx.SetValue(ConnContextKeyNamespace, nsCfg)
+				return nil
+			}
+		})
+		checker := func(t *testing.T, ts *testSuite) {
+			require.NoError(t, ts.mp.err, "case %d", i)
+			require.Equal(t, test.clientCap, ts.mc.capability&compressCaps, "case %d", i)
+			require.Equal(t, test.expectedCap, ts.mb.capability&compressCaps, "case %d", i)
+			if test.expectedCap == pnet.ClientZstdCompressionAlgorithm {
+				require.Equal(t, test.expectedLvl, ts.mb.zstdLevel, "case %d", i)
+			}
+		}
+		ts.authenticateFirstTime(t, checker)
+		// The packets are transcoded between the two legs.
+		ts.mc.cmd = pnet.ComQuery
+		ts.mb.respondType = responseTypeResultSet
+		ts.mb.columns, ts.mb.rows = 3, 100
+		ts.executeCmd(t, nil)
+		ts.authenticateSecondTime(t, checker)
+		ts.executeCmd(t, nil)
+		clean()
+	}
+}
+
+// TiProxy verifies the client with the local user store and logs into the backend with the mapped credentials.
+func TestProxyAuth(t *testing.T) {
+	users := []userstore.User{
+		{
+			Name:            "native",
+			Plugin:          pnet.AuthNativePassword,
+			AuthString:      auth.EncodePassword("pwd1"),
+			BackendUser:     "svc",
+			BackendPassword: "svc_pwd",
+		},
+		{
+			Name:            "sha2",
+			Plugin:          pnet.AuthCachingSha2Password,
+			AuthString:      auth.NewHashPassword("pwd2", pnet.AuthCachingSha2Password),
+			BackendPassword: "sha2_pwd",
+		},
+	}
+	store := newUserStore(t, users)
+
+	tests := []struct {
+		user        string
+		password    string
+		plugin      string
+		disableTLS  bool
+		backendUser string
+		backendPwd  string
+	}{
+		{
+			user:        "native",
+			password:    "pwd1",
+			plugin:      pnet.AuthNativePassword,
+			backendUser: "svc",
+			backendPwd:  "svc_pwd",
+		},
+		{
+			// The client is asked to switch the auth plugin.
+			user:        "native",
+			password:    "pwd1",
+			plugin:      pnet.AuthCachingSha2Password,
+			backendUser: "svc",
+			backendPwd:  "svc_pwd",
+		},
+		{
+			user:     "native",
+			password: "wrong",
+			plugin:   pnet.AuthNativePassword,
+		},
+		{
+			user:        "sha2",
+			password:    "pwd2",
+			plugin:      pnet.AuthNativePassword,
+			backendUser: "sha2",
+			backendPwd:  "sha2_pwd",
+		},
+		{
+			// caching_sha2_password requires TLS.
+			user:       "sha2",
+			password:   "pwd2",
+			plugin:     pnet.AuthCachingSha2Password,
+			disableTLS: true,
+		},
+		{
+			user:     "unknown",
+			password: "pwd1",
+			plugin:   pnet.AuthNativePassword,
+		},
+	}
+
+	tc := newTCPConnSuite(t)
+	for i, test := range tests {
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.proxyConfig.bcConfig.UserStore = store
+			cfg.clientConfig.username = test.user
+			cfg.clientConfig.password = test.password
+			cfg.clientConfig.authPlugin = test.plugin
+			if test.disableTLS {
+				cfg.clientConfig.capability &^= pnet.ClientSSL
+			}
+		})
+		ts.authenticateFirstTime(t, func(t *testing.T, ts *testSuite) {
+			if len(test.backendUser) == 0 {
+				require.False(t, ts.mc.authSucceed, "case %d", i)
+				var myErr *mysql.MyError
+				require.ErrorAs(t, ts.mc.mysqlErr, &myErr, "case %d", i)
+				require.Equal(t, uint16(mysql.ER_ACCESS_DENIED_ERROR), myErr.Code, "case %d", i)
+				require.Equal(t, SrcClientAuthFail, Error2Source(ts.mp.err), "case %d", i)
+				return
+			}
+			require.NoError(t, ts.mp.err, "case %d", i)
+			require.True(t, ts.mc.authSucceed, "case %d", i)
+			require.Equal(t, test.backendUser, ts.mb.username, "case %d", i)
+			// The mock backend performs the caching_sha2_password full authentication.
+			require.Equal(t, append([]byte(test.backendPwd), 0), ts.mb.authData, "case %d", i)
+			require.Equal(t, test.backendUser, ts.mp.authenticator.user, "case %d", i)
+		})
+		clean()
+	}
+}
+
+// A nonexistent user goes through the same packets as an existing user so that the clients can't tell whether the
+// user exists.
+func TestProxyAuthUnknownUser(t *testing.T) {
+	store := newUserStore(t, []userstore.User{
+		{
+			Name:       pnet.AuthNativePassword,
+			Plugin:     pnet.AuthNativePassword,
+			AuthString: auth.EncodePassword("pwd1"),
+		},
+		{
+			Name:       pnet.AuthCachingSha2Password,
+			Plugin:     pnet.AuthCachingSha2Password,
+			AuthString: auth.NewHashPassword("pwd2", pnet.AuthCachingSha2Password),
+		},
+	})
+	// Find a nonexistent user for each plugin.
+	unknownUsers := make(map[string]string, 2)
+	for i := 0; len(unknownUsers) < 2; i++ {
+		name := fmt.Sprintf("unknown%d", i)
+		plugin := unknownUser(name).Plugin
+		if _, ok := unknownUsers[plugin]; !ok {
+			unknownUsers[plugin] = name
+		}
+		require.Equal(t, plugin, unknownUser(name).Plugin)
+	}
+
+	tc := newTCPConnSuite(t)
+	for existingUser, unknown := range unknownUsers {
+		var packets [][2]uint64
+		for _, user := range []string{existingUser, unknown} {
+			ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+				cfg.proxyConfig.bcConfig.UserStore = store
+				cfg.clientConfig.username = user
+				cfg.clientConfig.password = "wrong"
+				cfg.clientConfig.authPlugin = pnet.AuthNativePassword
+			})
+			ts.authenticateFirstTime(t, func(t *testing.T, ts *testSuite) {
+				require.False(t, ts.mc.authSucceed, user)
+				require.Equal(t, SrcClientAuthFail, Error2Source(ts.mp.err), user)
+				packets = append(packets, [2]uint64{ts.tc.clientIO.InPackets(), ts.tc.clientIO.OutPackets()})
+			})
+			clean()
+		}
+		require.Equal(t, packets[0], packets[1], existingUser)
+	}
+}
+
+// COM_CHANGE_USER is verified by the local user store and mapped to the backend user in the proxy-auth mode.
+func TestChangeUserWithProxyAuth(t *testing.T) {
+	store := newUserStore(t, []userstore.User{
+		{
+			Name:            "native",
+			Plugin:          pnet.AuthNativePassword,
+			AuthString:      auth.EncodePassword("pwd1"),
+			BackendUser:     "svc",
+			BackendPassword: "svc_pwd",
+		},
+	})
+	tests := []struct {
+		user        string
+		password    string
+		backendUser string
+	}{
+		{
+			user:        "native",
+			password:    "pwd1",
+			backendUser: "svc",
+		},
+		{
+			user:     "native",
+			password: "wrong",
+		},
+		{
+			// The backend user can't be used directly.
+			user:     "svc",
+			password: "svc_pwd",
+		},
+		{
+			user:     "unknown",
+			password: "pwd1",
+		},
+	}
+
+	tc := newTCPConnSuite(t)
+	for i, test := range tests {
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.clientConfig.cmd = pnet.ComChangeUser
+			cfg.clientConfig.username = test.user
+			cfg.clientConfig.password = test.password
+			cfg.backendConfig.respondType = responseTypeOK
+		})
+		ts.mp.cmdProcessor.userStore = store
+		var backendRunner func(pnet.PacketIO) error
+		if len(test.backendUser) > 0 {
+			backendRunner = ts.mb.respond
+		}
+		ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+			require.NoError(t, ts.mc.err, "case %d", i)
+			if len(test.backendUser) > 0 {
+				require.NoError(t, ts.mp.err, "case %d", i)
+				require.Nil(t, ts.mc.mysqlErr, "case %d", i)
+				require.Equal(t, test.backendUser, ts.mb.username, "case %d", i)
+				require.Equal(t, test.backendUser, ts.mp.cmdProcessor.changedUser, "case %d", i)
+				return
+			}
+			var myErr *mysql.MyError
+			require.ErrorAs(t, ts.mc.mysqlErr, &myErr, "case %d", i)
+			require.Equal(t, uint16(mysql.ER_ACCESS_DENIED_ERROR), myErr.Code, "case %d", i)
+		}, ts.mc.request, backendRunner, ts.mp.processCmd)
+		clean()
+	}
+}
+
+func newUserStore(t *testing.T, users []userstore.User) *userstore.Store {
+	data, err := json.Marshal(users)
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "users.json")
+	require.NoError(t, os.WriteFile(file, data, 0600))
+	lg, _ := logger.CreateLoggerForTest(t)
+	store := userstore.NewStore(lg)
+	store.Reset(config.ProxyAuth{Enable: true, UserFile: file})
+	return store
+}
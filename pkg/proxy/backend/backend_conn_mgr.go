@@ -24,8 +24,16 @@ import (
 	"github.com/pingcap/tiproxy/lib/util/errors"
 	"github.com/pingcap/tiproxy/lib/util/waitgroup"
 	"github.com/pingcap/tiproxy/pkg/balance/router"
+	"github.com/pingcap/tiproxy/pkg/manager/namespace"
+	"github.com/pingcap/tiproxy/pkg/proxy/audit"
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/resultcache"
+	"github.com/pingcap/tiproxy/pkg/proxy/rule"
+	"github.com/pingcap/tiproxy/pkg/proxy/stmtsummary"
+	"github.com/pingcap/tiproxy/pkg/proxy/userstore"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 	"github.com/siddontang/go/hack"
 	"go.uber.org/zap"
 )
@@ -95,6 +103,18 @@ type BCConfig struct {
 	ConnBufferSize       int
 	ProxyProtocol        bool
 	RequireBackendTLS    bool
+	CertAuth             config.CertAuth
+	// QueryRules, Firewall, Audit, UserStore, ResultCache, StmtSummary and ProcessLister are shared by all
+	// connections. They may be nil.
+	QueryRules    *rule.Engine
+	Firewall      *firewall.Firewall
+	Audit         *audit.Auditor
+	UserStore     *userstore.Store
+	ResultCache   *resultcache.Cache
+	StmtSummary   *stmtsummary.Summary
+	ProcessLister ProcessLister
+	// DigestResult computes the result of each command, which is used by traffic replay to compare results.
+	DigestResult bool
 }
 
 func (cfg *BCConfig) check() {
@@ -136,10 +156,43 @@ type BackendConnManager struct {
 	redirectResCh chan *redirectResult
 	// GracefulClose() sets it without lock.
 	closeStatus atomic.Int32
+	// closeCh is closed once the manager starts closing.
+	closeCh chan struct{}
 	// The last time when the backend is active.
 	lastActiveTime time.Time
+	// The last time when the client sends a command, used for checking idle timeout.
+	lastCmdTime time.Time
+	// The time when the connection is established, used for checking max lifetime.
+	connectTime time.Time
+	// idleTimeout and maxLifetime are read from the namespace config after the handshake.
+	idleTimeout, maxLifetime time.Duration
+	// maintenance is the maintenance state of the namespace. It may be nil.
+	maintenance *namespace.MaintenanceState
+	// failoverEnabled and checkpointInterval are read from the namespace config.
+	failoverEnabled    bool
+	checkpointInterval time.Duration
+	// snapshot is nil if the session states may be outdated. snapshotDirty means it should be refreshed by the next
+	// checkpoint outside a transaction. snapshotTime is the last time when it's refreshed.
+	snapshot      *sessionSnapshot
+	snapshotDirty bool
+	snapshotTime  time.Time
+	// maxExecutionTime kills the statement through a side connection authenticated by adminUser.
+	maxExecutionTime         time.Duration
+	adminUser, adminPassword string
+	// stmtKiller kills the running statement once it exceeds the limits.
+	stmtKiller stmtKiller
+	// auditCfg is nil if the session is not audited.
+	auditCfg *audit.SessionConfig
+	// processState is the state of the session shown in the processlist.
+	processState processState
+	// preparedStmts maps the statement IDs to the prepared statements for the statement summary and the audit log.
+	preparedStmts map[uint32]string
+	// lastResult is the result of the last command if the result digest is enabled.
+	lastResult *cmd.Result
 	// The traffic recorded last time.
 	inBytes, inPackets, outBytes, outPackets uint64
+	// The compression statistics recorded last time.
+	clientCompress, backendCompress pnet.CompressionStats
 	// cancelFunc is used to cancel the signal processing goroutine.
 	cancelFunc context.CancelFunc
 	clientIO   pnet.PacketIO
@@ -153,7 +206,9 @@ type BackendConnManager struct {
 	}
 	connectionID uint64
 	quitSource   ErrorSource
-	cpt          capture.Capture
+	// closeSource is the quit source after the connection is gracefully closed.
+	closeSource ErrorSource
+	cpt         capture.Capture
 }
 
 // NewBackendConnManager creates a BackendConnManager.
@@ -163,15 +218,18 @@ func NewBackendConnManager(logger *zap.Logger, handshakeHandler HandshakeHandler
 		logger:           logger,
 		config:           config,
 		connectionID:     connectionID,
-		cmdProcessor:     NewCmdProcessor(logger.Named("cp")),
+		cmdProcessor:     NewCmdProcessor(logger.Named("cp"), config),
 		handshakeHandler: handshakeHandler,
 		authenticator:    NewAuthenticator(config),
 		// There are 2 types of signals, which may be sent concurrently.
 		signalReceived: make(chan signalType, signalTypeNums),
 		redirectResCh:  make(chan *redirectResult, 1),
+		closeCh:        make(chan struct{}),
 		quitSource:     SrcNone,
+		closeSource:    SrcProxyQuit,
 		cpt:            cpt,
 	}
+	mgr.cmdProcessor.onResultLimit = mgr.onResultLimit
 	mgr.ctxmap.m = make(map[any]any)
 	mgr.SetValue(ConnContextKeyConnID, connectionID)
 	return mgr
@@ -185,6 +243,20 @@ func (mgr *BackendConnManager) ConnectionID() uint64 {
 
 // Connect connects to the first backend and then start watching redirection signals.
 func (mgr *BackendConnManager) Connect(ctx context.Context, clientIO pnet.PacketIO, frontendTLSConfig, backendTLSConfig *tls.Config, username, password string) error {
+	var cred *Credential
+	if len(username) > 0 {
+		cred = &Credential{User: username, Password: password}
+	}
+	return mgr.connect(ctx, clientIO, frontendTLSConfig, backendTLSConfig, cred)
+}
+
+// ConnectWithCredential connects to the backend as a fake client with the credential, which is used for replaying traffic.
+func (mgr *BackendConnManager) ConnectWithCredential(ctx context.Context, clientIO pnet.PacketIO, backendTLSConfig *tls.Config, cred Credential) error {
+	return mgr.connect(ctx, clientIO, nil, backendTLSConfig, &cred)
+}
+
+// connect handshakes with the real client if cred is nil.
+func (mgr *BackendConnManager) connect(ctx context.Context, clientIO pnet.PacketIO, frontendTLSConfig, backendTLSConfig *tls.Config, cred *Credential) error {
 	mgr.processLock.Lock()
 	defer mgr.processLock.Unlock()
 
@@ -197,12 +269,12 @@ func (mgr *BackendConnManager) Connect(ctx context.Context, clientIO pnet.Packet
 	}
 	startTime := time.Now()
 	var err error
-	if len(username) == 0 {
+	if cred == nil {
 		// real client
 		err = mgr.authenticator.handshakeFirstTime(ctx, mgr.logger.Named("authenticator"), mgr, clientIO, mgr.handshakeHandler, mgr.getBackendIO, frontendTLSConfig, backendTLSConfig)
 	} else {
 		// fake client, used for replaying traffic
-		err = mgr.authenticator.handshakeWithBackend(ctx, mgr.logger.Named("authenticator"), mgr, mgr.handshakeHandler, username, password, mgr.getBackendIO, backendTLSConfig)
+		err = mgr.authenticator.handshakeWithBackend(ctx, mgr.logger.Named("authenticator"), mgr, mgr.handshakeHandler, *cred, mgr.getBackendIO, backendTLSConfig)
 	}
 	if err != nil {
 		src := Error2Source(err)
@@ -220,13 +292,43 @@ func (mgr *BackendConnManager) Connect(ctx context.Context, clientIO pnet.Packet
 	endTime := time.Now()
 	addHandshakeMetrics(mgr.ServerAddr(), endTime.Sub(startTime))
 	mgr.updateTraffic(*mgr.backendIO.Load())
+	mgr.updateClientCompression()
 
 	mgr.cmdProcessor.capability = mgr.authenticator.capability
 	childCtx, cancelFunc := context.WithCancel(ctx)
 	mgr.cancelFunc = cancelFunc
 	mgr.lastActiveTime = endTime
+	mgr.lastCmdTime, mgr.connectTime = endTime, endTime
+	mgr.cmdProcessor.ruleScope.User = mgr.authenticator.user
+	mgr.cmdProcessor.curDB = mgr.authenticator.dbname
+	mgr.cmdProcessor.collation = mgr.authenticator.collation
+	mgr.cmdProcessor.updateCacheVars()
+	if mgr.authenticator.certIdentity != nil {
+		mgr.cmdProcessor.certUser = mgr.authenticator.certIdentity.User
+	}
+	if mgr.authenticator.proxyAuth {
+		mgr.cmdProcessor.userStore = mgr.authenticator.userStore
+	}
+	if nsCfg, ok := mgr.Value(ConnContextKeyNamespace).(*config.Namespace); ok && nsCfg != nil {
+		mgr.idleTimeout = time.Duration(nsCfg.Frontend.IdleTimeout) * time.Second
+		mgr.maxLifetime = time.Duration(nsCfg.Frontend.MaxLifetime) * time.Second
+		mgr.cmdProcessor.ruleScope.Namespace = nsCfg.Namespace
+		mgr.cmdProcessor.maxResultRows = nsCfg.Frontend.MaxResultRows
+		mgr.cmdProcessor.maxResultBytes = nsCfg.Frontend.MaxResultBytes
+		mgr.cmdProcessor.loadDataDeny = nsCfg.Frontend.LoadDataLocal == config.LoadDataLocalDeny
+		mgr.cmdProcessor.maxLoadDataBytes = nsCfg.Frontend.MaxLoadDataBytes
+		mgr.maxExecutionTime = time.Duration(nsCfg.Frontend.MaxExecutionTime) * time.Second
+		mgr.adminUser, mgr.adminPassword = nsCfg.Backend.AdminUser, nsCfg.Backend.AdminPassword
+		mgr.failoverEnabled = nsCfg.Backend.Failover
+		mgr.checkpointInterval = time.Duration(nsCfg.Backend.CheckpointInterval) * time.Second
+	}
+	mgr.updateProcessState(nil, endTime)
+	// The first snapshot is saved by the checkpoint in the background.
+	mgr.snapshotDirty = mgr.failoverEnabled
+	mgr.initAudit()
+	mgr.auditConnect(endTime)
 	if mgr.cpt != nil && !reflect.ValueOf(mgr.cpt).IsNil() {
-		mgr.cpt.InitConn(endTime, mgr.connectionID, mgr.authenticator.dbname)
+		mgr.cpt.InitConn(endTime, mgr.connectionID, (*captureSession)(mgr).ConnInfo())
 	}
 	mgr.wg.RunWithRecover(func() {
 		mgr.processSignals(childCtx)
@@ -238,13 +340,13 @@ func (mgr *BackendConnManager) Connect(ctx context.Context, clientIO pnet.Packet
 	return nil
 }
 
-func (mgr *BackendConnManager) newExponentialBackOff() *backoff.ExponentialBackOff {
+func (mgr *BackendConnManager) newExponentialBackOff(maxElapsedTime time.Duration) *backoff.ExponentialBackOff {
 	b := &backoff.ExponentialBackOff{
 		InitialInterval:     defaultExponentialBackOffInitialInterval,
 		RandomizationFactor: defaultExponentialBackOffRandomizationFactor,
 		Multiplier:          defaultExponentialBackOffMultiplier,
 		MaxInterval:         defaultExponentionBackOffMaxInterval,
-		MaxElapsedTime:      mgr.config.ConnectTimeout,
+		MaxElapsedTime:      maxElapsedTime,
 		Stop:                backoff.Stop,
 		Clock:               backoff.SystemClock,
 	}
@@ -257,10 +359,16 @@ func (mgr *BackendConnManager) getBackendIO(ctx context.Context, cctx ConnContex
 	if err != nil {
 		return nil, errors.Wrap(err, ErrProxyErr)
 	}
+	holdTime, err := mgr.checkMaintenance(cctx)
+	if err != nil {
+		return nil, err
+	}
 	// Reasons to wait:
 	// - The TiDB instances may not be initialized yet
 	// - One TiDB may be just shut down and another is just started but not ready yet
-	bctx, cancel := context.WithTimeout(ctx, mgr.config.ConnectTimeout)
+	// - The namespace is under maintenance and the connection is held until a backend is available
+	connectTimeout := max(mgr.config.ConnectTimeout, holdTime)
+	bctx, cancel := context.WithTimeout(ctx, connectTimeout)
 	selector := r.GetBackendSelector()
 	startTime := time.Now()
 	var addr string
@@ -276,24 +384,18 @@ func (mgr *BackendConnManager) getBackendIO(ctx context.Context, cctx ConnContex
 				return nil, backoff.Permanent(errors.Wrap(err, ErrProxyErr))
 			}
 
-			var cn net.Conn
 			addr = backend.Addr()
-			cn, err = net.DialTimeout("tcp", addr, DialTimeout)
+			backendIO, err := mgr.dialBackend(addr)
 			selector.Finish(mgr, err == nil)
 			if err != nil {
 				return nil, errors.Wrap(errors.Wrapf(err, "dial backend %s error", addr), ErrBackendHandshake)
 			}
-
-			// NOTE: should use DNS name as much as possible
-			// Usually certs are signed with domain instead of IP addrs
-			// And `RemoteAddr()` will return IP addr
-			backendIO := pnet.PacketIO(pnet.NewPacketIO(cn, mgr.logger, mgr.config.ConnBufferSize, pnet.WithRemoteAddr(addr, cn.RemoteAddr()), pnet.WithWrapError(ErrBackendConn)))
 			mgr.backendIO.Store(&backendIO)
 			mgr.curBackend = backend
 			mgr.setKeepAlive()
 			return backendIO, nil
 		},
-		backoff.WithContext(mgr.newExponentialBackOff(), bctx),
+		backoff.WithContext(mgr.newExponentialBackOff(connectTimeout), bctx),
 		func(err error, d time.Duration) {
 			origErr = err
 			mgr.handshakeHandler.OnHandshake(cctx, addr, err, Error2Source(err))
@@ -314,22 +416,68 @@ func (mgr *BackendConnManager) getBackendIO(ctx context.Context, cctx ConnContex
 			err = origErr
 		}
 	}
+	if err != nil && holdTime > 0 {
+		err = mgr.holdFailed(err)
+	}
 	return io, err
 }
 
+// dialBackend connects to the backend. The proxy header and the handshake are not sent yet.
+func (mgr *BackendConnManager) dialBackend(addr string) (pnet.PacketIO, error) {
+	cn, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: should use DNS name as much as possible
+	// Usually certs are signed with domain instead of IP addrs
+	// And `RemoteAddr()` will return IP addr
+	return pnet.NewPacketIO(cn, mgr.logger, mgr.config.ConnBufferSize, pnet.WithRemoteAddr(addr, cn.RemoteAddr()), pnet.WithWrapError(ErrBackendConn)), nil
+}
+
 // ExecuteCmd forwards messages between the client and the backend.
 // If it finds that the session is ready for redirection, it migrates the session.
 func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (err error) {
-	startTime := time.Now()
-	if mgr.cpt != nil && !reflect.ValueOf(mgr.cpt).IsNil() {
-		mgr.cpt.Capture(request, startTime, mgr.connectionID, mgr.initForCapture)
+	// Hold the command before locking so that the session can still be closed or redirected.
+	// It's also held before capturing so that the rejected commands are not captured.
+	var maintenanceErr *mysql.MyError
+	if len(request) > 0 {
+		maintenanceErr = mgr.holdForMaintenance(ctx, pnet.Command(request[0]))
 	}
+	startTime := time.Now()
+	pending := capture.PendingNone
+	if maintenanceErr == nil && mgr.cpt != nil && !reflect.ValueOf(mgr.cpt).IsNil() {
+		pending = mgr.cpt.Capture(request, startTime, mgr.connectionID, (*captureSession)(mgr))
+	}
+	// Capturing may query the session states, which is not counted in the execution duration.
+	execStartTime := time.Now()
+	// The delay of query rules is also applied before locking.
+	matchedRule := mgr.cmdProcessor.matchRules(request)
+	if matchedRule != nil && matchedRule.Action == config.RuleActionDelay && maintenanceErr == nil {
+		mgr.delay(ctx, matchedRule.Delay)
+	}
+	// The file of LOAD DATA LOCAL INFILE is captured after the statement finishes, and capturing may query the
+	// session states, so it must be called after unlocking.
+	var loadDataHash string
+	defer func() {
+		if pending != capture.PendingNone {
+			mgr.cpt.CaptureResult(mgr.connectionID, err == nil, time.Since(execStartTime), mgr.lastResult)
+		}
+		if loadDataHash != "" && mgr.cpt != nil && !reflect.ValueOf(mgr.cpt).IsNil() {
+			mgr.cpt.CaptureLoadData(request, startTime, mgr.connectionID, (*captureSession)(mgr), loadDataHash)
+		}
+	}()
 	mgr.processLock.Lock()
+	mgr.cmdProcessor.setMatchedRule(matchedRule)
+	mgr.lastResult = nil
+	mgr.cmdProcessor.digestResult = pending == capture.PendingResult || mgr.config.DigestResult
+	stmtCounters := mgr.newStmtCounters()
+	mgr.updateProcessState(request, startTime)
 	defer func() {
 		if err != nil && !pnet.IsMySQLError(err) {
 			mgr.setQuitSourceByErr(err)
 		}
 		mgr.handshakeHandler.OnTraffic(mgr)
+		mgr.updateClientCompression()
 		now := time.Now()
 		if err != nil && errors.Is(err, ErrBackendConn) {
 			cmd, data := pnet.Command(request[0]), request[1:]
@@ -346,6 +494,13 @@ func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (
 				zap.Duration("execute_time", now.Sub(startTime)), zap.Stringer("cmd", cmd), zap.String("query", query))
 		}
 		mgr.lastActiveTime = now
+		mgr.lastCmdTime = now
+		if len(request) > 0 {
+			mgr.auditStatement(request, startTime, now, err)
+			mgr.addStmtSummary(request, startTime, now, stmtCounters, err)
+			mgr.trackPreparedStmts(request, err)
+		}
+		mgr.updateProcessState(nil, now)
 		mgr.processLock.Unlock()
 	}()
 	if len(request) < 1 {
@@ -358,14 +513,32 @@ func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (
 	if mgr.closeStatus.Load() >= statusClosing {
 		return
 	}
+	if maintenanceErr != nil {
+		if err = mgr.clientIO.WritePacket(pnet.MakeErrPacket(maintenanceErr), true); err != nil {
+			return
+		}
+		err = maintenanceErr
+		return
+	}
 	waitingRedirect := mgr.redirectInfo.Load() != nil
 	var holdRequest bool
 	backendIO := *mgr.backendIO.Load()
+	inTxn, clientOutPackets := !mgr.cmdProcessor.finishedTxn(), mgr.clientIO.OutPackets()
+	stopStmt := mgr.startStatement(cmd)
 	holdRequest, err = mgr.cmdProcessor.executeCmd(request, mgr.clientIO, backendIO, waitingRedirect)
+	stopStmt()
+	loadDataHash = mgr.cmdProcessor.loadDataHash
 	if !holdRequest {
-		addCmdMetrics(cmd, backendIO.RemoteAddr().String(), startTime)
+		addCmdMetrics(cmd, backendIO.RemoteAddr().String(), startTime, err)
 		mgr.updateTraffic(backendIO)
 	}
+	if err != nil && !pnet.IsMySQLError(err) && errors.Is(err, ErrBackendConn) {
+		err = mgr.failover(ctx, request, inTxn, mgr.clientIO.OutPackets() != clientOutPackets, err)
+		stmtCounters.setBackend(*mgr.backendIO.Load())
+	}
+	if mgr.cmdProcessor.digestResult {
+		mgr.lastResult = mgr.cmdProcessor.result()
+	}
 	if err != nil {
 		if !pnet.IsMySQLError(err) {
 			return
@@ -393,7 +566,10 @@ func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (
 		case pnet.ComChangeUser:
 			// Critical errors should not happen because CmdProcessor has parsed it already.
 			req, _ := pnet.ParseChangeUser(request, mgr.authenticator.capability)
+			req.User = mgr.cmdProcessor.changedUser
 			mgr.authenticator.changeUser(req)
+			mgr.cmdProcessor.ruleScope.User = req.User
+			mgr.initAudit()
 		}
 	}
 	// Even if it meets an MySQL error, it may have changed the status, such as when executing multi-statements.
@@ -407,17 +583,47 @@ func (mgr *BackendConnManager) ExecuteCmd(ctx context.Context, request []byte) (
 	// Execute the held request no matter redirection succeeds or not.
 	if holdRequest && mgr.closeStatus.Load() < statusNotifyClose {
 		backendIO = *mgr.backendIO.Load()
+		stopStmt = mgr.startStatement(cmd)
 		_, err = mgr.cmdProcessor.executeCmd(request, mgr.clientIO, backendIO, false)
-		addCmdMetrics(cmd, backendIO.RemoteAddr().String(), startTime)
+		stopStmt()
+		if mgr.cmdProcessor.digestResult {
+			mgr.lastResult = mgr.cmdProcessor.result()
+		}
+		stmtCounters.setBackend(backendIO)
+		addCmdMetrics(cmd, backendIO.RemoteAddr().String(), startTime, err)
 		mgr.updateTraffic(backendIO)
 	}
+	if err == nil || pnet.IsMySQLError(err) {
+		mgr.updateSnapshot(request)
+	}
 	return
 }
 
+// delay waits for the delay of a query rule. It's called without holding processLock so that the session can still be
+// redirected or closed during the delay.
+func (mgr *BackendConnManager) delay(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-mgr.closeCh:
+	case <-ctx.Done():
+	}
+}
+
 func (mgr *BackendConnManager) updateTraffic(backendIO pnet.PacketIO) {
 	inBytes, inPackets, outBytes, outPackets := backendIO.InBytes(), backendIO.InPackets(), backendIO.OutBytes(), backendIO.OutPackets()
 	addTraffic(backendIO.RemoteAddr().String(), inBytes-mgr.inBytes, inPackets-mgr.inPackets, outBytes-mgr.outBytes, outPackets-mgr.outPackets, mgr.curBackend.Local())
 	mgr.inBytes, mgr.inPackets, mgr.outBytes, mgr.outPackets = inBytes, inPackets, outBytes, outPackets
+	mgr.backendCompress = addCompressionStats(compressLegBackend, backendIO.CompressionStats(), mgr.backendCompress)
+}
+
+// updateClientCompression reports the compression cost of the client connection. The client connection updates the
+// stats when reading packets without holding processLock, so it must only be called in the client goroutine.
+func (mgr *BackendConnManager) updateClientCompression() {
+	if mgr.clientIO != nil {
+		mgr.clientCompress = addCompressionStats(compressLegFrontend, mgr.clientIO.CompressionStats(), mgr.clientCompress)
+	}
 }
 
 // SetEventReceiver implements RedirectableConn.SetEventReceiver interface.
@@ -456,7 +662,25 @@ func (mgr *BackendConnManager) querySessionStates(backendIO pnet.PacketIO) (sess
 	return
 }
 
-func (mgr *BackendConnManager) initForCapture() (string, error) {
+// captureSession exposes the connection to the traffic capture.
+type captureSession BackendConnManager
+
+var _ capture.Session = (*captureSession)(nil)
+
+// ConnInfo is called in the goroutine of the connection, so it needn't lock.
+func (cs *captureSession) ConnInfo() capture.ConnInfo {
+	mgr := (*BackendConnManager)(cs)
+	return capture.ConnInfo{
+		User:       mgr.cmdProcessor.ruleScope.User,
+		DB:         mgr.cmdProcessor.curDB,
+		Namespace:  mgr.cmdProcessor.ruleScope.Namespace,
+		ClientAddr: mgr.ClientAddr(),
+		Attrs:      mgr.authenticator.attrs,
+	}
+}
+
+func (cs *captureSession) InitSession() (string, error) {
+	mgr := (*BackendConnManager)(cs)
 	mgr.processLock.Lock()
 	defer mgr.processLock.Unlock()
 	if mgr.closeStatus.Load() >= statusClosing {
@@ -498,7 +722,9 @@ func (mgr *BackendConnManager) processSignals(ctx context.Context) {
 			mgr.notifyRedirectResult(ctx, rs)
 		case <-checkBackendTicker.C:
 			func() {
-				mgr.checkBackendActive()
+				mgr.checkBackendActive(ctx)
+				mgr.checkSessionExpired(ctx)
+				mgr.checkpoint()
 				mgr.processLock.Lock()
 				defer mgr.processLock.Unlock()
 				mgr.setKeepAlive()
@@ -530,6 +756,10 @@ func (mgr *BackendConnManager) tryRedirect(ctx context.Context) {
 	defer func() {
 		// The `mgr` won't be notified again before it calls `OnRedirectSucceed`, so simply `StorePointer` is also fine.
 		mgr.redirectInfo.Store(nil)
+		// The redirection is skipped if it's closing.
+		if rs.err != nil || mgr.ServerAddr() == rs.to {
+			mgr.auditMigration(rs)
+		}
 		// Notifying may block. Notify the receiver asynchronously to:
 		// - Reduce the latency of session migration
 		// - Avoid the risk of deadlock
@@ -565,13 +795,11 @@ func (mgr *BackendConnManager) tryRedirect(ctx context.Context) {
 		return
 	}
 
-	var cn net.Conn
-	cn, rs.err = net.DialTimeout("tcp", rs.to, DialTimeout)
-	if rs.err != nil {
+	var newBackendIO pnet.PacketIO
+	if newBackendIO, rs.err = mgr.dialBackend(rs.to); rs.err != nil {
 		mgr.handshakeHandler.OnHandshake(mgr, rs.to, rs.err, SrcBackendNetwork)
 		return
 	}
-	newBackendIO := pnet.PacketIO(pnet.NewPacketIO(cn, mgr.logger, mgr.config.ConnBufferSize, pnet.WithRemoteAddr(rs.to, cn.RemoteAddr()), pnet.WithWrapError(ErrBackendConn)))
 
 	if rs.err = mgr.authenticator.handshakeSecondTime(mgr.logger, mgr.clientIO, newBackendIO, mgr.backendTLS, sessionToken); rs.err == nil {
 		rs.err = mgr.initSessionStates(newBackendIO, sessionStates)
@@ -586,6 +814,7 @@ func (mgr *BackendConnManager) tryRedirect(ctx context.Context) {
 	}
 	mgr.updateTraffic(backendIO)
 	mgr.inBytes, mgr.inPackets, mgr.outBytes, mgr.outPackets = 0, 0, 0, 0
+	mgr.backendCompress = pnet.CompressionStats{}
 	mgr.updateTraffic(newBackendIO)
 	if ignoredErr := backendIO.Close(); ignoredErr != nil && !pnet.IsDisconnectError(ignoredErr) {
 		mgr.logger.Error("close previous backend connection failed", zap.Error(ignoredErr))
@@ -594,6 +823,9 @@ func (mgr *BackendConnManager) tryRedirect(ctx context.Context) {
 	mgr.curBackend = *backendInst
 	mgr.setKeepAlive()
 	mgr.handshakeHandler.OnHandshake(mgr, mgr.ServerAddr(), nil, SrcNone)
+	if mgr.failoverEnabled {
+		mgr.snapshot = &sessionSnapshot{states: sessionStates, token: sessionToken}
+	}
 }
 
 // The original db in the auth info may be dropped during the session, so we need to authenticate with the current db.
@@ -657,7 +889,7 @@ func (mgr *BackendConnManager) tryGracefulClose(ctx context.Context) {
 	if !mgr.cmdProcessor.finishedTxn() {
 		return
 	}
-	mgr.quitSource = SrcProxyQuit
+	mgr.quitSource = mgr.closeSource
 	// Closing clientIO will cause the whole connection to be closed.
 	if err := mgr.clientIO.GracefulClose(); err != nil {
 		mgr.logger.Warn("graceful close client IO error", zap.Stringer("client_addr", mgr.clientIO.RemoteAddr()), zap.Error(err))
@@ -665,7 +897,39 @@ func (mgr *BackendConnManager) tryGracefulClose(ctx context.Context) {
 	mgr.closeStatus.CompareAndSwap(statusNotifyClose, statusClosing)
 }
 
-func (mgr *BackendConnManager) checkBackendActive() {
+// checkSessionExpired gracefully closes the connection if it's idle or alive for too long, or the namespace is under
+// maintenance and requires closing existing sessions.
+// The check runs every TickerInterval, so the connection may live a little longer than the limit.
+func (mgr *BackendConnManager) checkSessionExpired(ctx context.Context) {
+	mgr.processLock.Lock()
+	defer mgr.processLock.Unlock()
+
+	if mgr.closeStatus.Load() >= statusNotifyClose {
+		return
+	}
+	now := time.Now()
+	var src ErrorSource
+	switch {
+	case mgr.idleTimeout > 0 && now.Sub(mgr.lastCmdTime) >= mgr.idleTimeout:
+		src = SrcIdleTimeout
+	case mgr.maxLifetime > 0 && now.Sub(mgr.connectTime) >= mgr.maxLifetime:
+		src = SrcMaxLifetime
+	case mgr.shouldCloseForMaintenance():
+		src = SrcMaintenance
+	default:
+		return
+	}
+	if !mgr.closeStatus.CompareAndSwap(statusActive, statusNotifyClose) {
+		return
+	}
+	mgr.closeSource = src
+	mgr.logger.Info("session expires, close the connection", zap.Stringer("reason", src),
+		zap.Duration("idle_time", now.Sub(mgr.lastCmdTime)), zap.Duration("lifetime", now.Sub(mgr.connectTime)))
+	// If the session is in a transaction, it will be closed after the transaction finishes.
+	mgr.tryGracefulClose(ctx)
+}
+
+func (mgr *BackendConnManager) checkBackendActive(ctx context.Context) {
 	mgr.processLock.Lock()
 	defer mgr.processLock.Unlock()
 
@@ -678,6 +942,11 @@ func (mgr *BackendConnManager) checkBackendActive() {
 	}
 	backendIO := *mgr.backendIO.Load()
 	if !backendIO.IsPeerActive() {
+		// Restore the idle session on another backend instead of dropping it.
+		if mgr.cmdProcessor.finishedTxn() && mgr.restoreSession(ctx, errors.New("backend connection is closed")) {
+			mgr.lastActiveTime = now
+			return
+		}
 		mgr.logger.Info("backend connection is closed, close client connection",
 			zap.Stringer("client_addr", mgr.clientIO.RemoteAddr()), zap.Stringer("backend_addr", backendIO.RemoteAddr()),
 			zap.Bool("backend_healthy", mgr.curBackend.Healthy()))
@@ -691,6 +960,13 @@ func (mgr *BackendConnManager) checkBackendActive() {
 	}
 }
 
+// LastResult returns the result of the last command if the result digest is enabled. It's nil if the result is unknown.
+func (mgr *BackendConnManager) LastResult() *cmd.Result {
+	mgr.processLock.Lock()
+	defer mgr.processLock.Unlock()
+	return mgr.lastResult
+}
+
 func (mgr *BackendConnManager) ClientAddr() string {
 	if mgr.clientIO == nil {
 		return ""
@@ -750,6 +1026,7 @@ func (mgr *BackendConnManager) Close() error {
 	}
 
 	mgr.closeStatus.Store(statusClosing)
+	close(mgr.closeCh)
 	if mgr.cancelFunc != nil {
 		mgr.cancelFunc()
 		mgr.cancelFunc = nil
@@ -757,6 +1034,7 @@ func (mgr *BackendConnManager) Close() error {
 
 	// OnConnClose may read ServerAddr(), so call it before closing backendIO.
 	handErr := mgr.handshakeHandler.OnConnClose(mgr, mgr.quitSource)
+	mgr.auditDisconnect(time.Now())
 
 	var connErr error
 	var addr string
@@ -778,9 +1056,9 @@ func (mgr *BackendConnManager) Close() error {
 			}
 		}
 	}
-	// Maybe it's unexpectedly closing without a QUIT command, explicitly add one.
+	// Maybe it's unexpectedly closing without a QUIT command, the capture adds one.
 	if mgr.cpt != nil && !reflect.ValueOf(mgr.cpt).IsNil() {
-		mgr.cpt.Capture([]byte{pnet.ComQuit.Byte()}, time.Now(), mgr.connectionID, nil)
+		mgr.cpt.CloseConn(time.Now(), mgr.connectionID)
 	}
 	mgr.closeStatus.Store(statusClosed)
 	return errors.Collect(ErrCloseConnMgr, connErr, handErr)
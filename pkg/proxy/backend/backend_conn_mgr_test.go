@@ -5,20 +5,30 @@ package backend
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
 	"github.com/pingcap/tiproxy/lib/util/errors"
 	"github.com/pingcap/tiproxy/lib/util/logger"
 	"github.com/pingcap/tiproxy/lib/util/waitgroup"
 	"github.com/pingcap/tiproxy/pkg/balance/router"
 	"github.com/pingcap/tiproxy/pkg/metrics"
+	"github.com/pingcap/tiproxy/pkg/proxy/audit"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/rule"
+	"github.com/pingcap/tiproxy/pkg/proxy/stmtsummary"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
@@ -805,6 +815,91 @@ func TestGracefulCloseBeforeHandshake(t *testing.T) {
 	ts.runTests(runners)
 }
 
+func TestSessionExpired(t *testing.T) {
+	nsCfg := &config.Namespace{
+		Frontend: config.FrontendNamespace{
+			IdleTimeout: 100,
+			MaxLifetime: 200,
+		},
+	}
+	setNamespace := func(config *testConfig) {
+		config.proxyConfig.bcConfig.TickerInterval = time.Millisecond
+		config.proxyConfig.handler.handleHandshakeResp = func(ctx ConnContext, resp *pnet.HandshakeResp) error {
+			ctx.SetValue(ConnContextKeyNamespace, nsCfg)
+			return nil
+		}
+	}
+
+	// The idle connection is closed after idle-timeout.
+	ts := newBackendMgrTester(t, setNamespace)
+	runners := []runner{
+		{
+			client: ts.mc.authenticate,
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.NoError(t, ts.firstHandshake4Proxy(clientIO, backendIO))
+				ts.mp.processLock.Lock()
+				require.Equal(t, 100*time.Second, ts.mp.idleTimeout)
+				require.Equal(t, 200*time.Second, ts.mp.maxLifetime)
+				ts.mp.idleTimeout = 100 * time.Millisecond
+				ts.mp.processLock.Unlock()
+				return nil
+			},
+			backend: ts.handshake4Backend,
+		},
+		{
+			proxy: ts.checkConnClosed4Proxy,
+		},
+		{
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.Equal(t, SrcIdleTimeout, ts.mp.QuitSource())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+
+	// The connection in a transaction is closed after the transaction finishes.
+	ts = newBackendMgrTester(t, setNamespace)
+	runners = []runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		{
+			client:  ts.mc.request,
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.startTxn4Backend,
+		},
+		{
+			proxy: func(_, _ pnet.PacketIO) error {
+				ts.mp.processLock.Lock()
+				ts.mp.maxLifetime = time.Millisecond
+				ts.mp.processLock.Unlock()
+				require.Eventually(t, func() bool {
+					return ts.mp.closeStatus.Load() == statusNotifyClose
+				}, 3*time.Second, 10*time.Millisecond)
+				return nil
+			},
+		},
+		{
+			client:  ts.mc.request,
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.respondWithNoTxn4Backend,
+		},
+		{
+			proxy: ts.checkConnClosed4Proxy,
+		},
+		{
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.Equal(t, SrcMaxLifetime, ts.mp.QuitSource())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
 func TestHandlerReturnError(t *testing.T) {
 	tests := []struct {
 		cfg        cfgOverrider
@@ -1251,6 +1346,52 @@ func TestCloseWhileExecute(t *testing.T) {
 	ts.runTests(runners)
 }
 
+// The delay of query rules doesn't hold the lock, so the connection can be closed during the delay.
+func TestCloseWhileDelay(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	rules := rule.NewEngine(lg)
+	require.NoError(t, rules.Reset([]config.QueryRule{
+		{
+			Name:     "delay",
+			Keywords: []string{"SELECT"},
+			Action:   config.RuleActionDelay,
+			Delay:    3600 * 1000,
+		},
+	}))
+	ts := newBackendMgrTester(t, func(cfg *testConfig) {
+		cfg.proxyConfig.bcConfig.QueryRules = rules
+	})
+	runners := []runner{
+		// 1st handshake
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// close while the statement is delayed
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				packetIO.ResetSequence()
+				return packetIO.WritePacket(append([]byte{pnet.ComQuery.Byte()}, "SELECT 1"...), true)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				clientIO.ResetSequence()
+				request, err := clientIO.ReadPacket()
+				if err != nil {
+					return err
+				}
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					require.NoError(ts.t, ts.mp.BackendConnManager.Close())
+				}()
+				return ts.mp.ExecuteCmd(context.Background(), request)
+			},
+		},
+	}
+
+	ts.runTests(runners)
+}
+
 func TestCloseWhileGracefulClose(t *testing.T) {
 	ts := newBackendMgrTester(t)
 	runners := []runner{
@@ -1364,6 +1505,49 @@ func TestTrafficMetrics(t *testing.T) {
 	ts.runTests(runners)
 }
 
+// Test that redirecting while the compressed client connection is being read doesn't race on the compression stats.
+func TestRedirectWhileClientSending(t *testing.T) {
+	ts := newBackendMgrTester(t, func(cfg *testConfig) {
+		cfg.clientConfig.capability |= pnet.ClientCompress
+	})
+	runners := []runner{
+		// 1st handshake
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// redirect while reading the request from the client
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "select '" + strings.Repeat("a", 1<<16) + "'"
+				return ts.mc.request(packetIO)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.NotEqual(t, pnet.CompressionNone, clientIO.CompressionStats().Algorithm)
+				var wg waitgroup.WaitGroup
+				var request []byte
+				var err error
+				wg.Run(func() {
+					clientIO.ResetSequence()
+					request, err = clientIO.ReadPacket()
+				})
+				ts.mp.Redirect(newMockBackendInst(ts))
+				ts.mp.getEventReceiver().(*mockEventReceiver).checkEvent(ts.t, eventSucceed)
+				wg.Wait()
+				require.NoError(t, err)
+				return ts.mp.ExecuteCmd(context.Background(), request)
+			},
+			backend: func(packetIO pnet.PacketIO) error {
+				require.NoError(t, ts.redirectSucceed4Backend(packetIO))
+				ts.mb.respondType = responseTypeOK
+				return ts.mb.respond(ts.tc.backendIO)
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
 func TestDisconnectLog(t *testing.T) {
 	ts := newBackendMgrTester(t)
 	tests := []struct {
@@ -1484,12 +1668,36 @@ func TestCapture(t *testing.T) {
 				return ts.mb.respond(packetIO)
 			},
 		},
+		// LOAD DATA LOCAL INFILE is captured with the hash of the file after it finishes.
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "load data local infile '/tmp/t.csv' into table t"
+				ts.mc.filePkts = 2
+				return ts.mc.request(packetIO)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				err := ts.forwardCmd4Proxy(clientIO, backendIO)
+				cpt := ts.mp.cpt.(*mockCapture)
+				packet := append([]byte{pnet.ComQuery.Byte()}, []byte("load data local infile '/tmp/t.csv' into table t")...)
+				require.Equal(t, packet, cpt.packet)
+				require.Len(t, cpt.loadDataHash, 64)
+				return err
+			},
+			backend: func(packetIO pnet.PacketIO) error {
+				// respond to `SHOW SESSION STATES`
+				ts.mb.respondType = responseTypeResultSet
+				err := ts.mb.respond(packetIO)
+				require.NoError(ts.t, err)
+				ts.mb.respondType = responseTypeLoadFile
+				return ts.mb.respond(packetIO)
+			},
+		},
 		{
 			proxy: func(clientIO, backendIO pnet.PacketIO) error {
 				_ = ts.mp.Close()
 				ts.closed = true
 				cpt := ts.mp.cpt.(*mockCapture)
-				require.Equal(t, []byte{pnet.ComQuit.Byte()}, cpt.packet)
+				require.True(t, cpt.closed)
 				return nil
 			},
 		},
@@ -1497,6 +1705,62 @@ func TestCapture(t *testing.T) {
 	ts.runTests(runners)
 }
 
+func TestCaptureResult(t *testing.T) {
+	ts := newBackendMgrTester(t, func(config *testConfig) {
+		config.proxyConfig.capture = &mockCapture{pending: capture.PendingResult}
+	})
+	var digest string
+	selectRunner := func(rows int, check func(result *cmd.Result)) runner {
+		return runner{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "select 1"
+				return ts.mc.request(packetIO)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				err := ts.forwardCmd4Proxy(clientIO, backendIO)
+				cpt := ts.mp.cpt.(*mockCapture)
+				require.True(t, cpt.succeeded)
+				require.Greater(t, cpt.duration, time.Duration(0))
+				require.NotNil(t, cpt.result)
+				require.EqualValues(t, rows, cpt.result.Rows)
+				require.Len(t, cpt.result.Digest, 32)
+				require.Equal(t, cpt.result, ts.mp.LastResult())
+				check(cpt.result)
+				return err
+			},
+			backend: func(packetIO pnet.PacketIO) error {
+				// respond to `SHOW SESSION STATES`
+				ts.mb.respondType = responseTypeResultSet
+				err := ts.mb.respond(packetIO)
+				require.NoError(ts.t, err)
+				ts.mb.respondType = responseTypeResultSet
+				ts.mb.columns = 1
+				ts.mb.rows = rows
+				return ts.mb.respond(packetIO)
+			},
+		}
+	}
+	runners := []runner{
+		// 1st handshake
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		selectRunner(2, func(result *cmd.Result) {
+			digest = result.Digest
+		}),
+		// The same result has the same digest.
+		selectRunner(2, func(result *cmd.Result) {
+			require.Equal(t, digest, result.Digest)
+		}),
+		selectRunner(3, func(result *cmd.Result) {
+			require.NotEqual(t, digest, result.Digest)
+		}),
+	}
+	ts.runTests(runners)
+}
+
 func TestConnectWithBackend(t *testing.T) {
 	ts := newBackendMgrTester(t, func(config *testConfig) {
 		config.proxyConfig.username = "u1"
@@ -1530,3 +1794,389 @@ func BenchmarkLockedMap(b *testing.B) {
 		lock.Unlock()
 	}
 }
+
+func TestKillQueryAfterMaxExecutionTime(t *testing.T) {
+	nsCfg := &config.Namespace{
+		Frontend: config.FrontendNamespace{
+			MaxExecutionTime: 100,
+		},
+		Backend: config.BackendNamespace{
+			AdminUser:     "admin",
+			AdminPassword: "123456",
+		},
+	}
+	ts := newBackendMgrTester(t, func(config *testConfig) {
+		config.proxyConfig.handler.handleHandshakeResp = func(ctx ConnContext, resp *pnet.HandshakeResp) error {
+			ctx.SetValue(ConnContextKeyNamespace, nsCfg)
+			return nil
+		}
+	})
+	runners := []runner{
+		{
+			client: ts.mc.authenticate,
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.NoError(t, ts.firstHandshake4Proxy(clientIO, backendIO))
+				ts.mp.processLock.Lock()
+				require.Equal(t, 100*time.Second, ts.mp.maxExecutionTime)
+				ts.mp.maxExecutionTime = 100 * time.Millisecond
+				ts.mp.processLock.Unlock()
+				return nil
+			},
+			backend: ts.handshake4Backend,
+		},
+		{
+			client: ts.mc.request,
+			proxy:  ts.forwardCmd4Proxy,
+			backend: func(packetIO pnet.PacketIO) error {
+				packetIO.ResetSequence()
+				if _, err := packetIO.ReadPacket(); err != nil {
+					return err
+				}
+				ts.acceptKillQuery(t)
+				return packetIO.WritePacket(pnet.MakeErrPacket(mysql.NewDefaultError(mysql.ER_QUERY_INTERRUPTED)), true)
+			},
+		},
+		{
+			proxy: func(_, _ pnet.PacketIO) error {
+				var myErr *mysql.MyError
+				require.ErrorAs(t, ts.mc.mysqlErr, &myErr)
+				require.Equal(t, uint16(mysql.ER_QUERY_INTERRUPTED), myErr.Code)
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
+
+// acceptKillQuery accepts the side connection from the proxy and checks that it kills the statement.
+func (ts *backendMgrTester) acceptKillQuery(t *testing.T) {
+	conn, err := ts.tc.backendListener.Accept()
+	require.NoError(t, err)
+	sideIO := pnet.NewPacketIO(conn, ts.lg, pnet.DefaultConnBufferSize)
+	sideBackend := newMockBackend(ts.mb.backendConfig)
+	require.NoError(t, sideBackend.authenticate(sideIO))
+	require.Equal(t, "admin", sideBackend.username)
+	sideIO.ResetSequence()
+	pkt, err := sideIO.ReadPacket()
+	require.NoError(t, err)
+	require.Equal(t, "KILL QUERY 100", string(pkt[1:]))
+	require.NoError(t, sideIO.WritePacket(pnet.MakeOKPacket(0, pnet.OKHeader), true))
+	require.NoError(t, sideIO.Close())
+}
+
+// The statement is killed once the result exceeds the limits instead of draining the remaining results.
+func TestKillQueryAfterResultLimit(t *testing.T) {
+	nsCfg := &config.Namespace{
+		Frontend: config.FrontendNamespace{
+			MaxResultRows: 5,
+		},
+		Backend: config.BackendNamespace{
+			AdminUser:     "admin",
+			AdminPassword: "123456",
+		},
+	}
+	ts := newBackendMgrTester(t, func(config *testConfig) {
+		config.proxyConfig.handler.handleHandshakeResp = func(ctx ConnContext, resp *pnet.HandshakeResp) error {
+			ctx.SetValue(ConnContextKeyNamespace, nsCfg)
+			return nil
+		}
+	})
+	ts.runTests([]runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+	})
+	backend := func(packetIO pnet.PacketIO) error {
+		packetIO.ResetSequence()
+		if _, err := packetIO.ReadPacket(); err != nil {
+			return err
+		}
+		require.NoError(t, packetIO.WritePacket(pnet.DumpLengthEncodedInt(nil, 1), false))
+		require.NoError(t, packetIO.WritePacket((&mysql.Field{Name: []byte("c")}).Dump(), false))
+		if ts.mb.capability&pnet.ClientDeprecateEOF == 0 {
+			require.NoError(t, packetIO.WritePacket(pnet.MakeEOFPacket(0), false))
+		}
+		// The statement is still running after sending more rows than the limit.
+		for i := 0; i < 10; i++ {
+			require.NoError(t, packetIO.WritePacket(pnet.DumpLengthEncodedString(nil, []byte("a")), false))
+		}
+		require.NoError(t, packetIO.Flush())
+		ts.acceptKillQuery(t)
+		return packetIO.WritePacket(pnet.MakeErrPacket(mysql.NewDefaultError(mysql.ER_QUERY_INTERRUPTED)), true)
+	}
+	ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+		require.NoError(t, ts.mc.err)
+		require.NoError(t, ts.mb.err)
+		var myErr *mysql.MyError
+		require.ErrorAs(t, ts.mc.mysqlErr, &myErr)
+		require.Contains(t, myErr.Message, "max-result-rows")
+	}, ts.mc.request, backend, ts.forwardCmd4Proxy)
+}
+
+// A kill that's triggered after the statement finishes doesn't kill the next statement.
+func TestKillFinishedStatement(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, listener.Close())
+	}()
+	mgr := NewBackendConnManager(lg, nil, nil, 0, &BCConfig{})
+	mgr.adminUser = "admin"
+	stop := mgr.startStatement(pnet.ComQuery)
+	seq := mgr.stmtKiller.seq
+	mgr.stmtKiller.addr = listener.Addr().String()
+	stop()
+	mgr.killStatement(seq, limitTypeExecutionTime)
+	stop = mgr.startStatement(pnet.ComQuery)
+	mgr.stmtKiller.addr = listener.Addr().String()
+	mgr.killStatement(seq, limitTypeExecutionTime)
+	stop()
+	// No side connection is created.
+	require.NoError(t, listener.(*net.TCPListener).SetDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = listener.Accept()
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestAuditLog(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	auditor := audit.NewAuditor(lg)
+	auditor.Reset(config.Audit{LogFile: config.LogFile{Filename: filename}})
+	auditor.Start(context.Background())
+	nsCfg := &config.Namespace{
+		Namespace: "ns",
+		Audit: &config.NamespaceAudit{
+			Enable:  true,
+			LogText: true,
+		},
+	}
+	ts := newBackendMgrTester(t, func(config *testConfig) {
+		config.proxyConfig.bcConfig.Audit = auditor
+		config.proxyConfig.handler.handleHandshakeResp = func(ctx ConnContext, resp *pnet.HandshakeResp) error {
+			ctx.SetValue(ConnContextKeyNamespace, nsCfg)
+			return nil
+		}
+	})
+	runners := []runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		{
+			client:  ts.mc.request,
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.respondWithNoTxn4Backend,
+		},
+		{
+			proxy:   ts.redirectSucceed4Proxy,
+			backend: ts.redirectSucceed4Backend,
+		},
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.cmd = pnet.ComStmtPrepare
+				ts.mc.sql = "select ?"
+				return ts.mc.request(packetIO)
+			},
+			proxy: ts.forwardCmd4Proxy,
+			backend: func(packetIO pnet.PacketIO) error {
+				ts.mb.respondType = responseTypePrepareOK
+				ts.mb.columns = 1
+				ts.mb.params = 1
+				return ts.mb.respond(packetIO)
+			},
+		},
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.cmd = pnet.ComStmtExecute
+				ts.mc.prepStmtID = mockCmdInt
+				return ts.mc.request(packetIO)
+			},
+			proxy: ts.forwardCmd4Proxy,
+			backend: func(packetIO pnet.PacketIO) error {
+				ts.mb.respondType = responseTypeResultSet
+				ts.mb.columns = 1
+				ts.mb.rows = 1
+				return ts.mb.respond(packetIO)
+			},
+		},
+		{
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				ts.closed = true
+				return ts.mp.Close()
+			},
+		},
+	}
+	sql := ts.mc.sql
+	ts.runTests(runners)
+	auditor.Close()
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 6)
+	types := make([]string, 0, len(lines))
+	var stmts []audit.Event
+	for _, line := range lines {
+		var event audit.Event
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		require.Equal(t, "ns", event.Namespace)
+		require.Equal(t, mockUsername, event.User)
+		require.Equal(t, ts.mp.ConnectionID(), event.ConnID)
+		types = append(types, event.Type)
+		if event.Type == audit.EventStatement {
+			stmts = append(stmts, event)
+		}
+	}
+	require.Equal(t, []string{audit.EventConnect, audit.EventStatement, audit.EventMigration, audit.EventStatement,
+		audit.EventStatement, audit.EventDisconnect}, types)
+	require.Equal(t, sql, stmts[0].SQL)
+	require.Equal(t, pnet.ComQuery.String(), stmts[0].Cmd)
+	require.Equal(t, pnet.ComStmtPrepare.String(), stmts[1].Cmd)
+	// COM_STMT_EXECUTE carries the text cached at COM_STMT_PREPARE.
+	require.Equal(t, "select ?", stmts[2].SQL)
+	require.Equal(t, pnet.ComStmtExecute.String(), stmts[2].Cmd)
+}
+
+func TestStmtSummary(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	summary := stmtsummary.NewSummary(lg)
+	summary.Reset(config.StmtSummary{Enable: true})
+	summary.Start(context.Background())
+	defer summary.Close()
+	ts := newBackendMgrTester(t, func(config *testConfig) {
+		config.proxyConfig.bcConfig.StmtSummary = summary
+	})
+	runners := []runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.cmd = pnet.ComQuery
+				ts.mc.sql = "select * from t where id = 1"
+				return ts.mc.request(packetIO)
+			},
+			proxy: ts.forwardCmd4Proxy,
+			backend: func(packetIO pnet.PacketIO) error {
+				ts.mb.respondType = responseTypeResultSet
+				ts.mb.columns = 2
+				ts.mb.rows = 3
+				return ts.mb.respond(packetIO)
+			},
+		},
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.cmd = pnet.ComStmtPrepare
+				ts.mc.sql = "select ?"
+				return ts.mc.request(packetIO)
+			},
+			proxy: ts.forwardCmd4Proxy,
+			backend: func(packetIO pnet.PacketIO) error {
+				ts.mb.respondType = responseTypePrepareOK
+				ts.mb.columns = 1
+				ts.mb.params = 1
+				return ts.mb.respond(packetIO)
+			},
+		},
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.cmd = pnet.ComStmtExecute
+				ts.mc.prepStmtID = mockCmdInt
+				return ts.mc.request(packetIO)
+			},
+			proxy: ts.forwardCmd4Proxy,
+			backend: func(packetIO pnet.PacketIO) error {
+				ts.mb.respondType = responseTypeResultSet
+				ts.mb.columns = 1
+				ts.mb.rows = 2
+				return ts.mb.respond(packetIO)
+			},
+		},
+		{
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				ts.closed = true
+				return ts.mp.Close()
+			},
+		},
+	}
+	ts.runTests(runners)
+
+	var windows []stmtsummary.Window
+	require.Eventually(t, func() bool {
+		var err error
+		windows, err = summary.Windows(false, stmtsummary.OrderBySumRows, 0)
+		require.NoError(t, err)
+		return len(windows) == 1 && len(windows[0].Stmts) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	for i, expected := range []struct {
+		sql  string
+		rows uint64
+	}{
+		{sql: "select * from `t` where `id` = ?", rows: 3},
+		{sql: "select ?", rows: 2},
+	} {
+		stmt := windows[0].Stmts[i]
+		require.Equal(t, expected.sql, stmt.NormalizedSQL)
+		require.Equal(t, expected.rows, stmt.SumRows)
+		require.EqualValues(t, 1, stmt.ExecCount)
+		require.Equal(t, mockUsername, stmt.User)
+		require.Positive(t, stmt.SumInBytes)
+		require.Positive(t, stmt.SumOutBytes)
+	}
+}
+
+func TestProcessInfo(t *testing.T) {
+	ts := newBackendMgrTester(t)
+	_, ok := ts.mp.ProcessInfo()
+	require.False(t, ok)
+	runners := []runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		{
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				info, ok := ts.mp.ProcessInfo()
+				require.True(t, ok)
+				require.Equal(t, ts.mp.ConnectionID(), info.ConnID)
+				require.Equal(t, mockUsername, info.User)
+				require.Equal(t, ts.mp.ServerAddr(), info.Backend)
+				require.Equal(t, ts.mp.ClientAddr(), info.ClientAddr)
+				require.Equal(t, ProcessStateIdle, info.State)
+				require.Empty(t, info.SQL)
+
+				// A sensitive statement is redacted.
+				ts.mp.processLock.Lock()
+				ts.mp.updateProcessState(pnet.MakeQueryPacket("create user u identified by 'pwd'"), time.Now())
+				ts.mp.processLock.Unlock()
+				info, _ = ts.mp.ProcessInfo()
+				require.Equal(t, ProcessStateExecuting, info.State)
+				require.Equal(t, pnet.ComQuery.String(), info.Cmd)
+				require.Equal(t, redactedSQL, info.SQL)
+
+				ts.mp.processLock.Lock()
+				ts.mp.updateProcessState(pnet.MakeQueryPacket("select 1"), time.Now())
+				ts.mp.processLock.Unlock()
+				info, _ = ts.mp.ProcessInfo()
+				require.Equal(t, "select 1", info.SQL)
+
+				ts.mp.processLock.Lock()
+				ts.mp.cmdProcessor.serverStatus |= StatusInTrans
+				ts.mp.updateProcessState(nil, time.Now())
+				ts.mp.cmdProcessor.serverStatus &^= StatusInTrans
+				ts.mp.processLock.Unlock()
+				info, _ = ts.mp.ProcessInfo()
+				require.Equal(t, ProcessStateInTxn, info.State)
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"crypto/tls"
+	"path"
+	"strings"
+
+	"github.com/pingcap/tiproxy/lib/config"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/proxyprotocol"
+	"go.uber.org/zap"
+)
+
+// The connection attributes that carry the certificate identity to TiDB.
+const (
+	attrCertCN  = "_tiproxy_cert_cn"
+	attrCertOU  = "_tiproxy_cert_ou"
+	attrCertSAN = "_tiproxy_cert_san"
+)
+
+// CertIdentity is the identity in the client certificate and the user and namespace mapped from it.
+// The client certificate is already verified by the CA of server-tls during the TLS handshake.
+type CertIdentity struct {
+	CN   string
+	OUs  []string
+	SANs []string
+	// User is the user that the connection is bound to. Empty means the certificate doesn't bind a user.
+	User string
+	// Namespace is the namespace that the connection is routed to. Empty means routing by the user.
+	Namespace  string
+	TLSVersion string
+	Cipher     string
+}
+
+func newCertIdentity(state tls.ConnectionState) *CertIdentity {
+	if len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	identity := &CertIdentity{
+		CN:         cert.Subject.CommonName,
+		OUs:        cert.Subject.OrganizationalUnit,
+		TLSVersion: tls.VersionName(state.Version),
+		Cipher:     tls.CipherSuiteName(state.CipherSuite),
+	}
+	identity.SANs = append(identity.SANs, cert.DNSNames...)
+	identity.SANs = append(identity.SANs, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		identity.SANs = append(identity.SANs, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		identity.SANs = append(identity.SANs, uri.String())
+	}
+	return identity
+}
+
+func (identity *CertIdentity) match(rule config.CertAuthRule) bool {
+	return matchPattern(rule.CN, identity.CN) && matchSAN(rule.SAN, identity.SANs...) && matchPattern(rule.OU, identity.OUs...)
+}
+
+// matchSAN matches the SANs segment by segment so that '*' never spans multiple segments.
+// URIs are split by '/' and the others, such as DNS names, are split by '.'.
+func matchSAN(pattern string, values ...string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, value := range values {
+		sep := "."
+		if strings.Contains(value, "://") {
+			sep = "/"
+		}
+		patternSegs, valueSegs := strings.Split(pattern, sep), strings.Split(value, sep)
+		if len(patternSegs) != len(valueSegs) {
+			continue
+		}
+		matched := true
+		for i := range patternSegs {
+			if ok, _ := path.Match(patternSegs[i], valueSegs[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern string, values ...string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, value := range values {
+		// The patterns are already checked when loading the config.
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// mapCertIdentity matches the client certificate against the cert-auth rules and replaces the handshake user with the
+// mapped user if necessary. If the client is rejected, the error is sent to the client and returned.
+func (auth *Authenticator) mapCertIdentity(logger *zap.Logger, clientIO pnet.PacketIO, resp *pnet.HandshakeResp, isSSL bool) (*CertIdentity, error) {
+	cfg := &auth.certAuth
+	if len(cfg.Rules) == 0 && !cfg.RequireMatch && cfg.ForwardIdentity == config.ForwardIdentityNone {
+		return nil, nil
+	}
+	var identity *CertIdentity
+	if isSSL {
+		identity = newCertIdentity(clientIO.TLSConnectionState())
+	}
+	matched := false
+	if identity != nil {
+		for _, rule := range cfg.Rules {
+			if !identity.match(rule) {
+				continue
+			}
+			matched = true
+			identity.User, identity.Namespace = rule.User, rule.Namespace
+			if rule.User != "" && rule.User != resp.User {
+				if rule.RequireUserMatch {
+					logger.Debug("handshake user mismatches the certificate", zap.String("user", resp.User), zap.String("cert_user", rule.User))
+					return nil, writeAccessDenied(clientIO, resp)
+				}
+				resp.User = rule.User
+			}
+			break
+		}
+	}
+	if !matched && cfg.RequireMatch {
+		logger.Debug("client certificate matches no cert-auth rule", zap.String("user", resp.User), zap.Bool("has_cert", identity != nil))
+		return nil, writeAccessDenied(clientIO, resp)
+	}
+	if identity != nil {
+		logger.Debug("client certificate identity", zap.String("cn", identity.CN), zap.Strings("ou", identity.OUs),
+			zap.String("cert_user", identity.User), zap.String("cert_namespace", identity.Namespace))
+	}
+	return identity, nil
+}
+
+// identityAttrs returns the connection attributes with the certificate identity if the identity is forwarded by them.
+func (auth *Authenticator) identityAttrs(attrs map[string]string) map[string]string {
+	if auth.certIdentity == nil || auth.certAuth.ForwardIdentity != config.ForwardIdentityConnAttrs {
+		return attrs
+	}
+	newAttrs := make(map[string]string, len(attrs)+3)
+	for k, v := range attrs {
+		newAttrs[k] = v
+	}
+	newAttrs[attrCertCN] = auth.certIdentity.CN
+	newAttrs[attrCertOU] = strings.Join(auth.certIdentity.OUs, ",")
+	newAttrs[attrCertSAN] = strings.Join(auth.certIdentity.SANs, ",")
+	return newAttrs
+}
+
+// identityProxy returns the PROXY header with the certificate identity if the identity is forwarded by it.
+// The SSL TLVs from the upstream proxy are replaced because TiProxy terminates the TLS connection.
+func (auth *Authenticator) identityProxy(proxy *proxyprotocol.Proxy) *proxyprotocol.Proxy {
+	if auth.certIdentity == nil || auth.certAuth.ForwardIdentity != config.ForwardIdentityProxyProtocol {
+		return proxy
+	}
+	newProxy := *proxy
+	newProxy.TLV = make([]proxyprotocol.ProxyTlv, 0, len(proxy.TLV)+1)
+	for _, tlv := range proxy.TLV {
+		if tlv.Typ != proxyprotocol.ProxyTlvSSL {
+			newProxy.TLV = append(newProxy.TLV, tlv)
+		}
+	}
+	newProxy.TLV = append(newProxy.TLV, proxyprotocol.MakeSSLTLV(proxyprotocol.ProxyClientSSL|proxyprotocol.ProxyClientCertConn,
+		proxyprotocol.ProxyTlv{Typ: proxyprotocol.ProxyTlvSSLVersion, Content: []byte(auth.certIdentity.TLSVersion)},
+		proxyprotocol.ProxyTlv{Typ: proxyprotocol.ProxyTlvSSLCN, Content: []byte(auth.certIdentity.CN)},
+		proxyprotocol.ProxyTlv{Typ: proxyprotocol.ProxyTlvSSLCipher, Content: []byte(auth.certIdentity.Cipher)},
+	))
+	return &newProxy
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/proxyprotocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertIdentityMatch(t *testing.T) {
+	identity := &CertIdentity{
+		CN:   "app-1",
+		OUs:  []string{"dev", "db"},
+		SANs: []string{"app-1.example.com", "spiffe://cluster/app"},
+	}
+	tests := []struct {
+		rule    config.CertAuthRule
+		matched bool
+	}{
+		{config.CertAuthRule{CN: "app-1"}, true},
+		{config.CertAuthRule{CN: "app-*"}, true},
+		{config.CertAuthRule{CN: "app"}, false},
+		{config.CertAuthRule{OU: "db"}, true},
+		{config.CertAuthRule{OU: "prod"}, false},
+		{config.CertAuthRule{SAN: "*.example.com"}, true},
+		{config.CertAuthRule{SAN: "*.com"}, false},
+		{config.CertAuthRule{SAN: "spiffe://cluster/*"}, true},
+		{config.CertAuthRule{SAN: "spiffe://*"}, false},
+		{config.CertAuthRule{SAN: "spiffe://*/app"}, true},
+		{config.CertAuthRule{CN: "app-*", OU: "db", SAN: "*.example.com"}, true},
+		{config.CertAuthRule{CN: "app-*", OU: "prod"}, false},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.matched, identity.match(test.rule), "case %d", i)
+	}
+}
+
+func TestCertAuth(t *testing.T) {
+	rule := config.CertAuthRule{CN: "app-*", User: "app", Namespace: "ns1", RequireUserMatch: true}
+	tests := []struct {
+		cn            string
+		user          string
+		certAuth      config.CertAuth
+		proxyProtocol bool
+		// Empty backendUser means the client is rejected.
+		backendUser string
+	}{
+		{
+			cn:          "app-1",
+			user:        "app",
+			certAuth:    config.CertAuth{Rules: []config.CertAuthRule{rule}},
+			backendUser: "app",
+		},
+		{
+			// The handshake user must match the certificate.
+			cn:       "app-1",
+			user:     "root",
+			certAuth: config.CertAuth{Rules: []config.CertAuthRule{rule}},
+		},
+		{
+			// The handshake user is replaced.
+			cn:          "svc",
+			user:        "root",
+			certAuth:    config.CertAuth{Rules: []config.CertAuthRule{{CN: "svc", User: "svc"}}},
+			backendUser: "svc",
+		},
+		{
+			// No rule matches and it's allowed.
+			cn:          "other",
+			user:        "root",
+			certAuth:    config.CertAuth{Rules: []config.CertAuthRule{rule}},
+			backendUser: "root",
+		},
+		{
+			cn:       "other",
+			user:     "root",
+			certAuth: config.CertAuth{Rules: []config.CertAuthRule{rule}, RequireMatch: true},
+		},
+		{
+			// The client doesn't send a certificate.
+			user:     "root",
+			certAuth: config.CertAuth{RequireMatch: true},
+		},
+		{
+			cn:          "app-1",
+			user:        "app",
+			certAuth:    config.CertAuth{Rules: []config.CertAuthRule{rule}, ForwardIdentity: config.ForwardIdentityConnAttrs},
+			backendUser: "app",
+		},
+		{
+			cn:            "app-1",
+			user:          "app",
+			certAuth:      config.CertAuth{Rules: []config.CertAuthRule{rule}, ForwardIdentity: config.ForwardIdentityProxyProtocol},
+			proxyProtocol: true,
+			backendUser:   "app",
+		},
+	}
+
+	tc := newTCPConnSuite(t)
+	for i, test := range tests {
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			serverTLSConfig := cfg.proxyConfig.frontendTLSConfig.Clone()
+			serverTLSConfig.ClientAuth = tls.RequestClientCert
+			cfg.proxyConfig.frontendTLSConfig = serverTLSConfig
+			if len(test.cn) > 0 {
+				clientTLSConfig := cfg.clientConfig.tlsConfig.Clone()
+				clientTLSConfig.Certificates = []tls.Certificate{createClientCert(t, test.cn, []string{"db"})}
+				cfg.clientConfig.tlsConfig = clientTLSConfig
+			}
+			cfg.clientConfig.username = test.user
+			cfg.clientConfig.attrs = map[string]string{"k": "v"}
+			cfg.proxyConfig.bcConfig.CertAuth = test.certAuth
+			cfg.proxyConfig.bcConfig.ProxyProtocol = test.proxyProtocol
+			cfg.backendConfig.proxyProtocol = test.proxyProtocol
+		})
+		ts.authenticateFirstTime(t, func(t *testing.T, ts *testSuite) {
+			if len(test.backendUser) == 0 {
+				require.False(t, ts.mc.authSucceed, "case %d", i)
+				var myErr *mysql.MyError
+				require.ErrorAs(t, ts.mc.mysqlErr, &myErr, "case %d", i)
+				require.Equal(t, uint16(mysql.ER_ACCESS_DENIED_ERROR), myErr.Code, "case %d", i)
+				require.Equal(t, SrcClientAuthFail, Error2Source(ts.mp.err), "case %d", i)
+				return
+			}
+			require.NoError(t, ts.mp.err, "case %d", i)
+			require.True(t, ts.mc.authSucceed, "case %d", i)
+			require.Equal(t, test.backendUser, ts.mb.username, "case %d", i)
+			identity, _ := ts.mp.Value(ConnContextKeyCertIdentity).(*CertIdentity)
+			require.NotNil(t, identity, "case %d", i)
+			require.Equal(t, test.cn, identity.CN, "case %d", i)
+			if test.cn == "app-1" {
+				require.Equal(t, "ns1", identity.Namespace, "case %d", i)
+			}
+			require.Equal(t, "v", ts.mb.attrs["k"], "case %d", i)
+			switch test.certAuth.ForwardIdentity {
+			case config.ForwardIdentityConnAttrs:
+				require.Equal(t, test.cn, ts.mb.attrs[attrCertCN], "case %d", i)
+				require.Equal(t, "db", ts.mb.attrs[attrCertOU], "case %d", i)
+			case config.ForwardIdentityProxyProtocol:
+				require.NotNil(t, ts.mb.proxy, "case %d", i)
+				tlv := ts.mb.proxy.TLV[len(ts.mb.proxy.TLV)-1]
+				require.Equal(t, proxyprotocol.ProxyTlvSSL, tlv.Typ, "case %d", i)
+				require.True(t, bytes.Contains(tlv.Content, []byte(test.cn)), "case %d", i)
+			default:
+				require.NotContains(t, ts.mb.attrs, attrCertCN, "case %d", i)
+			}
+		})
+		clean()
+	}
+}
+
+func TestChangeUserWithCert(t *testing.T) {
+	tc := newTCPConnSuite(t)
+	for i, user := range []string{"app", "root"} {
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.clientConfig.cmd = pnet.ComChangeUser
+			cfg.clientConfig.username = user
+			cfg.backendConfig.respondType = responseTypeOK
+		})
+		ts.mp.cmdProcessor.certUser = "app"
+		var backendRunner func(pnet.PacketIO) error
+		if user == "app" {
+			backendRunner = ts.mb.respond
+		}
+		ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+			require.NoError(t, ts.mc.err, "case %d", i)
+			if user == "app" {
+				require.NoError(t, ts.mp.err, "case %d", i)
+				require.Nil(t, ts.mc.mysqlErr, "case %d", i)
+				return
+			}
+			var myErr *mysql.MyError
+			require.ErrorAs(t, ts.mc.mysqlErr, &myErr, "case %d", i)
+			require.Equal(t, uint16(mysql.ER_ACCESS_DENIED_ERROR), myErr.Code, "case %d", i)
+		}, ts.mc.request, backendRunner, ts.mp.processCmd)
+		clean()
+	}
+}
+
+func createClientCert(t *testing.T, cn string, ous []string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: ous},
+		DNSNames:     []string{cn + ".example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
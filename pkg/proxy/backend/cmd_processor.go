@@ -6,7 +6,11 @@ package backend
 import (
 	"encoding/binary"
 
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/resultcache"
+	"github.com/pingcap/tiproxy/pkg/proxy/rule"
+	"github.com/pingcap/tiproxy/pkg/proxy/userstore"
 	"go.uber.org/zap"
 )
 
@@ -25,18 +29,72 @@ type CmdProcessor struct {
 	// Only includes in_trans or quit status.
 	serverStatus uint32
 	logger       *zap.Logger
+	// rules, firewall, resultCache and processLister may be nil if they are not used.
+	rules         *rule.Engine
+	firewall      *firewall.Firewall
+	resultCache   *resultcache.Cache
+	processLister ProcessLister
+	ruleScope     rule.Scope
+	// matchedRule is the rule matched before locking. ruleMatched is true if matchedRule is set, even if it's nil.
+	matchedRule *rule.Result
+	ruleMatched bool
+	// curDB is the current DB, which is a part of the key of the result cache.
+	curDB string
+	// resultVars are the SET statements that last changed the session variables affecting the results, such as
+	// time_zone. They and the handshake collation form cacheVars, which is also a part of the key.
+	resultVars map[string]string
+	collation  uint8
+	cacheVars  string
+	// cacheStateUnknown means the DB or the session variables may be changed without being tracked, so the
+	// result cache is skipped.
+	cacheStateUnknown bool
+	// certUser is the user bound by the client certificate. Changing to other users is rejected.
+	certUser string
+	// userStore is set if the client is authenticated by TiProxy. COM_CHANGE_USER is also verified by it and
+	// mapped to the backend user.
+	userStore *userstore.Store
+	// changedUser is the backend user of the last COM_CHANGE_USER.
+	changedUser string
+	// maxResultRows and maxResultBytes limit the result sets of each statement. 0 means no limit.
+	maxResultRows  int64
+	maxResultBytes int64
+	// onResultLimit is called once the result sets exceed the limits. If it's nil, the remaining results are drained.
+	onResultLimit func(limitType string)
+	// loadDataDeny rejects LOAD DATA LOCAL INFILE and maxLoadDataBytes limits the uploaded file. 0 means no limit.
+	loadDataDeny     bool
+	maxLoadDataBytes int64
+	// loadDataHash is the SHA-256 of the file uploaded by the current command, which is recorded by traffic capture.
+	loadDataHash string
+	// affectedRows is the sum of the affected rows of the current command.
+	affectedRows uint64
+	// returnedRows is the sum of the rows in the result sets of the current command.
+	returnedRows uint64
+	// preparedStmtID is the statement ID returned by the current COM_STMT_PREPARE.
+	preparedStmtID uint32
+	// digestResult computes the digest of the result sets of the current command, which is used by traffic
+	// capture and replay to compare results.
+	digestResult bool
+	resultDigest resultDigest
+	// resultUnknown means the current command is served by TiProxy so its result can't be compared.
+	resultUnknown bool
 }
 
-func NewCmdProcessor(logger *zap.Logger) *CmdProcessor {
+func NewCmdProcessor(logger *zap.Logger, config *BCConfig) *CmdProcessor {
 	return &CmdProcessor{
 		serverStatus:       0,
 		preparedStmtStatus: make(map[int]uint32),
 		logger:             logger,
+		rules:              config.QueryRules,
+		firewall:           config.Firewall,
+		resultCache:        config.ResultCache,
+		processLister:      config.ProcessLister,
 	}
 }
 
 func (cp *CmdProcessor) handleOKPacket(request, response []byte) uint16 {
 	status := pnet.ParseOKPacket(response)
+	affectedRows, _, _ := pnet.ParseLengthEncodedInt(response[1:])
+	cp.affectedRows += affectedRows
 	cp.updateServerStatus(request, status)
 	return status
 }
@@ -9,8 +9,13 @@ import (
 
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tiproxy/lib/config"
 	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/rule"
+	"github.com/pingcap/tiproxy/pkg/proxy/userstore"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
 	"github.com/siddontang/go/hack"
 	"go.uber.org/zap"
 )
@@ -20,6 +25,17 @@ import (
 // err: unexpected errors or MySQL errors.
 func (cp *CmdProcessor) executeCmd(request []byte, clientIO, backendIO pnet.PacketIO, waitingRedirect bool) (holdRequest bool, err error) {
 	backendIO.ResetSequence()
+	cp.affectedRows = 0
+	cp.returnedRows = 0
+	cp.loadDataHash = ""
+	// The row order only matters if the statement sorts the rows.
+	cp.resultDigest.reset(cp.digestResult && pnet.Command(request[0]) == pnet.ComQuery && lex.HasOrderBy(hack.String(request[1:])))
+	cp.resultUnknown = false
+	// The virtual statements are served by TiProxy and don't touch the backend.
+	if handled, err := cp.handleShowProcessList(clientIO, request); handled {
+		cp.resultUnknown = true
+		return false, err
+	}
 	if waitingRedirect && cp.needHoldRequest(request) {
 		var response []byte
 		if _, response, err = cp.query(backendIO, "COMMIT"); err != nil {
@@ -34,7 +50,106 @@ func (cp *CmdProcessor) executeCmd(request []byte, clientIO, backendIO pnet.Pack
 		}
 		return true, err
 	}
-	return false, cp.forwardCommand(clientIO, backendIO, request)
+	if err = cp.checkFirewall(clientIO, request); err != nil {
+		return false, err
+	}
+	if err = cp.checkChangeUser(clientIO, request); err != nil {
+		return false, err
+	}
+	if err = cp.checkLoadData(clientIO, request); err != nil {
+		return false, err
+	}
+	var res *rule.Result
+	if request, res, err = cp.applyRules(clientIO, request); err != nil {
+		return false, err
+	}
+	if res != nil && res.Action == config.RuleActionCache {
+		return false, cp.forwardCachedQuery(clientIO, backendIO, request, res)
+	}
+	err = cp.forwardCommand(clientIO, backendIO, request)
+	cp.updateCacheStates(request, err == nil)
+	return false, err
+}
+
+// checkFirewall checks the original statement against the firewall allowlist.
+// If the statement is rejected, the error is sent to the client and returned.
+func (cp *CmdProcessor) checkFirewall(clientIO pnet.PacketIO, request []byte) error {
+	if cp.firewall == nil {
+		return nil
+	}
+	cmd := pnet.Command(request[0])
+	if cmd != pnet.ComQuery && cmd != pnet.ComStmtPrepare {
+		return nil
+	}
+	if !cp.firewall.Enabled(cp.ruleScope.User) {
+		return nil
+	}
+	_, digest := parser.NormalizeDigest(hack.String(request[1:]))
+	if err := cp.firewall.Check(cp.ruleScope.User, digest.String()); err != nil {
+		if writeErr := clientIO.WritePacket(pnet.MakeErrPacket(firewall.ErrNotAllowed), true); writeErr != nil {
+			return writeErr
+		}
+		return err
+	}
+	return nil
+}
+
+// checkChangeUser rejects COM_CHANGE_USER if it changes to a user other than the one bound by the client certificate.
+// If the request is rejected, the error is sent to the client and returned.
+func (cp *CmdProcessor) checkChangeUser(clientIO pnet.PacketIO, request []byte) error {
+	if cp.certUser == "" || pnet.Command(request[0]) != pnet.ComChangeUser {
+		return nil
+	}
+	req, err := pnet.ParseChangeUser(request, cp.capability)
+	if err != nil || req.User == cp.certUser {
+		// The malformed packet is handled when forwarding it.
+		return nil
+	}
+	return writeAccessDenied(clientIO, &pnet.HandshakeResp{User: req.User, AuthData: req.AuthData})
+}
+
+// matchRules matches the query rules and returns the matched rule. It returns nil if no rule matches.
+func (cp *CmdProcessor) matchRules(request []byte) *rule.Result {
+	if cp.rules == nil || len(request) == 0 {
+		return nil
+	}
+	cmd := pnet.Command(request[0])
+	if cmd != pnet.ComQuery && cmd != pnet.ComStmtPrepare {
+		return nil
+	}
+	return cp.rules.Match(cp.ruleScope, hack.String(request[1:]))
+}
+
+// setMatchedRule sets the rule that is matched before executing the command so that it won't be matched again.
+func (cp *CmdProcessor) setMatchedRule(res *rule.Result) {
+	cp.matchedRule, cp.ruleMatched = res, true
+}
+
+// applyRules matches the query rules and returns the request that should be forwarded and the matched rule.
+// If the statement is rejected, the error is sent to the client and returned.
+func (cp *CmdProcessor) applyRules(clientIO pnet.PacketIO, request []byte) ([]byte, *rule.Result, error) {
+	res := cp.matchedRule
+	if !cp.ruleMatched {
+		res = cp.matchRules(request)
+	}
+	cp.matchedRule, cp.ruleMatched = nil, false
+	if res == nil {
+		return request, nil, nil
+	}
+	cp.logger.Debug("statement matches query rule", zap.String("rule", res.Rule), zap.String("action", res.Action))
+	switch res.Action {
+	case config.RuleActionReject:
+		if err := clientIO.WritePacket(pnet.MakeErrPacket(res.Err), true); err != nil {
+			return nil, res, err
+		}
+		return nil, res, res.Err
+	case config.RuleActionRewrite:
+		newRequest := make([]byte, 0, len(res.SQL)+1)
+		newRequest = append(newRequest, request[0])
+		return append(newRequest, res.SQL...), res, nil
+	}
+	// The delay action is applied by BackendConnManager before locking.
+	return request, res, nil
 }
 
 func (cp *CmdProcessor) forwardCommand(clientIO, backendIO pnet.PacketIO, request []byte) error {
@@ -132,6 +247,7 @@ func (cp *CmdProcessor) forwardPrepareCmd(clientIO, backendIO pnet.PacketIO) err
 	}
 	switch response[0] {
 	case pnet.OKHeader.Byte():
+		cp.preparedStmtID = binary.LittleEndian.Uint32(response[1:])
 		// The OK packet doesn't contain a server status.
 		// See https://mariadb.com/kb/en/com_stmt_prepare/
 		numColumns := binary.LittleEndian.Uint16(response[5:])
@@ -168,7 +284,12 @@ func (cp *CmdProcessor) forwardPrepareCmd(clientIO, backendIO pnet.PacketIO) err
 }
 
 func (cp *CmdProcessor) forwardFetchCmd(clientIO, backendIO pnet.PacketIO, request []byte) error {
+	if cp.digestResult {
+		clientIO = cp.resultDigest.wrap(clientIO, cp.capability, 0)
+	}
+	inPackets := backendIO.InPackets()
 	_, err := cp.forwardUntilResultEnd(clientIO, backendIO, request)
+	cp.countRows(backendIO, inPackets, 0)
 	return err
 }
 
@@ -178,36 +299,59 @@ func (cp *CmdProcessor) forwardFieldListCmd(clientIO, backendIO pnet.PacketIO, r
 }
 
 func (cp *CmdProcessor) forwardQueryCmd(clientIO, backendIO pnet.PacketIO, request []byte) error {
+	limiter := newResultLimiter(cp.maxResultRows, cp.maxResultBytes)
+	dest := clientIO
+	var loadDataErr *mysql.MyError
 	for {
 		var serverStatus uint16
 		var first byte
-		err := backendIO.ForwardUntil(clientIO, func(firstByte byte, _ int) (end, needData bool) {
+		err := backendIO.ForwardUntil(dest, func(firstByte byte, _ int) (end, needData bool) {
 			first = firstByte
 			switch firstByte {
 			case pnet.OKHeader.Byte(), pnet.ErrHeader.Byte():
 				return true, true
 			default:
-				return true, false
+				// The column count is needed to count the rows.
+				return true, true
 			}
 		}, func(response []byte) error {
 			var err error
 			switch first {
 			case pnet.OKHeader.Byte():
 				serverStatus = cp.handleOKPacket(request, response)
-				err = clientIO.Flush()
+				err = dest.Flush()
 			case pnet.ErrHeader.Byte():
-				if err = clientIO.Flush(); err != nil {
+				if err = dest.Flush(); err != nil {
 					return err
 				}
 				// Subsequent statements won't be executed even if it's a multi-statement.
 				return cp.handleErrorPacket(response)
 			case pnet.LocalInFileHeader.Byte():
-				serverStatus, err = cp.forwardLoadInFile(clientIO, backendIO, request)
+				var rejected *mysql.MyError
+				if serverStatus, rejected, err = cp.forwardLoadInFile(dest, backendIO, request); rejected != nil {
+					loadDataErr = rejected
+				}
 			default:
-				serverStatus, err = cp.forwardResultSet(clientIO, backendIO, request)
+				columns, _, _ := pnet.ParseLengthEncodedInt(response)
+				serverStatus, err = cp.forwardResultSet(dest, backendIO, request, columns, limiter)
 			}
 			return err
 		})
+		abortErr := loadDataErr
+		if abortErr == nil && limiter != nil {
+			abortErr = limiter.err
+		}
+		if abortErr != nil && (err == nil || pnet.IsMySQLError(err)) {
+			// Drain the remaining result sets before returning the error to the client.
+			if err == nil && serverStatus&pnet.ServerMoreResultsExists > 0 {
+				dest = discardIO{clientIO}
+				continue
+			}
+			if err := clientIO.WritePacket(pnet.MakeErrPacket(abortErr), true); err != nil {
+				return err
+			}
+			return abortErr
+		}
 		if err != nil {
 			return err
 		}
@@ -219,43 +363,15 @@ func (cp *CmdProcessor) forwardQueryCmd(clientIO, backendIO pnet.PacketIO, reque
 	return nil
 }
 
-func (cp *CmdProcessor) forwardLoadInFile(clientIO, backendIO pnet.PacketIO, request []byte) (serverStatus uint16, err error) {
-	if err = clientIO.Flush(); err != nil {
-		return
+// forwardResultSet forwards the result set after the column count packet.
+// If limiter is not nil, the rows are checked against the limits.
+func (cp *CmdProcessor) forwardResultSet(clientIO, backendIO pnet.PacketIO, request []byte, columns uint64, limiter *resultLimiter) (serverStatus uint16, err error) {
+	if cp.digestResult {
+		clientIO = cp.resultDigest.wrap(clientIO, cp.capability, columns)
 	}
-	// The client sends file data until an empty packet.
-	for {
-		var data []byte
-		// Do not call PacketIO.ForwardUntil. It peeks 5 bytes but there may be only 4 bytes here.
-		if data, err = forwardOnePacket(backendIO, clientIO, false); err != nil {
-			return
-		}
-		if len(data) == 0 {
-			if err := backendIO.Flush(); err != nil {
-				return 0, err
-			}
-			break
-		}
-	}
-	var response []byte
-	if response, err = forwardOnePacket(clientIO, backendIO, true); err != nil {
-		return
-	}
-	switch response[0] {
-	case pnet.OKHeader.Byte():
-		return cp.handleOKPacket(request, response), nil
-	case pnet.ErrHeader.Byte():
-		return serverStatus, cp.handleErrorPacket(response)
-	}
-	// impossible here
-	return serverStatus, errors.Errorf("unexpected response, cmd:%d resp:%d", pnet.ComQuery, response[0])
-}
-
-func (cp *CmdProcessor) forwardResultSet(clientIO, backendIO pnet.PacketIO, request []byte) (uint16, error) {
 	if cp.capability&pnet.ClientDeprecateEOF == 0 {
-		var serverStatus uint16
 		// read columns
-		err := backendIO.ForwardUntil(clientIO, func(firstByte byte, firstPktLen int) (end, needData bool) {
+		err = backendIO.ForwardUntil(clientIO, func(firstByte byte, firstPktLen int) (end, needData bool) {
 			return pnet.IsEOFPacket(firstByte, firstPktLen), true
 		}, func(response []byte) error {
 			serverStatus = binary.LittleEndian.Uint16(response[3:])
@@ -270,9 +386,25 @@ func (cp *CmdProcessor) forwardResultSet(clientIO, backendIO pnet.PacketIO, requ
 		if err != nil || serverStatus&pnet.ServerStatusCursorExists > 0 {
 			return serverStatus, err
 		}
+		// The column definitions are already forwarded.
+		columns = 0
 	}
 	// Deprecate EOF or no cursor.
-	return cp.forwardUntilResultEnd(clientIO, backendIO, request)
+	inPackets := backendIO.InPackets()
+	if limiter != nil {
+		serverStatus, err = cp.forwardRowsWithLimit(clientIO, backendIO, request, columns, limiter)
+	} else {
+		serverStatus, err = cp.forwardUntilResultEnd(clientIO, backendIO, request)
+	}
+	cp.countRows(backendIO, inPackets, columns)
+	return
+}
+
+// countRows counts the rows read from the backend since inPackets, excluding the column definitions and the end packet.
+func (cp *CmdProcessor) countRows(backendIO pnet.PacketIO, inPackets, columns uint64) {
+	if packets := backendIO.InPackets() - inPackets; packets > columns+1 {
+		cp.returnedRows += packets - columns - 1
+	}
 }
 
 func (cp *CmdProcessor) forwardCloseCmd(request []byte) error {
@@ -296,6 +428,16 @@ func (cp *CmdProcessor) forwardChangeUserCmd(clientIO, backendIO pnet.PacketIO,
 			return mysql.ErrMalformPacket
 		}
 	}
+	// In the proxy-auth mode, the client is verified by TiProxy and the backend logs in with the mapped user.
+	var localUser *userstore.User
+	cp.changedUser = req.User
+	if cp.userStore != nil {
+		if localUser, err = cp.authenticateChangeUser(clientIO, req); err != nil {
+			return err
+		}
+		req.User = localUser.BackendUser
+		cp.changedUser = localUser.BackendUser
+	}
 	// The client may use the TiProxy salt to generate the auth data instead of using the TiDB salt,
 	// so we need another switch-auth request to pass the TiDB salt to the client.
 	// See https://github.com/pingcap/tiproxy/issues/127.
@@ -305,11 +447,22 @@ func (cp *CmdProcessor) forwardChangeUserCmd(clientIO, backendIO pnet.PacketIO,
 		return err
 	}
 
+	var authData []byte
 	for {
-		response, err := forwardOnePacket(clientIO, backendIO, true)
+		response, err := backendIO.ReadPacket()
 		if err != nil {
 			return err
 		}
+		// The backend auth requests are answered by TiProxy in the proxy-auth mode.
+		if localUser != nil && response[0] != pnet.OKHeader.Byte() && response[0] != pnet.ErrHeader.Byte() {
+			if authData, err = respondBackendAuth(backendIO, response, localUser.BackendPassword, authData); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = clientIO.WritePacket(response, true); err != nil {
+			return err
+		}
 		switch response[0] {
 		case pnet.OKHeader.Byte():
 			cp.handleOKPacket(request, response)
@@ -325,6 +478,25 @@ func (cp *CmdProcessor) forwardChangeUserCmd(clientIO, backendIO pnet.PacketIO,
 	}
 }
 
+// authenticateChangeUser verifies COM_CHANGE_USER with the local user store in the proxy-auth mode.
+// The client is asked to scramble the password again with a new salt because the auth data in COM_CHANGE_USER is
+// scrambled with the salt of the initial handshake, which may be replayed.
+func (cp *CmdProcessor) authenticateChangeUser(clientIO pnet.PacketIO, req *pnet.ChangeUserReq) (*userstore.User, error) {
+	// The client certificate replaces the password if it binds a user in the store.
+	if cp.certUser != "" && req.User == cp.certUser {
+		if user, ok := cp.userStore.Get(req.User); ok {
+			return user, nil
+		}
+	}
+	var salt [20]byte
+	if err := pnet.GenerateSalt(&salt); err != nil {
+		return nil, err
+	}
+	// The empty auth plugin forces an auth switch so that the new salt is used.
+	resp := &pnet.HandshakeResp{User: req.User, AuthData: req.AuthData}
+	return authenticateLocally(cp.logger, cp.userStore, clientIO, resp, salt[:], clientIO.TLSConnectionState().HandshakeComplete)
+}
+
 func (cp *CmdProcessor) forwardStatisticsCmd(clientIO, backendIO pnet.PacketIO) error {
 	// It just sends a string.
 	_, err := forwardOnePacket(clientIO, backendIO, true)
@@ -6,7 +6,13 @@ package backend
 import (
 	"testing"
 
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/resultcache"
+	"github.com/pingcap/tiproxy/pkg/proxy/rule"
 	"github.com/stretchr/testify/require"
 )
 
@@ -1100,3 +1106,456 @@ func TestQueryError(t *testing.T) {
 		clean()
 	}
 }
+
+func TestQueryRules(t *testing.T) {
+	tc := newTCPConnSuite(t)
+	lg, _ := logger.CreateLoggerForTest(t)
+	rules := rule.NewEngine(lg)
+	require.NoError(t, rules.Reset([]config.QueryRule{
+		{
+			Name:     "reject",
+			Keywords: []string{"DELETE"},
+			Action:   config.RuleActionReject,
+			ErrCode:  1234,
+			ErrMsg:   "rejected",
+		},
+		{
+			Name:    "rewrite",
+			Regex:   "^SELECT (.*) FROM t$",
+			Action:  config.RuleActionRewrite,
+			Rewrite: "SELECT /*+ USE_INDEX(t, idx) */ $1 FROM t",
+		},
+	}))
+
+	tests := []struct {
+		sql        string
+		backendSQL string
+		errCode    uint16
+	}{
+		{
+			sql:     "delete from t",
+			errCode: 1234,
+		},
+		{
+			sql:        "SELECT a FROM t",
+			backendSQL: "SELECT /*+ USE_INDEX(t, idx) */ a FROM t",
+		},
+		{
+			sql:        "SELECT a FROM t1",
+			backendSQL: "SELECT a FROM t1",
+		},
+	}
+	for i, test := range tests {
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.clientConfig.sql = test.sql
+			cfg.backendConfig.respondType = responseTypeOK
+		})
+		ts.mp.cmdProcessor.rules = rules
+		var backendRunner func(pnet.PacketIO) error
+		if test.errCode == 0 {
+			backendRunner = func(packetIO pnet.PacketIO) error {
+				packetIO.ResetSequence()
+				pkt, err := packetIO.ReadPacket()
+				require.NoError(t, err)
+				require.Equal(t, test.backendSQL, string(pkt[1:]), "case %d", i)
+				return packetIO.WritePacket(pnet.MakeOKPacket(0, pnet.OKHeader), true)
+			}
+		}
+		ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+			require.NoError(t, ts.mc.err, "case %d", i)
+			if test.errCode > 0 {
+				require.True(t, pnet.IsMySQLError(ts.mp.err), "case %d", i)
+				var myErr *mysql.MyError
+				require.ErrorAs(t, ts.mc.mysqlErr, &myErr, "case %d", i)
+				require.Equal(t, test.errCode, myErr.Code, "case %d", i)
+			} else {
+				require.NoError(t, ts.mp.err, "case %d", i)
+				require.NoError(t, ts.mb.err, "case %d", i)
+				require.Nil(t, ts.mc.mysqlErr, "case %d", i)
+			}
+		}, ts.mc.query, backendRunner, ts.mp.processCmd)
+		clean()
+	}
+}
+
+func TestFirewall(t *testing.T) {
+	tc := newTCPConnSuite(t)
+	lg, _ := logger.CreateLoggerForTest(t)
+	fw := firewall.NewFirewall(lg, "")
+
+	tests := []struct {
+		mode    string
+		sql     string
+		allowed bool
+	}{
+		{
+			mode:    config.FirewallModeLearning,
+			sql:     "select * from t where id = 1",
+			allowed: true,
+		},
+		{
+			mode:    config.FirewallModeEnforcing,
+			sql:     "select * from t where id = 2",
+			allowed: true,
+		},
+		{
+			mode:    config.FirewallModeEnforcing,
+			sql:     "delete from t",
+			allowed: false,
+		},
+		{
+			mode:    config.FirewallModeAlerting,
+			sql:     "delete from t",
+			allowed: true,
+		},
+	}
+	for i, test := range tests {
+		fw.Reset(config.Firewall{Mode: test.mode})
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.clientConfig.sql = test.sql
+			cfg.backendConfig.respondType = responseTypeOK
+		})
+		ts.mp.cmdProcessor.firewall = fw
+		var backendRunner func(pnet.PacketIO) error
+		if test.allowed {
+			backendRunner = ts.mb.respond
+		}
+		ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+			require.NoError(t, ts.mc.err, "case %d", i)
+			if test.allowed {
+				require.NoError(t, ts.mp.err, "case %d", i)
+				require.Nil(t, ts.mc.mysqlErr, "case %d", i)
+			} else {
+				require.ErrorIs(t, ts.mp.err, firewall.ErrNotAllowed, "case %d", i)
+				var myErr *mysql.MyError
+				require.ErrorAs(t, ts.mc.mysqlErr, &myErr, "case %d", i)
+				require.Equal(t, firewall.ErrNotAllowed.Code, myErr.Code, "case %d", i)
+			}
+		}, ts.mc.query, backendRunner, ts.mp.processCmd)
+		clean()
+	}
+}
+
+func TestResultLimit(t *testing.T) {
+	tc := newTCPConnSuite(t)
+	tests := []struct {
+		maxRows  int64
+		maxBytes int64
+		exceeded bool
+	}{
+		{
+			maxRows:  5,
+			exceeded: true,
+		},
+		{
+			maxRows: 10,
+		},
+		{
+			// Each row is 8 bytes.
+			maxBytes: 50,
+			exceeded: true,
+		},
+		{
+			maxRows:  100,
+			maxBytes: 80,
+		},
+	}
+	for i, test := range tests {
+		for _, capability := range []pnet.Capability{defaultTestBackendCapability, defaultTestBackendCapability &^ pnet.ClientDeprecateEOF} {
+			for _, stmtNum := range []int{1, 2} {
+				ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+					cfg.clientConfig.capability = capability
+					cfg.proxyConfig.capability = capability
+					cfg.backendConfig.capability = capability
+					cfg.backendConfig.respondType = responseTypeResultSet
+					cfg.backendConfig.columns = 2
+					cfg.backendConfig.rows = 10
+					cfg.backendConfig.stmtNum = stmtNum
+				})
+				ts.mp.cmdProcessor.capability = capability
+				ts.mp.cmdProcessor.maxResultRows = test.maxRows
+				ts.mp.cmdProcessor.maxResultBytes = test.maxBytes
+				ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+					require.NoError(t, ts.mc.err, "case %d", i)
+					require.NoError(t, ts.mb.err, "case %d", i)
+					// All the packets from the backend are read even if the result exceeds the limits.
+					require.Equal(t, ts.tc.backendIO.OutBytes(), ts.tc.proxyBIO.InBytes(), "case %d", i)
+					if test.exceeded {
+						require.True(t, pnet.IsMySQLError(ts.mp.err), "case %d", i)
+						var myErr *mysql.MyError
+						require.ErrorAs(t, ts.mc.mysqlErr, &myErr, "case %d", i)
+						require.Contains(t, myErr.Message, "exceeds", "case %d", i)
+					} else {
+						require.NoError(t, ts.mp.err, "case %d", i)
+						require.Nil(t, ts.mc.mysqlErr, "case %d", i)
+						require.Equal(t, ts.tc.backendIO.OutBytes(), ts.tc.clientIO.InBytes(), "case %d", i)
+					}
+				}, ts.mc.request, ts.mb.respond, ts.mp.processCmd)
+				clean()
+			}
+		}
+	}
+}
+
+func TestLoadDataLimit(t *testing.T) {
+	tc := newTCPConnSuite(t)
+	tests := []struct {
+		deny     bool
+		maxBytes int64
+		filePkts int
+		rejected bool
+		aborted  bool
+	}{
+		{
+			filePkts: 3,
+		},
+		{
+			deny:     true,
+			filePkts: 3,
+			rejected: true,
+		},
+		{
+			maxBytes: int64(3 * len(mockCmdBytes)),
+			filePkts: 3,
+		},
+		{
+			maxBytes: int64(3*len(mockCmdBytes)) - 1,
+			filePkts: 3,
+			aborted:  true,
+		},
+	}
+	for i, test := range tests {
+		for _, stmtNum := range []int{1, 2} {
+			ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+				cfg.clientConfig.filePkts = test.filePkts
+				cfg.backendConfig.respondType = responseTypeLoadFile
+				cfg.backendConfig.stmtNum = stmtNum
+			})
+			ts.mp.cmdProcessor.loadDataDeny = test.deny
+			ts.mp.cmdProcessor.maxLoadDataBytes = test.maxBytes
+			if test.aborted {
+				ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+					require.NoError(t, ts.mc.err, "case %d", i)
+					require.ErrorIs(t, ts.mp.err, errLoadDataAborted, "case %d", i)
+					require.False(t, pnet.IsMySQLError(ts.mp.err), "case %d", i)
+					require.NotNil(t, ts.mc.mysqlErr, "case %d", i)
+					// The file is streamed and the backend connection is closed once it exceeds the limit.
+					require.Error(t, ts.mb.err, "case %d", i)
+					// The query and the packets within the limit are forwarded.
+					require.Equal(t, uint64(3), ts.tc.proxyBIO.OutPackets(), "case %d", i)
+				}, ts.mc.request, ts.mb.respond, func(clientIO, backendIO pnet.PacketIO) error {
+					err := ts.mp.processCmd(clientIO, backendIO)
+					// Closing the session closes the backend connection.
+					require.NoError(t, backendIO.Close())
+					return err
+				})
+				clean()
+				continue
+			}
+			ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+				require.NoError(t, ts.mc.err, "case %d", i)
+				require.NoError(t, ts.mb.err, "case %d", i)
+				require.Equal(t, ts.tc.backendIO.OutBytes(), ts.tc.proxyBIO.InBytes(), "case %d", i)
+				if test.rejected {
+					require.True(t, pnet.IsMySQLError(ts.mp.err), "case %d", i)
+					require.NotNil(t, ts.mc.mysqlErr, "case %d", i)
+					require.Empty(t, ts.mp.cmdProcessor.loadDataHash, "case %d", i)
+					// Only the empty packets that end the files are sent to the backend.
+					require.Equal(t, uint64(stmtNum+1), ts.tc.proxyBIO.OutPackets(), "case %d", i)
+				} else {
+					require.NoError(t, ts.mp.err, "case %d", i)
+					require.Nil(t, ts.mc.mysqlErr, "case %d", i)
+					require.Equal(t, ts.tc.clientIO.OutBytes(), ts.tc.proxyCIO.InBytes(), "case %d", i)
+					require.Equal(t, ts.tc.proxyCIO.InBytes(), ts.tc.proxyBIO.OutBytes(), "case %d", i)
+					require.Len(t, ts.mp.cmdProcessor.loadDataHash, 64, "case %d", i)
+				}
+			}, ts.mc.request, ts.mb.respond, ts.mp.processCmd)
+			clean()
+		}
+	}
+
+	// The statement is rejected before it's sent to the backend.
+	ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+		cfg.clientConfig.sql = "LOAD DATA LOCAL INFILE '/tmp/t.csv' INTO TABLE t"
+	})
+	ts.mp.cmdProcessor.loadDataDeny = true
+	ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+		require.NoError(t, ts.mc.err)
+		require.ErrorIs(t, ts.mp.err, errLoadDataDenied)
+		var myErr *mysql.MyError
+		require.ErrorAs(t, ts.mc.mysqlErr, &myErr)
+		require.Equal(t, errLoadDataDenied.Code, myErr.Code)
+		require.Zero(t, ts.tc.proxyBIO.OutBytes())
+	}, ts.mc.query, nil, ts.mp.processCmd)
+	clean()
+}
+
+func TestResultCache(t *testing.T) {
+	tc := newTCPConnSuite(t)
+	lg, _ := logger.CreateLoggerForTest(t)
+	rules := rule.NewEngine(lg)
+	require.NoError(t, rules.Reset([]config.QueryRule{
+		{
+			Name:          "cache",
+			Keywords:      []string{"SELECT"},
+			Action:        config.RuleActionCache,
+			CacheTTL:      60,
+			CacheMaxBytes: 1 << 20,
+		},
+	}))
+	cache := resultcache.NewCache(lg)
+
+	tests := []struct {
+		sql    string
+		user   string
+		setup  []string
+		inTxn  bool
+		cached bool
+	}{
+		{
+			sql: "select count(*) from t",
+		},
+		{
+			sql:    "select count(*) from t",
+			cached: true,
+		},
+		{
+			sql:  "select count(*) from t",
+			user: "u2",
+		},
+		{
+			sql:   "select count(*) from t where id > 1",
+			inTxn: true,
+		},
+		{
+			// It's not cached in the transaction.
+			sql: "select count(*) from t where id > 1",
+		},
+		{
+			sql: "select count(*) from t; delete from t",
+		},
+		{
+			// Multi-statements are not cached.
+			sql: "select count(*) from t; delete from t",
+		},
+		{
+			// Comments and spaces are ignored.
+			sql:    "select count(*)  from t -- dashboard",
+			cached: true,
+		},
+		{
+			sql:   "select count(*) from t",
+			setup: []string{"set time_zone = '+08:00'"},
+		},
+		{
+			sql:    "select count(*) from t",
+			setup:  []string{"set time_zone = '+08:00'"},
+			cached: true,
+		},
+		{
+			// The DB may be changed by multi-statements, so it's not cached.
+			sql:   "select count(*) from t",
+			setup: []string{"use db2; select 1"},
+		},
+		{
+			sql: "select now()",
+		},
+		{
+			// Non-deterministic functions are not cached.
+			sql: "select now()",
+		},
+		{
+			sql: "select * from t for update",
+		},
+		{
+			// Locking reads are not cached.
+			sql: "select * from t for update",
+		},
+		{
+			sql: "/*!50000 DELETE FROM t */ SELECT 1",
+		},
+		{
+			// Executable comments are not cached.
+			sql: "/*!50000 DELETE FROM t */ SELECT 1",
+		},
+		{
+			sql: "select 1 /*T![clustered_index] for update */",
+		},
+		{
+			sql: "select 1 /*T![clustered_index] for update */",
+		},
+	}
+	var cachedBytes uint64
+	for i, test := range tests {
+		ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+			cfg.clientConfig.sql = test.sql
+			cfg.backendConfig.respondType = responseTypeResultSet
+			cfg.backendConfig.columns = 2
+			cfg.backendConfig.rows = 3
+		})
+		ts.mp.cmdProcessor.rules = rules
+		ts.mp.cmdProcessor.resultCache = cache
+		ts.mp.cmdProcessor.ruleScope.User = test.user
+		for _, sql := range test.setup {
+			ts.mp.cmdProcessor.updateCacheStates(append([]byte{pnet.ComQuery.Byte()}, sql...), true)
+		}
+		if test.inTxn {
+			ts.mp.cmdProcessor.serverStatus |= StatusInTrans
+		}
+		var backendRunner func(pnet.PacketIO) error
+		if !test.cached {
+			backendRunner = ts.mb.respond
+		}
+		ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+			require.NoError(t, ts.mc.err, "case %d", i)
+			require.NoError(t, ts.mp.err, "case %d", i)
+			require.Nil(t, ts.mc.mysqlErr, "case %d", i)
+			if test.cached {
+				require.Zero(t, ts.tc.proxyBIO.OutBytes(), "case %d", i)
+				require.Equal(t, cachedBytes, ts.tc.clientIO.InBytes(), "case %d", i)
+			} else {
+				require.NoError(t, ts.mb.err, "case %d", i)
+				require.Equal(t, ts.tc.backendIO.OutBytes(), ts.tc.clientIO.InBytes(), "case %d", i)
+				cachedBytes = ts.tc.clientIO.InBytes()
+			}
+		}, ts.mc.query, backendRunner, ts.mp.processCmd)
+		clean()
+	}
+}
+
+type mockProcessLister struct {
+	infos []ProcessInfo
+}
+
+func (m *mockProcessLister) ProcessList() []ProcessInfo {
+	return m.infos
+}
+
+func TestShowProcessList(t *testing.T) {
+	tc := newTCPConnSuite(t)
+	lister := &mockProcessLister{
+		infos: []ProcessInfo{
+			{ConnID: 1, User: "u1", State: ProcessStateIdle},
+			{ConnID: 2, User: "u2", State: ProcessStateIdle},
+			{ConnID: 3, User: "u1", State: ProcessStateExecuting, Cmd: pnet.ComQuery.String(), SQL: "show tiproxy processlist"},
+		},
+	}
+	ts, clean := newTestSuite(t, tc, func(cfg *testConfig) {
+		cfg.clientConfig.sql = "SHOW TIPROXY PROCESSLIST"
+	})
+	defer clean()
+	ts.mp.cmdProcessor.processLister = lister
+	ts.mp.cmdProcessor.ruleScope.User = "u1"
+	ts.runAndCheck(t, func(t *testing.T, ts *testSuite) {
+		require.NoError(t, ts.mc.err)
+		require.NoError(t, ts.mp.err)
+		require.Nil(t, ts.mc.mysqlErr)
+		// The statement is served by TiProxy and only the sessions of the same user are returned.
+		require.Zero(t, ts.tc.proxyBIO.OutBytes())
+		expectedPackets := 1 + len(processListColumns) + 2 + 1
+		if ts.mp.cmdProcessor.capability&pnet.ClientDeprecateEOF == 0 {
+			expectedPackets++
+		}
+		require.EqualValues(t, expectedPackets, ts.tc.clientIO.InPackets())
+	}, ts.mc.query, nil, ts.mp.processCmd)
+}
@@ -28,6 +28,7 @@ var (
 	ErrBackendCap       = errors.New("Verify TiDB capability failed, please upgrade TiDB")
 	ErrBackendHandshake = errors.New("TiProxy fails to connect to TiDB, please make sure TiDB is available")
 	ErrBackendNoTLS     = errors.New("Require TLS enabled on TiDB when require-backend-tls=true")
+	ErrMaintenance      = errors.New("the namespace is under maintenance")
 	ErrBackendPPV2      = errors.New("TiProxy fails to connect to TiDB, please make sure TiDB proxy-protocol is set correctly. If this error still exists, please contact PingCAP")
 )
 
@@ -91,6 +92,12 @@ const (
 	SrcBackendNetwork
 	// SrcBackendHandshake includes: dial failure; backend capability unsupported; backend disables TLS; TLS handshake fails; proxy protocol fails
 	SrcBackendHandshake
+	// SrcIdleTimeout includes: the client is idle for longer than idle-timeout
+	SrcIdleTimeout
+	// SrcMaxLifetime includes: the connection lives for longer than max-lifetime
+	SrcMaxLifetime
+	// SrcMaintenance includes: the namespace is under maintenance
+	SrcMaintenance
 )
 
 // Error2Source returns the ErrorSource by the error.
@@ -120,6 +127,8 @@ func Error2Source(err error) ErrorSource {
 		return SrcBackendHandshake
 	case errors.Is(err, ErrProxyNoBackend):
 		return SrcProxyNoBackend
+	case errors.Is(err, ErrMaintenance):
+		return SrcMaintenance
 	case pnet.IsMySQLError(err):
 		// ErrClientAuthFail and ErrBackendHandshake may also contain MySQL error.
 		return SrcClientSQLErr
@@ -156,6 +165,12 @@ func (es ErrorSource) String() string {
 		return "backend network break"
 	case SrcBackendHandshake:
 		return "backend handshake fail"
+	case SrcIdleTimeout:
+		return "idle timeout"
+	case SrcMaxLifetime:
+		return "max lifetime"
+	case SrcMaintenance:
+		return "maintenance"
 	}
 	return "unknown"
 }
@@ -165,7 +180,7 @@ func (es ErrorSource) GetSourceComp() SourceComp {
 	switch es {
 	case SrcClientNetwork, SrcClientHandshake, SrcClientAuthFail, SrcClientSQLErr:
 		return CompClient
-	case SrcProxyQuit, SrcProxyMalformed, SrcProxyNoBackend, SrcProxyErr:
+	case SrcProxyQuit, SrcProxyMalformed, SrcProxyNoBackend, SrcProxyErr, SrcIdleTimeout, SrcMaxLifetime, SrcMaintenance:
 		return CompProxy
 	case SrcBackendNetwork, SrcBackendHandshake:
 		return CompBackend
@@ -177,7 +192,7 @@ func (es ErrorSource) GetSourceComp() SourceComp {
 // Normal returns whether this error source is expected.
 func (es ErrorSource) Normal() bool {
 	switch es {
-	case SrcNone, SrcProxyQuit, SrcClientNetwork, SrcClientSQLErr:
+	case SrcNone, SrcProxyQuit, SrcClientNetwork, SrcClientSQLErr, SrcIdleTimeout, SrcMaxLifetime, SrcMaintenance:
 		return true
 	}
 	return false
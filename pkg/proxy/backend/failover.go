@@ -0,0 +1,230 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/pkg/balance/router"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
+	"github.com/siddontang/go/hack"
+	"go.uber.org/zap"
+)
+
+const (
+	failoverReconnect = "reconnect"
+	failoverRetry     = "retry"
+)
+
+// errFailoverNotRetried is sent to the client when the session is recovered but the statement is not retried because
+// it may have been executed on the broken backend.
+var errFailoverNotRetried = mysql.NewError(mysql.ER_QUERY_INTERRUPTED,
+	"Lost connection to TiDB during the statement and the session is recovered on another TiDB. The statement may or may not have been executed")
+
+// sessionSnapshot is the session states saved in advance, a.k.a. the checkpoint. Once the backend connection breaks,
+// the session states can't be queried anymore, so failover replays the snapshot on the new backend.
+type sessionSnapshot struct {
+	states string
+	token  string
+}
+
+// saveSessionStates queries the session states for failover. The snapshot is dropped if the query fails and it
+// will be retried at the next checkpoint.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) saveSessionStates(backendIO pnet.PacketIO) {
+	if !mgr.failoverEnabled {
+		return
+	}
+	mgr.snapshotTime = time.Now()
+	states, token, err := mgr.querySessionStates(backendIO)
+	if err != nil {
+		mgr.logger.Debug("save session states failed, failover is disabled until the next checkpoint", zap.Error(err))
+		mgr.snapshot = nil
+		return
+	}
+	mgr.snapshot = &sessionSnapshot{states: states, token: token}
+}
+
+// updateSnapshot is called after each command. Replaying an outdated snapshot would silently lose the changes, so the
+// snapshot is dropped once the command changes the session states. It's refreshed later by checkpoint so that the
+// statements don't wait for querying the session states.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) updateSnapshot(request []byte) {
+	if !mgr.failoverEnabled {
+		return
+	}
+	if mayChangeSessionStates(request) {
+		mgr.snapshot = nil
+		mgr.snapshotDirty = true
+	}
+}
+
+// checkpoint runs in the background. It refreshes the snapshot outside a transaction once the session states change,
+// and also periodically to renew the session token.
+func (mgr *BackendConnManager) checkpoint() {
+	mgr.processLock.Lock()
+	defer mgr.processLock.Unlock()
+	if !mgr.failoverEnabled || mgr.closeStatus.Load() >= statusNotifyClose || !mgr.cmdProcessor.finishedTxn() {
+		return
+	}
+	if !mgr.snapshotDirty && (mgr.checkpointInterval <= 0 || time.Since(mgr.snapshotTime) < mgr.checkpointInterval) {
+		return
+	}
+	mgr.snapshotDirty = false
+	mgr.saveSessionStates(*mgr.backendIO.Load())
+}
+
+// mayChangeSessionStates reports whether the command may change the session states, such as session variables,
+// the current DB or prepared statements. Statements are detected by the lexer, so some changes are missed, such as
+// assigning user variables in a SELECT statement. The periodic checkpoint makes up for them.
+func mayChangeSessionStates(request []byte) bool {
+	switch pnet.Command(request[0]) {
+	case pnet.ComInitDB, pnet.ComChangeUser, pnet.ComStmtPrepare, pnet.ComStmtClose, pnet.ComResetConnection:
+		return true
+	case pnet.ComQuery:
+		return lex.ChangesSessionStates(pnet.ParseQueryPacket(request[1:]))
+	}
+	return false
+}
+
+// canRetry reports whether the command can be executed again on the new backend.
+// Only plain SELECT and SHOW statements without side effects are retried because others may have been executed on
+// the broken backend. The failover is never started in a transaction, so the caller doesn't check it again.
+func canRetry(request []byte) bool {
+	if pnet.Command(request[0]) != pnet.ComQuery {
+		return false
+	}
+	return lex.IsIdempotentRead(pnet.ParseQueryPacket(request[1:]))
+}
+
+// failover reconnects the session to a backend with the saved snapshot after the backend connection breaks.
+// It returns nil if the command is retried successfully, errFailoverNotRetried if the session is recovered but the
+// command is not retried, or cause if the session can't be recovered.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) failover(ctx context.Context, request []byte, inTxn, responded bool, cause error) error {
+	// The session states in a transaction are not complete and the client may have read part of the response,
+	// so the connection can't be recovered.
+	if inTxn || responded || !mgr.restoreSession(ctx, cause) {
+		return cause
+	}
+	if !canRetry(request) {
+		if err := mgr.clientIO.WritePacket(pnet.MakeErrPacket(errFailoverNotRetried), true); err != nil {
+			return err
+		}
+		return errFailoverNotRetried
+	}
+	backendIO := *mgr.backendIO.Load()
+	_, err := mgr.cmdProcessor.executeCmd(request, mgr.clientIO, backendIO, false)
+	addFailoverMetrics(failoverRetry, err == nil || pnet.IsMySQLError(err))
+	return err
+}
+
+// restoreSession reconnects to a backend with the saved snapshot and returns whether it succeeds.
+// NOTE: processLock should be held before calling this function.
+func (mgr *BackendConnManager) restoreSession(ctx context.Context, cause error) bool {
+	if mgr.snapshot == nil || mgr.closeStatus.Load() >= statusClosing || ctx.Err() != nil {
+		return false
+	}
+	from := mgr.ServerAddr()
+	startTime := time.Now()
+	err := mgr.reconnect(ctx, mgr.snapshot)
+	addFailoverMetrics(failoverReconnect, err == nil)
+	if err != nil {
+		mgr.logger.Warn("failover failed", zap.String("from", from), zap.Duration("duration", time.Since(startTime)),
+			zap.NamedError("cause", cause), zap.Error(err))
+		return false
+	}
+	mgr.logger.Info("failover succeeds", zap.String("from", from), zap.String("to", mgr.ServerAddr()),
+		zap.Duration("duration", time.Since(startTime)), zap.NamedError("cause", cause))
+	return true
+}
+
+// reconnect connects to a backend chosen by the router and restores the snapshot on it.
+// The connection is moved from the broken backend to the new one in the router only after it succeeds.
+func (mgr *BackendConnManager) reconnect(ctx context.Context, snapshot *sessionSnapshot) error {
+	if err := mgr.updateAuthInfoFromSessionStates(hack.Slice(snapshot.states)); err != nil {
+		return err
+	}
+	resp := &pnet.HandshakeResp{User: mgr.authenticator.user, DB: mgr.authenticator.dbname, Attrs: mgr.authenticator.attrs}
+	r, err := mgr.handshakeHandler.GetRouter(mgr, resp)
+	if err != nil {
+		return errors.Wrap(err, ErrProxyErr)
+	}
+	selector := r.GetBackendSelector()
+	bctx, cancel := context.WithTimeout(ctx, mgr.config.ConnectTimeout)
+	defer cancel()
+	var backend router.BackendInst
+	newBackendIO, err := backoff.RetryWithData(
+		func() (pnet.PacketIO, error) {
+			if backend, err = selector.Next(); err == router.ErrNoBackend {
+				return nil, ErrProxyNoBackend
+			} else if err != nil {
+				return nil, backoff.Permanent(errors.Wrap(err, ErrProxyErr))
+			}
+			addr := backend.Addr()
+			backendIO, err := mgr.dialBackend(addr)
+			if err != nil {
+				selector.Finish(mgr, false)
+				return nil, errors.Wrap(errors.Wrapf(err, "dial backend %s error", addr), ErrBackendHandshake)
+			}
+			if err = mgr.authenticator.handshakeSecondTime(mgr.logger, mgr.clientIO, backendIO, mgr.backendTLS, snapshot.token); err == nil {
+				err = mgr.initSessionStates(backendIO, snapshot.states)
+			}
+			if err != nil {
+				mgr.handshakeHandler.OnHandshake(mgr, addr, err, Error2Source(err))
+				selector.Finish(mgr, false)
+				if ignoredErr := backendIO.Close(); ignoredErr != nil && !pnet.IsDisconnectError(ignoredErr) {
+					mgr.logger.Error("close new backend connection failed", zap.Error(ignoredErr))
+				}
+				// The token or the session states are rejected, so retrying other backends doesn't help.
+				if pnet.IsMySQLError(err) {
+					return nil, backoff.Permanent(err)
+				}
+				return nil, err
+			}
+			return backendIO, nil
+		},
+		backoff.WithContext(mgr.newExponentialBackOff(mgr.config.ConnectTimeout), bctx),
+	)
+	if err != nil {
+		return err
+	}
+
+	oldBackendIO := *mgr.backendIO.Load()
+	mgr.updateTraffic(oldBackendIO)
+	mgr.inBytes, mgr.inPackets, mgr.outBytes, mgr.outPackets = 0, 0, 0, 0
+	mgr.backendCompress = pnet.CompressionStats{}
+	mgr.updateTraffic(newBackendIO)
+	if ignoredErr := oldBackendIO.Close(); ignoredErr != nil && !pnet.IsDisconnectError(ignoredErr) {
+		mgr.logger.Error("close previous backend connection failed", zap.Error(ignoredErr))
+	}
+	// Remove the connection from the broken backend and then add it to the new one.
+	// A pending redirection is dropped because the router already forgets it in OnConnClosed.
+	mgr.redirectInfo.Store(nil)
+	if eventReceiver := mgr.getEventReceiver(); eventReceiver != nil {
+		if err := eventReceiver.OnConnClosed(oldBackendIO.RemoteAddr().String(), mgr); err != nil {
+			mgr.logger.Error("close connection error", zap.Stringer("backend_addr", oldBackendIO.RemoteAddr()), zap.NamedError("notify_err", err))
+		}
+	}
+	selector.Finish(mgr, true)
+	mgr.backendIO.Store(&newBackendIO)
+	mgr.curBackend = backend
+	mgr.setKeepAlive()
+	mgr.handshakeHandler.OnHandshake(mgr, mgr.ServerAddr(), nil, SrcNone)
+	return nil
+}
+
+func addFailoverMetrics(tp string, succeed bool) {
+	res := "succeed"
+	if !succeed {
+		res = "fail"
+	}
+	metrics.FailoverCounter.WithLabelValues(tp, res).Inc()
+}
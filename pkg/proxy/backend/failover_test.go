@@ -0,0 +1,350 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/stretchr/testify/require"
+)
+
+func enableFailover(cfg *testConfig) {
+	setFailoverConfig(config.BackendNamespace{Failover: true})(cfg)
+}
+
+func setFailoverConfig(backendCfg config.BackendNamespace) cfgOverrider {
+	return func(cfg *testConfig) {
+		cfg.proxyConfig.handler.handleHandshakeResp = func(ctx ConnContext, resp *pnet.HandshakeResp) error {
+			ctx.SetValue(ConnContextKeyNamespace, &config.Namespace{Backend: backendCfg})
+			return nil
+		}
+	}
+}
+
+// fastCheckpoint makes the checkpoint save the snapshot soon after the session states change.
+func fastCheckpoint(cfg *testConfig) {
+	cfg.proxyConfig.bcConfig.TickerInterval = 10 * time.Millisecond
+}
+
+// saveSnapshot responds to `SHOW SESSION_STATES` of the checkpoint and waits for the snapshot to be saved.
+func (ts *backendMgrTester) saveSnapshot(states string) runner {
+	return runner{
+		backend: func(packetIO pnet.PacketIO) error {
+			ts.mb.respondType = responseTypeResultSet
+			ts.mb.sessionStates = states
+			return ts.mb.respond(packetIO)
+		},
+		proxy: func(clientIO, backendIO pnet.PacketIO) error {
+			ts.waitSnapshot(states)
+			return nil
+		},
+	}
+}
+
+func (ts *backendMgrTester) waitSnapshot(states string) {
+	require.Eventually(ts.t, func() bool {
+		ts.mp.processLock.Lock()
+		defer ts.mp.processLock.Unlock()
+		return ts.mp.snapshot != nil && ts.mp.snapshot.states == states
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+// crashAndRecover4Backend closes the connection after receiving the request and then accepts the new connection.
+func (ts *backendMgrTester) crashAndRecover4Backend(packetIO pnet.PacketIO) error {
+	_, err := packetIO.ReadPacket()
+	require.NoError(ts.t, err)
+	require.NoError(ts.t, packetIO.Close())
+	require.NoError(ts.t, ts.handshake4Backend(packetIO))
+	// respond to `SET SESSION_STATES`
+	return ts.respondWithNoTxn4Backend(ts.tc.backendIO)
+}
+
+// runFailoverTests doesn't compare the packet sequences because the proxy sends extra queries to the backends.
+func (ts *backendMgrTester) runFailoverTests(runners []runner) {
+	for _, runner := range runners {
+		ts.runAndCheck(ts.t, func(t *testing.T, ts *testSuite) {
+			require.NoError(t, ts.mc.err)
+			require.NoError(t, ts.mb.err)
+		}, runner.client, runner.backend, runner.proxy)
+	}
+}
+
+func readFailoverCounter(t *testing.T, tp, res string) int {
+	count, err := metrics.ReadCounter(metrics.FailoverCounter.WithLabelValues(tp, res))
+	require.NoError(t, err)
+	return count
+}
+
+func TestFailoverRetry(t *testing.T) {
+	ts := newBackendMgrTester(t, enableFailover, fastCheckpoint)
+	reconnects, retries := readFailoverCounter(t, failoverReconnect, "succeed"), readFailoverCounter(t, failoverRetry, "succeed")
+	runners := []runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		ts.saveSnapshot(mockSessionStates),
+		// The read-only statement is retried on the new backend.
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "SELECT 1"
+				return ts.mc.request(packetIO)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				backend1 := ts.mp.backendIO.Load()
+				require.NoError(t, ts.forwardCmd4Proxy(clientIO, backendIO))
+				require.NotEqual(t, backend1, ts.mp.backendIO.Load())
+				ts.mp.getEventReceiver().(*mockEventReceiver).checkEvent(t, eventClose)
+				return nil
+			},
+			backend: func(packetIO pnet.PacketIO) error {
+				require.NoError(t, ts.crashAndRecover4Backend(packetIO))
+				ts.mb.respondType = responseTypeResultSet
+				ts.mb.columns = 2
+				return ts.mb.respond(ts.tc.backendIO)
+			},
+		},
+		// The other statements are not retried but the session is still recovered.
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "INSERT INTO t VALUES (1)"
+				require.NoError(t, ts.mc.request(packetIO))
+				var myErr *mysql.MyError
+				require.ErrorAs(t, ts.mc.mysqlErr, &myErr)
+				require.Equal(t, errFailoverNotRetried.Code, myErr.Code)
+				return nil
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.NoError(t, ts.forwardCmd4Proxy(clientIO, backendIO))
+				ts.mp.getEventReceiver().(*mockEventReceiver).checkEvent(t, eventClose)
+				return nil
+			},
+			backend: ts.crashAndRecover4Backend,
+		},
+		// The session still works.
+		{
+			client:  ts.mc.request,
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.respondWithNoTxn4Backend,
+		},
+	}
+	ts.runFailoverTests(runners)
+	require.Equal(t, reconnects+2, readFailoverCounter(t, failoverReconnect, "succeed"))
+	require.Equal(t, retries+1, readFailoverCounter(t, failoverRetry, "succeed"))
+}
+
+func TestNoFailover(t *testing.T) {
+	tests := []struct {
+		enabled bool
+		// prepare runs a command before the backend crashes.
+		prepare func(ts *backendMgrTester) runner
+	}{
+		{
+			enabled: false,
+		},
+		{
+			// The session is in a transaction.
+			enabled: true,
+			prepare: func(ts *backendMgrTester) runner {
+				return runner{client: ts.mc.request, proxy: ts.forwardCmd4Proxy, backend: ts.startTxn4Backend}
+			},
+		},
+		{
+			// The session states are changed and the snapshot is not refreshed yet.
+			enabled: true,
+			prepare: func(ts *backendMgrTester) runner {
+				return runner{
+					client: func(packetIO pnet.PacketIO) error {
+						ts.mc.sql = "SET @a = 1"
+						return ts.mc.request(packetIO)
+					},
+					proxy: func(clientIO, backendIO pnet.PacketIO) error {
+						require.NoError(ts.t, ts.forwardCmd4Proxy(clientIO, backendIO))
+						require.Nil(ts.t, ts.mp.snapshot)
+						return nil
+					},
+					backend: ts.respondWithNoTxn4Backend,
+				}
+			},
+		},
+	}
+	for i, test := range tests {
+		ts := newBackendMgrTester(t, func(cfg *testConfig) {
+			if test.enabled {
+				enableFailover(cfg)
+			}
+		})
+		runners := make([]runner, 0, 4)
+		runners = append(runners, runner{client: ts.mc.authenticate, proxy: ts.firstHandshake4Proxy, backend: ts.handshake4Backend})
+		if test.enabled {
+			// Save the snapshot directly because the checkpoint doesn't run in time.
+			runners = append(runners, runner{proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				ts.mp.snapshot, ts.mp.snapshotDirty = &sessionSnapshot{states: mockSessionStates}, false
+				return nil
+			}})
+		}
+		if test.prepare != nil {
+			runners = append(runners, test.prepare(ts))
+		}
+		runners = append(runners, runner{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "SELECT 1"
+				require.Error(t, ts.mc.request(packetIO), "case %d", i)
+				return nil
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				request, err := clientIO.ReadPacket()
+				require.NoError(t, err, "case %d", i)
+				err = ts.mp.ExecuteCmd(context.Background(), request)
+				require.ErrorIs(t, err, ErrBackendConn, "case %d", i)
+				return clientIO.Close()
+			},
+			backend: func(packetIO pnet.PacketIO) error {
+				_, err := packetIO.ReadPacket()
+				require.NoError(t, err, "case %d", i)
+				return packetIO.Close()
+			},
+		})
+		ts.runFailoverTests(runners)
+	}
+}
+
+func TestRefreshSnapshot(t *testing.T) {
+	ts := newBackendMgrTester(t, enableFailover, fastCheckpoint)
+	runners := []runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// The first snapshot is saved in the background.
+		ts.saveSnapshot(mockSessionStates),
+		// The snapshot is refreshed after the session states change.
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "SET @a = 1"
+				return ts.mc.request(packetIO)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.NoError(t, ts.forwardCmd4Proxy(clientIO, backendIO))
+				ts.waitSnapshot(`{"user-var":"1"}`)
+				return nil
+			},
+			backend: func(packetIO pnet.PacketIO) error {
+				require.NoError(t, ts.respondWithNoTxn4Backend(packetIO))
+				ts.mb.respondType = responseTypeResultSet
+				ts.mb.sessionStates = `{"user-var":"1"}`
+				return ts.mb.respond(packetIO)
+			},
+		},
+		// The snapshot is refreshed after the transaction finishes.
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "BEGIN"
+				return ts.mc.request(packetIO)
+			},
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.startTxn4Backend,
+		},
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "USE db"
+				return ts.mc.request(packetIO)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.NoError(t, ts.forwardCmd4Proxy(clientIO, backendIO))
+				// The checkpoint doesn't run in a transaction.
+				time.Sleep(50 * time.Millisecond)
+				ts.mp.processLock.Lock()
+				require.Nil(t, ts.mp.snapshot)
+				ts.mp.processLock.Unlock()
+				return nil
+			},
+			backend: ts.startTxn4Backend,
+		},
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				ts.mc.sql = "COMMIT"
+				return ts.mc.request(packetIO)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				require.NoError(t, ts.forwardCmd4Proxy(clientIO, backendIO))
+				ts.waitSnapshot(`{"current-db":"db"}`)
+				return nil
+			},
+			backend: func(packetIO pnet.PacketIO) error {
+				require.NoError(t, ts.respondWithNoTxn4Backend(packetIO))
+				ts.mb.respondType = responseTypeResultSet
+				ts.mb.sessionStates = `{"current-db":"db"}`
+				return ts.mb.respond(packetIO)
+			},
+		},
+	}
+	ts.runFailoverTests(runners)
+}
+
+func TestRestoreIdleSession(t *testing.T) {
+	ts := newBackendMgrTester(t, setFailoverConfig(config.BackendNamespace{Failover: true, CheckpointInterval: 1}), func(cfg *testConfig) {
+		cfg.proxyConfig.bcConfig.TickerInterval = 10 * time.Millisecond
+		cfg.proxyConfig.bcConfig.CheckBackendInterval = 10 * time.Millisecond
+	})
+	runners := []runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// The snapshot is saved in the background.
+		ts.saveSnapshot(`{"current-db":"db"}`),
+		// The backend crashes when the session is idle and the session is restored on a new backend.
+		{
+			backend: func(packetIO pnet.PacketIO) error {
+				require.NoError(t, packetIO.Close())
+				require.NoError(t, ts.handshake4Backend(packetIO))
+				// respond to `SET SESSION_STATES`
+				return ts.respondWithNoTxn4Backend(ts.tc.backendIO)
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				ts.mp.getEventReceiver().(*mockEventReceiver).checkEvent(t, eventClose)
+				require.Equal(t, statusActive, ts.mp.closeStatus.Load())
+				return nil
+			},
+		},
+		// The session still works.
+		{
+			client:  ts.mc.request,
+			proxy:   ts.forwardCmd4Proxy,
+			backend: ts.respondWithNoTxn4Backend,
+		},
+	}
+	ts.runFailoverTests(runners)
+	require.Equal(t, "db", ts.mp.authenticator.dbname)
+}
+
+func TestCanRetry(t *testing.T) {
+	tests := []struct {
+		request []byte
+		retry   bool
+	}{
+		{pnet.MakeQueryPacket("SELECT * FROM t"), true},
+		{pnet.MakeQueryPacket("SHOW TABLES"), true},
+		{pnet.MakeQueryPacket("SELECT * FROM t FOR UPDATE"), false},
+		{pnet.MakeQueryPacket("SELECT a INTO @a FROM t"), false},
+		{pnet.MakeQueryPacket("SELECT nextval(seq)"), false},
+		{pnet.MakeQueryPacket("SET @a = 1"), false},
+		{pnet.MakeQueryPacket("DO sleep(1)"), false},
+		{pnet.MakeQueryPacket("INSERT INTO t VALUES (1)"), false},
+		{[]byte{pnet.ComStmtExecute.Byte(), 1, 0, 0, 0}, false},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.retry, canRetry(test.request), "case %d", i)
+	}
+}
@@ -21,6 +21,12 @@ const (
 	ConnContextKeyTLSState ConnContextKey = "tls-state"
 	ConnContextKeyConnID   ConnContextKey = "conn-id"
 	ConnContextKeyConnAddr ConnContextKey = "conn-addr"
+	// ConnContextKeyNamespace is the *config.Namespace of the namespace that the connection belongs to.
+	ConnContextKeyNamespace ConnContextKey = "namespace"
+	// ConnContextKeyCertIdentity is the *CertIdentity of the client certificate.
+	ConnContextKeyCertIdentity ConnContextKey = "cert-identity"
+	// ConnContextKeyMaintenance is the *namespace.MaintenanceState of the namespace that the connection belongs to.
+	ConnContextKeyMaintenance ConnContextKey = "maintenance"
 )
 
 var _ HandshakeHandler = (*DefaultHandshakeHandler)(nil)
@@ -70,14 +76,28 @@ func (handler *DefaultHandshakeHandler) HandleHandshakeErr(ctx ConnContext, err
 }
 
 func (handler *DefaultHandshakeHandler) GetRouter(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
-	ns, ok := handler.nsManager.GetNamespaceByUser(resp.User)
-	if !ok {
-		ns, ok = handler.nsManager.GetNamespace("default")
-	}
-	if !ok {
-		return nil, errors.New("failed to find a namespace")
+	var ns *namespace.Namespace
+	var ok bool
+	if identity, _ := ctx.Value(ConnContextKeyCertIdentity).(*CertIdentity); identity != nil && identity.Namespace != "" {
+		if ns, ok = handler.nsManager.GetNamespace(identity.Namespace); !ok {
+			return nil, errors.Errorf("failed to find namespace %s mapped from the client certificate", identity.Namespace)
+		}
+	} else {
+		ns, ok = handler.nsManager.GetNamespaceByUser(resp.User)
+		if !ok {
+			ns, ok = handler.nsManager.GetNamespace("default")
+		}
+		if !ok {
+			return nil, errors.New("failed to find a namespace")
+		}
 	}
 	ctx.UpdateLogger(zap.String("ns", ns.Name()))
+	if cfg := ns.Config(); cfg != nil {
+		ctx.SetValue(ConnContextKeyNamespace, cfg)
+	}
+	if state := ns.Maintenance(); state != nil {
+		ctx.SetValue(ConnContextKeyMaintenance, state)
+	}
 	return ns.GetRouter(), nil
 }
 
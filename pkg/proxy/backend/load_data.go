@@ -0,0 +1,123 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
+)
+
+const (
+	loadDataAllow  = "allow"
+	loadDataDeny   = "deny"
+	loadDataExceed = "exceed"
+)
+
+var (
+	errLoadDataDenied  = mysql.NewError(mysql.ER_NOT_ALLOWED_COMMAND, "LOAD DATA LOCAL INFILE is denied by TiProxy")
+	errLoadDataAborted = errors.New("LOAD DATA LOCAL INFILE exceeds max-load-data-bytes, close the session to abort it")
+)
+
+// checkLoadData rejects LOAD DATA LOCAL INFILE before sending it to the backend if it's denied.
+// If the statement is rejected, the error is sent to the client and returned.
+func (cp *CmdProcessor) checkLoadData(clientIO pnet.PacketIO, request []byte) error {
+	if !cp.loadDataDeny || pnet.Command(request[0]) != pnet.ComQuery {
+		return nil
+	}
+	if !lex.IsLoadDataLocal(pnet.ParseQueryPacket(request[1:])) {
+		return nil
+	}
+	metrics.LoadDataCounter.WithLabelValues(loadDataDeny).Inc()
+	if err := clientIO.WritePacket(pnet.MakeErrPacket(errLoadDataDenied), true); err != nil {
+		return err
+	}
+	return errLoadDataDenied
+}
+
+// forwardLoadInFile forwards the file from the client to the backend after the backend requests it.
+// If the file is denied, the backend receives an empty file and rejected is returned so that the caller drains the
+// remaining results and then sends the error to the client.
+// The file is streamed to the backend. If it exceeds max-load-data-bytes, the part that is already sent can't be
+// withdrawn, so the error is sent to the client and errLoadDataAborted is returned to close the session, which makes
+// the backend abort the statement.
+func (cp *CmdProcessor) forwardLoadInFile(clientIO, backendIO pnet.PacketIO, request []byte) (serverStatus uint16, rejected *mysql.MyError, err error) {
+	if err = clientIO.Flush(); err != nil {
+		return
+	}
+	// The previous statement is rejected and the results are being drained, so the client won't send the file.
+	if _, ok := clientIO.(discardIO); ok {
+		return cp.finishLoadInFile(clientIO, backendIO, request, nil)
+	}
+	if cp.loadDataDeny {
+		// The lexer may miss some statements, such as those with comments in the middle.
+		rejected = errLoadDataDenied
+		metrics.LoadDataCounter.WithLabelValues(loadDataDeny).Inc()
+	}
+	var exceeded *mysql.MyError
+	var size int64
+	hash := sha256.New()
+	// The client sends file data until an empty packet.
+	for {
+		var data []byte
+		if data, err = clientIO.ReadPacket(); err != nil {
+			return
+		}
+		if len(data) == 0 {
+			break
+		}
+		if rejected != nil || exceeded != nil {
+			continue
+		}
+		size += int64(len(data))
+		if cp.maxLoadDataBytes > 0 && size > cp.maxLoadDataBytes {
+			exceeded = mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("LOAD DATA LOCAL INFILE exceeds the max-load-data-bytes %d of TiProxy", cp.maxLoadDataBytes))
+			metrics.LoadDataCounter.WithLabelValues(loadDataExceed).Inc()
+			continue
+		}
+		hash.Write(data)
+		if err = backendIO.WritePacket(data, false); err != nil {
+			return
+		}
+	}
+	if exceeded != nil {
+		if err = clientIO.WritePacket(pnet.MakeErrPacket(exceeded), true); err != nil {
+			return
+		}
+		err = errors.Wrap(errLoadDataAborted, ErrProxyErr)
+		return
+	}
+	if rejected != nil {
+		return cp.finishLoadInFile(discardIO{clientIO}, backendIO, request, rejected)
+	}
+	cp.loadDataHash = hex.EncodeToString(hash.Sum(nil))
+	metrics.LoadDataCounter.WithLabelValues(loadDataAllow).Inc()
+	metrics.LoadDataBytesCounter.Add(float64(size))
+	return cp.finishLoadInFile(clientIO, backendIO, request, nil)
+}
+
+// finishLoadInFile sends the empty packet that ends the file and then forwards the response.
+func (cp *CmdProcessor) finishLoadInFile(clientIO, backendIO pnet.PacketIO, request []byte, rejected *mysql.MyError) (serverStatus uint16, _ *mysql.MyError, err error) {
+	if err = backendIO.WritePacket(nil, true); err != nil {
+		return
+	}
+	var response []byte
+	if response, err = forwardOnePacket(clientIO, backendIO, true); err != nil {
+		return
+	}
+	switch response[0] {
+	case pnet.OKHeader.Byte():
+		return cp.handleOKPacket(request, response), rejected, nil
+	case pnet.ErrHeader.Byte():
+		return serverStatus, rejected, cp.handleErrorPacket(response)
+	}
+	// impossible here
+	return serverStatus, rejected, errors.Errorf("unexpected response, cmd:%d resp:%d", pnet.ComQuery, response[0])
+}
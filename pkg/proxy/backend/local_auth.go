@@ -0,0 +1,133 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"bytes"
+	"hash/maphash"
+	"net"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/userstore"
+	"github.com/siddontang/go/hack"
+	"go.uber.org/zap"
+)
+
+const (
+	// fastAuthOK is sent by the server after ShaCommand if the caching_sha2_password fast path succeeds.
+	fastAuthOK = 3
+	// requestPublicKey is sent by the client after FastAuthFail to request the RSA public key on a plain connection.
+	requestPublicKey = 2
+)
+
+// unknownUserSeed makes the plugin of an unknown user stable in the process but unpredictable to the clients.
+var unknownUserSeed = maphash.MakeSeed()
+
+// unknownUser returns the user to authenticate a user that is not in the store. It goes through the same exchange as
+// an existing user so that the clients can't tell whether a user exists. The plugin is chosen by the hash of the name
+// so that retrying the same name always gets the same exchange.
+func unknownUser(name string) *userstore.User {
+	plugin := pnet.AuthNativePassword
+	if maphash.String(unknownUserSeed, name)%2 == 1 {
+		plugin = pnet.AuthCachingSha2Password
+	}
+	return &userstore.User{Name: name, Plugin: plugin, AuthString: "*"}
+}
+
+// authenticateLocally verifies the client with the local user store.
+// The mysql_native_password scramble is verified directly. The caching_sha2_password hash can only be verified with
+// the clear text password, so it always performs the full authentication, which requires TLS.
+func authenticateLocally(logger *zap.Logger, store *userstore.Store, clientIO pnet.PacketIO, resp *pnet.HandshakeResp, salt []byte, isSSL bool) (*userstore.User, error) {
+	user, ok := store.Get(resp.User)
+	if !ok {
+		user = unknownUser(resp.User)
+	}
+	var err error
+	verified := false
+	authData := resp.AuthData
+	if resp.AuthPlugin != user.Plugin {
+		// Ask the client to scramble the password with the plugin of the user.
+		var s [20]byte
+		copy(s[:], salt)
+		if err = clientIO.WritePacket(pnet.MakeSwitchRequest(user.Plugin, s), true); err != nil {
+			return nil, err
+		}
+		if authData, err = clientIO.ReadPacket(); err != nil {
+			return nil, err
+		}
+	}
+	switch user.Plugin {
+	case pnet.AuthNativePassword:
+		verified = user.CheckScramble(salt, authData)
+	case pnet.AuthCachingSha2Password:
+		if len(authData) == 0 {
+			verified = user.CheckPassword("")
+			break
+		}
+		if err = clientIO.WritePacket(pnet.MakeShaCommand(), true); err != nil {
+			return nil, err
+		}
+		if authData, err = clientIO.ReadPacket(); err != nil {
+			return nil, err
+		}
+		if !isSSL || (len(authData) == 1 && authData[0] == requestPublicKey) {
+			logger.Debug("caching_sha2_password requires TLS in the proxy-auth mode", zap.String("user", resp.User))
+		} else {
+			verified = user.CheckPassword(string(bytes.TrimSuffix(authData, []byte{0})))
+		}
+	}
+	if ok && verified {
+		return user, nil
+	}
+	return nil, writeAccessDenied(clientIO, resp)
+}
+
+// writeAccessDenied sends ER_ACCESS_DENIED_ERROR to the client and returns it.
+func writeAccessDenied(clientIO pnet.PacketIO, resp *pnet.HandshakeResp) error {
+	host := ""
+	if addr := clientIO.RemoteAddr(); addr != nil {
+		host, _, _ = net.SplitHostPort(addr.String())
+	}
+	usingPassword := "NO"
+	if len(resp.AuthData) > 0 {
+		usingPassword = "YES"
+	}
+	myErr := mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, resp.User, host, usingPassword)
+	if err := clientIO.WritePacket(pnet.MakeErrPacket(myErr), true); err != nil {
+		return err
+	}
+	return errors.Wrap(myErr, ErrClientAuthFail)
+}
+
+// respondBackendAuth answers the auth switch request or the caching_sha2_password request from the backend with the
+// password. It returns the auth data that is last sent.
+func respondBackendAuth(backendIO pnet.PacketIO, pkt []byte, password string, authData []byte) ([]byte, error) {
+	var err error
+	switch pkt[0] {
+	case pnet.AuthSwitchHeader.Byte():
+		idx := bytes.IndexByte(pkt[1:], 0)
+		authPlugin := string(pkt[1 : idx+1])
+		salt := pkt[idx+2 : len(pkt)-1]
+		if len(salt) != 20 {
+			return nil, mysql.ErrMalformPacket
+		}
+		if authData, err = pnet.GenerateAuthResp(password, authPlugin, salt); err != nil {
+			return nil, errors.Wrap(err, ErrBackendHandshake)
+		}
+	case pnet.ShaCommand:
+		if len(pkt) == 2 && pkt[1] == fastAuthOK {
+			return authData, nil
+		}
+		// Full authentication sends the clear text password.
+		authData = append(hack.Slice(password), 0)
+	default:
+		return nil, mysql.ErrMalformPacket
+	}
+	if err = backendIO.WritePacket(authData, true); err != nil {
+		return nil, err
+	}
+	return authData, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/pkg/manager/namespace"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaintenanceHoldTimeout = 30 * time.Second
+	defaultMaintenanceMessage     = "the cluster is under maintenance, please retry later"
+)
+
+func maintenanceError(cfg *config.Maintenance) *mysql.MyError {
+	code, msg := cfg.ErrorCode, cfg.ErrorMessage
+	if code == 0 {
+		code = mysql.ER_UNKNOWN_ERROR
+	}
+	if len(msg) == 0 {
+		msg = defaultMaintenanceMessage
+	}
+	return mysql.NewError(code, msg)
+}
+
+func holdTimeout(cfg *config.Maintenance) time.Duration {
+	if cfg.HoldTimeout == 0 {
+		return defaultMaintenanceHoldTimeout
+	}
+	return time.Duration(cfg.HoldTimeout) * time.Second
+}
+
+// waitMaintenance waits until the maintenance ends or the hold timeout elapses.
+// It returns the maintenance mode if the namespace is still under maintenance.
+func waitMaintenance(ctx context.Context, state *namespace.MaintenanceState, cfg *config.Maintenance) *config.Maintenance {
+	timer := time.NewTimer(holdTimeout(cfg))
+	defer timer.Stop()
+	for {
+		cfg, changed := state.Get()
+		if cfg == nil {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return cfg
+		case <-ctx.Done():
+			return cfg
+		}
+	}
+}
+
+// checkMaintenance is called before connecting to the backend. If the namespace is under maintenance in the error
+// mode, it sends the maintenance error to the client. In the hold mode, it returns the hold timeout, within which
+// the connection waits for an available backend.
+func (mgr *BackendConnManager) checkMaintenance(cctx ConnContext) (time.Duration, error) {
+	state, _ := cctx.Value(ConnContextKeyMaintenance).(*namespace.MaintenanceState)
+	if state == nil {
+		return 0, nil
+	}
+	mgr.maintenance = state
+	cfg, _ := state.Get()
+	if cfg == nil {
+		return 0, nil
+	}
+	if cfg.Mode == config.MaintenanceModeHold {
+		mgr.logger.Debug("namespace is under maintenance, hold the connection until a backend is available")
+		return holdTimeout(cfg), nil
+	}
+	return 0, mgr.rejectForMaintenance(cfg)
+}
+
+// holdFailed is called when no backend is available within the hold timeout. It sends the maintenance error to the
+// client if the namespace is still under maintenance, otherwise it returns the original error.
+func (mgr *BackendConnManager) holdFailed(err error) error {
+	cfg, _ := mgr.maintenance.Get()
+	if cfg == nil {
+		return err
+	}
+	return mgr.rejectForMaintenance(cfg)
+}
+
+func (mgr *BackendConnManager) rejectForMaintenance(cfg *config.Maintenance) error {
+	myErr := maintenanceError(cfg)
+	if mgr.clientIO != nil {
+		if err := mgr.clientIO.WritePacket(pnet.MakeErrPacket(myErr), true); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(myErr, ErrMaintenance)
+}
+
+// holdForMaintenance holds the command of an existing session until the maintenance ends.
+// It returns the maintenance error if the maintenance doesn't end within the hold timeout.
+// It doesn't hold the commands that have no response.
+func (mgr *BackendConnManager) holdForMaintenance(ctx context.Context, cmd pnet.Command) *mysql.MyError {
+	if mgr.maintenance == nil {
+		return nil
+	}
+	switch cmd {
+	case pnet.ComQuit, pnet.ComStmtClose, pnet.ComStmtSendLongData:
+		return nil
+	}
+	cfg, _ := mgr.maintenance.Get()
+	if cfg == nil || cfg.Sessions != config.MaintenanceSessionsHold {
+		return nil
+	}
+	mgr.logger.Debug("namespace is under maintenance, hold the command", zap.Stringer("cmd", cmd))
+	if cfg = waitMaintenance(ctx, mgr.maintenance, cfg); cfg == nil {
+		return nil
+	}
+	return maintenanceError(cfg)
+}
+
+// shouldCloseForMaintenance returns whether the session should be closed because of the maintenance.
+func (mgr *BackendConnManager) shouldCloseForMaintenance() bool {
+	if mgr.maintenance == nil {
+		return false
+	}
+	cfg, _ := mgr.maintenance.Get()
+	return cfg != nil && cfg.Sessions == config.MaintenanceSessionsClose
+}
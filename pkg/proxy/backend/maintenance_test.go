@@ -0,0 +1,195 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/pkg/balance/router"
+	"github.com/pingcap/tiproxy/pkg/manager/namespace"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/stretchr/testify/require"
+)
+
+func setMaintenanceState(state *namespace.MaintenanceState) cfgOverrider {
+	return func(config *testConfig) {
+		config.proxyConfig.bcConfig.TickerInterval = time.Millisecond
+		config.proxyConfig.handler.handleHandshakeResp = func(ctx ConnContext, resp *pnet.HandshakeResp) error {
+			ctx.SetValue(ConnContextKeyMaintenance, state)
+			return nil
+		}
+	}
+}
+
+func noBackend(config *testConfig) {
+	config.proxyConfig.bcConfig.ConnectTimeout = 100 * time.Millisecond
+	config.proxyConfig.handler.getRouter = func(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
+		return router.NewStaticRouter(nil), nil
+	}
+}
+
+func TestMaintenanceRejectNewConn(t *testing.T) {
+	state := namespace.NewMaintenanceState()
+	tests := []*config.Maintenance{
+		{Mode: config.MaintenanceModeError, ErrorCode: 9001, ErrorMessage: "upgrading"},
+		// No backend is available within the hold timeout.
+		{Mode: config.MaintenanceModeHold, ErrorCode: 9001, ErrorMessage: "upgrading", HoldTimeout: 1},
+	}
+	for i, test := range tests {
+		state.Set(test)
+		ts := newBackendMgrTester(t, setMaintenanceState(state), noBackend)
+		rn := runner{
+			client: func(packetIO pnet.PacketIO) error {
+				require.NoError(t, ts.mc.authenticate(packetIO), "case %d", i)
+				var myErr *mysql.MyError
+				require.ErrorAs(t, ts.mc.mysqlErr, &myErr, "case %d", i)
+				require.Equal(t, uint16(9001), myErr.Code, "case %d", i)
+				require.Equal(t, "upgrading", myErr.Message, "case %d", i)
+				return nil
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				startTime := time.Now()
+				err := ts.mp.Connect(context.Background(), clientIO, ts.mp.frontendTLSConfig, ts.mp.backendTLSConfig, "", "")
+				require.ErrorIs(t, err, ErrMaintenance, "case %d", i)
+				require.Equal(t, SrcMaintenance, ts.mp.QuitSource(), "case %d", i)
+				if test.Mode == config.MaintenanceModeHold {
+					// It keeps waiting for a backend longer than the connect timeout.
+					require.Greater(t, time.Since(startTime), 500*time.Millisecond, "case %d", i)
+				}
+				return nil
+			},
+		}
+		ts.runAndCheck(ts.t, func(t *testing.T, ts *testSuite) {}, rn.client, rn.backend, rn.proxy)
+	}
+}
+
+func TestMaintenanceHoldNewConn(t *testing.T) {
+	state := namespace.NewMaintenanceState()
+	state.Set(&config.Maintenance{Mode: config.MaintenanceModeHold, HoldTimeout: 10})
+	var addr string
+	ts := newBackendMgrTester(t, setMaintenanceState(state), func(config *testConfig) {
+		config.proxyConfig.bcConfig.ConnectTimeout = 100 * time.Millisecond
+		config.proxyConfig.handler.getRouter = func(ctx ConnContext, resp *pnet.HandshakeResp) (router.Router, error) {
+			return router.NewStaticRouter([]string{addr}), nil
+		}
+	})
+	// The backend is down at first and becomes available while the namespace is still under maintenance.
+	addr = ts.tc.backendListener.Addr().String()
+	require.NoError(t, ts.tc.backendListener.Close())
+	ts.runTests([]runner{
+		{
+			client: ts.mc.authenticate,
+			proxy:  ts.firstHandshake4Proxy,
+			backend: func(packetIO pnet.PacketIO) error {
+				time.Sleep(300 * time.Millisecond)
+				listener, err := net.Listen("tcp", addr)
+				require.NoError(t, err)
+				ts.tc.backendListener = listener
+				return ts.handshake4Backend(packetIO)
+			},
+		},
+	})
+	require.True(t, ts.mc.authSucceed)
+	cfg, _ := state.Get()
+	require.NotNil(t, cfg)
+}
+
+func TestMaintenanceEndsWithoutBackend(t *testing.T) {
+	state := namespace.NewMaintenanceState()
+	state.Set(&config.Maintenance{Mode: config.MaintenanceModeHold, HoldTimeout: 1})
+	ts := newBackendMgrTester(t, setMaintenanceState(state), noBackend)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		state.Set(nil)
+	}()
+	rn := runner{
+		client: func(packetIO pnet.PacketIO) error {
+			require.NoError(t, ts.mc.authenticate(packetIO))
+			require.ErrorContains(t, ts.mc.mysqlErr, ErrProxyNoBackend.Error())
+			return nil
+		},
+		proxy: func(clientIO, backendIO pnet.PacketIO) error {
+			// The connection is not held forever even if the maintenance ends.
+			err := ts.mp.Connect(context.Background(), clientIO, ts.mp.frontendTLSConfig, ts.mp.backendTLSConfig, "", "")
+			require.ErrorIs(t, err, ErrProxyNoBackend)
+			require.Equal(t, SrcProxyNoBackend, ts.mp.QuitSource())
+			return nil
+		},
+	}
+	ts.runAndCheck(ts.t, func(t *testing.T, ts *testSuite) {}, rn.client, rn.backend, rn.proxy)
+}
+
+func TestMaintenanceSessions(t *testing.T) {
+	state := namespace.NewMaintenanceState()
+	ts := newBackendMgrTester(t, setMaintenanceState(state))
+	runners := []runner{
+		{
+			client:  ts.mc.authenticate,
+			proxy:   ts.firstHandshake4Proxy,
+			backend: ts.handshake4Backend,
+		},
+		// The command is held until the maintenance ends.
+		{
+			client: ts.mc.request,
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				state.Set(&config.Maintenance{Mode: config.MaintenanceModeError, Sessions: config.MaintenanceSessionsHold, HoldTimeout: 10})
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					state.Set(nil)
+				}()
+				return ts.forwardCmd4Proxy(clientIO, backendIO)
+			},
+			backend: ts.respondWithNoTxn4Backend,
+		},
+		// The command gets the maintenance error after the hold timeout.
+		{
+			client: func(packetIO pnet.PacketIO) error {
+				if err := ts.mc.request(packetIO); err != nil {
+					return err
+				}
+				var myErr *mysql.MyError
+				require.ErrorAs(t, ts.mc.mysqlErr, &myErr)
+				require.Equal(t, uint16(mysql.ER_UNKNOWN_ERROR), myErr.Code)
+				require.Equal(t, defaultMaintenanceMessage, myErr.Message)
+				return nil
+			},
+			proxy: func(clientIO, backendIO pnet.PacketIO) error {
+				state.Set(&config.Maintenance{Mode: config.MaintenanceModeError, Sessions: config.MaintenanceSessionsHold, HoldTimeout: 1})
+				clientIO.ResetSequence()
+				request, err := clientIO.ReadPacket()
+				require.NoError(t, err)
+				cpt := &mockCapture{}
+				ts.mp.cpt = cpt
+				err = ts.mp.ExecuteCmd(context.Background(), request)
+				ts.mp.cpt = nil
+				require.True(t, pnet.IsMySQLError(err))
+				// The rejected command is not captured.
+				require.Nil(t, cpt.packet)
+				return nil
+			},
+		},
+		// Existing sessions are closed.
+		{
+			proxy: func(_, _ pnet.PacketIO) error {
+				state.Set(&config.Maintenance{Mode: config.MaintenanceModeError, Sessions: config.MaintenanceSessionsClose})
+				return nil
+			},
+		},
+		{
+			proxy: ts.checkConnClosed4Proxy,
+		},
+		{
+			proxy: func(_, _ pnet.PacketIO) error {
+				require.Equal(t, SrcMaintenance, ts.mp.QuitSource())
+				return nil
+			},
+		},
+	}
+	ts.runTests(runners)
+}
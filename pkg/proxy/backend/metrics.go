@@ -12,9 +12,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	compressLegFrontend = "frontend"
+	compressLegBackend  = "backend"
+)
+
 type mcPerCmd struct {
-	counter  prometheus.Counter
-	observer prometheus.Observer
+	counter    prometheus.Counter
+	errCounter prometheus.Counter
+	observer   prometheus.Observer
 }
 
 type mcPerBackend struct {
@@ -41,7 +47,7 @@ func newCmdMetricsCache() cmdMetricsCache {
 
 var cache = newCmdMetricsCache()
 
-func addCmdMetrics(cmd pnet.Command, addr string, startTime time.Time) {
+func addCmdMetrics(cmd pnet.Command, addr string, startTime time.Time, err error) {
 	cache.Lock()
 	defer cache.Unlock()
 	backendMetrics := ensureBackendMetrics(addr)
@@ -49,9 +55,13 @@ func addCmdMetrics(cmd pnet.Command, addr string, startTime time.Time) {
 	if mc.counter == nil {
 		label := cmd.String()
 		mc.counter = metrics.QueryTotalCounter.WithLabelValues(addr, label)
+		mc.errCounter = metrics.QueryErrorCounter.WithLabelValues(addr, label)
 		mc.observer = metrics.QueryDurationHistogram.WithLabelValues(addr, label)
 	}
 	mc.counter.Inc()
+	if err != nil {
+		mc.errCounter.Inc()
+	}
 	cost := time.Since(startTime)
 	mc.observer.Observe(cost.Seconds())
 }
@@ -70,6 +80,26 @@ func addTraffic(addr string, inBytes, inPackets, outBytes, outPackets uint64, lo
 	backendMetrics.outPackets.Add(float64(outPackets))
 }
 
+// addCompressionStats reports the compression cost since the last report and returns the current stats.
+// The compression ratio is raw bytes / wire bytes and the CPU cost is the time spent per raw byte.
+func addCompressionStats(leg string, cur, last pnet.CompressionStats) pnet.CompressionStats {
+	if cur.Algorithm == pnet.CompressionNone {
+		return cur
+	}
+	algorithm := cur.Algorithm.String()
+	add := func(op string, cur, last pnet.CompressionCost) {
+		if cur.RawBytes == last.RawBytes {
+			return
+		}
+		metrics.CompressRawBytesCounter.WithLabelValues(leg, algorithm, op).Add(float64(cur.RawBytes - last.RawBytes))
+		metrics.CompressWireBytesCounter.WithLabelValues(leg, algorithm, op).Add(float64(cur.WireBytes - last.WireBytes))
+		metrics.CompressDurationCounter.WithLabelValues(leg, algorithm, op).Add((cur.Duration - last.Duration).Seconds())
+	}
+	add("compress", cur.Compress, last.Compress)
+	add("decompress", cur.Decompress, last.Decompress)
+	return cur
+}
+
 func ensureBackendMetrics(addr string) *mcPerBackend {
 	backendMetrics, ok := cache.backendMetrics[addr]
 	if !ok {
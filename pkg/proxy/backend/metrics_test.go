@@ -8,17 +8,54 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pingcap/tiproxy/lib/util/errors"
 	"github.com/pingcap/tiproxy/pkg/metrics"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
 )
 
+func TestAddCompressionStats(t *testing.T) {
+	readCounter := func(counter *prometheus.CounterVec, op string) int {
+		val, err := metrics.ReadCounter(counter.WithLabelValues(compressLegBackend, "zstd", op))
+		require.NoError(t, err)
+		return val
+	}
+	raw, wire := readCounter(metrics.CompressRawBytesCounter, "compress"), readCounter(metrics.CompressWireBytesCounter, "compress")
+	last := addCompressionStats(compressLegBackend, pnet.CompressionStats{}, pnet.CompressionStats{})
+	cur := pnet.CompressionStats{
+		Algorithm: pnet.CompressionZstd,
+		Compress:  pnet.CompressionCost{RawBytes: 1000, WireBytes: 100, Duration: time.Millisecond},
+	}
+	last = addCompressionStats(compressLegBackend, cur, last)
+	require.Equal(t, raw+1000, readCounter(metrics.CompressRawBytesCounter, "compress"))
+	require.Equal(t, wire+100, readCounter(metrics.CompressWireBytesCounter, "compress"))
+	// Only the increment is reported.
+	cur.Compress.RawBytes, cur.Compress.WireBytes = 3000, 300
+	addCompressionStats(compressLegBackend, cur, last)
+	require.Equal(t, raw+3000, readCounter(metrics.CompressRawBytesCounter, "compress"))
+	require.Equal(t, wire+300, readCounter(metrics.CompressWireBytesCounter, "compress"))
+	require.Equal(t, 0, readCounter(metrics.CompressRawBytesCounter, "decompress"))
+}
+
+func TestAddCmdMetrics(t *testing.T) {
+	addr := "127.0.0.1:4001"
+	addCmdMetrics(pnet.ComQuery, addr, time.Now(), nil)
+	addCmdMetrics(pnet.ComQuery, addr, time.Now(), errors.New("mock error"))
+	total, err := readCmdCounter(pnet.ComQuery, addr)
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	failed, err := metrics.ReadCounter(metrics.QueryErrorCounter.WithLabelValues(addr, pnet.ComQuery.String()))
+	require.NoError(t, err)
+	require.Equal(t, 1, failed)
+}
+
 func BenchmarkAddCmdMetrics(b *testing.B) {
 	cmd := pnet.ComQuery
 	addr := "127.0.0.1:4000"
 	startTime := time.Now()
 	for i := 0; i < b.N; i++ {
-		addCmdMetrics(cmd, addr, startTime)
+		addCmdMetrics(cmd, addr, startTime, nil)
 	}
 }
 
@@ -10,6 +10,7 @@ import (
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/pingcap/tiproxy/lib/util/errors"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/proxyprotocol"
 )
 
 type backendConfig struct {
@@ -53,6 +54,7 @@ type mockBackend struct {
 	attrs     map[string]string
 	authData  []byte
 	zstdLevel int
+	proxy     *proxyprotocol.Proxy
 }
 
 func newMockBackend(cfg *backendConfig) *mockBackend {
@@ -79,6 +81,7 @@ func (mb *mockBackend) authenticate(packetIO pnet.PacketIO) error {
 	if clientPkt, err = packetIO.ReadPacket(); err != nil {
 		return packetIO.WritePacket(pnet.MakeErrPacket(mysql.NewError(mysql.ER_UNKNOWN_ERROR, err.Error())), true)
 	}
+	mb.proxy = packetIO.Proxy()
 	// upgrade to TLS
 	capability := binary.LittleEndian.Uint16(clientPkt[:2])
 	sslEnabled := pnet.Capability(capability)&pnet.ClientSSL > 0 && mb.capability&pnet.ClientSSL > 0
@@ -4,6 +4,7 @@
 package backend
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/binary"
 
@@ -26,6 +27,8 @@ type clientConfig struct {
 	collation  uint8
 	cmd        pnet.Command
 	zstdLevel  int
+	// password is used to scramble the auth data with the salt if it's set. Otherwise, authData is sent directly.
+	password string
 	// for both auth and cmd
 	abnormalExit bool
 }
@@ -73,6 +76,11 @@ func (mc *mockClient) authenticate(packetIO pnet.PacketIO) error {
 	mc.capability = mc.capability & initialHandshake.Capability
 	mc.serverVersion = initialHandshake.ServerVersion
 	mc.connid = initialHandshake.ConnID
+	if len(mc.password) > 0 {
+		if mc.authData, err = pnet.GenerateAuthResp(mc.password, mc.authPlugin, initialHandshake.Salt[:]); err != nil {
+			return err
+		}
+	}
 
 	resp := &pnet.HandshakeResp{
 		User:       mc.username,
@@ -108,12 +116,17 @@ func (mc *mockClient) writePassword(packetIO pnet.PacketIO) error {
 		switch serverPkt[0] {
 		case pnet.OKHeader.Byte():
 			mc.authSucceed = true
-			return nil
+			return setCompress(packetIO, mc.capability, mc.zstdLevel)
 		case pnet.ErrHeader.Byte():
 			mc.authSucceed = false
 			mc.mysqlErr = pnet.ParseErrorPacket(serverPkt)
 			return nil
 		case pnet.AuthSwitchHeader.Byte(), pnet.ShaCommand:
+			if len(mc.password) > 0 {
+				if err := mc.scramblePassword(serverPkt); err != nil {
+					return err
+				}
+			}
 			if err := packetIO.WritePacket(mc.authData, true); err != nil {
 				return err
 			}
@@ -121,6 +134,18 @@ func (mc *mockClient) writePassword(packetIO pnet.PacketIO) error {
 	}
 }
 
+// scramblePassword sets the auth data according to the auth switch request or the caching_sha2_password full auth request.
+func (mc *mockClient) scramblePassword(serverPkt []byte) (err error) {
+	if serverPkt[0] == pnet.AuthSwitchHeader.Byte() {
+		idx := bytes.IndexByte(serverPkt[1:], 0)
+		mc.authPlugin = string(serverPkt[1 : idx+1])
+		mc.authData, err = pnet.GenerateAuthResp(mc.password, mc.authPlugin, serverPkt[idx+2:len(serverPkt)-1])
+		return err
+	}
+	mc.authData = append([]byte(mc.password), 0)
+	return nil
+}
+
 // request sends commands except prepared statements commands.
 func (mc *mockClient) request(packetIO pnet.PacketIO) error {
 	if mc.abnormalExit {
@@ -191,6 +216,11 @@ func (mc *mockClient) requestChangeUser(packetIO pnet.PacketIO) error {
 			mc.mysqlErr = pnet.ParseErrorPacket(resp)
 			return nil
 		default:
+			if len(mc.password) > 0 {
+				if err := mc.scramblePassword(resp); err != nil {
+					return err
+				}
+			}
 			if err := packetIO.WritePacket(mc.authData, true); err != nil {
 				return err
 			}
@@ -335,6 +365,7 @@ func (mc *mockClient) readResultSet(packetIO pnet.PacketIO) error {
 			if pkt[0] == pnet.OKHeader.Byte() {
 				serverStatus = binary.LittleEndian.Uint16(pkt[3:])
 			} else {
+				mc.mysqlErr = pnet.ParseErrorPacket(pkt)
 				return nil
 			}
 		default:
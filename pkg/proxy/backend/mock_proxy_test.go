@@ -14,6 +14,7 @@ import (
 	"github.com/pingcap/tiproxy/lib/util/logger"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 	"go.uber.org/zap"
 )
 
@@ -26,9 +27,11 @@ type proxyConfig struct {
 	username          string
 	password          string
 	sessionToken      string
-	capability        pnet.Capability
-	waitRedirect      bool
-	connectionID      uint64
+	// credential overrides username and password when authenticating with the backend directly.
+	credential   *Credential
+	capability   pnet.Capability
+	waitRedirect bool
+	connectionID uint64
 }
 
 func newProxyConfig() *proxyConfig {
@@ -80,9 +83,16 @@ func (mp *mockProxy) authenticateSecondTime(clientIO, backendIO pnet.PacketIO) e
 	return mp.authenticator.handshakeSecondTime(mp.logger, clientIO, backendIO, mp.backendTLSConfig, mp.sessionToken)
 }
 
+func (mp *mockProxy) credential() Credential {
+	if mp.proxyConfig.credential != nil {
+		return *mp.proxyConfig.credential
+	}
+	return Credential{User: mp.username, Password: mp.password}
+}
+
 func (mp *mockProxy) authenticateWithBackend(_, backendIO pnet.PacketIO) error {
 	if err := mp.authenticator.handshakeWithBackend(context.Background(), mp.logger, mp, mp.handshakeHandler,
-		mp.username, mp.password, func(ctx context.Context, cctx ConnContext, resp *pnet.HandshakeResp) (pnet.PacketIO, error) {
+		mp.credential(), func(ctx context.Context, cctx ConnContext, resp *pnet.HandshakeResp) (pnet.PacketIO, error) {
 			return backendIO, nil
 		}, mp.backendTLSConfig); err != nil {
 		return err
@@ -121,6 +131,14 @@ type mockCapture struct {
 	packet    []byte
 	startTime time.Time
 	connID    uint64
+	// loadDataHash is the hash of the last captured LOAD DATA LOCAL INFILE.
+	loadDataHash string
+	// pending makes Capture wait for the result, which is recorded by CaptureResult.
+	pending   capture.Pending
+	succeeded bool
+	duration  time.Duration
+	result    *cmd.Result
+	closed    bool
 }
 
 func (mc *mockCapture) Start(cfg capture.CaptureConfig) error {
@@ -130,19 +148,39 @@ func (mc *mockCapture) Start(cfg capture.CaptureConfig) error {
 func (mc *mockCapture) Stop(err error) {
 }
 
-func (mc *mockCapture) InitConn(startTime time.Time, connID uint64, dbname string) {
-	mc.db = dbname
+func (mc *mockCapture) InitConn(startTime time.Time, connID uint64, info capture.ConnInfo) {
+	mc.db = info.DB
+	mc.startTime = startTime
+	mc.connID = connID
+}
+
+func (mc *mockCapture) CloseConn(startTime time.Time, connID uint64) {
+	mc.closed = true
 	mc.startTime = startTime
 	mc.connID = connID
 }
 
-func (mc *mockCapture) Capture(packet []byte, startTime time.Time, connID uint64, initSession func() (string, error)) {
+func (mc *mockCapture) Capture(packet []byte, startTime time.Time, connID uint64, session capture.Session) capture.Pending {
 	mc.packet = packet
 	mc.startTime = startTime
 	mc.connID = connID
-	if initSession != nil {
-		mc.initSql, _ = initSession()
+	if session != nil {
+		mc.initSql, _ = session.InitSession()
 	}
+	return mc.pending
+}
+
+func (mc *mockCapture) CaptureResult(connID uint64, succeeded bool, duration time.Duration, result *cmd.Result) {
+	mc.succeeded = succeeded
+	mc.duration = duration
+	mc.result = result
+}
+
+func (mc *mockCapture) CaptureLoadData(packet []byte, startTime time.Time, connID uint64, session capture.Session, payloadHash string) {
+	mc.packet = packet
+	mc.startTime = startTime
+	mc.connID = connID
+	mc.loadDataHash = payloadHash
 }
 
 func (mc *mockCapture) Progress() (float64, time.Time, bool, error) {
@@ -0,0 +1,147 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"sync"
+	"time"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
+	"github.com/siddontang/go/hack"
+)
+
+const (
+	ProcessStateIdle        = "idle"
+	ProcessStateExecuting   = "executing"
+	ProcessStateInTxn       = "in-txn"
+	ProcessStateRedirecting = "redirecting"
+)
+
+const redactedSQL = "<redacted>"
+
+// ProcessInfo is the state of a session in the processlist.
+type ProcessInfo struct {
+	ConnID     uint64 `json:"conn_id"`
+	User       string `json:"user"`
+	ClientAddr string `json:"client_addr"`
+	Namespace  string `json:"namespace,omitempty"`
+	Backend    string `json:"backend"`
+	State      string `json:"state"`
+	// Cmd and SQL are the current command and statement. SQL is redacted if it's sensitive.
+	Cmd string `json:"cmd,omitempty"`
+	SQL string `json:"sql,omitempty"`
+	// ElapsedMs is the time since the current command starts, or since the last command finishes if it's idle.
+	ElapsedMs int64 `json:"elapsed_ms"`
+}
+
+// ProcessLister lists the sessions of all the connections.
+type ProcessLister interface {
+	ProcessList() []ProcessInfo
+}
+
+// processState is read by other goroutines while the session is executing a command, so it's protected by its own
+// lock instead of processLock.
+type processState struct {
+	sync.Mutex
+	connected bool
+	user      string
+	namespace string
+	inTxn     bool
+	// request is the command being executed. It's cleared before the request buffer is reused.
+	request []byte
+	// since is the start time of the current command, or the end time of the last command.
+	since time.Time
+}
+
+// updateProcessState must be called after holding processLock.
+func (mgr *BackendConnManager) updateProcessState(request []byte, now time.Time) {
+	ps := &mgr.processState
+	ps.Lock()
+	ps.connected = true
+	ps.user = mgr.authenticator.user
+	ps.namespace = mgr.cmdProcessor.ruleScope.Namespace
+	ps.inTxn = !mgr.cmdProcessor.finishedTxn()
+	ps.request = request
+	ps.since = now
+	ps.Unlock()
+}
+
+// ProcessInfo returns the state of the session. It returns false if the session is not connected.
+func (mgr *BackendConnManager) ProcessInfo() (ProcessInfo, bool) {
+	ps := &mgr.processState
+	ps.Lock()
+	if !ps.connected {
+		ps.Unlock()
+		return ProcessInfo{}, false
+	}
+	info := ProcessInfo{
+		ConnID:     mgr.connectionID,
+		User:       ps.user,
+		ClientAddr: mgr.ClientAddr(),
+		Namespace:  ps.namespace,
+		Backend:    mgr.ServerAddr(),
+		ElapsedMs:  time.Since(ps.since).Milliseconds(),
+	}
+	switch {
+	case len(ps.request) > 0:
+		info.State = ProcessStateExecuting
+		cmd := pnet.Command(ps.request[0])
+		info.Cmd = cmd.String()
+		if cmd == pnet.ComQuery || cmd == pnet.ComStmtPrepare {
+			// Copy the statement before unlocking because the request buffer may be reused.
+			info.SQL = string(pnet.ParseQueryPacket(ps.request[1:]))
+		}
+	case mgr.redirectInfo.Load() != nil:
+		info.State = ProcessStateRedirecting
+	case ps.inTxn:
+		info.State = ProcessStateInTxn
+	default:
+		info.State = ProcessStateIdle
+	}
+	ps.Unlock()
+	if len(info.SQL) > 0 && lex.IsSensitiveSQL(info.SQL) {
+		info.SQL = redactedSQL
+	}
+	return info, true
+}
+
+var processListColumns = []string{"Id", "User", "Host", "Namespace", "Backend", "State", "Command", "Time", "Info"}
+
+// handleShowProcessList serves SHOW TIPROXY PROCESSLIST. Like SHOW PROCESSLIST without the PROCESS privilege,
+// it only shows the sessions of the current user because TiProxy can't check privileges.
+func (cp *CmdProcessor) handleShowProcessList(clientIO pnet.PacketIO, request []byte) (bool, error) {
+	if cp.processLister == nil || pnet.Command(request[0]) != pnet.ComQuery {
+		return false, nil
+	}
+	if !lex.IsShowTiProxyProcessList(hack.String(request[1:])) {
+		return false, nil
+	}
+	var rows [][]any
+	for _, info := range cp.processLister.ProcessList() {
+		if info.User != cp.ruleScope.User {
+			continue
+		}
+		var sql any
+		if len(info.SQL) > 0 {
+			sql = info.SQL
+		}
+		rows = append(rows, []any{info.ConnID, info.User, info.ClientAddr, info.Namespace, info.Backend, info.State,
+			info.Cmd, info.ElapsedMs / 1000, sql})
+	}
+	status := pnet.ServerStatusAutocommit
+	if !cp.finishedTxn() {
+		status |= pnet.ServerStatusInTrans
+	}
+	packets, err := pnet.MakeTextResultSet(processListColumns, rows, status, cp.capability)
+	if err != nil {
+		return true, err
+	}
+	for i, packet := range packets {
+		if err := clientIO.WritePacket(packet, i == len(packets)-1); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
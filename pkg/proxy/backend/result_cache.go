@@ -0,0 +1,176 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/tidb/parser"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/resultcache"
+	"github.com/pingcap/tiproxy/pkg/proxy/rule"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
+	"github.com/siddontang/go/hack"
+)
+
+// resultRecorder records the packets written to the client so that the result set can be cached.
+// It's not a *packetIO, so PacketIO.ForwardUntil writes the packets one by one.
+type resultRecorder struct {
+	pnet.PacketIO
+	packets  [][]byte
+	size     int64
+	maxBytes int64
+	exceeded bool
+}
+
+func (rr *resultRecorder) WritePacket(data []byte, flush bool) error {
+	// Stop recording once it exceeds the limit because it won't be cached anyway.
+	if !rr.exceeded {
+		rr.size += int64(len(data))
+		if rr.size > rr.maxBytes {
+			rr.exceeded = true
+			rr.packets = nil
+		} else {
+			rr.packets = append(rr.packets, append([]byte(nil), data...))
+		}
+	}
+	return rr.PacketIO.WritePacket(data, flush)
+}
+
+// forwardCachedQuery serves the statement from the result cache, or forwards it to the backend and caches the result.
+// Only deterministic COM_QUERY reads outside transactions are cached because the results in a transaction may
+// be different from those outside it.
+func (cp *CmdProcessor) forwardCachedQuery(clientIO, backendIO pnet.PacketIO, request []byte, res *rule.Result) error {
+	if !cp.cacheable(request) {
+		err := cp.forwardCommand(clientIO, backendIO, request)
+		cp.updateCacheStates(request, err == nil)
+		return err
+	}
+	normalized := lex.NormalizeText(hack.String(request[1:]))
+	_, digest := parser.NormalizeDigest(normalized)
+	key := resultcache.Key{
+		Namespace:    cp.ruleScope.Namespace,
+		User:         cp.ruleScope.User,
+		DB:           cp.curDB,
+		Digest:       digest.String(),
+		SQL:          normalized,
+		Vars:         cp.cacheVars,
+		DeprecateEOF: cp.capability&pnet.ClientDeprecateEOF > 0,
+	}
+	if packets := cp.resultCache.Get(key, res.Rule); packets != nil {
+		cp.resultUnknown = true
+		for i, packet := range packets {
+			if err := clientIO.WritePacket(packet, i == len(packets)-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	recorder := &resultRecorder{PacketIO: clientIO, maxBytes: res.CacheMaxBytes}
+	if err := cp.forwardCommand(recorder, backendIO, request); err != nil {
+		return err
+	}
+	// A SELECT statement may start a transaction if autocommit is off.
+	if !recorder.exceeded && cp.serverStatus&StatusInTrans == 0 {
+		cp.resultCache.Put(key, res.Rule, res.CacheTTL, res.CacheMaxBytes, recorder.packets)
+	}
+	return nil
+}
+
+// cacheable returns true if the result of the statement can be cached. INTO, locking reads, multi-statements,
+// executable comments and non-deterministic functions are refused, and so is the session whose DB or variables are
+// unknown.
+func (cp *CmdProcessor) cacheable(request []byte) bool {
+	if cp.resultCache == nil || pnet.Command(request[0]) != pnet.ComQuery || cp.serverStatus&StatusInTrans > 0 ||
+		cp.cacheStateUnknown {
+		return false
+	}
+	sql := hack.String(request[1:])
+	// The lexer skips comments, so anything hidden in /*! */ or /*T! */ is refused explicitly.
+	if lex.HasExecutableComment(sql) {
+		return false
+	}
+	return lex.IsDeterministicRead(sql)
+}
+
+// updateCacheStates updates the current DB and the session variables in the result cache key after the command.
+// The DB is changed only if the command succeeds. Multi-statements may change them even if they fail, and they are
+// not tracked, so the result cache is skipped for the session since then.
+func (cp *CmdProcessor) updateCacheStates(request []byte, succeed bool) {
+	switch pnet.Command(request[0]) {
+	case pnet.ComInitDB:
+		if succeed {
+			cp.curDB = string(request[1:])
+		}
+	case pnet.ComQuery:
+		sql := hack.String(request[1:])
+		if lex.HasMultiStatements(sql) {
+			cp.cacheStateUnknown = true
+			return
+		}
+		if !succeed {
+			return
+		}
+		if db, ok := lex.UseDB(sql); ok {
+			cp.curDB = strings.Clone(db)
+			return
+		}
+		vars, fixed := lex.ResultVars(sql)
+		if !fixed {
+			cp.cacheStateUnknown = true
+			return
+		}
+		if len(vars) == 0 {
+			return
+		}
+		if cp.resultVars == nil {
+			cp.resultVars = make(map[string]string, len(vars))
+		}
+		normalized := lex.NormalizeText(sql)
+		for _, v := range vars {
+			cp.resultVars[v] = normalized
+		}
+		cp.updateCacheVars()
+	case pnet.ComResetConnection:
+		// The DB is kept, so it's still unknown if it was.
+		if succeed {
+			cp.resultVars = nil
+			cp.updateCacheVars()
+		}
+	case pnet.ComChangeUser:
+		if !succeed {
+			return
+		}
+		// Critical errors should not happen because it has been parsed when forwarding.
+		req, _ := pnet.ParseChangeUser(request, cp.capability)
+		cp.curDB = strings.Clone(req.DB)
+		if len(req.Charset) > 0 {
+			cp.collation = req.Charset[0]
+		}
+		cp.resultVars = nil
+		cp.cacheStateUnknown = false
+		cp.updateCacheVars()
+	}
+}
+
+// updateCacheVars joins the handshake collation and the SET statements that changed the session variables in order.
+func (cp *CmdProcessor) updateCacheVars() {
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(int(cp.collation)))
+	names := make([]string, 0, len(cp.resultVars))
+	for name := range cp.resultVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sb.WriteString("\n")
+		sb.WriteString(name)
+		sb.WriteString("=")
+		sb.WriteString(cp.resultVars[name])
+	}
+	cp.cacheVars = sb.String()
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
+)
+
+const fnvPrime64 = 1099511628211
+
+// resultDigest accumulates the digest of the result sets of a command so that the results of traffic capture and
+// replay can be compared. Only the column types and the rows are hashed because the other metadata, such as the
+// column lengths and the server status, may differ across TiDB versions.
+type resultDigest struct {
+	// columns are the column counts and column types of all result sets.
+	columns []byte
+	// rowSum is the sum of the row hashes, so that the digest doesn't depend on the row order, which is undefined
+	// without ORDER BY. If ordered is true, the row hashes are combined in order instead.
+	rowSum     uint64
+	resultSets int
+	ordered    bool
+}
+
+func (rd *resultDigest) reset(ordered bool) {
+	rd.columns = rd.columns[:0]
+	rd.rowSum = 0
+	rd.resultSets = 0
+	rd.ordered = ordered
+}
+
+func (rd *resultDigest) addRow(rowHash uint64) {
+	if rd.ordered {
+		// The same as the FNV-1 step, which makes the combination depend on the row order.
+		rd.rowSum = rd.rowSum*fnvPrime64 ^ rowHash
+	} else {
+		rd.rowSum += rowHash
+	}
+}
+
+// wrap returns a PacketIO that hashes the result set written to the client.
+// columns is the number of the column definitions that are not written yet.
+func (rd *resultDigest) wrap(clientIO pnet.PacketIO, capability pnet.Capability, columns uint64) pnet.PacketIO {
+	rd.resultSets++
+	rd.columns = binary.AppendUvarint(rd.columns, columns)
+	return &resultDigester{PacketIO: clientIO, digest: rd, capability: capability, columns: columns}
+}
+
+func (rd *resultDigest) String() string {
+	if rd.resultSets == 0 {
+		return ""
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(rd.columns)
+	return fmt.Sprintf("%016x%016x", h.Sum64(), rd.rowSum)
+}
+
+// resultDigester hashes the packets of a result set before writing them to the client.
+// It's not a *packetIO, so PacketIO.ForwardUntil writes the packets one by one.
+type resultDigester struct {
+	pnet.PacketIO
+	digest     *resultDigest
+	capability pnet.Capability
+	columns    uint64
+}
+
+func (rd *resultDigester) WritePacket(data []byte, flush bool) error {
+	switch {
+	case len(data) == 0:
+	case rd.columns > 0:
+		rd.columns--
+		rd.digest.columns = append(rd.digest.columns, columnType(data))
+	case pnet.IsErrorPacket(data[0]), pnet.IsEOFPacket(data[0], len(data)):
+	case rd.capability&pnet.ClientDeprecateEOF > 0 && pnet.IsResultSetOKPacket(data[0], len(data)):
+	default:
+		h := fnv.New64a()
+		_, _ = h.Write(data)
+		rd.digest.addRow(h.Sum64())
+	}
+	return rd.PacketIO.WritePacket(data, flush)
+}
+
+// columnType returns the type of a column definition packet.
+// See https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_com_query_response_text_resultset_column_definition.html
+func columnType(data []byte) byte {
+	pos := 0
+	// catalog, schema, table, org_table, name, org_name
+	for i := 0; i < 6 && pos < len(data); i++ {
+		_, _, n, err := pnet.ParseLengthEncodedBytes(data[pos:])
+		if err != nil {
+			return 0
+		}
+		pos += n
+	}
+	if pos >= len(data) {
+		return 0
+	}
+	// length of fixed fields, character set, column length
+	pos += pnet.SkipLengthEncodedInt(data[pos:]) + 2 + 4
+	if pos >= len(data) {
+		return 0
+	}
+	return data[pos]
+}
+
+// result returns the result of the current command, or nil if it's unknown.
+func (cp *CmdProcessor) result() *cmd.Result {
+	if cp.resultUnknown {
+		return nil
+	}
+	return &cmd.Result{
+		AffectedRows: cp.affectedRows,
+		Rows:         cp.returnedRows,
+		Digest:       cp.resultDigest.String(),
+	}
+}
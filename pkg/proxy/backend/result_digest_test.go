@@ -0,0 +1,27 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultDigestOrder(t *testing.T) {
+	digest := func(ordered bool, rows ...uint64) string {
+		var rd resultDigest
+		rd.reset(ordered)
+		rd.resultSets++
+		for _, row := range rows {
+			rd.addRow(row)
+		}
+		return rd.String()
+	}
+	// Without ORDER BY, the row order doesn't matter.
+	require.Equal(t, digest(false, 1, 2, 3), digest(false, 3, 2, 1))
+	// With ORDER BY, reordered rows have different digests.
+	require.NotEqual(t, digest(true, 1, 2, 3), digest(true, 3, 2, 1))
+	require.Equal(t, digest(true, 1, 2, 3), digest(true, 1, 2, 3))
+}
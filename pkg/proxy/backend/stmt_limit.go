@@ -0,0 +1,252 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"go.uber.org/zap"
+)
+
+const (
+	limitTypeExecutionTime = "max_execution_time"
+	limitTypeResultRows    = "max_result_rows"
+	limitTypeResultBytes   = "max_result_bytes"
+	// killTimeout is the timeout of connecting to the backend and killing the statement.
+	killTimeout = 5 * time.Second
+)
+
+// resultLimiter counts the rows and bytes of each result set of one statement.
+type resultLimiter struct {
+	maxRows  int64
+	maxBytes int64
+	rows     int64
+	bytes    int64
+	// err and limitType are set once the result sets exceed the limits.
+	err       *mysql.MyError
+	limitType string
+}
+
+func newResultLimiter(maxRows, maxBytes int64) *resultLimiter {
+	if maxRows <= 0 && maxBytes <= 0 {
+		return nil
+	}
+	return &resultLimiter{
+		maxRows:  maxRows,
+		maxBytes: maxBytes,
+	}
+}
+
+// addRow returns false if the row exceeds the limits.
+func (rl *resultLimiter) addRow(length int) bool {
+	if rl.err != nil {
+		return false
+	}
+	rl.rows++
+	rl.bytes += int64(length)
+	switch {
+	case rl.maxRows > 0 && rl.rows > rl.maxRows:
+		rl.err = mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("Result set exceeds the max-result-rows %d of TiProxy", rl.maxRows))
+		rl.limitType = limitTypeResultRows
+	case rl.maxBytes > 0 && rl.bytes > rl.maxBytes:
+		rl.err = mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("Result set exceeds the max-result-bytes %d of TiProxy", rl.maxBytes))
+		rl.limitType = limitTypeResultBytes
+	default:
+		return true
+	}
+	metrics.StmtLimitCounter.WithLabelValues(rl.limitType).Inc()
+	return false
+}
+
+// discardIO drops all the packets written to the client.
+// It's used to drain the remaining result sets from the backend after the result exceeds the limits and the statement
+// is being killed.
+type discardIO struct {
+	pnet.PacketIO
+}
+
+func (discardIO) WritePacket(data []byte, flush bool) error {
+	return nil
+}
+
+func (discardIO) Flush() error {
+	return nil
+}
+
+// forwardRowsWithLimit forwards the rest of a result set packet by packet so that it can stop forwarding rows
+// once the result sets exceed the limits. After that, the statement is killed and the remaining packets until the
+// kill error are read from the backend but discarded.
+// columns is the number of column definitions that are not forwarded yet.
+func (cp *CmdProcessor) forwardRowsWithLimit(clientIO, backendIO pnet.PacketIO, request []byte, columns uint64, limiter *resultLimiter) (uint16, error) {
+	// Multi-statements return multiple result sets and each one is limited separately.
+	limiter.rows, limiter.bytes = 0, 0
+	for i := uint64(0); ; i++ {
+		response, err := backendIO.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		dest := clientIO
+		if limiter.err != nil {
+			dest = discardIO{clientIO}
+		}
+		switch {
+		case pnet.IsErrorPacket(response[0]):
+			if err := dest.WritePacket(response, true); err != nil {
+				return 0, err
+			}
+			return 0, cp.handleErrorPacket(response)
+		case cp.capability&pnet.ClientDeprecateEOF == 0 && pnet.IsEOFPacket(response[0], len(response)):
+			if err := dest.WritePacket(response, true); err != nil {
+				return 0, err
+			}
+			return cp.handleEOFPacket(request, response), nil
+		case cp.capability&pnet.ClientDeprecateEOF > 0 && i >= columns && pnet.IsResultSetOKPacket(response[0], len(response)):
+			if err := dest.WritePacket(response, true); err != nil {
+				return 0, err
+			}
+			return cp.handleOKPacket(request, response), nil
+		}
+		if i >= columns && limiter.err == nil && !limiter.addRow(len(response)) {
+			if cp.onResultLimit != nil {
+				cp.onResultLimit(limiter.limitType)
+			}
+			continue
+		}
+		if err := dest.WritePacket(response, false); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// stmtKiller kills the running statement through a side connection.
+// Each statement has a sequence so that a kill triggered late won't kill the next statement on the connection.
+type stmtKiller struct {
+	sync.Mutex
+	// seq is the sequence of the running statement. It's 0 if no statement is running.
+	seq     uint64
+	lastSeq uint64
+	// addr and backendConnID identify the backend connection of the running statement.
+	addr          string
+	backendConnID uint64
+}
+
+// killQuery kills the running statement of the backend connection through a side connection.
+func (mgr *BackendConnManager) killQuery(addr string, backendConnID uint64, user, password string) error {
+	backendIO, err := mgr.dialBackend(addr)
+	if err != nil {
+		return errors.Wrapf(err, "dial backend %s error", addr)
+	}
+	// Closing the connection interrupts the blocking reads and writes after the timeout.
+	timer := time.AfterFunc(killTimeout, func() {
+		_ = backendIO.Close()
+	})
+	defer func() {
+		timer.Stop()
+		_ = backendIO.Close()
+	}()
+	auth := NewAuthenticator(mgr.config)
+	// The side connection is from the same client so that the backend accepts it when the proxy protocol is enabled.
+	if err = auth.writeProxyProtocol(mgr.clientIO, backendIO); err != nil {
+		return err
+	}
+	getBackendIO := func(context.Context, ConnContext, *pnet.HandshakeResp) (pnet.PacketIO, error) {
+		return backendIO, nil
+	}
+	if err = auth.handshakeWithBackend(context.Background(), mgr.logger, mgr, mgr.handshakeHandler, Credential{User: user, Password: password}, getBackendIO, mgr.backendTLS); err != nil {
+		return err
+	}
+	backendIO.ResetSequence()
+	if err = backendIO.WritePacket(pnet.MakeQueryPacket(fmt.Sprintf("KILL QUERY %d", backendConnID)), true); err != nil {
+		return err
+	}
+	response, err := backendIO.ReadPacket()
+	if err != nil {
+		return err
+	}
+	if pnet.IsErrorPacket(response[0]) {
+		return pnet.ParseErrorPacket(response)
+	}
+	return nil
+}
+
+// startStatement starts a statement and kills it if it runs longer than max-execution-time.
+// The returned function must be called after the statement finishes.
+func (mgr *BackendConnManager) startStatement(cmd pnet.Command) (stop func()) {
+	killer := &mgr.stmtKiller
+	killer.Lock()
+	killer.lastSeq++
+	seq := killer.lastSeq
+	killer.seq = seq
+	// The backend doesn't change during the statement because the process lock is held.
+	killer.addr, killer.backendConnID = mgr.ServerAddr(), mgr.authenticator.backendConnID
+	killer.Unlock()
+	var timer *time.Timer
+	if mgr.maxExecutionTime > 0 && (cmd == pnet.ComQuery || cmd == pnet.ComStmtExecute) {
+		timer = time.AfterFunc(mgr.maxExecutionTime, func() {
+			metrics.StmtLimitCounter.WithLabelValues(limitTypeExecutionTime).Inc()
+			mgr.killStatement(seq, limitTypeExecutionTime)
+		})
+	}
+	return func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		// Wait for the running kill so that it won't kill the next statement.
+		killer.Lock()
+		killer.seq = 0
+		killer.Unlock()
+	}
+}
+
+// onResultLimit is called by CmdProcessor once the result sets exceed the limits. The statement is killed so that
+// the backend stops sending the remaining results.
+func (mgr *BackendConnManager) onResultLimit(limitType string) {
+	mgr.stmtKiller.Lock()
+	seq := mgr.stmtKiller.seq
+	mgr.stmtKiller.Unlock()
+	mgr.wg.RunWithRecover(func() {
+		mgr.killStatement(seq, limitType)
+	}, nil, mgr.logger)
+}
+
+// killStatement kills the statement if it's still running.
+// If the statement exceeds the result limits but can't be killed, the backend connection is closed instead of
+// draining the remaining results.
+func (mgr *BackendConnManager) killStatement(seq uint64, limitType string) {
+	killer := &mgr.stmtKiller
+	killer.Lock()
+	defer killer.Unlock()
+	if seq == 0 || killer.seq != seq {
+		return
+	}
+	addr, backendConnID := killer.addr, killer.backendConnID
+	var err error
+	if len(mgr.adminUser) == 0 {
+		err = errors.New("admin-user is not set")
+	} else {
+		err = mgr.killQuery(addr, backendConnID, mgr.adminUser, mgr.adminPassword)
+	}
+	if err == nil {
+		mgr.logger.Info("killed statement that exceeds limits", zap.String("limit", limitType), zap.String("backend_addr", addr),
+			zap.Uint64("backend_conn_id", backendConnID))
+		return
+	}
+	mgr.logger.Warn("killing statement that exceeds limits failed", zap.String("limit", limitType), zap.String("backend_addr", addr),
+		zap.Uint64("backend_conn_id", backendConnID), zap.Error(err))
+	if limitType == limitTypeExecutionTime {
+		return
+	}
+	if backendIO := mgr.backendIO.Load(); backendIO != nil {
+		if err := (*backendIO).Close(); err != nil && !pnet.IsDisconnectError(err) {
+			mgr.logger.Warn("close backend connection error", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package backend
+
+import (
+	"encoding/binary"
+	"time"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/stmtsummary"
+)
+
+// stmtCounters are the counters before executing a statement, which are used to calculate the statement summary.
+type stmtCounters struct {
+	clientInBytes  uint64
+	clientOutBytes uint64
+	// prevBackendIO is the backend before executing the statement and backendIO is the one that executes it.
+	prevBackendIO pnet.PacketIO
+	backendIO     pnet.PacketIO
+}
+
+// newStmtCounters must be called after holding processLock.
+func (mgr *BackendConnManager) newStmtCounters() *stmtCounters {
+	if !mgr.stmtSummaryEnabled() || mgr.clientIO == nil {
+		return nil
+	}
+	counters := &stmtCounters{
+		clientInBytes:  mgr.clientIO.InBytes(),
+		clientOutBytes: mgr.clientIO.OutBytes(),
+	}
+	if backendIO := mgr.backendIO.Load(); backendIO != nil {
+		counters.prevBackendIO, counters.backendIO = *backendIO, *backendIO
+	}
+	return counters
+}
+
+// setBackend updates the backend that executes the statement after it's held or retried on another backend.
+func (c *stmtCounters) setBackend(backendIO pnet.PacketIO) {
+	if c != nil {
+		c.backendIO = backendIO
+	}
+}
+
+func (mgr *BackendConnManager) stmtSummaryEnabled() bool {
+	return mgr.config.StmtSummary != nil && mgr.config.StmtSummary.Enabled()
+}
+
+// addStmtSummary records the statement into the statement summary.
+func (mgr *BackendConnManager) addStmtSummary(request []byte, startTime, now time.Time, counters *stmtCounters, err error) {
+	if counters == nil || counters.backendIO == nil {
+		return
+	}
+	failed := err != nil
+	var sql string
+	switch cmd := pnet.Command(request[0]); cmd {
+	case pnet.ComQuery:
+		sql = string(pnet.ParseQueryPacket(request[1:]))
+	case pnet.ComStmtExecute:
+		var ok bool
+		if sql, ok = mgr.preparedSQL(request); !ok {
+			return
+		}
+	default:
+		return
+	}
+	mgr.config.StmtSummary.Add(&stmtsummary.Record{
+		Namespace: mgr.cmdProcessor.ruleScope.Namespace,
+		User:      mgr.authenticator.user,
+		Backend:   counters.backendIO.RemoteAddr().String(),
+		SQL:       sql,
+		EndTime:   now,
+		Latency:   now.Sub(startTime),
+		Failed:    failed,
+		InBytes:   uint64(len(request)) + mgr.clientIO.InBytes() - counters.clientInBytes,
+		OutBytes:  mgr.clientIO.OutBytes() - counters.clientOutBytes,
+		Rows:      mgr.cmdProcessor.returnedRows,
+		Migrated:  counters.backendIO != counters.prevBackendIO,
+	})
+}
+
+// preparedSQL returns the statement text of COM_STMT_EXECUTE, which is cached at COM_STMT_PREPARE.
+func (mgr *BackendConnManager) preparedSQL(request []byte) (string, bool) {
+	if len(request) < 5 {
+		return "", false
+	}
+	sql, ok := mgr.preparedStmts[binary.LittleEndian.Uint32(request[1:])]
+	return sql, ok
+}
+
+// trackPreparedStmts caches the text of the prepared statements for the statement summary and the audit log.
+func (mgr *BackendConnManager) trackPreparedStmts(request []byte, err error) {
+	if !mgr.stmtSummaryEnabled() && !mgr.auditEnabled() {
+		return
+	}
+	switch pnet.Command(request[0]) {
+	case pnet.ComStmtPrepare:
+		if err == nil {
+			if mgr.preparedStmts == nil {
+				mgr.preparedStmts = make(map[uint32]string)
+			}
+			mgr.preparedStmts[mgr.cmdProcessor.preparedStmtID] = string(pnet.ParseQueryPacket(request[1:]))
+		}
+	case pnet.ComStmtClose:
+		if len(request) >= 5 {
+			delete(mgr.preparedStmts, binary.LittleEndian.Uint32(request[1:]))
+		}
+	case pnet.ComResetConnection, pnet.ComChangeUser:
+		mgr.preparedStmts = nil
+	}
+}
@@ -212,7 +212,7 @@ func (ts *testSuite) authenticateSecondTime(t *testing.T, c checker) {
 func (ts *testSuite) authenticateWithBackend(t *testing.T, c checker) {
 	ts.runAndCheck(t, c, nil, ts.mb.authenticate, ts.mp.authenticateWithBackend)
 	if c == nil {
-		require.Equal(t, ts.mp.username, ts.mb.username)
+		require.Equal(t, ts.mp.credential().User, ts.mb.username)
 	}
 }
 
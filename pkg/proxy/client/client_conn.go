@@ -84,6 +84,11 @@ func (cc *ClientConnection) processMsg(ctx context.Context) error {
 	}
 }
 
+// ProcessInfo returns the state of the session. It returns false if the session is not connected.
+func (cc *ClientConnection) ProcessInfo() (backend.ProcessInfo, bool) {
+	return cc.connMgr.ProcessInfo()
+}
+
 func (cc *ClientConnection) GracefulClose() {
 	cc.connMgr.GracefulClose()
 }
@@ -0,0 +1,251 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package firewall
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/lib/util/waitgroup"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// flushInterval is the interval of persisting the learned allowlists.
+	flushInterval = 30 * time.Second
+	allowlistFile = "firewall_allowlist.json"
+)
+
+// ErrNotAllowed is returned to the client when the statement is rejected in the enforcing mode.
+var ErrNotAllowed = mysql.NewError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "Statement is not in the TiProxy firewall allowlist")
+
+// Allowlists maps users to their allowed statement digests.
+type Allowlists map[string][]string
+
+// Firewall learns the statement digests of each user in the learning mode and checks them in the alerting or
+// enforcing mode. The allowlists are persisted in the work directory so that they survive restarts.
+type Firewall struct {
+	sync.RWMutex
+	mode  string
+	users map[string]struct{}
+	// user -> digest set
+	allowlists map[string]map[string]struct{}
+	dirty      bool
+	file       string
+	logger     *zap.Logger
+	wg         waitgroup.WaitGroup
+	cancel     context.CancelFunc
+}
+
+// NewFirewall creates a Firewall. If dir is empty, the allowlists are not persisted.
+func NewFirewall(logger *zap.Logger, dir string) *Firewall {
+	fw := &Firewall{
+		allowlists: make(map[string]map[string]struct{}),
+		logger:     logger,
+	}
+	if len(dir) > 0 {
+		fw.file = filepath.Join(dir, allowlistFile)
+	}
+	return fw
+}
+
+// Start loads the persisted allowlists and starts persisting them periodically.
+func (fw *Firewall) Start(ctx context.Context) error {
+	if err := fw.load(); err != nil {
+		return err
+	}
+	childCtx, cancel := context.WithCancel(ctx)
+	fw.cancel = cancel
+	fw.wg.RunWithRecover(func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-childCtx.Done():
+				return
+			case <-ticker.C:
+				if err := fw.flush(); err != nil {
+					fw.logger.Warn("persisting firewall allowlists failed", zap.Error(err))
+				}
+			}
+		}
+	}, nil, fw.logger)
+	return nil
+}
+
+// Reset updates the mode and the scope of the firewall.
+func (fw *Firewall) Reset(cfg config.Firewall) {
+	fw.Lock()
+	defer fw.Unlock()
+	if fw.mode != cfg.Mode {
+		fw.logger.Info("firewall mode changes", zap.String("from", fw.mode), zap.String("to", cfg.Mode))
+	}
+	fw.mode = cfg.Mode
+	fw.users = make(map[string]struct{}, len(cfg.Users))
+	for _, user := range cfg.Users {
+		fw.users[user] = struct{}{}
+	}
+}
+
+// Enabled returns whether the statements of the user need to be checked.
+// It's used to avoid calculating the digests when the firewall is off.
+func (fw *Firewall) Enabled(user string) bool {
+	fw.RLock()
+	defer fw.RUnlock()
+	if fw.mode == config.FirewallModeOff {
+		return false
+	}
+	if len(fw.users) == 0 {
+		return true
+	}
+	_, ok := fw.users[user]
+	return ok
+}
+
+// Check records or checks the statement digest of the user.
+// It returns ErrNotAllowed if the statement should be rejected.
+func (fw *Firewall) Check(user, digest string) error {
+	fw.RLock()
+	mode := fw.mode
+	_, allowed := fw.allowlists[user][digest]
+	fw.RUnlock()
+	if allowed {
+		return nil
+	}
+	switch mode {
+	case config.FirewallModeLearning:
+		fw.Lock()
+		digests, ok := fw.allowlists[user]
+		if !ok {
+			digests = make(map[string]struct{})
+			fw.allowlists[user] = digests
+		}
+		digests[digest] = struct{}{}
+		fw.dirty = true
+		fw.Unlock()
+		metrics.FirewallCounter.WithLabelValues(config.FirewallModeLearning).Inc()
+	case config.FirewallModeAlerting:
+		fw.logger.Warn("statement is not in the firewall allowlist", zap.String("user", user), zap.String("digest", digest))
+		metrics.FirewallCounter.WithLabelValues(config.FirewallModeAlerting).Inc()
+	case config.FirewallModeEnforcing:
+		fw.logger.Debug("statement is rejected by the firewall", zap.String("user", user), zap.String("digest", digest))
+		metrics.FirewallCounter.WithLabelValues(config.FirewallModeEnforcing).Inc()
+		return ErrNotAllowed
+	}
+	return nil
+}
+
+// Export returns a copy of all the allowlists.
+func (fw *Firewall) Export() Allowlists {
+	fw.RLock()
+	defer fw.RUnlock()
+	return fw.exportLocked()
+}
+
+func (fw *Firewall) exportLocked() Allowlists {
+	lists := make(Allowlists, len(fw.allowlists))
+	for user, digests := range fw.allowlists {
+		list := make([]string, 0, len(digests))
+		for digest := range digests {
+			list = append(list, digest)
+		}
+		slices.Sort(list)
+		lists[user] = list
+	}
+	return lists
+}
+
+// Import merges the allowlists into the current ones, or replaces the current ones if replace is true.
+func (fw *Firewall) Import(lists Allowlists, replace bool) error {
+	fw.Lock()
+	if replace {
+		fw.allowlists = make(map[string]map[string]struct{}, len(lists))
+	}
+	fw.importLocked(lists)
+	fw.dirty = true
+	fw.Unlock()
+	return fw.flush()
+}
+
+func (fw *Firewall) importLocked(lists Allowlists) {
+	for user, list := range lists {
+		digests, ok := fw.allowlists[user]
+		if !ok {
+			digests = make(map[string]struct{}, len(list))
+			fw.allowlists[user] = digests
+		}
+		for _, digest := range list {
+			digests[digest] = struct{}{}
+		}
+	}
+}
+
+func (fw *Firewall) load() error {
+	if len(fw.file) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(fw.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	var lists Allowlists
+	if err := json.Unmarshal(data, &lists); err != nil {
+		return errors.Wrapf(err, "unmarshal firewall allowlists from %s failed", fw.file)
+	}
+	fw.Lock()
+	fw.importLocked(lists)
+	fw.Unlock()
+	return nil
+}
+
+func (fw *Firewall) flush() error {
+	if len(fw.file) == 0 {
+		return nil
+	}
+	fw.Lock()
+	if !fw.dirty {
+		fw.Unlock()
+		return nil
+	}
+	lists := fw.exportLocked()
+	fw.dirty = false
+	fw.Unlock()
+
+	data, err := json.Marshal(lists)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fw.file), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	// Write to a temporary file first so that the file is not corrupted if TiProxy crashes.
+	tmpFile := fw.file + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmpFile, fw.file))
+}
+
+// Close stops the background goroutine and persists the allowlists.
+func (fw *Firewall) Close() {
+	if fw.cancel != nil {
+		fw.cancel()
+	}
+	fw.wg.Wait()
+	if err := fw.flush(); err != nil {
+		fw.logger.Warn("persisting firewall allowlists failed", zap.Error(err))
+	}
+}
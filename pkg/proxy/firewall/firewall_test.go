@@ -0,0 +1,61 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package firewall
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModes(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	fw := NewFirewall(lg, "")
+	require.False(t, fw.Enabled("u1"))
+
+	fw.Reset(config.Firewall{Mode: config.FirewallModeLearning})
+	require.True(t, fw.Enabled("u1"))
+	require.NoError(t, fw.Check("u1", "d1"))
+	require.NoError(t, fw.Check("u2", "d2"))
+	require.Equal(t, Allowlists{"u1": {"d1"}, "u2": {"d2"}}, fw.Export())
+
+	fw.Reset(config.Firewall{Mode: config.FirewallModeAlerting})
+	require.NoError(t, fw.Check("u1", "d2"))
+	require.Equal(t, Allowlists{"u1": {"d1"}, "u2": {"d2"}}, fw.Export())
+
+	fw.Reset(config.Firewall{Mode: config.FirewallModeEnforcing})
+	require.NoError(t, fw.Check("u1", "d1"))
+	require.ErrorIs(t, fw.Check("u1", "d2"), ErrNotAllowed)
+	require.ErrorIs(t, fw.Check("u3", "d1"), ErrNotAllowed)
+
+	fw.Reset(config.Firewall{Mode: config.FirewallModeEnforcing, Users: []string{"u1"}})
+	require.True(t, fw.Enabled("u1"))
+	require.False(t, fw.Enabled("u3"))
+}
+
+func TestPersistence(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	dir := t.TempDir()
+	fw := NewFirewall(lg, dir)
+	require.NoError(t, fw.Start(context.Background()))
+	fw.Reset(config.Firewall{Mode: config.FirewallModeLearning})
+	require.NoError(t, fw.Check("u1", "d1"))
+	fw.Close()
+
+	fw = NewFirewall(lg, dir)
+	require.NoError(t, fw.Start(context.Background()))
+	require.Equal(t, Allowlists{"u1": {"d1"}}, fw.Export())
+	require.NoError(t, fw.Import(Allowlists{"u2": {"d2"}}, false))
+	require.Equal(t, Allowlists{"u1": {"d1"}, "u2": {"d2"}}, fw.Export())
+	require.NoError(t, fw.Import(Allowlists{"u3": {"d3"}}, true))
+	fw.Close()
+
+	fw = NewFirewall(lg, dir)
+	require.NoError(t, fw.Start(context.Background()))
+	require.Equal(t, Allowlists{"u3": {"d3"}}, fw.Export())
+	fw.Close()
+}
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"io"
+	"time"
 
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/klauspost/compress/zstd"
@@ -40,6 +41,35 @@ const (
 	zlibCompressionLevel = 6
 )
 
+// String implements fmt.Stringer.
+func (algorithm CompressAlgorithm) String() string {
+	switch algorithm {
+	case CompressionZlib:
+		return "zlib"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return "none"
+}
+
+// CompressionCost is the accumulated cost of compressing or uncompressing data.
+type CompressionCost struct {
+	// RawBytes is the size of the uncompressed payloads.
+	RawBytes uint64
+	// WireBytes is the size of the payloads on the wire, which is equal to RawBytes for the small payloads that
+	// are sent without compression.
+	WireBytes uint64
+	// Duration is the time spent on compression or decompression.
+	Duration time.Duration
+}
+
+// CompressionStats is the compression statistics of a connection.
+type CompressionStats struct {
+	Algorithm  CompressAlgorithm
+	Compress   CompressionCost
+	Decompress CompressionCost
+}
+
 func (p *packetIO) SetCompressionAlgorithm(algorithm CompressAlgorithm, zstdLevel int) error {
 	switch algorithm {
 	case CompressionZlib, CompressionZstd:
@@ -51,6 +81,15 @@ func (p *packetIO) SetCompressionAlgorithm(algorithm CompressAlgorithm, zstdLeve
 	return nil
 }
 
+// CompressionStats returns the compression statistics of the connection.
+// The algorithm is CompressionNone if the connection is not compressed.
+func (p *packetIO) CompressionStats() CompressionStats {
+	if crw, ok := p.readWriter.(*compressedReadWriter); ok {
+		return crw.stats
+	}
+	return CompressionStats{}
+}
+
 var _ packetReadWriter = (*compressedReadWriter)(nil)
 
 type compressedReadWriter struct {
@@ -63,6 +102,7 @@ type compressedReadWriter struct {
 	zstdLevel   zstd.EncoderLevel
 	header      []byte
 	sequence    uint8
+	stats       CompressionStats
 }
 
 func newCompressedReadWriter(rw packetReadWriter, algorithm CompressAlgorithm, zstdLevel int, logger *zap.Logger) *compressedReadWriter {
@@ -73,6 +113,7 @@ func newCompressedReadWriter(rw packetReadWriter, algorithm CompressAlgorithm, z
 		logger:           logger,
 		rwStatus:         rwNone,
 		header:           make([]byte, 7),
+		stats:            CompressionStats{Algorithm: algorithm},
 	}
 }
 
@@ -150,6 +191,8 @@ func (crw *compressedReadWriter) readFromConn() error {
 		if _, err = io.CopyN(&crw.readBuffer, crw.packetReadWriter, int64(compressedLength)); err != nil {
 			return errors.WithStack(err)
 		}
+		crw.stats.Decompress.RawBytes += uint64(compressedLength)
+		crw.stats.Decompress.WireBytes += uint64(compressedLength)
 	} else {
 		// If the data is compressed, the compressed length is the length of data after the compressed header and
 		// the uncompressed length is the length of data after decompression.
@@ -157,9 +200,13 @@ func (crw *compressedReadWriter) readFromConn() error {
 		if err = ReadFull(crw.packetReadWriter, data); err != nil {
 			return err
 		}
+		startTime := time.Now()
 		if err = crw.uncompress(data, uncompressedLength); err != nil {
 			return err
 		}
+		crw.stats.Decompress.Duration += time.Since(startTime)
+		crw.stats.Decompress.RawBytes += uint64(uncompressedLength)
+		crw.stats.Decompress.WireBytes += uint64(compressedLength)
 	}
 	return nil
 }
@@ -203,15 +250,20 @@ func (crw *compressedReadWriter) Flush() error {
 	// after the compressed header.
 	uncompressedLength := 0
 	compressedLength := len(data)
+	rawLength := len(data)
 	if len(data) >= minCompressSize {
 		// If the data is compressed, the compressed length is the length of data after the compressed header and
 		// the uncompressed length is the length of data after decompression.
 		uncompressedLength = len(data)
+		startTime := time.Now()
 		if data, err = crw.compress(data); err != nil {
 			return err
 		}
+		crw.stats.Compress.Duration += time.Since(startTime)
 		compressedLength = len(data)
 	}
+	crw.stats.Compress.RawBytes += uint64(rawLength)
+	crw.stats.Compress.WireBytes += uint64(compressedLength)
 
 	crw.header[0] = byte(compressedLength)
 	crw.header[1] = byte(compressedLength >> 8)
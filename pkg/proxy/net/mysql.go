@@ -29,7 +29,7 @@ var (
 	Status        = ServerStatusAutocommit
 )
 
-// WriteSwitchRequest writes a switch request to the client. It's only for testing.
+// MakeSwitchRequest makes a switch request to the client.
 func MakeSwitchRequest(authPlugin string, salt [20]byte) []byte {
 	length := 1 + len(authPlugin) + 1 + len(salt) + 1
 	data := make([]byte, 0, length)
@@ -84,6 +84,32 @@ func MakeEOFPacket(status uint16) []byte {
 	return data
 }
 
+// MakeTextResultSet makes the packets of a text result set that is returned by TiProxy itself.
+// The values of each column must be of the same type and nil means NULL.
+func MakeTextResultSet(names []string, values [][]any, status uint16, capability Capability) ([][]byte, error) {
+	rs, err := gomysql.BuildSimpleTextResultset(names, values)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	packets := make([][]byte, 0, len(rs.Fields)+len(rs.RowDatas)+3)
+	packets = append(packets, DumpLengthEncodedInt(nil, uint64(len(rs.Fields))))
+	for _, field := range rs.Fields {
+		packets = append(packets, field.Dump())
+	}
+	if capability&ClientDeprecateEOF == 0 {
+		packets = append(packets, MakeEOFPacket(status))
+	}
+	for _, row := range rs.RowDatas {
+		packets = append(packets, row)
+	}
+	if capability&ClientDeprecateEOF == 0 {
+		packets = append(packets, MakeEOFPacket(status))
+	} else {
+		packets = append(packets, MakeOKPacket(status, EOFHeader))
+	}
+	return packets, nil
+}
+
 // WriteUserError writes an unknown error to the client.
 func MakeUserError(err error) []byte {
 	if err == nil {
@@ -163,3 +163,26 @@ func TestPrepareStmts(t *testing.T) {
 	require.EqualValues(t, args, pArgs)
 	require.Equal(t, expectedTypes, newParamTypes)
 }
+
+func TestMakeTextResultSet(t *testing.T) {
+	names := []string{"id", "name"}
+	values := [][]any{{uint64(1), "a"}, {uint64(2), nil}}
+	for _, capability := range []Capability{0, ClientDeprecateEOF} {
+		packets, err := MakeTextResultSet(names, values, ServerStatusAutocommit, capability)
+		require.NoError(t, err)
+		columns, _, _ := ParseLengthEncodedInt(packets[0])
+		require.EqualValues(t, 2, columns)
+		last := packets[len(packets)-1]
+		if capability&ClientDeprecateEOF == 0 {
+			require.Len(t, packets, 7)
+			require.True(t, IsEOFPacket(packets[3][0], len(packets[3])))
+			require.True(t, IsEOFPacket(last[0], len(last)))
+		} else {
+			require.Len(t, packets, 6)
+			require.True(t, IsResultSetOKPacket(last[0], len(last)))
+		}
+		require.Equal(t, ServerStatusAutocommit, ParseOKPacket(append([]byte{OKHeader.Byte()}, last[1:]...)))
+	}
+	_, err := MakeTextResultSet(names, [][]any{{uint64(1)}}, 0, 0)
+	require.Error(t, err)
+}
@@ -220,6 +220,7 @@ type PacketIO interface {
 
 	// compression
 	SetCompressionAlgorithm(algorithm CompressAlgorithm, zstdLevel int) error
+	CompressionStats() CompressionStats
 }
 
 // PacketIO is a helper to read and write sql and proxy protocol.
@@ -4,6 +4,7 @@
 package net
 
 import (
+	"bytes"
 	"encoding/binary"
 	"net"
 	"testing"
@@ -504,6 +505,80 @@ func TestForwardUntil(t *testing.T) {
 	}
 }
 
+// Test that packets are transcoded when the two connections use different compression algorithms.
+func TestForwardUntilTranscode(t *testing.T) {
+	algorithms := [][2]CompressAlgorithm{
+		{CompressionZstd, CompressionNone},
+		{CompressionNone, CompressionZstd},
+		{CompressionZlib, CompressionZstd},
+	}
+	setCompress := func(p *packetIO, algorithm CompressAlgorithm) {
+		p.ResetSequence()
+		require.NoError(t, p.SetCompressionAlgorithm(algorithm, 3))
+	}
+	data := bytes.Repeat([]byte("transcode"), 100)
+	for _, algs := range algorithms {
+		srvCh := make(chan *packetIO)
+		exitCh := make(chan struct{})
+		loops := 10
+		var wg waitgroup.WaitGroup
+		wg.Run(func() {
+			testTCPConn(t,
+				func(t *testing.T, cli *packetIO) {
+					setCompress(cli, algs[0])
+					for i := 0; i < loops; i++ {
+						require.NoError(t, cli.WritePacket(append([]byte{byte(i)}, data...), true))
+					}
+				},
+				func(t *testing.T, srv1 *packetIO) {
+					setCompress(srv1, algs[0])
+					srv2 := <-srvCh
+					err := srv1.ForwardUntil(srv2, func(firstByte byte, firstPktLen int) (bool, bool) {
+						return firstByte == byte(loops-1), false
+					}, func(response []byte) error {
+						return srv2.Flush()
+					})
+					require.NoError(t, err)
+					// The stats are only recorded on the compressed connections.
+					for i, stats := range []CompressionStats{srv1.CompressionStats(), srv2.CompressionStats()} {
+						require.Equal(t, algs[i], stats.Algorithm)
+						if algs[i] == CompressionNone {
+							continue
+						}
+						cost := stats.Decompress
+						if i == 1 {
+							cost = stats.Compress
+						}
+						require.Equal(t, uint64(loops*(len(data)+5)), cost.RawBytes)
+						require.Less(t, cost.WireBytes, cost.RawBytes)
+					}
+					exitCh <- struct{}{}
+				},
+				1,
+			)
+		})
+		wg.Run(func() {
+			testTCPConn(t,
+				func(t *testing.T, cli *packetIO) {
+					setCompress(cli, algs[1])
+					for i := 0; i < loops; i++ {
+						pkt, err := cli.ReadPacket()
+						require.NoError(t, err)
+						require.Equal(t, append([]byte{byte(i)}, data...), pkt)
+					}
+				},
+				func(t *testing.T, srv2 *packetIO) {
+					setCompress(srv2, algs[1])
+					srvCh <- srv2
+					<-exitCh
+				},
+				1,
+			)
+		})
+		wg.Wait()
+	}
+}
+
 func TestForwardUntilLongData(t *testing.T) {
 	srvCh := make(chan *packetIO)
 	exitCh := make(chan struct{})
@@ -4,8 +4,10 @@
 package proxy
 
 import (
+	"cmp"
 	"context"
 	"net"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -16,10 +18,16 @@ import (
 	"github.com/pingcap/tiproxy/pkg/manager/cert"
 	"github.com/pingcap/tiproxy/pkg/manager/id"
 	"github.com/pingcap/tiproxy/pkg/metrics"
+	"github.com/pingcap/tiproxy/pkg/proxy/audit"
 	"github.com/pingcap/tiproxy/pkg/proxy/backend"
 	"github.com/pingcap/tiproxy/pkg/proxy/client"
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
 	"github.com/pingcap/tiproxy/pkg/proxy/keepalive"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/resultcache"
+	"github.com/pingcap/tiproxy/pkg/proxy/rule"
+	"github.com/pingcap/tiproxy/pkg/proxy/stmtsummary"
+	"github.com/pingcap/tiproxy/pkg/proxy/userstore"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
 	"go.uber.org/zap"
 )
@@ -34,6 +42,7 @@ type serverState struct {
 	requireBackendTLS  bool
 	tcpKeepAlive       bool
 	proxyProtocol      bool
+	certAuth           config.CertAuth
 	gracefulWait       int // graceful-wait-before-shutdown
 	gracefulClose      int // graceful-close-conn-timeout
 }
@@ -46,6 +55,12 @@ type SQLServer struct {
 	idMgr      *id.IDManager
 	hsHandler  backend.HandshakeHandler
 	cpt        capture.Capture
+	rules      *rule.Engine
+	firewall   *firewall.Firewall
+	audit      *audit.Auditor
+	users      *userstore.Store
+	cache      *resultcache.Cache
+	summary    *stmtsummary.Summary
 	wg         waitgroup.WaitGroup
 	cancelFunc context.CancelFunc
 
@@ -61,6 +76,12 @@ func NewSQLServer(logger *zap.Logger, cfg *config.Config, certMgr *cert.CertMana
 		idMgr:     idMgr,
 		hsHandler: hsHandler,
 		cpt:       cpt,
+		rules:     rule.NewEngine(logger.Named("rule")),
+		firewall:  firewall.NewFirewall(logger.Named("firewall"), cfg.Workdir),
+		audit:     audit.NewAuditor(logger.Named("audit")),
+		users:     userstore.NewStore(logger.Named("userstore")),
+		cache:     resultcache.NewCache(logger.Named("resultcache")),
+		summary:   stmtsummary.NewSummary(logger.Named("stmtsummary")),
 		mu: serverState{
 			clients: make(map[uint64]*client.ClientConnection),
 		},
@@ -76,6 +97,11 @@ func NewSQLServer(logger *zap.Logger, cfg *config.Config, certMgr *cert.CertMana
 			return nil, err
 		}
 	}
+	if err = s.firewall.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	s.audit.Start(context.Background())
+	s.summary.Start(context.Background())
 
 	return s, nil
 }
@@ -85,6 +111,7 @@ func (s *SQLServer) reset(cfg *config.Config) {
 	s.mu.tcpKeepAlive = cfg.Proxy.FrontendKeepalive.Enabled
 	s.mu.maxConnections = cfg.Proxy.MaxConnections
 	s.mu.requireBackendTLS = cfg.Security.RequireBackendTLS
+	s.mu.certAuth = cfg.Security.CertAuth
 	s.mu.proxyProtocol = cfg.Proxy.ProxyProtocol != ""
 	s.mu.gracefulWait = cfg.Proxy.GracefulWaitBeforeShutdown
 	s.mu.gracefulClose = cfg.Proxy.GracefulCloseConnTimeout
@@ -92,6 +119,13 @@ func (s *SQLServer) reset(cfg *config.Config) {
 	s.mu.unhealthyKeepAlive = cfg.Proxy.BackendUnhealthyKeepalive
 	s.mu.connBufferSize = cfg.Proxy.ConnBufferSize
 	s.mu.Unlock()
+	if err := s.rules.Reset(cfg.QueryRules); err != nil {
+		s.logger.Error("update query rules failed", zap.Error(err))
+	}
+	s.firewall.Reset(cfg.Security.Firewall)
+	s.audit.Reset(cfg.Audit)
+	s.summary.Reset(cfg.StmtSummary)
+	s.users.Reset(cfg.Security.ProxyAuth)
 }
 
 func (s *SQLServer) Run(ctx context.Context, cfgch <-chan *config.Config) {
@@ -158,9 +192,17 @@ func (s *SQLServer) onConn(ctx context.Context, conn net.Conn, addr string) {
 			s.hsHandler, s.cpt, connID, addr, &backend.BCConfig{
 				ProxyProtocol:      s.mu.proxyProtocol,
 				RequireBackendTLS:  s.mu.requireBackendTLS,
+				CertAuth:           s.mu.certAuth,
 				HealthyKeepAlive:   s.mu.healthyKeepAlive,
 				UnhealthyKeepAlive: s.mu.unhealthyKeepAlive,
 				ConnBufferSize:     s.mu.connBufferSize,
+				QueryRules:         s.rules,
+				Firewall:           s.firewall,
+				Audit:              s.audit,
+				UserStore:          s.users,
+				ResultCache:        s.cache,
+				StmtSummary:        s.summary,
+				ProcessLister:      s,
 			})
 		s.mu.clients[connID] = clientConn
 		logger.Debug("new connection", zap.Bool("proxy-protocol", s.mu.proxyProtocol), zap.Bool("require_backend_tls", s.mu.requireBackendTLS))
@@ -258,5 +300,45 @@ func (s *SQLServer) Close() error {
 	s.mu.RUnlock()
 
 	s.wg.Wait()
+	s.firewall.Close()
+	s.users.Close()
+	// Close it after all the connections are closed to record the disconnect events.
+	s.audit.Close()
+	s.summary.Close()
 	return nil
 }
+
+// Firewall returns the firewall shared by all connections.
+func (s *SQLServer) Firewall() *firewall.Firewall {
+	return s.firewall
+}
+
+func (s *SQLServer) ResultCache() *resultcache.Cache {
+	return s.cache
+}
+
+// ProcessList returns the states of all the connected sessions, ordered by the connection ID.
+func (s *SQLServer) ProcessList() []backend.ProcessInfo {
+	s.mu.RLock()
+	infos := make([]backend.ProcessInfo, 0, len(s.mu.clients))
+	for _, conn := range s.mu.clients {
+		if info, ok := conn.ProcessInfo(); ok {
+			infos = append(infos, info)
+		}
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(infos, func(a, b backend.ProcessInfo) int {
+		return cmp.Compare(a.ConnID, b.ConnID)
+	})
+	return infos
+}
+
+// StmtSummary returns the statement summary of all connections.
+func (s *SQLServer) StmtSummary() *stmtsummary.Summary {
+	return s.summary
+}
+
+// UserStore returns the local user store for the proxy-auth mode.
+func (s *SQLServer) UserStore() *userstore.Store {
+	return s.users
+}
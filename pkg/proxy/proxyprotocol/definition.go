@@ -37,18 +37,29 @@ const (
 
 type ProxyTlvType int
 
+// The TLV types are defined by the PROXY protocol v2 spec and shared with the upstream proxies and TiDB.
 const (
-	ProxyTlvALPN ProxyTlvType = iota + 0x01
-	ProxyTlvAuthority
-	ProxyTlvCRC32C
-	ProxyTlvNoop
-	ProxyTlvUniqueID
-	ProxyTlvSSL ProxyTlvType = iota + 0x20
-	ProxyTlvSSLCN
-	ProxyTlvSSLCipher
-	ProxyTlvSSLSignALG
-	ProxyTlvSSLKeyALG
-	ProxyTlvNetns ProxyTlvType = iota + 0x30
+	ProxyTlvALPN       ProxyTlvType = 0x01
+	ProxyTlvAuthority  ProxyTlvType = 0x02
+	ProxyTlvCRC32C     ProxyTlvType = 0x03
+	ProxyTlvNoop       ProxyTlvType = 0x04
+	ProxyTlvUniqueID   ProxyTlvType = 0x05
+	ProxyTlvSSL        ProxyTlvType = 0x20
+	ProxyTlvSSLCN      ProxyTlvType = 0x22
+	ProxyTlvSSLCipher  ProxyTlvType = 0x23
+	ProxyTlvSSLSignALG ProxyTlvType = 0x24
+	ProxyTlvSSLKeyALG  ProxyTlvType = 0x25
+	ProxyTlvNetns      ProxyTlvType = 0x30
+)
+
+// ProxyTlvSSLVersion is the PP2_SUBTYPE_SSL_VERSION sub-TLV of the PP2_TYPE_SSL TLV.
+const ProxyTlvSSLVersion ProxyTlvType = 0x21
+
+// The client flags in the PP2_TYPE_SSL TLV.
+const (
+	ProxyClientSSL      byte = 0x01
+	ProxyClientCertConn byte = 0x02
+	ProxyClientCertSess byte = 0x04
 )
 
 type ProxyTlv struct {
@@ -94,6 +94,18 @@ func (p *Proxy) ToBytes() ([]byte, error) {
 	return buf, nil
 }
 
+// MakeSSLTLV makes a PP2_TYPE_SSL TLV that contains the sub-TLVs.
+// The verify field is always 0, which means the client certificate is verified.
+func MakeSSLTLV(client byte, subTLVs ...ProxyTlv) ProxyTlv {
+	content := []byte{client, 0, 0, 0, 0}
+	for _, tlv := range subTLVs {
+		tlen := len(tlv.Content)
+		content = append(content, byte(tlv.Typ), byte(tlen>>8), byte(tlen))
+		content = append(content, tlv.Content...)
+	}
+	return ProxyTlv{Typ: ProxyTlvSSL, Content: content}
+}
+
 func ParseProxyV2(rd io.Reader) (m *Proxy, n int, err error) {
 	var hdr [4]byte
 
@@ -92,3 +92,29 @@ func TestProxyToBytes(t *testing.T) {
 	_, err = hdr.ToBytes()
 	require.NoError(t, err)
 }
+
+func TestMakeSSLTLV(t *testing.T) {
+	tlv := MakeSSLTLV(ProxyClientSSL|ProxyClientCertConn,
+		ProxyTlv{Typ: ProxyTlvSSLVersion, Content: []byte("TLS 1.3")},
+		ProxyTlv{Typ: ProxyTlvSSLCN, Content: []byte("app")},
+	)
+	require.Equal(t, ProxyTlvSSL, tlv.Typ)
+	expected := []byte{0x03, 0, 0, 0, 0, byte(ProxyTlvSSLVersion), 0, 7}
+	expected = append(expected, "TLS 1.3"...)
+	expected = append(expected, byte(ProxyTlvSSLCN), 0, 3)
+	expected = append(expected, "app"...)
+	require.Equal(t, expected, tlv.Content)
+}
+
+func TestTLVValues(t *testing.T) {
+	// The values follow the PROXY protocol v2 spec.
+	require.EqualValues(t, 0x01, ProxyTlvALPN)
+	require.EqualValues(t, 0x05, ProxyTlvUniqueID)
+	require.EqualValues(t, 0x20, ProxyTlvSSL)
+	require.EqualValues(t, 0x21, ProxyTlvSSLVersion)
+	require.EqualValues(t, 0x22, ProxyTlvSSLCN)
+	require.EqualValues(t, 0x23, ProxyTlvSSLCipher)
+	require.EqualValues(t, 0x24, ProxyTlvSSLSignALG)
+	require.EqualValues(t, 0x25, ProxyTlvSSLKeyALG)
+	require.EqualValues(t, 0x30, ProxyTlvNetns)
+}
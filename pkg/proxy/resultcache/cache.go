@@ -0,0 +1,158 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resultcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	resHit  = "hit"
+	resMiss = "miss"
+)
+
+// Key identifies a cached result set.
+type Key struct {
+	Namespace string
+	User      string
+	DB        string
+	// Digest is the digest of the normalized statement. SQL is the statement text without comments and redundant
+	// spaces, which keeps the literals because they decide the result.
+	Digest string
+	SQL    string
+	// Vars are the session variables that change the result, such as time_zone and sql_mode.
+	Vars string
+	// The result set packets are different when CLIENT_DEPRECATE_EOF is set.
+	DeprecateEOF bool
+}
+
+type entry struct {
+	key      Key
+	rule     string
+	packets  [][]byte
+	size     int64
+	expireAt time.Time
+	elem     *list.Element
+}
+
+// ruleEntries are the entries cached by one rule in the order of insertion.
+// All the entries of a rule have the same TTL, so the front ones expire first.
+type ruleEntries struct {
+	entries *list.List
+	size    int64
+}
+
+// Cache caches the result sets of the statements that match the cache rules.
+// Each rule has its own TTL and size limit, and the oldest result sets of the rule are evicted once it exceeds.
+type Cache struct {
+	sync.Mutex
+	entries map[Key]*entry
+	rules   map[string]*ruleEntries
+	size    int64
+	lg      *zap.Logger
+}
+
+func NewCache(lg *zap.Logger) *Cache {
+	return &Cache{
+		entries: make(map[Key]*entry),
+		rules:   make(map[string]*ruleEntries),
+		lg:      lg,
+	}
+}
+
+// Get returns the packets of the cached result set, or nil if it's not cached or expired.
+func (c *Cache) Get(key Key, rule string) [][]byte {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Now().After(e.expireAt) {
+		c.removeEntry(e)
+		ok = false
+	}
+	if !ok {
+		metrics.ResultCacheCounter.WithLabelValues(rule, resMiss).Inc()
+		return nil
+	}
+	metrics.ResultCacheCounter.WithLabelValues(rule, resHit).Inc()
+	return e.packets
+}
+
+// Put caches the result set. It's ignored if the result set alone exceeds maxBytes.
+func (c *Cache) Put(key Key, rule string, ttl time.Duration, maxBytes int64, packets [][]byte) {
+	var size int64
+	for _, pkt := range packets {
+		size += int64(len(pkt))
+	}
+	if size > maxBytes {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.removeEntry(e)
+	}
+	re, ok := c.rules[rule]
+	if !ok {
+		re = &ruleEntries{entries: list.New()}
+	}
+	// Evict the expired result sets and then the oldest ones until the new one fits in.
+	now := time.Now()
+	for elem := re.entries.Front(); elem != nil; elem = re.entries.Front() {
+		e := elem.Value.(*entry)
+		if re.size+size <= maxBytes && now.Before(e.expireAt) {
+			break
+		}
+		c.removeEntry(e)
+	}
+	c.rules[rule] = re
+	e := &entry{
+		key:      key,
+		rule:     rule,
+		packets:  packets,
+		size:     size,
+		expireAt: now.Add(ttl),
+	}
+	e.elem = re.entries.PushBack(e)
+	re.size += size
+	c.entries[key] = e
+	c.size += size
+	metrics.ResultCacheBytesGauge.Set(float64(c.size))
+}
+
+// Flush removes the result sets cached by the rule, or all the result sets if rule is empty.
+// It returns the number of removed result sets.
+func (c *Cache) Flush(rule string) int {
+	c.Lock()
+	defer c.Unlock()
+	var removed int
+	for name, re := range c.rules {
+		if len(rule) > 0 && name != rule {
+			continue
+		}
+		for elem := re.entries.Front(); elem != nil; elem = re.entries.Front() {
+			c.removeEntry(elem.Value.(*entry))
+			removed++
+		}
+	}
+	c.lg.Info("result cache is flushed", zap.String("rule", rule), zap.Int("removed", removed))
+	return removed
+}
+
+// removeEntry must be called after holding the lock.
+func (c *Cache) removeEntry(e *entry) {
+	re := c.rules[e.rule]
+	re.entries.Remove(e.elem)
+	re.size -= e.size
+	if re.entries.Len() == 0 {
+		delete(c.rules, e.rule)
+	}
+	delete(c.entries, e.key)
+	c.size -= e.size
+	metrics.ResultCacheBytesGauge.Set(float64(c.size))
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package resultcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAndPut(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	c := NewCache(lg)
+	key := Key{Namespace: "ns", User: "u1", DB: "db", SQL: "select count(*) from t"}
+	require.Nil(t, c.Get(key, "r1"))
+
+	packets := [][]byte{{1}, {2, 3}}
+	c.Put(key, "r1", time.Minute, 100, packets)
+	require.Equal(t, packets, c.Get(key, "r1"))
+	// Any difference in the key misses the cache.
+	for _, k := range []Key{
+		{Namespace: "ns", User: "u2", DB: "db", SQL: "select count(*) from t"},
+		{Namespace: "ns", User: "u1", DB: "db2", SQL: "select count(*) from t"},
+		{Namespace: "ns", User: "u1", DB: "db", SQL: "select count(*) from t2"},
+		{Namespace: "ns", User: "u1", DB: "db", SQL: "select count(*) from t", DeprecateEOF: true},
+		{Namespace: "ns", User: "u1", DB: "db", SQL: "select count(*) from t", Vars: "TIME_ZONE=SET time_zone='UTC'"},
+	} {
+		require.Nil(t, c.Get(k, "r1"))
+	}
+
+	// The result set is replaced.
+	packets = [][]byte{{4}}
+	c.Put(key, "r1", time.Minute, 100, packets)
+	require.Equal(t, packets, c.Get(key, "r1"))
+	require.EqualValues(t, 1, c.size)
+}
+
+func TestExpire(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	c := NewCache(lg)
+	key := Key{SQL: "select 1"}
+	c.Put(key, "r1", time.Millisecond, 100, [][]byte{{1}})
+	require.Eventually(t, func() bool {
+		return c.Get(key, "r1") == nil
+	}, time.Second, time.Millisecond)
+	require.Empty(t, c.entries)
+	require.Empty(t, c.rules)
+	require.Zero(t, c.size)
+}
+
+func TestEvict(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	c := NewCache(lg)
+	keys := []Key{{SQL: "select 1"}, {SQL: "select 2"}, {SQL: "select 3"}}
+	// Each result set is 4 bytes and each rule can cache 2 result sets.
+	for _, key := range keys {
+		c.Put(key, "r1", time.Minute, 8, [][]byte{{1, 2}, {3, 4}})
+	}
+	require.Nil(t, c.Get(keys[0], "r1"))
+	require.NotNil(t, c.Get(keys[1], "r1"))
+	require.NotNil(t, c.Get(keys[2], "r1"))
+	require.EqualValues(t, 8, c.size)
+
+	// A result set larger than the limit is not cached.
+	c.Put(Key{SQL: "select 4"}, "r1", time.Minute, 8, [][]byte{make([]byte, 9)})
+	require.Nil(t, c.Get(Key{SQL: "select 4"}, "r1"))
+	require.NotNil(t, c.Get(keys[1], "r1"))
+
+	// Rules don't evict the result sets of each other.
+	c.Put(Key{SQL: "select 5"}, "r3", time.Minute, 8, [][]byte{{1, 2, 3, 4}})
+	require.NotNil(t, c.Get(keys[1], "r1"))
+	require.NotNil(t, c.Get(keys[2], "r1"))
+	require.EqualValues(t, 12, c.size)
+}
+
+func TestFlush(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	c := NewCache(lg)
+	c.Put(Key{SQL: "select 1"}, "r1", time.Minute, 100, [][]byte{{1}})
+	c.Put(Key{SQL: "select 2"}, "r1", time.Minute, 100, [][]byte{{1}})
+	c.Put(Key{SQL: "select 3"}, "r2", time.Minute, 100, [][]byte{{1}})
+	require.Equal(t, 2, c.Flush("r1"))
+	require.Nil(t, c.Get(Key{SQL: "select 1"}, "r1"))
+	require.NotNil(t, c.Get(Key{SQL: "select 3"}, "r2"))
+	require.Equal(t, 1, c.Flush(""))
+	require.Nil(t, c.Get(Key{SQL: "select 3"}, "r2"))
+	require.Zero(t, c.size)
+}
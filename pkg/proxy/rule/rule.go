@@ -0,0 +1,173 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rule
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
+	"go.uber.org/zap"
+)
+
+// Scope identifies the session that a statement comes from.
+type Scope struct {
+	Namespace string
+	User      string
+}
+
+// Result is the action to take on a matched statement.
+type Result struct {
+	Rule   string
+	Action string
+	// Err is returned to the client for the reject action.
+	Err *mysql.MyError
+	// SQL is the rewritten statement for the rewrite action.
+	SQL string
+	// Delay is the delay for the delay action.
+	Delay time.Duration
+	// CacheTTL and CacheMaxBytes are the TTL and the size limit for the cache action.
+	CacheTTL      time.Duration
+	CacheMaxBytes int64
+}
+
+type rule struct {
+	cfg      config.QueryRule
+	regex    *regexp.Regexp
+	keywords []string
+}
+
+func newRule(cfg config.QueryRule) (*rule, error) {
+	if err := cfg.Check(); err != nil {
+		return nil, err
+	}
+	r := &rule{cfg: cfg}
+	if len(cfg.Regex) > 0 {
+		// It's already checked, so it never fails.
+		r.regex = regexp.MustCompile(cfg.Regex)
+	}
+	r.keywords = make([]string, 0, len(cfg.Keywords))
+	for _, kw := range cfg.Keywords {
+		r.keywords = append(r.keywords, strings.ToUpper(kw))
+	}
+	return r, nil
+}
+
+func (r *rule) inScope(scope Scope) bool {
+	if len(r.cfg.Namespaces) > 0 && !slices.Contains(r.cfg.Namespaces, scope.Namespace) {
+		return false
+	}
+	if len(r.cfg.Users) > 0 && !slices.Contains(r.cfg.Users, scope.User) {
+		return false
+	}
+	return true
+}
+
+func (r *rule) match(sql string, digest func() string) bool {
+	if len(r.keywords) > 0 {
+		lexer := lex.NewLexer(sql)
+		for _, kw := range r.keywords {
+			if lexer.NextToken() != kw {
+				return false
+			}
+		}
+	}
+	if len(r.cfg.Digest) > 0 && r.cfg.Digest != digest() {
+		return false
+	}
+	if r.regex != nil && !r.regex.MatchString(sql) {
+		return false
+	}
+	return true
+}
+
+func (r *rule) apply(sql string) *Result {
+	res := &Result{
+		Rule:   r.cfg.Name,
+		Action: r.cfg.Action,
+	}
+	switch r.cfg.Action {
+	case config.RuleActionReject:
+		code, msg := r.cfg.ErrCode, r.cfg.ErrMsg
+		if code == 0 {
+			code = mysql.ER_UNKNOWN_ERROR
+		}
+		if len(msg) == 0 {
+			msg = "Statement is rejected by TiProxy query rule " + r.cfg.Name
+		}
+		res.Err = mysql.NewError(code, msg)
+	case config.RuleActionRewrite:
+		if r.regex != nil {
+			res.SQL = r.regex.ReplaceAllString(sql, r.cfg.Rewrite)
+		} else {
+			res.SQL = r.cfg.Rewrite
+		}
+	case config.RuleActionDelay:
+		res.Delay = time.Duration(r.cfg.Delay) * time.Millisecond
+	case config.RuleActionCache:
+		res.CacheTTL = time.Duration(r.cfg.CacheTTL) * time.Second
+		res.CacheMaxBytes = r.cfg.CacheMaxBytes
+	}
+	return res
+}
+
+// Engine matches statements against the query rules.
+// The rules can be reset at runtime and all the connections see the new rules immediately.
+type Engine struct {
+	rules  atomic.Pointer[[]*rule]
+	logger *zap.Logger
+}
+
+func NewEngine(logger *zap.Logger) *Engine {
+	return &Engine{
+		logger: logger,
+	}
+}
+
+// Reset replaces all the rules. If any rule is invalid, the previous rules are kept.
+func (e *Engine) Reset(cfgs []config.QueryRule) error {
+	rules := make([]*rule, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		r, err := newRule(cfg)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+	}
+	e.rules.Store(&rules)
+	e.logger.Info("query rules are updated", zap.Int("rule_num", len(rules)))
+	return nil
+}
+
+// Match returns the result of the first matched rule, or nil if no rule matches.
+func (e *Engine) Match(scope Scope, sql string) *Result {
+	rules := e.rules.Load()
+	if rules == nil || len(*rules) == 0 {
+		return nil
+	}
+	// The digest is expensive, so only calculate it when necessary.
+	var digest string
+	getDigest := func() string {
+		if len(digest) == 0 {
+			_, d := parser.NormalizeDigest(sql)
+			digest = d.String()
+		}
+		return digest
+	}
+	for _, r := range *rules {
+		if !r.inScope(scope) || !r.match(sql, getDigest) {
+			continue
+		}
+		metrics.QueryRuleCounter.WithLabelValues(r.cfg.Name, r.cfg.Action).Inc()
+		return r.apply(sql)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package rule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchRules(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	_, digest := parser.NormalizeDigest("select * from t where id = 1")
+	e := NewEngine(lg)
+	require.Nil(t, e.Match(Scope{}, "select 1"))
+	require.NoError(t, e.Reset([]config.QueryRule{
+		{
+			Name:       "reject_delete",
+			Namespaces: []string{"ns1"},
+			Users:      []string{"app"},
+			Keywords:   []string{"delete", "from"},
+			Action:     config.RuleActionReject,
+		},
+		{
+			Name:    "delay_digest",
+			Digest:  digest.String(),
+			Action:  config.RuleActionDelay,
+			Delay:   100,
+			ErrCode: 1,
+		},
+		{
+			Name:    "rewrite_regex",
+			Regex:   `^SELECT \* FROM (\w+)$`,
+			Action:  config.RuleActionRewrite,
+			Rewrite: "SELECT /*+ MAX_EXECUTION_TIME(1000) */ * FROM $1",
+		},
+		{
+			Name:     "rewrite_all",
+			Keywords: []string{"TRUNCATE"},
+			Action:   config.RuleActionRewrite,
+			Rewrite:  "SELECT 1",
+		},
+		{
+			Name:          "cache_count",
+			Keywords:      []string{"SELECT", "COUNT"},
+			Action:        config.RuleActionCache,
+			CacheTTL:      10,
+			CacheMaxBytes: 1024,
+		},
+	}))
+
+	tests := []struct {
+		scope  Scope
+		sql    string
+		result *Result
+	}{
+		{
+			scope:  Scope{Namespace: "ns1", User: "app"},
+			sql:    "/* comment */ DELETE FROM t",
+			result: &Result{Rule: "reject_delete", Action: config.RuleActionReject, Err: mysql.NewError(mysql.ER_UNKNOWN_ERROR, "Statement is rejected by TiProxy query rule reject_delete")},
+		},
+		{
+			// The contents of executable comments are executed, so they can't bypass the rule.
+			scope:  Scope{Namespace: "ns1", User: "app"},
+			sql:    "/*!50000 DELETE */ FROM t",
+			result: &Result{Rule: "reject_delete", Action: config.RuleActionReject, Err: mysql.NewError(mysql.ER_UNKNOWN_ERROR, "Statement is rejected by TiProxy query rule reject_delete")},
+		},
+		{
+			scope:  Scope{Namespace: "ns1", User: "app"},
+			sql:    "/*T![feature] DELETE*/FROM t",
+			result: &Result{Rule: "reject_delete", Action: config.RuleActionReject, Err: mysql.NewError(mysql.ER_UNKNOWN_ERROR, "Statement is rejected by TiProxy query rule reject_delete")},
+		},
+		{
+			scope: Scope{Namespace: "ns2", User: "app"},
+			sql:   "DELETE FROM t",
+		},
+		{
+			scope: Scope{Namespace: "ns1", User: "root"},
+			sql:   "DELETE FROM t",
+		},
+		{
+			scope: Scope{Namespace: "ns1", User: "app"},
+			sql:   "DELETE t FROM t",
+		},
+		{
+			sql:    "SELECT * FROM t WHERE id = 100",
+			result: &Result{Rule: "delay_digest", Action: config.RuleActionDelay, Delay: 100 * time.Millisecond},
+		},
+		{
+			sql:    "SELECT * FROM t",
+			result: &Result{Rule: "rewrite_regex", Action: config.RuleActionRewrite, SQL: "SELECT /*+ MAX_EXECUTION_TIME(1000) */ * FROM t"},
+		},
+		{
+			sql:    "truncate table t",
+			result: &Result{Rule: "rewrite_all", Action: config.RuleActionRewrite, SQL: "SELECT 1"},
+		},
+		{
+			sql:    "SELECT COUNT(*) FROM t",
+			result: &Result{Rule: "cache_count", Action: config.RuleActionCache, CacheTTL: 10 * time.Second, CacheMaxBytes: 1024},
+		},
+		{
+			sql: "SELECT * FROM t WHERE id > 1",
+		},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.result, e.Match(test.scope, test.sql), "case %d", i)
+	}
+
+	// Invalid rules don't overwrite the previous ones.
+	require.Error(t, e.Reset([]config.QueryRule{{Name: "invalid", Regex: "(", Action: config.RuleActionReject}}))
+	require.NotNil(t, e.Match(Scope{}, "truncate table t"))
+	require.NoError(t, e.Reset(nil))
+	require.Nil(t, e.Match(Scope{}, "truncate table t"))
+}
+
+func TestRejectError(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	e := NewEngine(lg)
+	require.NoError(t, e.Reset([]config.QueryRule{
+		{
+			Name:     "reject",
+			Keywords: []string{"SELECT"},
+			Action:   config.RuleActionReject,
+			ErrCode:  mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR,
+			ErrMsg:   "denied",
+		},
+	}))
+	res := e.Match(Scope{}, "select 1")
+	require.NotNil(t, res)
+	require.Equal(t, uint16(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR), res.Err.Code)
+	require.Equal(t, "denied", res.Err.Message)
+	require.Equal(t, mysql.MySQLState[mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR], res.Err.State)
+}
@@ -0,0 +1,349 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package stmtsummary
+
+import (
+	"context"
+	"math/bits"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/lib/util/waitgroup"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	maxPendingRecords = 1 << 14 // 16K
+	maxSQLLen         = 4096
+	// The latency of the i-th bucket is in [2^(i-1), 2^i) microseconds, so percentiles are accurate to a factor of 2.
+	numBuckets = 40
+)
+
+const (
+	OrderBySumLatency = "sum_latency"
+	OrderByAvgLatency = "avg_latency"
+	OrderByMaxLatency = "max_latency"
+	OrderByP99Latency = "p99_latency"
+	OrderByExecCount  = "exec_count"
+	OrderByErrCount   = "err_count"
+	OrderBySumRows    = "sum_rows"
+	OrderByInBytes    = "sum_in_bytes"
+	OrderByOutBytes   = "sum_out_bytes"
+	OrderByMigrations = "migration_count"
+)
+
+var ErrInvalidOrderBy = errors.New("invalid order-by")
+
+var orderFields = map[string]func(*Stmt) int64{
+	OrderBySumLatency: func(s *Stmt) int64 { return s.SumLatencyUs },
+	OrderByAvgLatency: func(s *Stmt) int64 { return s.AvgLatencyUs },
+	OrderByMaxLatency: func(s *Stmt) int64 { return s.MaxLatencyUs },
+	OrderByP99Latency: func(s *Stmt) int64 { return s.P99LatencyUs },
+	OrderByExecCount:  func(s *Stmt) int64 { return int64(s.ExecCount) },
+	OrderByErrCount:   func(s *Stmt) int64 { return int64(s.ErrCount) },
+	OrderBySumRows:    func(s *Stmt) int64 { return int64(s.SumRows) },
+	OrderByInBytes:    func(s *Stmt) int64 { return int64(s.SumInBytes) },
+	OrderByOutBytes:   func(s *Stmt) int64 { return int64(s.SumOutBytes) },
+	OrderByMigrations: func(s *Stmt) int64 { return int64(s.MigrationCount) },
+}
+
+// Record is one statement executed by a session.
+type Record struct {
+	Namespace string
+	User      string
+	Backend   string
+	// SQL is the statement text, or the prepared statement text for COM_STMT_EXECUTE.
+	// It's normalized in the background so that the forwarding path is not slowed down.
+	SQL     string
+	EndTime time.Time
+	// Latency is measured from receiving the request to sending the last response, including the network time
+	// between TiProxy and the backend and the time of session migration.
+	Latency  time.Duration
+	Failed   bool
+	InBytes  uint64
+	OutBytes uint64
+	Rows     uint64
+	// Migrated means the statement is held and executed on a new backend after session migration or failover.
+	Migrated bool
+}
+
+type key struct {
+	namespace string
+	user      string
+	backend   string
+	digest    string
+}
+
+// Stmt is the statistics of one digest of one user, namespace, and backend in one window.
+// The entry with an empty digest aggregates the statements that exceed max-stmt-count.
+type Stmt struct {
+	Digest         string    `json:"digest"`
+	NormalizedSQL  string    `json:"normalized_sql,omitempty"`
+	Namespace      string    `json:"namespace,omitempty"`
+	User           string    `json:"user,omitempty"`
+	Backend        string    `json:"backend,omitempty"`
+	ExecCount      uint64    `json:"exec_count"`
+	ErrCount       uint64    `json:"err_count"`
+	SumLatencyUs   int64     `json:"sum_latency_us"`
+	AvgLatencyUs   int64     `json:"avg_latency_us"`
+	MaxLatencyUs   int64     `json:"max_latency_us"`
+	P50LatencyUs   int64     `json:"p50_latency_us"`
+	P95LatencyUs   int64     `json:"p95_latency_us"`
+	P99LatencyUs   int64     `json:"p99_latency_us"`
+	SumInBytes     uint64    `json:"sum_in_bytes"`
+	SumOutBytes    uint64    `json:"sum_out_bytes"`
+	SumRows        uint64    `json:"sum_rows"`
+	MigrationCount uint64    `json:"migration_count"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	buckets        [numBuckets]uint64
+}
+
+func (s *Stmt) add(r *Record) {
+	latency := r.Latency.Microseconds()
+	if s.ExecCount == 0 {
+		s.FirstSeen = r.EndTime
+	}
+	s.ExecCount++
+	if r.Failed {
+		s.ErrCount++
+	}
+	s.SumLatencyUs += latency
+	s.MaxLatencyUs = max(s.MaxLatencyUs, latency)
+	s.buckets[min(bits.Len64(uint64(max(latency, 0))), numBuckets-1)]++
+	s.SumInBytes += r.InBytes
+	s.SumOutBytes += r.OutBytes
+	s.SumRows += r.Rows
+	if r.Migrated {
+		s.MigrationCount++
+	}
+	s.LastSeen = r.EndTime
+}
+
+// export fills the calculated fields and returns a copy.
+func (s *Stmt) export() Stmt {
+	stmt := *s
+	if stmt.ExecCount > 0 {
+		stmt.AvgLatencyUs = stmt.SumLatencyUs / int64(stmt.ExecCount)
+	}
+	stmt.P50LatencyUs = s.percentile(0.5)
+	stmt.P95LatencyUs = s.percentile(0.95)
+	stmt.P99LatencyUs = s.percentile(0.99)
+	return stmt
+}
+
+// percentile returns the upper bound of the bucket that the percentile falls in.
+func (s *Stmt) percentile(p float64) int64 {
+	target := uint64(float64(s.ExecCount)*p + 0.5)
+	var count uint64
+	for i, n := range s.buckets {
+		count += n
+		if count >= max(target, 1) {
+			return min(int64(1)<<i, s.MaxLatencyUs)
+		}
+	}
+	return s.MaxLatencyUs
+}
+
+// Window is the statistics of all the statements in a time window.
+type Window struct {
+	Begin time.Time `json:"begin"`
+	End   time.Time `json:"end"`
+	Stmts []Stmt    `json:"statements"`
+	stmts map[key]*Stmt
+}
+
+func (w *Window) export(orderBy string, limit int) Window {
+	field := orderFields[orderBy]
+	stmts := make([]Stmt, 0, len(w.stmts))
+	for _, stmt := range w.stmts {
+		stmts = append(stmts, stmt.export())
+	}
+	sort.Slice(stmts, func(i, j int) bool {
+		if vi, vj := field(&stmts[i]), field(&stmts[j]); vi != vj {
+			return vi > vj
+		}
+		// Make the order stable for the same values.
+		return stmts[i].Digest+stmts[i].Namespace+stmts[i].User+stmts[i].Backend <
+			stmts[j].Digest+stmts[j].Namespace+stmts[j].User+stmts[j].Backend
+	})
+	if limit > 0 && len(stmts) > limit {
+		stmts = stmts[:limit]
+	}
+	return Window{Begin: w.Begin, End: w.End, Stmts: stmts}
+}
+
+// Summary aggregates the statements by digest, user, namespace, and backend in each time window.
+// Records are sent to a buffered channel and aggregated in the background so that the forwarding path is never
+// blocked. If the channel is full, the records are dropped.
+type Summary struct {
+	sync.Mutex
+	cfg config.StmtSummary
+	cur *Window
+	// history is the finished windows, the oldest first.
+	history []*Window
+	// enabled is read without lock on the forwarding path.
+	enabled  atomic.Bool
+	recordCh chan *Record
+	wg       waitgroup.WaitGroup
+	cancel   context.CancelFunc
+	lg       *zap.Logger
+}
+
+func NewSummary(lg *zap.Logger) *Summary {
+	return &Summary{
+		cfg:      config.DefaultStmtSummary(),
+		recordCh: make(chan *Record, maxPendingRecords),
+		lg:       lg,
+	}
+}
+
+// Start starts aggregating records in the background.
+func (s *Summary) Start(ctx context.Context) {
+	childCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.wg.RunWithRecover(func() {
+		s.run(childCtx)
+	}, nil, s.lg)
+}
+
+// Reset updates the config. Disabling the summary clears all the windows.
+func (s *Summary) Reset(cfg config.StmtSummary) {
+	defaultCfg := config.DefaultStmtSummary()
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = defaultCfg.RefreshInterval
+	}
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = defaultCfg.HistorySize
+	}
+	if cfg.MaxStmtCount <= 0 {
+		cfg.MaxStmtCount = defaultCfg.MaxStmtCount
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.cfg == cfg {
+		return
+	}
+	if !cfg.Enable || cfg.RefreshInterval != s.cfg.RefreshInterval {
+		s.cur, s.history = nil, nil
+	}
+	s.cfg = cfg
+	s.trimHistory()
+	s.enabled.Store(cfg.Enable)
+	s.lg.Info("statement summary config is updated", zap.Bool("enable", cfg.Enable), zap.Int("refresh_interval", cfg.RefreshInterval),
+		zap.Int("history_size", cfg.HistorySize), zap.Int("max_stmt_count", cfg.MaxStmtCount))
+}
+
+// Enabled returns whether statements should be recorded.
+func (s *Summary) Enabled() bool {
+	return s.enabled.Load()
+}
+
+// Add sends the record to the background without blocking.
+func (s *Summary) Add(r *Record) {
+	select {
+	case s.recordCh <- r:
+	default:
+		metrics.StmtSummaryDroppedCounter.Inc()
+	}
+}
+
+func (s *Summary) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-s.recordCh:
+			s.aggregate(r)
+		}
+	}
+}
+
+func (s *Summary) aggregate(r *Record) {
+	normalized, digest := parser.NormalizeDigest(r.SQL)
+	if len(normalized) > maxSQLLen {
+		normalized = normalized[:maxSQLLen]
+	}
+	k := key{namespace: r.Namespace, user: r.User, backend: r.Backend, digest: digest.String()}
+	s.Lock()
+	defer s.Unlock()
+	if !s.cfg.Enable {
+		return
+	}
+	s.rotate(r.EndTime)
+	if s.cur == nil {
+		interval := time.Duration(s.cfg.RefreshInterval) * time.Second
+		begin := r.EndTime.Truncate(interval)
+		s.cur = &Window{Begin: begin, End: begin.Add(interval), stmts: make(map[key]*Stmt)}
+	}
+	stmt, ok := s.cur.stmts[k]
+	if !ok {
+		if len(s.cur.stmts) >= s.cfg.MaxStmtCount {
+			k = key{}
+			stmt = s.cur.stmts[k]
+		}
+		if stmt == nil {
+			stmt = &Stmt{Digest: k.digest, Namespace: k.namespace, User: k.user, Backend: k.backend}
+			if len(k.digest) > 0 {
+				stmt.NormalizedSQL = normalized
+			}
+			s.cur.stmts[k] = stmt
+		}
+	}
+	stmt.add(r)
+}
+
+// rotate moves the current window to the history if it's finished. It must be called after holding the lock.
+func (s *Summary) rotate(now time.Time) {
+	if s.cur == nil || now.Before(s.cur.End) {
+		return
+	}
+	s.history = append(s.history, s.cur)
+	s.cur = nil
+	s.trimHistory()
+}
+
+func (s *Summary) trimHistory() {
+	if len(s.history) > s.cfg.HistorySize {
+		s.history = s.history[len(s.history)-s.cfg.HistorySize:]
+	}
+}
+
+// Windows returns the current window and the history windows if history is true, the latest first.
+// The statements in each window are sorted by orderBy in descending order and at most limit ones are returned.
+func (s *Summary) Windows(history bool, orderBy string, limit int) ([]Window, error) {
+	if len(orderBy) == 0 {
+		orderBy = OrderBySumLatency
+	}
+	if _, ok := orderFields[orderBy]; !ok {
+		return nil, errors.Wrapf(ErrInvalidOrderBy, "%s", orderBy)
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.rotate(time.Now())
+	windows := make([]Window, 0, len(s.history)+1)
+	if s.cur != nil {
+		windows = append(windows, s.cur.export(orderBy, limit))
+	}
+	if history {
+		for i := len(s.history) - 1; i >= 0; i-- {
+			windows = append(windows, s.history[i].export(orderBy, limit))
+		}
+	}
+	return windows, nil
+}
+
+// Close stops aggregating records.
+func (s *Summary) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
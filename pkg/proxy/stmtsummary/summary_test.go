@@ -0,0 +1,126 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package stmtsummary
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func newSummary(t *testing.T, cfg config.StmtSummary) *Summary {
+	lg, _ := logger.CreateLoggerForTest(t)
+	s := NewSummary(lg)
+	cfg.Enable = true
+	s.Reset(cfg)
+	return s
+}
+
+func TestAggregate(t *testing.T) {
+	s := newSummary(t, config.StmtSummary{})
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		s.aggregate(&Record{
+			Namespace: "ns",
+			User:      "u1",
+			Backend:   "b1",
+			SQL:       "select * from t where id = 1",
+			EndTime:   now,
+			Latency:   time.Duration(i+1) * time.Millisecond,
+			Failed:    i%10 == 0,
+			InBytes:   10,
+			OutBytes:  100,
+			Rows:      1,
+			Migrated:  i == 0,
+		})
+	}
+	// Different literals have the same digest, but different users don't.
+	s.aggregate(&Record{Namespace: "ns", User: "u1", Backend: "b1", SQL: "select * from t where id = 2", EndTime: now})
+	s.aggregate(&Record{Namespace: "ns", User: "u2", Backend: "b1", SQL: "select * from t where id = 1", EndTime: now})
+
+	windows, err := s.Windows(false, OrderByExecCount, 0)
+	require.NoError(t, err)
+	require.Len(t, windows, 1)
+	require.Len(t, windows[0].Stmts, 2)
+	stmt := windows[0].Stmts[0]
+	require.Equal(t, "select * from `t` where `id` = ?", stmt.NormalizedSQL)
+	require.NotEmpty(t, stmt.Digest)
+	require.Equal(t, "u1", stmt.User)
+	require.EqualValues(t, 101, stmt.ExecCount)
+	require.EqualValues(t, 10, stmt.ErrCount)
+	require.EqualValues(t, 1000, stmt.SumInBytes)
+	require.EqualValues(t, 10000, stmt.SumOutBytes)
+	require.EqualValues(t, 100, stmt.SumRows)
+	require.EqualValues(t, 1, stmt.MigrationCount)
+	require.EqualValues(t, 100000, stmt.MaxLatencyUs)
+	require.EqualValues(t, 5050000/101, stmt.AvgLatencyUs)
+	// The percentiles are accurate to a factor of 2.
+	require.GreaterOrEqual(t, stmt.P50LatencyUs, int64(50000))
+	require.Less(t, stmt.P50LatencyUs, int64(100000))
+	require.GreaterOrEqual(t, stmt.P99LatencyUs, stmt.P95LatencyUs)
+	require.LessOrEqual(t, stmt.P99LatencyUs, stmt.MaxLatencyUs)
+	require.Equal(t, "u2", windows[0].Stmts[1].User)
+
+	windows, err = s.Windows(false, OrderByExecCount, 1)
+	require.NoError(t, err)
+	require.Len(t, windows[0].Stmts, 1)
+	_, err = s.Windows(false, "unknown", 0)
+	require.ErrorIs(t, err, ErrInvalidOrderBy)
+}
+
+func TestMaxStmtCount(t *testing.T) {
+	s := newSummary(t, config.StmtSummary{MaxStmtCount: 2})
+	now := time.Now()
+	for _, sql := range []string{"select 1", "select a from t", "select b from t", "select c from t"} {
+		s.aggregate(&Record{SQL: sql, EndTime: now})
+	}
+	windows, err := s.Windows(false, OrderByExecCount, 0)
+	require.NoError(t, err)
+	require.Len(t, windows[0].Stmts, 3)
+	// The exceeded statements are aggregated into the entry with an empty digest.
+	require.Empty(t, windows[0].Stmts[0].Digest)
+	require.EqualValues(t, 2, windows[0].Stmts[0].ExecCount)
+}
+
+func TestRotate(t *testing.T) {
+	s := newSummary(t, config.StmtSummary{RefreshInterval: 60, HistorySize: 2})
+	begin := time.Now().Truncate(time.Minute)
+	for i := 0; i < 4; i++ {
+		s.aggregate(&Record{SQL: "select 1", EndTime: begin.Add(time.Duration(i) * time.Minute)})
+	}
+	s.Lock()
+	require.Len(t, s.history, 2)
+	require.Equal(t, begin.Add(3*time.Minute), s.cur.Begin)
+	require.Equal(t, begin.Add(4*time.Minute), s.cur.End)
+	s.Unlock()
+	windows, err := s.Windows(true, "", 0)
+	require.NoError(t, err)
+	require.Len(t, windows, 3)
+	require.Equal(t, begin.Add(3*time.Minute), windows[0].Begin)
+	require.Equal(t, begin.Add(time.Minute), windows[2].Begin)
+
+	// Disabling clears the windows and ignores the records.
+	s.Reset(config.StmtSummary{})
+	require.False(t, s.Enabled())
+	s.aggregate(&Record{SQL: "select 1", EndTime: begin})
+	windows, err = s.Windows(true, "", 0)
+	require.NoError(t, err)
+	require.Empty(t, windows)
+}
+
+func TestAddInBackground(t *testing.T) {
+	s := newSummary(t, config.StmtSummary{})
+	s.Start(context.Background())
+	defer s.Close()
+	s.Add(&Record{SQL: "select 1", EndTime: time.Now()})
+	require.Eventually(t, func() bool {
+		windows, err := s.Windows(false, "", 0)
+		require.NoError(t, err)
+		return len(windows) == 1 && len(windows[0].Stmts) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+}
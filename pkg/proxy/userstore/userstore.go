@@ -0,0 +1,241 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb/parser/auth"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/lib/util/waitgroup"
+	"github.com/pingcap/tiproxy/pkg/util/etcd"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// reloadInterval is the interval of reloading the users so that the changes take effect without restarting.
+	reloadInterval = 10 * time.Second
+	// EtcdPrefix is the etcd path of the users. The key is the user name and the value is the JSON of User.
+	EtcdPrefix = "/tiproxy/auth/users/"
+
+	etcdTimeout       = 3 * time.Second
+	etcdRetryInterval = time.Second
+	etcdRetryCnt      = 3
+
+	// The length of `*<40 hex chars>`.
+	nativeHashLen = 41
+	// The length of `$A$005$<20 bytes salt><43 bytes hash>`.
+	sha2HashLen = 70
+)
+
+// User is a user in the local user store.
+type User struct {
+	Name string `json:"name"`
+	// Plugin is mysql_native_password or caching_sha2_password.
+	Plugin string `json:"plugin"`
+	// AuthString is the hash of the password, which is the same as mysql.user.authentication_string.
+	// Empty means no password.
+	AuthString string `json:"auth-string"`
+	// BackendUser and BackendPassword are the credentials to log into TiDB.
+	// BackendUser is the same as Name if it's empty.
+	BackendUser     string `json:"backend-user"`
+	BackendPassword string `json:"backend-password"`
+}
+
+func (u *User) check() error {
+	if len(u.Name) == 0 {
+		return errors.New("user name is empty")
+	}
+	if len(u.BackendUser) == 0 {
+		u.BackendUser = u.Name
+	}
+	switch u.Plugin {
+	case mysql.AuthNativePassword:
+		if len(u.AuthString) > 0 && (len(u.AuthString) != nativeHashLen || u.AuthString[0] != '*') {
+			return errors.Errorf("user %s has an invalid %s hash", u.Name, u.Plugin)
+		}
+	case mysql.AuthCachingSha2Password:
+		if len(u.AuthString) > 0 && (len(u.AuthString) != sha2HashLen || !strings.HasPrefix(u.AuthString, "$A$")) {
+			return errors.Errorf("user %s has an invalid %s hash", u.Name, u.Plugin)
+		}
+	default:
+		return errors.Errorf("user %s has an unsupported auth plugin %s", u.Name, u.Plugin)
+	}
+	return nil
+}
+
+// CheckScramble checks the scrambled password of mysql_native_password.
+func (u *User) CheckScramble(salt, scramble []byte) bool {
+	if len(u.AuthString) == 0 {
+		return len(scramble) == 0
+	}
+	hpwd, err := auth.DecodePassword(u.AuthString)
+	if err != nil {
+		return false
+	}
+	return auth.CheckScrambledPassword(salt, hpwd, scramble)
+}
+
+// CheckPassword checks the clear text password.
+func (u *User) CheckPassword(password string) bool {
+	if len(u.AuthString) == 0 {
+		return len(password) == 0
+	}
+	switch u.Plugin {
+	case mysql.AuthNativePassword:
+		return auth.EncodePassword(password) == u.AuthString
+	case mysql.AuthCachingSha2Password:
+		ok, err := auth.CheckHashingPassword([]byte(u.AuthString), password, mysql.AuthCachingSha2Password)
+		return err == nil && ok
+	}
+	return false
+}
+
+// Store is the local user store of TiProxy. The users are loaded from a file or etcd and reloaded periodically.
+type Store struct {
+	sync.RWMutex
+	enabled bool
+	file    string
+	users   map[string]*User
+	etcdCli *clientv3.Client
+	logger  *zap.Logger
+	wg      waitgroup.WaitGroup
+	cancel  context.CancelFunc
+}
+
+func NewStore(logger *zap.Logger) *Store {
+	return &Store{
+		users:  make(map[string]*User),
+		logger: logger,
+	}
+}
+
+// Start loads the users from etcd if etcdCli is not nil and reloads them periodically.
+func (s *Store) Start(ctx context.Context, etcdCli *clientv3.Client) {
+	s.Lock()
+	s.etcdCli = etcdCli
+	s.Unlock()
+	childCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.reload(childCtx)
+	s.wg.RunWithRecover(func() {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-childCtx.Done():
+				return
+			case <-ticker.C:
+				s.reload(childCtx)
+			}
+		}
+	}, nil, s.logger)
+}
+
+// Reset updates the config and reloads the users.
+func (s *Store) Reset(cfg config.ProxyAuth) {
+	s.Lock()
+	changed := s.enabled != cfg.Enable || s.file != cfg.UserFile
+	s.enabled, s.file = cfg.Enable, cfg.UserFile
+	s.Unlock()
+	if changed {
+		s.reload(context.Background())
+	}
+}
+
+// Enabled returns whether TiProxy authenticates the clients by itself.
+func (s *Store) Enabled() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.enabled
+}
+
+// Get returns the user with the name.
+func (s *Store) Get(name string) (*User, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	user, ok := s.users[name]
+	return user, ok
+}
+
+// reload replaces the users. The previous users are kept if loading fails.
+func (s *Store) reload(ctx context.Context) {
+	s.RLock()
+	enabled, file, etcdCli := s.enabled, s.file, s.etcdCli
+	s.RUnlock()
+	if !enabled {
+		return
+	}
+	var users []*User
+	var err error
+	if len(file) > 0 {
+		users, err = loadFromFile(file)
+	} else if etcdCli != nil {
+		users, err = loadFromEtcd(ctx, etcdCli)
+	} else {
+		return
+	}
+	if err != nil {
+		s.logger.Warn("loading users failed", zap.Error(err))
+		return
+	}
+	userMap := make(map[string]*User, len(users))
+	for _, user := range users {
+		if err := user.check(); err != nil {
+			s.logger.Warn("ignore invalid user", zap.Error(err))
+			continue
+		}
+		userMap[user.Name] = user
+	}
+	s.Lock()
+	s.users = userMap
+	s.Unlock()
+}
+
+func loadFromFile(file string) ([]*User, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var users []*User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal users from %s failed", file)
+	}
+	return users, nil
+}
+
+func loadFromEtcd(ctx context.Context, etcdCli *clientv3.Client) ([]*User, error) {
+	kvs, err := etcd.GetKVs(ctx, etcdCli, EtcdPrefix, []clientv3.OpOption{clientv3.WithPrefix()}, etcdTimeout, etcdRetryInterval, etcdRetryCnt)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*User, 0, len(kvs))
+	for _, kv := range kvs {
+		var user User
+		if err := json.Unmarshal(kv.Value, &user); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal user from %s failed", string(kv.Key))
+		}
+		if len(user.Name) == 0 {
+			user.Name = strings.TrimPrefix(string(kv.Key), EtcdPrefix)
+		}
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+// Close stops reloading the users.
+func (s *Store) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
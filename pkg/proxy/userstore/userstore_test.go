@@ -0,0 +1,138 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package userstore
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap/tidb/parser/auth"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/pingcap/tiproxy/pkg/manager/cert"
+	"github.com/pingcap/tiproxy/pkg/util/etcd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPassword(t *testing.T) {
+	salt := []byte("01234567890123456789")
+	native := &User{Name: "u1", Plugin: mysql.AuthNativePassword, AuthString: auth.EncodePassword("pwd")}
+	require.NoError(t, native.check())
+	require.Equal(t, "u1", native.BackendUser)
+	scramble, err := scrambleNative(salt, "pwd")
+	require.NoError(t, err)
+	require.True(t, native.CheckScramble(salt, scramble))
+	scramble, err = scrambleNative(salt, "wrong")
+	require.NoError(t, err)
+	require.False(t, native.CheckScramble(salt, scramble))
+	require.False(t, native.CheckScramble(salt, nil))
+	require.True(t, native.CheckPassword("pwd"))
+	require.False(t, native.CheckPassword(""))
+
+	sha2 := &User{Name: "u2", Plugin: mysql.AuthCachingSha2Password, AuthString: auth.NewHashPassword("pwd", mysql.AuthCachingSha2Password)}
+	require.NoError(t, sha2.check())
+	require.True(t, sha2.CheckPassword("pwd"))
+	require.False(t, sha2.CheckPassword("wrong"))
+
+	empty := &User{Name: "u3", Plugin: mysql.AuthCachingSha2Password}
+	require.NoError(t, empty.check())
+	require.True(t, empty.CheckPassword(""))
+	require.False(t, empty.CheckPassword("pwd"))
+
+	invalid := []*User{
+		{Plugin: mysql.AuthNativePassword},
+		{Name: "u", Plugin: mysql.AuthNativePassword, AuthString: "pwd"},
+		{Name: "u", Plugin: mysql.AuthCachingSha2Password, AuthString: auth.EncodePassword("pwd")},
+		{Name: "u", Plugin: mysql.AuthTiDBSM3Password},
+	}
+	for i, user := range invalid {
+		require.Error(t, user.check(), "case %d", i)
+	}
+}
+
+func TestLoadFromFile(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	file := filepath.Join(t.TempDir(), "users.json")
+	writeUsers := func(users []User) {
+		data, err := json.Marshal(users)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(file, data, 0600))
+	}
+	writeUsers([]User{
+		{Name: "u1", Plugin: mysql.AuthNativePassword, BackendUser: "svc", BackendPassword: "123"},
+		{Name: "u2", Plugin: "unknown"},
+	})
+
+	s := NewStore(lg)
+	s.Reset(config.ProxyAuth{UserFile: file})
+	require.False(t, s.Enabled())
+	_, ok := s.Get("u1")
+	require.False(t, ok)
+
+	s.Reset(config.ProxyAuth{Enable: true, UserFile: file})
+	require.True(t, s.Enabled())
+	user, ok := s.Get("u1")
+	require.True(t, ok)
+	require.Equal(t, "svc", user.BackendUser)
+	require.Equal(t, "123", user.BackendPassword)
+	// Invalid users are ignored.
+	_, ok = s.Get("u2")
+	require.False(t, ok)
+
+	// The users are replaced after reloading.
+	writeUsers([]User{{Name: "u2", Plugin: mysql.AuthNativePassword}})
+	s.reload(context.Background())
+	_, ok = s.Get("u1")
+	require.False(t, ok)
+	_, ok = s.Get("u2")
+	require.True(t, ok)
+
+	// The previous users are kept if the file is broken.
+	require.NoError(t, os.WriteFile(file, []byte("{"), 0600))
+	s.reload(context.Background())
+	_, ok = s.Get("u2")
+	require.True(t, ok)
+	s.Close()
+}
+
+func TestLoadFromEtcd(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	server, err := etcd.CreateEtcdServer("0.0.0.0:0", t.TempDir(), lg)
+	require.NoError(t, err)
+	defer server.Close()
+	cfg := etcd.ConfigForEtcdTest(server.Clients[0].Addr().String())
+	certMgr := cert.NewCertManager()
+	require.NoError(t, certMgr.Init(cfg, lg, nil))
+	client, err := etcd.InitEtcdClient(lg, cfg, certMgr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	data, err := json.Marshal(User{Plugin: mysql.AuthNativePassword, AuthString: auth.EncodePassword("pwd"), BackendPassword: "123"})
+	require.NoError(t, err)
+	_, err = client.Put(context.Background(), EtcdPrefix+"u1", string(data))
+	require.NoError(t, err)
+
+	s := NewStore(lg)
+	s.Reset(config.ProxyAuth{Enable: true})
+	s.Start(context.Background(), client)
+	user, ok := s.Get("u1")
+	require.True(t, ok)
+	// The name is taken from the key.
+	require.Equal(t, "u1", user.BackendUser)
+	require.True(t, user.CheckPassword("pwd"))
+	s.Close()
+}
+
+func scrambleNative(salt []byte, password string) ([]byte, error) {
+	hash1 := auth.Sha1Hash([]byte(password))
+	hash2 := auth.Sha1Hash(append(append([]byte{}, salt...), auth.Sha1Hash(hash1)...))
+	for i := range hash2 {
+		hash2[i] ^= hash1[i]
+	}
+	return hash2, nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
+)
+
+func (h *Server) registerFirewall(group *gin.RouterGroup) {
+	group.GET("/allowlist", h.FirewallExport)
+	group.PUT("/allowlist", h.FirewallImport)
+}
+
+func (h *Server) FirewallExport(c *gin.Context) {
+	if h.mgr.Firewall == nil {
+		c.String(http.StatusInternalServerError, "firewall is not available")
+		return
+	}
+	c.JSON(http.StatusOK, h.mgr.Firewall.Export())
+}
+
+// FirewallImport merges the allowlists into the current ones. If `replace=true` is specified in the URL,
+// it replaces the current allowlists instead.
+func (h *Server) FirewallImport(c *gin.Context) {
+	if h.mgr.Firewall == nil {
+		c.String(http.StatusInternalServerError, "firewall is not available")
+		return
+	}
+	var lists firewall.Allowlists
+	if err := c.ShouldBindJSON(&lists); err != nil {
+		c.String(http.StatusBadRequest, "bad allowlist json: %s", err.Error())
+		return
+	}
+	replace := strings.EqualFold(c.Query("replace"), "true")
+	if err := h.mgr.Firewall.Import(lists, replace); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "allowlist imported")
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirewallAllowlist(t *testing.T) {
+	_, doHTTP := createServer(t)
+
+	checkExport := func(expected firewall.Allowlists) {
+		doHTTP(t, http.MethodGet, "/api/firewall/allowlist", httpOpts{}, func(t *testing.T, r *http.Response) {
+			require.Equal(t, http.StatusOK, r.StatusCode)
+			all, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			var lists firewall.Allowlists
+			require.NoError(t, json.Unmarshal(all, &lists))
+			require.Equal(t, expected, lists)
+		})
+	}
+	checkExport(firewall.Allowlists{})
+
+	doHTTP(t, http.MethodPut, "/api/firewall/allowlist", httpOpts{
+		reader: strings.NewReader(`{"u1":["d2","d1"]}`),
+		header: map[string]string{"Content-Type": "application/json"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	})
+	checkExport(firewall.Allowlists{"u1": {"d1", "d2"}})
+
+	doHTTP(t, http.MethodPut, "/api/firewall/allowlist", httpOpts{
+		reader: strings.NewReader(`{"u2":["d3"]}`),
+		header: map[string]string{"Content-Type": "application/json"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	})
+	checkExport(firewall.Allowlists{"u1": {"d1", "d2"}, "u2": {"d3"}})
+
+	doHTTP(t, http.MethodPut, "/api/firewall/allowlist?replace=true", httpOpts{
+		reader: strings.NewReader(`{"u2":["d4"]}`),
+		header: map[string]string{"Content-Type": "application/json"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	})
+	checkExport(firewall.Allowlists{"u2": {"d4"}})
+
+	doHTTP(t, http.MethodPut, "/api/firewall/allowlist", httpOpts{
+		reader: strings.NewReader(`{"u2":`),
+		header: map[string]string{"Content-Type": "application/json"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusBadRequest, r.StatusCode)
+	})
+}
@@ -20,11 +20,14 @@ import (
 var _ namespace.NamespaceManager = (*mockNamespaceManager)(nil)
 
 type mockNamespaceManager struct {
-	success atomic.Bool
+	success     atomic.Bool
+	maintenance map[string]*namespace.MaintenanceState
 }
 
 func newMockNamespaceManager() *mockNamespaceManager {
-	mgr := &mockNamespaceManager{}
+	mgr := &mockNamespaceManager{
+		maintenance: map[string]*namespace.MaintenanceState{},
+	}
 	mgr.success.Store(true)
 	return mgr
 }
@@ -42,6 +45,11 @@ func (m *mockNamespaceManager) GetNamespaceByUser(_ string) (*namespace.Namespac
 	return nil, false
 }
 
+func (m *mockNamespaceManager) Maintenance(nm string) (*namespace.MaintenanceState, bool) {
+	state, ok := m.maintenance[nm]
+	return state, ok
+}
+
 func (m *mockNamespaceManager) SetNamespace(_ context.Context, _ string, _ *config.Namespace) error {
 	if m.success.Load() {
 		return nil
@@ -0,0 +1,336 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/lib/util/waitgroup"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/proxy/userstore"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// mysqlAdminCapability is the capability of the admin port. Compression is not supported, and TLS is supported only
+// if security.server-tls is set.
+const mysqlAdminCapability = pnet.ClientLongPassword | pnet.ClientLongFlag | pnet.ClientConnectWithDB |
+	pnet.ClientProtocol41 | pnet.ClientTransactions | pnet.ClientSecureConnection | pnet.ClientMultiResults |
+	pnet.ClientPluginAuth | pnet.ClientConnectAttrs | pnet.ClientPluginAuthLenencClientData | pnet.ClientDeprecateEOF
+
+const (
+	// maxFailedLogins is the number of failed logins from one host before the host is blocked.
+	maxFailedLogins = 5
+	// failedLoginBlockTime is how long a host is blocked after too many failed logins.
+	failedLoginBlockTime = time.Minute
+	// erSecureTransportRequired is ER_SECURE_TRANSPORT_REQUIRED, which is not defined in go-mysql.
+	erSecureTransportRequired = 3159
+)
+
+// failedLogins counts the failed logins of a host.
+type failedLogins struct {
+	count        int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// mysqlAdmin serves the admin statements over the MySQL protocol so that DBAs can manage TiProxy with MySQL clients.
+// The statements are translated into requests to the HTTP handlers so that they behave the same as the HTTP API.
+// The clients must use TLS if security.server-tls is set, and the hosts with too many failed logins are blocked
+// for a while to stop guessing passwords.
+type mysqlAdmin struct {
+	listener  net.Listener
+	handler   http.Handler
+	user      userstore.User
+	tlsConfig func() *tls.Config
+	blockTime time.Duration
+	lg        *zap.Logger
+	wg        waitgroup.WaitGroup
+	connID    atomic.Uint64
+	mu        struct {
+		sync.Mutex
+		conns        map[uint64]net.Conn
+		failedLogins map[string]*failedLogins
+		closed       bool
+	}
+}
+
+func newMySQLAdmin(cfg config.API, lg *zap.Logger, handler http.Handler, tlsConfig func() *tls.Config) (*mysqlAdmin, error) {
+	admin := &mysqlAdmin{
+		handler: handler,
+		user: userstore.User{
+			Name:       cfg.MySQLUser,
+			Plugin:     pnet.AuthNativePassword,
+			AuthString: cfg.MySQLAuthString,
+		},
+		tlsConfig: tlsConfig,
+		blockTime: failedLoginBlockTime,
+		lg:        lg,
+	}
+	admin.mu.conns = make(map[uint64]net.Conn)
+	admin.mu.failedLogins = make(map[string]*failedLogins)
+	var err error
+	if admin.listener, err = net.Listen("tcp", cfg.MySQLAddr); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	admin.wg.RunWithRecover(admin.run, nil, lg)
+	return admin, nil
+}
+
+func (admin *mysqlAdmin) run() {
+	for {
+		conn, err := admin.listener.Accept()
+		if err != nil {
+			admin.lg.Info("MySQL admin port closed", zap.Error(err))
+			return
+		}
+		connID := admin.connID.Add(1)
+		admin.mu.Lock()
+		if admin.mu.closed {
+			admin.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		admin.mu.conns[connID] = conn
+		admin.mu.Unlock()
+		admin.wg.RunWithRecover(func() {
+			defer func() {
+				admin.mu.Lock()
+				delete(admin.mu.conns, connID)
+				admin.mu.Unlock()
+				_ = conn.Close()
+			}()
+			admin.handleConn(conn, connID)
+		}, nil, admin.lg)
+	}
+}
+
+func (admin *mysqlAdmin) handleConn(conn net.Conn, connID uint64) {
+	pktIO := pnet.NewPacketIO(conn, admin.lg, pnet.DefaultConnBufferSize)
+	capability, err := admin.handshake(pktIO, connID)
+	if err != nil {
+		admin.lg.Debug("MySQL admin handshake failed", zap.Uint64("connID", connID), zap.Stringer("addr", conn.RemoteAddr()), zap.Error(err))
+		return
+	}
+	for {
+		pktIO.ResetSequence()
+		data, err := pktIO.ReadPacket()
+		if err != nil || len(data) == 0 {
+			return
+		}
+		var packets [][]byte
+		switch pnet.Command(data[0]) {
+		case pnet.ComQuit:
+			return
+		case pnet.ComPing, pnet.ComInitDB:
+			packets = [][]byte{pnet.MakeOKPacket(gomysql.SERVER_STATUS_AUTOCOMMIT, pnet.OKHeader)}
+		case pnet.ComQuery:
+			packets = admin.executeQuery(conn, string(data[1:]), capability)
+		default:
+			myErr := gomysql.NewDefaultError(gomysql.ER_UNKNOWN_COM_ERROR)
+			packets = [][]byte{pnet.MakeErrPacket(myErr)}
+		}
+		for i, pkt := range packets {
+			if err := pktIO.WritePacket(pkt, i == len(packets)-1); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handshake authenticates the client with mysql_native_password and returns the negotiated capability.
+func (admin *mysqlAdmin) handshake(pktIO pnet.PacketIO, connID uint64) (pnet.Capability, error) {
+	host := ""
+	if addr := pktIO.RemoteAddr(); addr != nil {
+		host, _, _ = net.SplitHostPort(addr.String())
+	}
+	// Reject the blocked host before the handshake, which is the same as MySQL.
+	if admin.isBlocked(host) {
+		myErr := gomysql.NewDefaultError(gomysql.ER_HOST_IS_BLOCKED, host)
+		if err := pktIO.WritePacket(pnet.MakeErrPacket(myErr), true); err != nil {
+			return 0, err
+		}
+		return 0, myErr
+	}
+	var salt [20]byte
+	if err := pnet.GenerateSalt(&salt); err != nil {
+		return 0, err
+	}
+	capability := mysqlAdminCapability
+	var tlsConfig *tls.Config
+	if admin.tlsConfig != nil {
+		tlsConfig = admin.tlsConfig()
+	}
+	if tlsConfig != nil {
+		capability |= pnet.ClientSSL
+	}
+	if err := pktIO.WritePacket(pnet.MakeInitialHandshake(capability, salt, pnet.AuthNativePassword, pnet.ServerVersion, connID), true); err != nil {
+		return 0, err
+	}
+	data, err := pktIO.ReadPacket()
+	if err != nil {
+		return 0, err
+	}
+	if pnet.ParseSSLRequestOrHandshakeResp(data) && tlsConfig != nil {
+		if _, err = pktIO.ServerTLSHandshake(tlsConfig); err != nil {
+			return 0, err
+		}
+		if data, err = pktIO.ReadPacket(); err != nil {
+			return 0, err
+		}
+	} else if tlsConfig != nil {
+		myErr := gomysql.NewError(erSecureTransportRequired, "Connections using insecure transport are prohibited by TiProxy")
+		if err := pktIO.WritePacket(pnet.MakeErrPacket(myErr), true); err != nil {
+			return 0, err
+		}
+		return 0, myErr
+	}
+	resp, err := pnet.ParseHandshakeResponse(data)
+	if err != nil {
+		return 0, err
+	}
+	authData := resp.AuthData
+	if resp.Capability&pnet.ClientPluginAuth > 0 && resp.AuthPlugin != pnet.AuthNativePassword {
+		if err = pktIO.WritePacket(pnet.MakeSwitchRequest(pnet.AuthNativePassword, salt), true); err != nil {
+			return 0, err
+		}
+		if authData, err = pktIO.ReadPacket(); err != nil {
+			return 0, err
+		}
+	}
+	if resp.User != admin.user.Name || !admin.user.CheckScramble(salt[:], authData) {
+		admin.recordFailedLogin(host)
+		usingPassword := "NO"
+		if len(authData) > 0 {
+			usingPassword = "YES"
+		}
+		myErr := gomysql.NewDefaultError(gomysql.ER_ACCESS_DENIED_ERROR, resp.User, host, usingPassword)
+		if err := pktIO.WritePacket(pnet.MakeErrPacket(myErr), true); err != nil {
+			return 0, err
+		}
+		return 0, myErr
+	}
+	admin.mu.Lock()
+	delete(admin.mu.failedLogins, host)
+	admin.mu.Unlock()
+	if err := pktIO.WritePacket(pnet.MakeOKPacket(gomysql.SERVER_STATUS_AUTOCOMMIT, pnet.OKHeader), true); err != nil {
+		return 0, err
+	}
+	return resp.Capability & mysqlAdminCapability, nil
+}
+
+func (admin *mysqlAdmin) isBlocked(host string) bool {
+	admin.mu.Lock()
+	defer admin.mu.Unlock()
+	fl, ok := admin.mu.failedLogins[host]
+	return ok && time.Now().Before(fl.blockedUntil)
+}
+
+// recordFailedLogin blocks the host once it fails to log in too many times in a row.
+func (admin *mysqlAdmin) recordFailedLogin(host string) {
+	now := time.Now()
+	admin.mu.Lock()
+	defer admin.mu.Unlock()
+	// Forget the hosts that haven't failed for a while so that the map doesn't grow forever.
+	for h, fl := range admin.mu.failedLogins {
+		if now.Sub(fl.lastFailure) > admin.blockTime && now.After(fl.blockedUntil) {
+			delete(admin.mu.failedLogins, h)
+		}
+	}
+	fl, ok := admin.mu.failedLogins[host]
+	if !ok {
+		fl = &failedLogins{}
+		admin.mu.failedLogins[host] = fl
+	}
+	fl.count++
+	fl.lastFailure = now
+	if fl.count >= maxFailedLogins {
+		fl.count = 0
+		fl.blockedUntil = now.Add(admin.blockTime)
+		admin.lg.Warn("MySQL admin port blocks the host because of too many failed logins", zap.String("host", host),
+			zap.Duration("duration", admin.blockTime))
+	}
+}
+
+// executeQuery runs the admin statement and returns the response packets.
+func (admin *mysqlAdmin) executeQuery(conn net.Conn, sql string, capability pnet.Capability) [][]byte {
+	stmt, err := parseAdminStmt(sql)
+	if err != nil {
+		return [][]byte{pnet.MakeUserError(err)}
+	}
+	if stmt == nil {
+		return [][]byte{pnet.MakeOKPacket(gomysql.SERVER_STATUS_AUTOCOMMIT, pnet.OKHeader)}
+	}
+
+	var body *bytes.Reader
+	contentType := ""
+	switch {
+	case len(stmt.body) > 0:
+		body = bytes.NewReader(stmt.body)
+	case len(stmt.form) > 0 && stmt.method != http.MethodGet:
+		body = bytes.NewReader([]byte(stmt.form.Encode()))
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		body = bytes.NewReader(nil)
+	}
+	path := stmt.path
+	if len(stmt.form) > 0 && stmt.method == http.MethodGet {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + stmt.form.Encode()
+	}
+	req, err := http.NewRequestWithContext(context.Background(), stmt.method, path, body)
+	if err != nil {
+		return [][]byte{pnet.MakeUserError(errors.WithStack(err))}
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.RemoteAddr = conn.RemoteAddr().String()
+	recorder := httptest.NewRecorder()
+	admin.handler.ServeHTTP(recorder, req)
+
+	if recorder.Code < http.StatusOK || recorder.Code >= http.StatusMultipleChoices {
+		return [][]byte{pnet.MakeUserError(responseToError(recorder.Code, recorder.Body.Bytes()))}
+	}
+	if !stmt.query {
+		return [][]byte{pnet.MakeOKPacket(gomysql.SERVER_STATUS_AUTOCOMMIT, pnet.OKHeader)}
+	}
+	names, rows := responseToResultSet(recorder.Body.Bytes())
+	packets, err := pnet.MakeTextResultSet(names, rows, gomysql.SERVER_STATUS_AUTOCOMMIT, capability)
+	if err != nil {
+		return [][]byte{pnet.MakeUserError(err)}
+	}
+	return packets
+}
+
+func (admin *mysqlAdmin) Addr() net.Addr {
+	return admin.listener.Addr()
+}
+
+// Close closes the listener and all the admin connections.
+func (admin *mysqlAdmin) Close() error {
+	err := admin.listener.Close()
+	admin.mu.Lock()
+	admin.mu.closed = true
+	for _, conn := range admin.mu.conns {
+		_ = conn.Close()
+	}
+	admin.mu.Unlock()
+	admin.wg.Wait()
+	return errors.WithStack(err)
+}
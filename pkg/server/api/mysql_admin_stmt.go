@@ -0,0 +1,410 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+)
+
+var (
+	errUnsupportedAdminStmt = errors.New("unsupported admin statement")
+	errAdminSyntax          = errors.New("syntax error in admin statement")
+)
+
+// adminStmt is an admin statement translated into a request to the HTTP handlers.
+type adminStmt struct {
+	method string
+	path   string
+	form   url.Values
+	body   []byte
+	// query means the response is returned as a result set. Otherwise, an OK packet is returned.
+	query bool
+}
+
+type adminToken struct {
+	text   string
+	quoted bool
+}
+
+type adminAssignment struct {
+	key   string
+	value adminToken
+}
+
+// parseAdminStmt parses the statements supported by the MySQL admin port:
+//
+//	SHOW TIPROXY {BACKENDS | NAMESPACES | CONFIG | PROCESSLIST}
+//	SET TIPROXY CONFIG key = value [, key = value]...
+//	TIPROXY TRAFFIC {CAPTURE | REPLAY | SPEED | CANCEL | SHOW} [key = value [, key = value]...]
+//	TIPROXY REDIRECT
+//
+// Other SET statements return nil so that the session variables set by drivers are ignored.
+func parseAdminStmt(sql string) (*adminStmt, error) {
+	tokens, err := tokenizeAdminStmt(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &adminParser{tokens: tokens}
+	switch {
+	case p.acceptKeyword("SHOW"):
+		if !p.acceptKeyword("TIPROXY") {
+			return nil, errUnsupportedAdminStmt
+		}
+		var stmt *adminStmt
+		switch {
+		case p.acceptKeyword("BACKENDS"):
+			stmt = &adminStmt{method: http.MethodGet, path: "/api/backend/metrics", query: true}
+		case p.acceptKeyword("NAMESPACES"):
+			stmt = &adminStmt{method: http.MethodGet, path: "/api/admin/namespace/", query: true}
+		case p.acceptKeyword("CONFIG"):
+			stmt = &adminStmt{method: http.MethodGet, path: "/api/admin/config/?format=json", query: true}
+		case p.acceptKeyword("PROCESSLIST"):
+			stmt = &adminStmt{method: http.MethodGet, path: "/api/processlist", query: true}
+		default:
+			return nil, errUnsupportedAdminStmt
+		}
+		return stmt, p.expectEnd()
+	case p.acceptKeyword("SET"):
+		if !p.acceptKeyword("TIPROXY") {
+			return nil, nil
+		}
+		if !p.acceptKeyword("CONFIG") {
+			return nil, errUnsupportedAdminStmt
+		}
+		assignments, err := p.parseAssignments()
+		if err != nil {
+			return nil, err
+		}
+		if len(assignments) == 0 {
+			return nil, errors.Wrapf(errAdminSyntax, "no config item to set")
+		}
+		body, err := assignmentsToTOML(assignments)
+		if err != nil {
+			return nil, err
+		}
+		return &adminStmt{method: http.MethodPut, path: "/api/admin/config/", body: body}, nil
+	case p.acceptKeyword("TIPROXY"):
+		switch {
+		case p.acceptKeyword("TRAFFIC"):
+			var stmt *adminStmt
+			switch {
+			case p.acceptKeyword("CAPTURE"):
+				stmt = &adminStmt{method: http.MethodPost, path: "/api/traffic/capture"}
+			case p.acceptKeyword("REPLAY"):
+				stmt = &adminStmt{method: http.MethodPost, path: "/api/traffic/replay"}
+			case p.acceptKeyword("SPEED"):
+				stmt = &adminStmt{method: http.MethodPost, path: "/api/traffic/replay/speed"}
+			case p.acceptKeyword("CANCEL"):
+				stmt = &adminStmt{method: http.MethodPost, path: "/api/traffic/cancel"}
+			case p.acceptKeyword("SHOW"):
+				stmt = &adminStmt{method: http.MethodGet, path: "/api/traffic/show", query: true}
+			default:
+				return nil, errUnsupportedAdminStmt
+			}
+			assignments, err := p.parseAssignments()
+			if err != nil {
+				return nil, err
+			}
+			stmt.form = make(url.Values, len(assignments))
+			for _, a := range assignments {
+				stmt.form.Set(a.key, a.value.text)
+			}
+			return stmt, nil
+		case p.acceptKeyword("REDIRECT"):
+			return &adminStmt{method: http.MethodPost, path: "/api/debug/redirect"}, p.expectEnd()
+		}
+	}
+	return nil, errUnsupportedAdminStmt
+}
+
+// tokenizeAdminStmt splits the statement into words, quoted strings, `=` and `,`.
+// Everything after `;` is ignored.
+func tokenizeAdminStmt(sql string) ([]adminToken, error) {
+	var tokens []adminToken
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == ';':
+			return tokens, nil
+		case c == '=' || c == ',':
+			tokens = append(tokens, adminToken{text: string(c)})
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(sql); j++ {
+				if sql[j] == '\\' && j+1 < len(sql) {
+					j++
+					sb.WriteByte(sql[j])
+					continue
+				}
+				if sql[j] == c {
+					// A doubled quote is an escaped quote.
+					if j+1 < len(sql) && sql[j+1] == c {
+						j++
+						sb.WriteByte(c)
+						continue
+					}
+					break
+				}
+				sb.WriteByte(sql[j])
+			}
+			if j >= len(sql) {
+				return nil, errors.Wrapf(errAdminSyntax, "unterminated quoted string")
+			}
+			tokens = append(tokens, adminToken{text: sb.String(), quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(sql) && !strings.ContainsRune(" \t\n\r;=,'\"`", rune(sql[j])); j++ {
+			}
+			tokens = append(tokens, adminToken{text: sql[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type adminParser struct {
+	tokens []adminToken
+	pos    int
+}
+
+func (p *adminParser) acceptKeyword(keyword string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *adminParser) expectEnd() error {
+	if p.pos < len(p.tokens) {
+		return errors.Wrapf(errAdminSyntax, "unexpected '%s'", p.tokens[p.pos].text)
+	}
+	return nil
+}
+
+// parseAssignments parses `key = value [,] key = value ...` until the end of the statement.
+func (p *adminParser) parseAssignments() ([]adminAssignment, error) {
+	var assignments []adminAssignment
+	for p.pos < len(p.tokens) {
+		if len(assignments) > 0 && p.tokens[p.pos].text == "," && !p.tokens[p.pos].quoted {
+			p.pos++
+		}
+		if p.pos+3 > len(p.tokens) {
+			return nil, errors.Wrapf(errAdminSyntax, "incomplete assignment")
+		}
+		key, eq, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+		if key.quoted || !isAdminWord(key.text) || eq.quoted || eq.text != "=" || (!value.quoted && !isAdminWord(value.text)) {
+			return nil, errors.Wrapf(errAdminSyntax, "invalid assignment near '%s'", key.text)
+		}
+		assignments = append(assignments, adminAssignment{key: key.text, value: value})
+		p.pos += 3
+	}
+	return assignments, nil
+}
+
+func isAdminWord(s string) bool {
+	return len(s) > 0 && s != "=" && s != ","
+}
+
+// assignmentsToTOML converts `a.b.c = value` to the TOML that is accepted by the config API.
+// Unquoted values are parsed as booleans or numbers if possible.
+func assignmentsToTOML(assignments []adminAssignment) ([]byte, error) {
+	root := make(map[string]any)
+	for _, a := range assignments {
+		parts := strings.Split(a.key, ".")
+		table := root
+		for i, part := range parts {
+			if !isTOMLBareKey(part) {
+				return nil, errors.Wrapf(errAdminSyntax, "invalid config name '%s'", a.key)
+			}
+			if i == len(parts)-1 {
+				if _, ok := table[part]; ok {
+					return nil, errors.Wrapf(errAdminSyntax, "duplicate config name '%s'", a.key)
+				}
+				table[part] = parseConfigValue(a.value)
+				break
+			}
+			child, ok := table[part]
+			if !ok {
+				child = make(map[string]any)
+				table[part] = child
+			}
+			if table, ok = child.(map[string]any); !ok {
+				return nil, errors.Wrapf(errAdminSyntax, "duplicate config name '%s'", a.key)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(root); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func isTOMLBareKey(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func parseConfigValue(token adminToken) any {
+	if token.quoted {
+		return token.text
+	}
+	switch strings.ToLower(token.text) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if i, err := strconv.ParseInt(token.text, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(token.text, 64); err == nil {
+		return f
+	}
+	return token.text
+}
+
+// responseToResultSet converts the response of a HTTP handler to the column names and the rows of a result set.
+//   - An array of objects is returned as one row per object and one column per key.
+//   - An object is flattened into Name and Value, where Name is the dotted path of the field.
+//   - Others are returned as one column named Result.
+//
+// All the values are strings so that the types of each column are consistent.
+func responseToResultSet(body []byte) ([]string, [][]any) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return []string{"Result"}, nil
+	}
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil || decoder.More() {
+		return []string{"Result"}, [][]any{{string(body)}}
+	}
+	switch v := v.(type) {
+	case []any:
+		if objs, ok := objectsOf(v); ok {
+			return objectsToRows(objs)
+		}
+	case map[string]any:
+		rows := make([][]any, 0, len(v))
+		flattenObject("", v, &rows)
+		return []string{"Name", "Value"}, rows
+	case nil:
+		return []string{"Result"}, nil
+	}
+	return []string{"Result"}, [][]any{{formatJSONValue(v)}}
+}
+
+func objectsOf(values []any) ([]map[string]any, bool) {
+	objs := make([]map[string]any, 0, len(values))
+	for _, value := range values {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		objs = append(objs, obj)
+	}
+	return objs, true
+}
+
+func objectsToRows(objs []map[string]any) ([]string, [][]any) {
+	keySet := make(map[string]struct{})
+	for _, obj := range objs {
+		for key := range obj {
+			keySet[key] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(keySet))
+	for key := range keySet {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		names = append(names, "Result")
+	}
+	rows := make([][]any, 0, len(objs))
+	for _, obj := range objs {
+		row := make([]any, 0, len(names))
+		for _, name := range names {
+			row = append(row, formatJSONValue(obj[name]))
+		}
+		rows = append(rows, row)
+	}
+	return names, rows
+}
+
+func flattenObject(prefix string, obj map[string]any, rows *[][]any) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := key
+		if len(prefix) > 0 {
+			name = prefix + "." + key
+		}
+		if child, ok := obj[key].(map[string]any); ok && len(child) > 0 {
+			flattenObject(name, child, rows)
+			continue
+		}
+		*rows = append(*rows, []any{name, formatJSONValue(obj[key])})
+	}
+}
+
+// formatJSONValue returns nil for NULL and a string otherwise.
+func formatJSONValue(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return string(b)
+	}
+}
+
+// responseToError extracts the error message from a failed response of the HTTP handlers.
+func responseToError(status int, body []byte) error {
+	body = bytes.TrimSpace(body)
+	var msg string
+	if err := json.Unmarshal(body, &msg); err != nil {
+		msg = string(body)
+	}
+	if len(msg) == 0 {
+		msg = http.StatusText(status)
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/logger"
+	"github.com/pingcap/tiproxy/lib/util/security"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAdminStmt(t *testing.T) {
+	tests := []struct {
+		sql    string
+		method string
+		path   string
+		form   map[string]string
+		body   string
+		query  bool
+		err    error
+	}{
+		{sql: "show tiproxy backends", method: http.MethodGet, path: "/api/backend/metrics", query: true},
+		{sql: "SHOW TIPROXY NAMESPACES;", method: http.MethodGet, path: "/api/admin/namespace/", query: true},
+		{sql: "SHOW TIPROXY CONFIG", method: http.MethodGet, path: "/api/admin/config/?format=json", query: true},
+		{sql: "SHOW TIPROXY PROCESSLIST", method: http.MethodGet, path: "/api/processlist", query: true},
+		{sql: "SHOW TIPROXY CONFIG abc", err: errAdminSyntax},
+		{sql: "SHOW TABLES", err: errUnsupportedAdminStmt},
+		{sql: "SET NAMES utf8mb4"},
+		{sql: "SET TIPROXY CONFIG proxy.max-connections = 100, log.level = 'warn'", method: http.MethodPut, path: "/api/admin/config/",
+			body: "[log]\n  level = \"warn\"\n\n[proxy]\n  max-connections = 100\n"},
+		{sql: "SET TIPROXY CONFIG proxy.graceful-close-conn-timeout=10 proxy.require-backend-tls=true", method: http.MethodPut, path: "/api/admin/config/",
+			body: "[proxy]\n  graceful-close-conn-timeout = 10\n  require-backend-tls = true\n"},
+		{sql: "SET TIPROXY CONFIG", err: errAdminSyntax},
+		{sql: "SET TIPROXY CONFIG proxy.addr = ", err: errAdminSyntax},
+		{sql: "SET TIPROXY CONFIG proxy.addr = '1", err: errAdminSyntax},
+		{sql: "SET TIPROXY CONFIG `proxy]\na` = 1", err: errAdminSyntax},
+		{sql: "SET TIPROXY CONFIG proxy = 1, proxy.addr = 2", err: errAdminSyntax},
+		{sql: "TIPROXY TRAFFIC CAPTURE output='/tmp/traffic', duration = \"1h\"", method: http.MethodPost, path: "/api/traffic/capture",
+			form: map[string]string{"output": "/tmp/traffic", "duration": "1h"}},
+		{sql: "tiproxy traffic replay input='/tmp/traffic' username=u1 password='it''s'", method: http.MethodPost, path: "/api/traffic/replay",
+			form: map[string]string{"input": "/tmp/traffic", "username": "u1", "password": "it's"}},
+		{sql: "TIPROXY TRAFFIC SPEED speed = 2.5", method: http.MethodPost, path: "/api/traffic/replay/speed", form: map[string]string{"speed": "2.5"}},
+		{sql: "TIPROXY TRAFFIC CANCEL", method: http.MethodPost, path: "/api/traffic/cancel", form: map[string]string{}},
+		{sql: "TIPROXY TRAFFIC SHOW", method: http.MethodGet, path: "/api/traffic/show", form: map[string]string{}, query: true},
+		{sql: "TIPROXY TRAFFIC STOP", err: errUnsupportedAdminStmt},
+		{sql: "TIPROXY REDIRECT", method: http.MethodPost, path: "/api/debug/redirect"},
+	}
+	for i, test := range tests {
+		msg := fmt.Sprintf("case %d: %s", i, test.sql)
+		stmt, err := parseAdminStmt(test.sql)
+		if test.err != nil {
+			require.ErrorIs(t, err, test.err, msg)
+			continue
+		}
+		require.NoError(t, err, msg)
+		if len(test.method) == 0 {
+			require.Nil(t, stmt, msg)
+			continue
+		}
+		require.Equal(t, test.method, stmt.method, msg)
+		require.Equal(t, test.path, stmt.path, msg)
+		require.Equal(t, test.query, stmt.query, msg)
+		require.Equal(t, test.body, string(stmt.body), msg)
+		if test.form != nil {
+			require.Len(t, stmt.form, len(test.form), msg)
+			for key, value := range test.form {
+				require.Equal(t, value, stmt.form.Get(key), msg)
+			}
+		}
+	}
+}
+
+func TestResponseToResultSet(t *testing.T) {
+	tests := []struct {
+		body  string
+		names []string
+		rows  [][]any
+	}{
+		{body: "", names: []string{"Result"}},
+		{body: "null", names: []string{"Result"}},
+		{body: "plain text", names: []string{"Result"}, rows: [][]any{{"plain text"}}},
+		{body: `"done"`, names: []string{"Result"}, rows: [][]any{{"done"}}},
+		{body: `{"b":{"c":1,"d":[1,2]},"a":true,"e":null,"f":{}}`, names: []string{"Name", "Value"},
+			rows: [][]any{{"a", "true"}, {"b.c", "1"}, {"b.d", "[1,2]"}, {"e", nil}, {"f", "{}"}}},
+		{body: `[{"namespace":"ns1","frontend":{"user":"u1"}},{"namespace":"ns2","backend":{}}]`, names: []string{"backend", "frontend", "namespace"},
+			rows: [][]any{{nil, `{"user":"u1"}`, "ns1"}, {"{}", nil, "ns2"}}},
+		{body: `[1,2]`, names: []string{"Result"}, rows: [][]any{{"[1,2]"}}},
+	}
+	for i, test := range tests {
+		names, rows := responseToResultSet([]byte(test.body))
+		require.Equal(t, test.names, names, "case %d", i)
+		require.Equal(t, len(test.rows), len(rows), "case %d", i)
+		for j := range test.rows {
+			require.Equal(t, test.rows[j], rows[j], "case %d", i)
+		}
+	}
+}
+
+func TestMySQLAdmin(t *testing.T) {
+	srv, _ := createServer(t)
+	addr := srv.admin.Addr().String()
+
+	// Wrong password.
+	db, err := sql.Open("mysql", fmt.Sprintf("admin:wrong@tcp(%s)/", addr))
+	require.NoError(t, err)
+	require.ErrorContains(t, db.Ping(), "Access denied")
+	require.NoError(t, db.Close())
+
+	db, err = sql.Open("mysql", fmt.Sprintf("admin:123456@tcp(%s)/", addr))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	require.NoError(t, db.Ping())
+
+	queryRows := func(query string) ([]string, [][]sql.NullString) {
+		rs, err := db.Query(query)
+		require.NoError(t, err, query)
+		defer func() {
+			require.NoError(t, rs.Close())
+		}()
+		columns, err := rs.Columns()
+		require.NoError(t, err)
+		var rows [][]sql.NullString
+		for rs.Next() {
+			row := make([]sql.NullString, len(columns))
+			dest := make([]any, len(columns))
+			for i := range row {
+				dest[i] = &row[i]
+			}
+			require.NoError(t, rs.Scan(dest...))
+			rows = append(rows, row)
+		}
+		require.NoError(t, rs.Err())
+		return columns, rows
+	}
+	findConfig := func(name string) string {
+		_, rows := queryRows("SHOW TIPROXY CONFIG")
+		for _, row := range rows {
+			if row[0].String == name {
+				return row[1].String
+			}
+		}
+		return ""
+	}
+
+	// SET TIPROXY CONFIG goes through the config API and SHOW TIPROXY CONFIG reads it back.
+	_, err = db.Exec("SET TIPROXY CONFIG proxy.max-connections = 100, log.level = 'warn'")
+	require.NoError(t, err)
+	require.EqualValues(t, 100, srv.mgr.CfgMgr.GetConfig().Proxy.MaxConnections)
+	require.Equal(t, "warn", srv.mgr.CfgMgr.GetConfig().Log.Level)
+	require.Equal(t, "100", findConfig("proxy.max-connections"))
+	require.Equal(t, "warn", findConfig("log.level"))
+
+	// The errors of the handlers are returned as MySQL errors.
+	_, err = db.Exec("SET TIPROXY CONFIG proxy.max-connections = 'abc'")
+	require.Error(t, err)
+	_, err = db.Exec("SHOW DATABASES")
+	require.ErrorContains(t, err, errUnsupportedAdminStmt.Error())
+
+	columns, _ := queryRows("SHOW TIPROXY NAMESPACES")
+	require.NotEmpty(t, columns)
+	columns, _ = queryRows("SHOW TIPROXY BACKENDS")
+	require.NotEmpty(t, columns)
+	columns, _ = queryRows("SHOW TIPROXY PROCESSLIST")
+	require.NotEmpty(t, columns)
+	columns, _ = queryRows("TIPROXY TRAFFIC SHOW")
+	require.NotEmpty(t, columns)
+
+	_, err = db.Exec("TIPROXY TRAFFIC CANCEL")
+	require.NoError(t, err)
+	_, err = db.Exec("TIPROXY REDIRECT")
+	require.NoError(t, err)
+}
+
+func TestMySQLAdminBlockHost(t *testing.T) {
+	srv, _ := createServer(t)
+	srv.admin.blockTime = 300 * time.Millisecond
+	addr := srv.admin.Addr().String()
+
+	ping := func(password string) error {
+		db, err := sql.Open("mysql", fmt.Sprintf("admin:%s@tcp(%s)/", password, addr))
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, db.Close())
+		}()
+		return db.Ping()
+	}
+	// A successful login resets the count.
+	for i := 0; i < maxFailedLogins-1; i++ {
+		require.ErrorContains(t, ping("wrong"), "Access denied")
+	}
+	require.NoError(t, ping("123456"))
+	for i := 0; i < maxFailedLogins; i++ {
+		require.ErrorContains(t, ping("wrong"), "Access denied")
+	}
+	// The host is blocked even if the password is correct.
+	require.ErrorContains(t, ping("123456"), "blocked")
+	require.Eventually(t, func() bool {
+		return ping("123456") == nil
+	}, 3*time.Second, 100*time.Millisecond)
+}
+
+func TestMySQLAdminTLS(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	serverTLS, clientTLS, err := security.CreateTLSConfigForTest()
+	require.NoError(t, err)
+	admin, err := newMySQLAdmin(config.API{
+		MySQLAddr:       "127.0.0.1:0",
+		MySQLUser:       "admin",
+		MySQLAuthString: "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9",
+	}, lg, http.NotFoundHandler(), func() *tls.Config {
+		return serverTLS
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, admin.Close())
+	})
+	require.NoError(t, mysql.RegisterTLSConfig("mysql-admin", clientTLS))
+	t.Cleanup(func() {
+		mysql.DeregisterTLSConfig("mysql-admin")
+	})
+
+	// Plaintext connections are rejected once TLS is enabled.
+	db, err := sql.Open("mysql", fmt.Sprintf("admin:123456@tcp(%s)/", admin.Addr().String()))
+	require.NoError(t, err)
+	require.ErrorContains(t, db.Ping(), "insecure transport")
+	require.NoError(t, db.Close())
+
+	db, err = sql.Open("mysql", fmt.Sprintf("admin:123456@tcp(%s)/?tls=mysql-admin", admin.Addr().String()))
+	require.NoError(t, err)
+	require.NoError(t, db.Ping())
+	require.NoError(t, db.Close())
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/pingcap/tiproxy/lib/config"
 	"github.com/pingcap/tiproxy/lib/util/errors"
+	"go.uber.org/zap"
 )
 
 func (h *Server) NamespaceGet(c *gin.Context) {
@@ -28,7 +29,7 @@ func (h *Server) NamespaceGet(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, nsc)
+	c.JSON(http.StatusOK, nsc.Redact())
 }
 
 func (h *Server) NamespaceUpsert(c *gin.Context) {
@@ -41,6 +42,17 @@ func (h *Server) NamespaceUpsert(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, "bad namespace json")
 		return
 	}
+	if err := nsc.Check(); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	// The namespace may be read from NamespaceGet, so keep the password if it's redacted.
+	if nsc.Backend.AdminPassword == config.RedactedPassword {
+		nsc.Backend.AdminPassword = ""
+		if prev, err := h.mgr.CfgMgr.GetNamespace(c, nsc.Namespace); err == nil {
+			nsc.Backend.AdminPassword = prev.Backend.AdminPassword
+		}
+	}
 
 	if err := h.mgr.CfgMgr.SetNamespace(c, nsc.Namespace, nsc); err != nil {
 		c.Errors = append(c.Errors, &gin.Error{
@@ -130,16 +142,58 @@ func (h *Server) NamespaceList(c *gin.Context) {
 		return
 	}
 	if nscs != nil {
-		c.JSON(http.StatusOK, nscs)
+		redacted := make([]*config.Namespace, 0, len(nscs))
+		for _, nsc := range nscs {
+			redacted = append(redacted, nsc.Redact())
+		}
+		c.JSON(http.StatusOK, redacted)
 	} else {
 		c.JSON(http.StatusOK, "")
 	}
 }
 
+func (h *Server) NamespaceMaintenanceGet(c *gin.Context) {
+	ns := c.Param("namespace")
+	state, ok := h.mgr.NsMgr.Maintenance(ns)
+	if !ok {
+		c.JSON(http.StatusNotFound, "namespace not found")
+		return
+	}
+	cfg, _ := state.Get()
+	if cfg == nil {
+		cfg = &config.Maintenance{}
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+// NamespaceMaintenanceSet puts the namespace into the maintenance mode. An empty mode ends the maintenance.
+func (h *Server) NamespaceMaintenanceSet(c *gin.Context) {
+	ns := c.Param("namespace")
+	cfg := &config.Maintenance{}
+	if err := c.ShouldBindJSON(cfg); err != nil {
+		c.JSON(http.StatusBadRequest, "bad maintenance json")
+		return
+	}
+	if err := cfg.Check(); err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	state, ok := h.mgr.NsMgr.Maintenance(ns)
+	if !ok {
+		c.JSON(http.StatusNotFound, "namespace not found")
+		return
+	}
+	state.Set(cfg)
+	h.lg.Info("update namespace maintenance", zap.String("namespace", ns), zap.Any("maintenance", cfg))
+	c.JSON(http.StatusOK, "")
+}
+
 func (h *Server) registerNamespace(group *gin.RouterGroup) {
 	group.GET("/", h.NamespaceList)
 	group.POST("/commit", h.NamespaceCommit)
 	group.GET("/:namespace", h.NamespaceGet)
+	group.GET("/:namespace/maintenance", h.NamespaceMaintenanceGet)
+	group.PUT("/:namespace/maintenance", h.NamespaceMaintenanceSet)
 	group.PUT("/:namespace", h.NamespaceUpsert)
 	group.PUT("/", h.NamespaceUpsert)
 	group.DELETE("/:namespace", h.NamespaceRemove)
@@ -4,11 +4,15 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
 
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/pkg/manager/namespace"
 	"github.com/stretchr/testify/require"
 )
 
@@ -56,3 +60,82 @@ func TestNamespace(t *testing.T) {
 		require.Equal(t, http.StatusOK, r.StatusCode)
 	})
 }
+
+func TestNamespaceRedactPassword(t *testing.T) {
+	srv, doHTTP := createServer(t)
+	doHTTP(t, http.MethodPut, "/api/admin/namespace/ns", httpOpts{reader: strings.NewReader(`{"backend":{"admin-user":"admin","admin-password":"123456"}}`)}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	})
+	var redacted []byte
+	doHTTP(t, http.MethodGet, "/api/admin/namespace/ns", httpOpts{}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		var err error
+		redacted, err = io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NotContains(t, string(redacted), "123456")
+		require.Contains(t, string(redacted), config.RedactedPassword)
+	})
+	doHTTP(t, http.MethodGet, "/api/admin/namespace", httpOpts{}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		all, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NotContains(t, string(all), "123456")
+	})
+	// Writing back the redacted namespace keeps the password.
+	doHTTP(t, http.MethodPut, "/api/admin/namespace/ns", httpOpts{reader: strings.NewReader(string(redacted))}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	})
+	nsc, err := srv.mgr.CfgMgr.GetNamespace(context.Background(), "ns")
+	require.NoError(t, err)
+	require.Equal(t, "123456", nsc.Backend.AdminPassword)
+}
+
+func TestNamespaceMaintenance(t *testing.T) {
+	srv, doHTTP := createServer(t)
+	state := namespace.NewMaintenanceState()
+	srv.mgr.NsMgr.(*mockNamespaceManager).maintenance["test"] = state
+
+	doHTTP(t, http.MethodGet, "/api/admin/namespace/unknown/maintenance", httpOpts{}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusNotFound, r.StatusCode)
+	})
+	doHTTP(t, http.MethodGet, "/api/admin/namespace/test/maintenance", httpOpts{}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		all, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, `{}`, string(all))
+	})
+
+	doHTTP(t, http.MethodPut, "/api/admin/namespace/test/maintenance", httpOpts{
+		reader: strings.NewReader(`{"mode":"error","sessions":"close","error-code":9001,"error-message":"upgrading"}`),
+		header: map[string]string{"Content-Type": "application/json"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	})
+	cfg, _ := state.Get()
+	require.Equal(t, &config.Maintenance{Mode: config.MaintenanceModeError, Sessions: config.MaintenanceSessionsClose, ErrorCode: 9001, ErrorMessage: "upgrading"}, cfg)
+	doHTTP(t, http.MethodGet, "/api/admin/namespace/test/maintenance", httpOpts{}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		all, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var m config.Maintenance
+		require.NoError(t, json.Unmarshal(all, &m))
+		require.Equal(t, *cfg, m)
+	})
+
+	doHTTP(t, http.MethodPut, "/api/admin/namespace/test/maintenance", httpOpts{
+		reader: strings.NewReader(`{"mode":"reject"}`),
+		header: map[string]string{"Content-Type": "application/json"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusBadRequest, r.StatusCode)
+	})
+
+	// End the maintenance.
+	doHTTP(t, http.MethodPut, "/api/admin/namespace/test/maintenance", httpOpts{
+		reader: strings.NewReader(`{}`),
+		header: map[string]string{"Content-Type": "application/json"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	})
+	cfg, _ = state.Get()
+	require.Nil(t, cfg)
+}
@@ -0,0 +1,23 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Server) registerProcessList(group *gin.RouterGroup) {
+	group.GET("", h.ProcessList)
+}
+
+// ProcessList returns the states of all the sessions, including the current statements and the elapsed time.
+func (h *Server) ProcessList(c *gin.Context) {
+	if h.mgr.ProcessLister == nil {
+		c.String(http.StatusInternalServerError, "processlist is not available")
+		return
+	}
+	c.JSON(http.StatusOK, h.mgr.ProcessLister.ProcessList())
+}
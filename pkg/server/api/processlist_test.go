@@ -0,0 +1,39 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/pingcap/tiproxy/pkg/proxy/backend"
+	"github.com/stretchr/testify/require"
+)
+
+type mockProcessLister struct {
+	infos []backend.ProcessInfo
+}
+
+func (m *mockProcessLister) ProcessList() []backend.ProcessInfo {
+	return m.infos
+}
+
+func TestProcessList(t *testing.T) {
+	srv, doHTTP := createServer(t)
+	lister := srv.mgr.ProcessLister.(*mockProcessLister)
+	lister.infos = []backend.ProcessInfo{
+		{ConnID: 1, User: "u1", State: backend.ProcessStateIdle},
+		{ConnID: 2, User: "u2", State: backend.ProcessStateExecuting, Cmd: "Query", SQL: "select 1", ElapsedMs: 10},
+	}
+	doHTTP(t, http.MethodGet, "/api/processlist", httpOpts{}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		all, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var infos []backend.ProcessInfo
+		require.NoError(t, json.Unmarshal(all, &infos))
+		require.Equal(t, lister.infos, infos)
+	})
+}
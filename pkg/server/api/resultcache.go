@@ -0,0 +1,25 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Server) registerResultCache(group *gin.RouterGroup) {
+	group.POST("/flush", h.ResultCacheFlush)
+}
+
+// ResultCacheFlush removes the cached result sets. If `rule` is specified in the URL, only the result sets cached
+// by the rule are removed.
+func (h *Server) ResultCacheFlush(c *gin.Context) {
+	if h.mgr.ResultCache == nil {
+		c.String(http.StatusInternalServerError, "result cache is not available")
+		return
+	}
+	removed := h.mgr.ResultCache.Flush(c.Query("rule"))
+	c.String(http.StatusOK, "%d result sets flushed", removed)
+}
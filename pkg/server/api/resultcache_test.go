@@ -0,0 +1,35 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tiproxy/pkg/proxy/resultcache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultCacheFlush(t *testing.T) {
+	srv, doHTTP := createServer(t)
+	cache := srv.mgr.ResultCache
+	cache.Put(resultcache.Key{SQL: "select 1"}, "r1", time.Minute, 100, [][]byte{{1}})
+	cache.Put(resultcache.Key{SQL: "select 2"}, "r2", time.Minute, 100, [][]byte{{1}})
+
+	checkFlush := func(url, expected string) {
+		doHTTP(t, http.MethodPost, url, httpOpts{}, func(t *testing.T, r *http.Response) {
+			require.Equal(t, http.StatusOK, r.StatusCode)
+			all, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, expected, string(all))
+		})
+	}
+	checkFlush("/api/resultcache/flush?rule=r1", "1 result sets flushed")
+	require.Nil(t, cache.Get(resultcache.Key{SQL: "select 1"}, "r1"))
+	require.NotNil(t, cache.Get(resultcache.Key{SQL: "select 2"}, "r2"))
+	checkFlush("/api/resultcache/flush", "1 result sets flushed")
+	require.Nil(t, cache.Get(resultcache.Key{SQL: "select 2"}, "r2"))
+}
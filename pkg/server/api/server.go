@@ -22,7 +22,11 @@ import (
 	mgrcrt "github.com/pingcap/tiproxy/pkg/manager/cert"
 	mgrcfg "github.com/pingcap/tiproxy/pkg/manager/config"
 	mgrns "github.com/pingcap/tiproxy/pkg/manager/namespace"
+	"github.com/pingcap/tiproxy/pkg/proxy/backend"
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
 	"github.com/pingcap/tiproxy/pkg/proxy/proxyprotocol"
+	"github.com/pingcap/tiproxy/pkg/proxy/resultcache"
+	"github.com/pingcap/tiproxy/pkg/proxy/stmtsummary"
 	mgrrp "github.com/pingcap/tiproxy/pkg/sqlreplay/manager"
 	"go.uber.org/atomic"
 	"go.uber.org/ratelimit"
@@ -49,6 +53,12 @@ type Managers struct {
 	CertMgr       *mgrcrt.CertManager
 	BackendReader BackendReader
 	ReplayJobMgr  mgrrp.JobManager
+	// TrafficCluster is nil if there's no PD.
+	TrafficCluster mgrrp.ClusterManager
+	Firewall       *firewall.Firewall
+	ResultCache    *resultcache.Cache
+	StmtSummary    *stmtsummary.Summary
+	ProcessLister  backend.ProcessLister
 }
 
 type Server struct {
@@ -60,9 +70,10 @@ type Server struct {
 	grpc      *grpc.Server
 	isClosing atomic.Bool
 	mgr       Managers
+	admin     *mysqlAdmin
 }
 
-func NewServer(cfg config.API, lg *zap.Logger, mgr Managers, handler HTTPHandler, ready *atomic.Bool) (*Server, error) {
+func NewServer(cfg config.API, lg *zap.Logger, mgr Managers, handler HTTPHandler, ready *atomic.Bool) (_ *Server, err error) {
 	grpcOpts := []grpc_zap.Option{
 		grpc_zap.WithLevels(func(code codes.Code) zapcore.Level {
 			return zap.InfoLevel
@@ -85,11 +96,19 @@ func NewServer(cfg config.API, lg *zap.Logger, mgr Managers, handler HTTPHandler
 		mgr: mgr,
 	}
 
-	var err error
 	h.listener, err = net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		return nil, err
 	}
+	// Release the listeners if any later step fails.
+	defer func() {
+		if err != nil {
+			_ = h.listener.Close()
+			if h.admin != nil {
+				_ = h.admin.Close()
+			}
+		}
+	}()
 	switch cfg.ProxyProtocol {
 	case "v2":
 		h.listener = proxyprotocol.NewListener(h.listener)
@@ -118,6 +137,12 @@ func NewServer(cfg config.API, lg *zap.Logger, mgr Managers, handler HTTPHandler
 		}
 	}
 
+	if len(cfg.MySQLAddr) > 0 {
+		if h.admin, err = newMySQLAdmin(cfg, lg.Named("mysql_admin"), engine, mgr.CertMgr.ServerSQLTLS); err != nil {
+			return nil, err
+		}
+	}
+
 	if tlscfg := mgr.CertMgr.ServerHTTPTLS(); tlscfg != nil {
 		h.listener = tls.NewListener(h.listener, tlscfg)
 	}
@@ -211,6 +236,10 @@ func (h *Server) registerAPI(g *gin.RouterGroup) {
 	h.registerDebug(g.Group("debug"))
 	h.registerBackend(g.Group("backend"))
 	h.registerTraffic(g.Group("traffic"))
+	h.registerFirewall(g.Group("firewall"))
+	h.registerResultCache(g.Group("resultcache"))
+	h.registerStatements(g.Group("statements"))
+	h.registerProcessList(g.Group("processlist"))
 }
 
 func (h *Server) PreClose() {
@@ -219,6 +248,11 @@ func (h *Server) PreClose() {
 
 func (h *Server) Close() error {
 	err := h.listener.Close()
+	if h.admin != nil {
+		if adminErr := h.admin.Close(); err == nil {
+			err = adminErr
+		}
+	}
 	h.wg.Wait()
 	h.grpc.Stop()
 	return err
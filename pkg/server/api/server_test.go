@@ -14,6 +14,9 @@ import (
 	"github.com/pingcap/tiproxy/lib/util/logger"
 	mgrcrt "github.com/pingcap/tiproxy/pkg/manager/cert"
 	mgrcfg "github.com/pingcap/tiproxy/pkg/manager/config"
+	"github.com/pingcap/tiproxy/pkg/proxy/firewall"
+	"github.com/pingcap/tiproxy/pkg/proxy/resultcache"
+	"github.com/pingcap/tiproxy/pkg/proxy/stmtsummary"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
 	"google.golang.org/grpc"
@@ -34,13 +37,20 @@ func createServer(t *testing.T) (*Server, func(t *testing.T, method string, path
 	require.NoError(t, crtmgr.Init(cfgmgr.GetConfig(), lg, cfgmgr.WatchConfig()))
 	nsMgr := newMockNamespaceManager()
 	srv, err := NewServer(config.API{
-		Addr: "0.0.0.0:0",
+		Addr:            "0.0.0.0:0",
+		MySQLAddr:       "127.0.0.1:0",
+		MySQLUser:       "admin",
+		MySQLAuthString: "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9",
 	}, lg, Managers{
 		CfgMgr:        cfgmgr,
 		NsMgr:         nsMgr,
 		CertMgr:       crtmgr,
 		BackendReader: &mockBackendReader{},
 		ReplayJobMgr:  &mockReplayJobManager{},
+		Firewall:      firewall.NewFirewall(lg, t.TempDir()),
+		ResultCache:   resultcache.NewCache(lg),
+		StmtSummary:   stmtsummary.NewSummary(lg),
+		ProcessLister: &mockProcessLister{},
 	}, nil, ready)
 	require.NoError(t, err)
 	t.Cleanup(func() {
@@ -0,0 +1,48 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Server) registerStatements(group *gin.RouterGroup) {
+	group.GET("", h.StatementsList)
+}
+
+// StatementsList returns the statement summary of the current window, the latest first.
+// Query parameters:
+//   - `history`: also return the finished windows if it's true.
+//   - `order-by`: the field that the statements are sorted by in descending order, `sum_latency` by default.
+//   - `limit`: the max number of statements in each window. 0 means no limit.
+func (h *Server) StatementsList(c *gin.Context) {
+	if h.mgr.StmtSummary == nil {
+		c.String(http.StatusInternalServerError, "statement summary is not available")
+		return
+	}
+	var err error
+	var history bool
+	if historyStr := c.Query("history"); historyStr != "" {
+		if history, err = strconv.ParseBool(historyStr); err != nil {
+			c.String(http.StatusBadRequest, "invalid history: %s", err.Error())
+			return
+		}
+	}
+	var limit int
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 0 {
+			c.String(http.StatusBadRequest, "invalid limit: %s", limitStr)
+			return
+		}
+	}
+	windows, err := h.mgr.StmtSummary.Windows(history, c.Query("order-by"), limit)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, windows)
+}
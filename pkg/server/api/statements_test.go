@@ -0,0 +1,56 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/pkg/proxy/stmtsummary"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatements(t *testing.T) {
+	srv, doHTTP := createServer(t)
+	summary := srv.mgr.StmtSummary
+	summary.Reset(config.StmtSummary{Enable: true})
+	summary.Start(context.Background())
+	t.Cleanup(summary.Close)
+	summary.Add(&stmtsummary.Record{User: "u1", SQL: "select 1", EndTime: time.Now(), Latency: time.Second})
+	summary.Add(&stmtsummary.Record{User: "u1", SQL: "select a from t", EndTime: time.Now(), Latency: time.Millisecond})
+	summary.Add(&stmtsummary.Record{User: "u1", SQL: "select a from t", EndTime: time.Now(), Latency: time.Millisecond})
+
+	getStmts := func(url string) []stmtsummary.Window {
+		var windows []stmtsummary.Window
+		doHTTP(t, http.MethodGet, url, httpOpts{}, func(t *testing.T, r *http.Response) {
+			require.Equal(t, http.StatusOK, r.StatusCode)
+			all, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(all, &windows))
+		})
+		return windows
+	}
+	require.Eventually(t, func() bool {
+		windows := getStmts("/api/statements")
+		return len(windows) == 1 && len(windows[0].Stmts) == 2
+	}, 3*time.Second, 10*time.Millisecond)
+
+	windows := getStmts("/api/statements?limit=1")
+	require.Len(t, windows[0].Stmts, 1)
+	require.Equal(t, "select ?", windows[0].Stmts[0].NormalizedSQL)
+	windows = getStmts("/api/statements?limit=1&order-by=exec_count&history=true")
+	require.Len(t, windows[0].Stmts, 1)
+	require.EqualValues(t, 2, windows[0].Stmts[0].ExecCount)
+
+	for _, url := range []string{"/api/statements?order-by=abc", "/api/statements?limit=-1", "/api/statements?history=abc"} {
+		doHTTP(t, http.MethodGet, url, httpOpts{}, func(t *testing.T, r *http.Response) {
+			require.Equal(t, http.StatusBadRequest, r.StatusCode)
+		})
+	}
+}
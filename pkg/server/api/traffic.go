@@ -4,13 +4,16 @@
 package api
 
 import (
+	"context"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
+	mgrrp "github.com/pingcap/tiproxy/pkg/sqlreplay/manager"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/replay"
 	"go.uber.org/zap"
 )
@@ -18,11 +21,36 @@ import (
 func (h *Server) registerTraffic(group *gin.RouterGroup) {
 	group.POST("/capture", h.TrafficCapture)
 	group.POST("/replay", h.TrafficReplay)
+	group.POST("/replay/speed", h.TrafficReplaySpeed)
 	group.POST("/cancel", h.TrafficStop)
 	group.GET("/show", h.TrafficShow)
 }
 
+// handleClusterJob runs the job on all TiProxy instances if the request has `cluster=true`.
+// It returns false if the request is for this instance only.
+func (h *Server) handleClusterJob(c *gin.Context, run func(ctx context.Context, form url.Values) (string, error)) bool {
+	if !strings.EqualFold(c.Request.FormValue(mgrrp.FormCluster), "true") {
+		return false
+	}
+	if h.mgr.TrafficCluster == nil {
+		c.String(http.StatusBadRequest, "cluster jobs require PD")
+		return true
+	}
+	result, err := run(c.Request.Context(), c.Request.PostForm)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return true
+	}
+	c.String(http.StatusOK, result)
+	return true
+}
+
 func (h *Server) TrafficCapture(c *gin.Context) {
+	if h.handleClusterJob(c, func(ctx context.Context, form url.Values) (string, error) {
+		return h.mgr.TrafficCluster.Capture(ctx, form)
+	}) {
+		return
+	}
 	cfg := capture.CaptureConfig{}
 	cfg.Output = c.PostForm("output")
 	if durationStr := c.PostForm("duration"); durationStr != "" {
@@ -44,6 +72,51 @@ func (h *Server) TrafficCapture(c *gin.Context) {
 		}
 	}
 	cfg.Compress = compress
+	if loadDataStr := c.PostForm("load-data"); loadDataStr != "" {
+		loadData, err := strconv.ParseBool(loadDataStr)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.LoadData = loadData
+	}
+	if recordResultStr := c.PostForm("record-result"); recordResultStr != "" {
+		recordResult, err := strconv.ParseBool(recordResultStr)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.RecordResult = recordResult
+	}
+	if recordLatencyStr := c.PostForm("record-latency"); recordLatencyStr != "" {
+		recordLatency, err := strconv.ParseBool(recordLatencyStr)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.RecordLatency = recordLatency
+	}
+	cfg.Filter.Users = formList(c, "user")
+	cfg.Filter.DBs = formList(c, "db")
+	cfg.Filter.Namespaces = formList(c, "namespace")
+	cfg.Filter.ClientCIDRs = formList(c, "client-cidr")
+	cfg.Filter.CmdTypes = formList(c, "cmd-type")
+	if readOnlyStr := c.PostForm("read-only"); readOnlyStr != "" {
+		readOnly, err := strconv.ParseBool(readOnlyStr)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.Filter.ReadOnly = readOnly
+	}
+	if sampleRateStr := c.PostForm("sample-rate"); sampleRateStr != "" {
+		sampleRate, err := strconv.ParseFloat(sampleRateStr, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.Filter.SampleRate = sampleRate
+	}
 	cfg.KeyFile = h.mgr.CfgMgr.GetConfig().Security.Encryption.KeyPath
 
 	if err := h.mgr.ReplayJobMgr.StartCapture(cfg); err != nil {
@@ -53,7 +126,23 @@ func (h *Server) TrafficCapture(c *gin.Context) {
 	c.String(http.StatusOK, "capture started")
 }
 
+// formList parses a comma-separated form value.
+func formList(c *gin.Context, key string) []string {
+	var list []string
+	for _, value := range strings.Split(c.PostForm(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
 func (h *Server) TrafficReplay(c *gin.Context) {
+	if h.handleClusterJob(c, func(ctx context.Context, form url.Values) (string, error) {
+		return h.mgr.TrafficCluster.Replay(ctx, form)
+	}) {
+		return
+	}
 	cfg := replay.ReplayConfig{}
 	cfg.Input = c.PostForm("input")
 	if speedStr := c.PostForm("speed"); speedStr != "" {
@@ -64,10 +153,53 @@ func (h *Server) TrafficReplay(c *gin.Context) {
 		}
 		cfg.Speed = speed
 	}
+	if rampStepStr := c.PostForm("ramp-step"); rampStepStr != "" {
+		rampStep, err := strconv.ParseFloat(rampStepStr, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.RampStep = rampStep
+	}
+	if rampIntervalStr := c.PostForm("ramp-interval"); rampIntervalStr != "" {
+		rampInterval, err := time.ParseDuration(rampIntervalStr)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.RampInterval = rampInterval
+	}
+	if thresholdStr := c.PostForm("regression-threshold"); thresholdStr != "" {
+		threshold, err := strconv.ParseFloat(thresholdStr, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.RegressionThreshold = threshold
+	}
 	cfg.Username = c.PostForm("username")
 	cfg.Password = c.PostForm("password")
 	cfg.ReadOnly = strings.EqualFold(c.PostForm("readonly"), "true")
-	cfg.KeyFile = h.mgr.CfgMgr.GetConfig().Security.Encryption.KeyPath
+	// The owner sets the index and count when the replay runs on all instances.
+	if countStr := c.PostForm(mgrrp.FormClusterCount); countStr != "" {
+		count, err := strconv.Atoi(countStr)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		index, err := strconv.Atoi(c.PostForm(mgrrp.FormClusterIndex))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		cfg.ClusterIndex, cfg.ClusterCount = index, count
+	}
+	security := h.mgr.CfgMgr.GetConfig().Security
+	cfg.KeyFile = security.Encryption.KeyPath
+	// The credentials grant the access of any user, so they are only read from the config rather than the request.
+	cfg.CredentialFile = security.ReplayAuth.CredentialFile
+	cfg.SessionTokenSigningCert = security.ReplayAuth.SessionTokenSigningCert
+	cfg.SessionTokenSigningKey = security.ReplayAuth.SessionTokenSigningKey
 
 	if err := h.mgr.ReplayJobMgr.StartReplay(cfg); err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
@@ -76,12 +208,35 @@ func (h *Server) TrafficReplay(c *gin.Context) {
 	c.String(http.StatusOK, "replay started")
 }
 
+func (h *Server) TrafficReplaySpeed(c *gin.Context) {
+	speed, err := strconv.ParseFloat(c.PostForm("speed"), 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.mgr.ReplayJobMgr.SetReplaySpeed(speed); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.String(http.StatusOK, "replay speed updated")
+}
+
 func (h *Server) TrafficStop(c *gin.Context) {
+	if h.handleClusterJob(c, func(ctx context.Context, _ url.Values) (string, error) {
+		return h.mgr.TrafficCluster.Cancel(ctx)
+	}) {
+		return
+	}
 	result := h.mgr.ReplayJobMgr.Stop()
 	c.String(http.StatusOK, result)
 }
 
 func (h *Server) TrafficShow(c *gin.Context) {
+	if h.handleClusterJob(c, func(ctx context.Context, _ url.Values) (string, error) {
+		return h.mgr.TrafficCluster.Show(ctx)
+	}) {
+		return
+	}
 	result := h.mgr.ReplayJobMgr.Jobs()
 	c.String(http.StatusOK, result)
 }
@@ -4,9 +4,11 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
@@ -49,7 +51,7 @@ func TestTraffic(t *testing.T) {
 		require.Equal(t, "", mgr.curJob)
 	})
 	doHTTP(t, http.MethodPost, "/api/traffic/capture", httpOpts{
-		reader: cli.GetFormReader(map[string]string{"output": "/tmp", "duration": "1h", "encrypt-method": "aes256-ctr", "compress": "false"}),
+		reader: cli.GetFormReader(map[string]string{"output": "/tmp", "duration": "1h", "encrypt-method": "aes256-ctr", "compress": "false", "load-data": "true"}),
 		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
 	}, func(t *testing.T, r *http.Response) {
 		require.Equal(t, http.StatusOK, r.StatusCode)
@@ -57,7 +59,28 @@ func TestTraffic(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, "capture started", string(all))
 		require.Equal(t, "capture", mgr.curJob)
-		require.Equal(t, capture.CaptureConfig{Duration: time.Hour, Output: "/tmp", EncryptMethod: "aes256-ctr", Compress: false}, mgr.captureCfg)
+		require.Equal(t, capture.CaptureConfig{Duration: time.Hour, Output: "/tmp", EncryptMethod: "aes256-ctr", Compress: false, LoadData: true}, mgr.captureCfg)
+	})
+	doHTTP(t, http.MethodPost, "/api/traffic/cancel", httpOpts{}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+	})
+	doHTTP(t, http.MethodPost, "/api/traffic/capture", httpOpts{
+		reader: cli.GetFormReader(map[string]string{"output": "/tmp", "duration": "1h", "sample-rate": "abc"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusBadRequest, r.StatusCode)
+	})
+	doHTTP(t, http.MethodPost, "/api/traffic/capture", httpOpts{
+		reader: cli.GetFormReader(map[string]string{"output": "/tmp", "duration": "1h", "user": "u1, u2", "db": "", "namespace": "ns1",
+			"client-cidr": "10.0.0.0/8", "cmd-type": "Query", "read-only": "true", "sample-rate": "0.1", "record-result": "true",
+			"record-latency": "true"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		require.Equal(t, capture.CaptureFilter{Users: []string{"u1", "u2"}, Namespaces: []string{"ns1"}, ClientCIDRs: []string{"10.0.0.0/8"},
+			CmdTypes: []string{"Query"}, ReadOnly: true, SampleRate: 0.1}, mgr.captureCfg.Filter)
+		require.True(t, mgr.captureCfg.RecordResult)
+		require.True(t, mgr.captureCfg.RecordLatency)
 	})
 	doHTTP(t, http.MethodPost, "/api/traffic/replay", httpOpts{
 		reader: cli.GetFormReader(map[string]string{"input": "/tmp"}),
@@ -91,6 +114,19 @@ func TestTraffic(t *testing.T) {
 		require.Equal(t, "replay", mgr.curJob)
 		require.Equal(t, replay.ReplayConfig{Input: "/tmp", Username: "u1", Password: "p1", Speed: 2.0}, mgr.replayCfg)
 	})
+	doHTTP(t, http.MethodPost, "/api/traffic/replay/speed", httpOpts{
+		reader: cli.GetFormReader(map[string]string{"speed": "abc"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusBadRequest, r.StatusCode)
+	})
+	doHTTP(t, http.MethodPost, "/api/traffic/replay/speed", httpOpts{
+		reader: cli.GetFormReader(map[string]string{"speed": "3"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		require.Equal(t, 3.0, mgr.replayCfg.Speed)
+	})
 	doHTTP(t, http.MethodGet, "/api/traffic/show", httpOpts{}, func(t *testing.T, r *http.Response) {
 		require.Equal(t, http.StatusOK, r.StatusCode)
 		all, err := io.ReadAll(r.Body)
@@ -100,6 +136,28 @@ func TestTraffic(t *testing.T) {
 	doHTTP(t, http.MethodPost, "/api/traffic/cancel", httpOpts{}, func(t *testing.T, r *http.Response) {
 		require.Equal(t, http.StatusOK, r.StatusCode)
 	})
+	doHTTP(t, http.MethodPost, "/api/traffic/replay/speed", httpOpts{
+		reader: cli.GetFormReader(map[string]string{"speed": "3"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusInternalServerError, r.StatusCode)
+	})
+	// The replay credentials are only read from the config, and the ones in the request are ignored.
+	require.NoError(t, server.mgr.CfgMgr.SetTOMLConfig([]byte(`[security.replay-auth]
+credential-file = "/tmp/cred.json"
+session-token-signing-cert = "/tmp/cert.pem"
+session-token-signing-key = "/tmp/key.pem"`)))
+	doHTTP(t, http.MethodPost, "/api/traffic/replay", httpOpts{
+		reader: cli.GetFormReader(map[string]string{"input": "/tmp", "username": "u1", "ramp-step": "0.5", "ramp-interval": "1m",
+			"regression-threshold": "0.5", "credential-file": "/etc/passwd", "session-token-signing-cert": "/etc/cert.pem",
+			"session-token-signing-key": "/etc/key.pem"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		require.Equal(t, replay.ReplayConfig{Input: "/tmp", Username: "u1", RampStep: 0.5, RampInterval: time.Minute,
+			RegressionThreshold: 0.5, CredentialFile: "/tmp/cred.json", SessionTokenSigningCert: "/tmp/cert.pem",
+			SessionTokenSigningKey: "/tmp/key.pem"}, mgr.replayCfg)
+	})
 }
 
 var _ manager.JobManager = (*mockReplayJobManager)(nil)
@@ -139,7 +197,91 @@ func (m *mockReplayJobManager) StartReplay(replayCfg replay.ReplayConfig) error
 	return nil
 }
 
+func (m *mockReplayJobManager) SetReplaySpeed(speed float64) error {
+	if m.curJob != "replay" {
+		return errors.New("no replay job running")
+	}
+	m.replayCfg.Speed = speed
+	return nil
+}
+
 func (m *mockReplayJobManager) Stop() string {
 	m.curJob = ""
 	return "stopped"
 }
+
+func TestTrafficCluster(t *testing.T) {
+	server, doHTTP := createServer(t)
+	mgr := server.mgr.ReplayJobMgr.(*mockReplayJobManager)
+	form := httpOpts{
+		reader: cli.GetFormReader(map[string]string{"output": "/tmp", "cluster": "true"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}
+
+	// Cluster jobs are not supported without PD.
+	doHTTP(t, http.MethodPost, "/api/traffic/capture", form, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusBadRequest, r.StatusCode)
+		require.Equal(t, "", mgr.curJob)
+	})
+
+	cluster := &mockClusterManager{}
+	server.mgr.TrafficCluster = cluster
+	form.reader = cli.GetFormReader(map[string]string{"output": "/tmp", "cluster": "true"})
+	doHTTP(t, http.MethodPost, "/api/traffic/capture", form, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		require.Equal(t, "capture", cluster.curJob)
+		require.Equal(t, "/tmp", cluster.form.Get("output"))
+		require.Equal(t, "", mgr.curJob)
+	})
+	doHTTP(t, http.MethodGet, "/api/traffic/show?cluster=true", httpOpts{}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		all, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "capture", string(all))
+	})
+	doHTTP(t, http.MethodPost, "/api/traffic/cancel", httpOpts{
+		reader: cli.GetFormReader(map[string]string{"cluster": "true"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		require.Equal(t, "", cluster.curJob)
+	})
+
+	// The owner sends the index and count to each instance.
+	doHTTP(t, http.MethodPost, "/api/traffic/replay", httpOpts{
+		reader: cli.GetFormReader(map[string]string{"input": "/tmp", "username": "u1", "cluster-index": "1", "cluster-count": "3"}),
+		header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+	}, func(t *testing.T, r *http.Response) {
+		require.Equal(t, http.StatusOK, r.StatusCode)
+		require.Equal(t, replay.ReplayConfig{Input: "/tmp", Username: "u1", ClusterIndex: 1, ClusterCount: 3}, mgr.replayCfg)
+	})
+}
+
+var _ manager.ClusterManager = (*mockClusterManager)(nil)
+
+type mockClusterManager struct {
+	curJob string
+	form   url.Values
+}
+
+func (m *mockClusterManager) Capture(_ context.Context, form url.Values) (string, error) {
+	m.curJob, m.form = "capture", form
+	return "capture started", nil
+}
+
+func (m *mockClusterManager) Replay(_ context.Context, form url.Values) (string, error) {
+	m.curJob, m.form = "replay", form
+	return "replay started", nil
+}
+
+func (m *mockClusterManager) Cancel(context.Context) (string, error) {
+	m.curJob = ""
+	return "stopped", nil
+}
+
+func (m *mockClusterManager) Show(context.Context) (string, error) {
+	return m.curJob, nil
+}
+
+func (m *mockClusterManager) Close() {
+}
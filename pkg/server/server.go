@@ -45,6 +45,7 @@ type Server struct {
 	infoSyncer       *infosync.InfoSyncer
 	metricsReader    metricsreader.MetricsReader
 	replay           mgrrp.JobManager
+	trafficCluster   mgrrp.ClusterManager
 	// etcd client
 	etcdCli *clientv3.Client
 	// HTTP client
@@ -166,7 +167,17 @@ func NewServer(ctx context.Context, sctx *sctx.Context) (srv *Server, err error)
 
 	// setup capture and replay job manager
 	{
-		srv.replay = mgrrp.NewJobManager(lg.Named("replay"), srv.configManager.GetConfig(), srv.certManager, idMgr, hsHandler)
+		jobManager := mgrrp.NewJobManager(lg.Named("replay"), srv.configManager.GetConfig(), srv.certManager, idMgr, hsHandler)
+		jobManager.StartAutoCapture(srv.configManager, srv.metricsReader)
+		srv.replay = jobManager
+		// Cluster jobs need the topology of all TiProxy instances.
+		if srv.infoSyncer != nil {
+			trafficCluster := mgrrp.NewClusterManager(lg.Named("traffic_cluster"), srv.etcdCli, srv.infoSyncer, srv.httpCli)
+			if err = trafficCluster.Start(ctx, cfg); err != nil {
+				return
+			}
+			srv.trafficCluster = trafficCluster
+		}
 	}
 
 	// setup proxy server
@@ -175,6 +186,7 @@ func NewServer(ctx context.Context, sctx *sctx.Context) (srv *Server, err error)
 		if err != nil {
 			return
 		}
+		srv.proxy.UserStore().Start(ctx, srv.etcdCli)
 		srv.proxy.Run(ctx, srv.configManager.WatchConfig())
 	}
 
@@ -185,10 +197,23 @@ func NewServer(ctx context.Context, sctx *sctx.Context) (srv *Server, err error)
 		CertMgr:       srv.certManager,
 		BackendReader: srv.metricsReader,
 		ReplayJobMgr:  srv.replay,
+		Firewall:      srv.proxy.Firewall(),
+		ResultCache:   srv.proxy.ResultCache(),
+		StmtSummary:   srv.proxy.StmtSummary(),
+		ProcessLister: srv.proxy,
+	}
+	if srv.trafficCluster != nil {
+		mgrs.TrafficCluster = srv.trafficCluster
 	}
 	if srv.apiServer, err = api.NewServer(cfg.API, lg.Named("api"), mgrs, handler, ready); err != nil {
 		return
 	}
+	// Release the API and MySQL admin ports if a later step fails.
+	defer func() {
+		if err != nil {
+			_ = srv.apiServer.Close()
+		}
+	}()
 
 	// setup vip manager
 	{
@@ -253,6 +278,9 @@ func (s *Server) Close() error {
 	if s.apiServer != nil {
 		errs = append(errs, s.apiServer.Close())
 	}
+	if s.trafficCluster != nil {
+		s.trafficCluster.Close()
+	}
 	if s.namespaceManager != nil {
 		errs = append(errs, s.namespaceManager.Close())
 	}
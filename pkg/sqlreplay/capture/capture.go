@@ -34,6 +34,18 @@ const (
 	statusStopping
 )
 
+// Pending tells what a captured command waits for before it's recorded.
+type Pending int
+
+const (
+	// PendingNone means the command is recorded immediately.
+	PendingNone Pending = iota
+	// PendingDuration means the command waits for its execution duration.
+	PendingDuration
+	// PendingResult means the command waits for its result and maybe its execution duration.
+	PendingResult
+)
+
 type Capture interface {
 	// Start starts the capture
 	Start(cfg CaptureConfig) error
@@ -41,9 +53,17 @@ type Capture interface {
 	// err means the error that caused the capture to stop. nil means the capture stopped manually.
 	Stop(err error)
 	// InitConn is called when a new connection is created.
-	InitConn(startTime time.Time, connID uint64, db string)
-	// Capture captures traffic
-	Capture(packet []byte, startTime time.Time, connID uint64, initSession func() (string, error))
+	InitConn(startTime time.Time, connID uint64, info ConnInfo)
+	// CloseConn is called when a connection is closed, no matter whether the client sends COM_QUIT.
+	CloseConn(startTime time.Time, connID uint64)
+	// Capture captures traffic. session is nil if the connection is closing.
+	// If the returned value is not PendingNone, CaptureResult should be called after the command finishes.
+	Capture(packet []byte, startTime time.Time, connID uint64, session Session) Pending
+	// CaptureResult records the execution duration and the result of the command that is captured by Capture.
+	// result is nil if it's unknown.
+	CaptureResult(connID uint64, succeeded bool, duration time.Duration, result *cmd.Result)
+	// CaptureLoadData captures a LOAD DATA LOCAL INFILE statement with the hash of the uploaded file after it finishes.
+	CaptureLoadData(packet []byte, startTime time.Time, connID uint64, session Session, payloadHash string)
 	// Progress returns the progress of the capture job
 	Progress() (float64, time.Time, bool, error)
 	// Close closes the capture
@@ -51,11 +71,25 @@ type Capture interface {
 }
 
 type CaptureConfig struct {
-	Output             string
-	EncryptMethod      string
-	KeyFile            string
-	Duration           time.Duration
-	Compress           bool
+	Output        string
+	EncryptMethod string
+	KeyFile       string
+	Duration      time.Duration
+	Compress      bool
+	// LoadData records LOAD DATA LOCAL INFILE statements with the hash of the uploaded file.
+	// The file itself is not recorded, so the statements are not replayed.
+	LoadData bool
+	// RecordResult records the result of each command so that replay can compare the results.
+	// The commands are recorded after they finish, so a slow command is replayed later than it was issued.
+	RecordResult bool
+	// RecordLatency records the execution duration of each command so that replay can compare the latencies.
+	// Like RecordResult, the commands are recorded after they finish.
+	RecordLatency bool
+	// MaxSize is the max size of the captured traffic in bytes before compression and encryption.
+	// The capture stops once it's reached. 0 means unlimited.
+	MaxSize int64
+	// Filter selects the captured traffic.
+	Filter             CaptureFilter
 	cmdLogger          store.Writer
 	bufferCap          int
 	flushThreshold     int
@@ -67,21 +101,31 @@ func (cfg *CaptureConfig) Validate() error {
 	if cfg.Output == "" {
 		return errors.New("output is required")
 	}
-	st, err := os.Stat(cfg.Output)
-	if err == nil {
-		if !st.IsDir() {
-			return errors.New("output should be a directory")
+	if store.IsLocal(cfg.Output) {
+		st, err := os.Stat(cfg.Output)
+		if err == nil {
+			if !st.IsDir() {
+				return errors.New("output should be a directory")
+			}
+			err = store.PreCheckMeta(cfg.Output)
+		} else if os.IsNotExist(err) {
+			err = os.MkdirAll(cfg.Output, 0755)
 		}
-		err = store.PreCheckMeta(cfg.Output)
-	} else if os.IsNotExist(err) {
-		err = os.MkdirAll(cfg.Output, 0755)
-	}
-	if err != nil {
+		if err != nil {
+			return err
+		}
+	} else if err := store.PreCheckMeta(cfg.Output); err != nil {
 		return err
 	}
 	if cfg.Duration == 0 {
 		return errors.New("duration is required")
 	}
+	if cfg.MaxSize < 0 {
+		return errors.New("max size should not be negative")
+	}
+	if err := cfg.Filter.Validate(); err != nil {
+		return err
+	}
 	if cfg.bufferCap == 0 {
 		cfg.bufferCap = bufferCap
 	}
@@ -101,8 +145,15 @@ var _ Capture = (*capture)(nil)
 
 type capture struct {
 	sync.Mutex
-	cfg          CaptureConfig
-	conns        map[uint64]struct{}
+	cfg   CaptureConfig
+	conns map[uint64]struct{}
+	// excludedConns are the connections skipped by the filter.
+	excludedConns map[uint64]struct{}
+	// unrecordedConns are the connections whose users and attributes are not recorded because no commands of them
+	// are recorded yet. They are recorded with the first command.
+	unrecordedConns map[uint64]ConnInfo
+	// pendingCmds are the commands waiting for their results, at most one for each connection.
+	pendingCmds  map[uint64]*cmd.Command
 	wg           waitgroup.WaitGroup
 	cancel       context.CancelFunc
 	cmdCh        chan *cmd.Command
@@ -112,8 +163,11 @@ type capture struct {
 	progress     float64
 	capturedCmds uint64
 	filteredCmds uint64
-	status       int
-	lg           *zap.Logger
+	// excludedConnCnt and excludedCmds are the numbers of connections and commands skipped by the filter.
+	excludedConnCnt uint64
+	excludedCmds    uint64
+	status          int
+	lg              *zap.Logger
 }
 
 func NewCapture(lg *zap.Logger) *capture {
@@ -138,9 +192,14 @@ func (c *capture) Start(cfg CaptureConfig) error {
 	c.progress = 0
 	c.capturedCmds = 0
 	c.filteredCmds = 0
+	c.excludedConnCnt = 0
+	c.excludedCmds = 0
 	c.status = statusRunning
 	c.err = nil
 	c.conns = make(map[uint64]struct{})
+	c.excludedConns = make(map[uint64]struct{})
+	c.pendingCmds = make(map[uint64]*cmd.Command)
+	c.unrecordedConns = make(map[uint64]ConnInfo)
 	childCtx, cancel := context.WithTimeout(context.Background(), c.cfg.Duration)
 	c.cancel = cancel
 	bufCh := make(chan *bytes.Buffer, cfg.maxBuffers)
@@ -236,71 +295,155 @@ func (c *capture) flushBuffer(bufCh <-chan *bytes.Buffer) {
 		}
 	}
 	// Flush all buffers even if the context is timeout.
+	var written int64
 	for buf := range bufCh {
 		// TODO: each write size should be less than MaxSize.
 		if err := cmdLogger.Write(buf.Bytes()); err != nil {
 			c.stop(errors.Wrapf(err, "failed to flush traffic to disk"))
 			break
 		}
+		written += int64(buf.Len())
+		if c.cfg.MaxSize > 0 && written >= c.cfg.MaxSize {
+			c.lg.Info("captured traffic reaches the max size, stop capturing", zap.Int64("max_size", c.cfg.MaxSize))
+			c.stop(nil)
+			break
+		}
+	}
+	// Drain the remaining buffers so that collectCmds won't be blocked.
+	for range bufCh {
 	}
 	if err := cmdLogger.Close(); err != nil {
 		c.lg.Warn("failed to close command logger", zap.Error(err))
 	}
 
 	c.Lock()
-	startTime := c.startTime
-	capturedCmds := c.capturedCmds
-	filteredCmds := c.filteredCmds
+	meta := store.NewMeta(time.Since(c.startTime), c.capturedCmds, c.filteredCmds, c.cfg.EncryptMethod)
+	if !c.cfg.Filter.Empty() {
+		meta.Filter = c.cfg.Filter.String()
+		meta.ExcludedConns = c.excludedConnCnt
+		meta.ExcludedCmds = c.excludedCmds
+	}
 	c.Unlock()
 	// Write meta outside of the lock to avoid affecting QPS.
-	c.writeMeta(time.Since(startTime), capturedCmds, filteredCmds)
+	c.writeMeta(meta)
 }
 
-func (c *capture) InitConn(startTime time.Time, connID uint64, db string) {
+func (c *capture) InitConn(startTime time.Time, connID uint64, info ConnInfo) {
 	c.Lock()
 	defer c.Unlock()
 	if c.status != statusRunning {
 		return
 	}
-	if db != "" {
+	if !c.cfg.Filter.matchConn(connID, info) {
+		c.excludeConn(connID)
+		return
+	}
+	c.conns[connID] = struct{}{}
+	c.unrecordedConns[connID] = info
+	if db := info.DB; db != "" {
 		packet := make([]byte, 0, len(db)+1)
 		packet = append(packet, pnet.ComInitDB.Byte())
 		packet = append(packet, hack.Slice(db)...)
 		command := cmd.NewCommand(packet, startTime, connID)
-		if command == nil {
-			return
-		}
 		c.putCommand(command)
 	}
-	c.conns[connID] = struct{}{}
 }
 
-func (c *capture) Capture(packet []byte, startTime time.Time, connID uint64, initSession func() (string, error)) {
+// CloseConn forgets the excluded connection, or records a COM_QUIT in case the connection closes without it.
+func (c *capture) CloseConn(startTime time.Time, connID uint64) {
 	c.Lock()
-	if c.status != statusRunning {
+	if _, ok := c.excludedConns[connID]; ok {
+		delete(c.excludedConns, connID)
 		c.Unlock()
 		return
 	}
+	c.Unlock()
+	c.capture([]byte{pnet.ComQuit.Byte()}, startTime, connID, nil, "")
+}
+
+func (c *capture) Capture(packet []byte, startTime time.Time, connID uint64, session Session) Pending {
+	return c.capture(packet, startTime, connID, session, "")
+}
+
+func (c *capture) CaptureLoadData(packet []byte, startTime time.Time, connID uint64, session Session, payloadHash string) {
+	c.capture(packet, startTime, connID, session, payloadHash)
+}
+
+func (c *capture) CaptureResult(connID uint64, succeeded bool, duration time.Duration, result *cmd.Result) {
+	c.Lock()
+	defer c.Unlock()
+	command, ok := c.pendingCmds[connID]
+	if !ok {
+		return
+	}
+	delete(c.pendingCmds, connID)
+	command.Succeess = succeeded
+	if c.cfg.RecordResult {
+		command.Result = result
+	}
+	if c.cfg.RecordLatency {
+		command.Duration = duration
+	}
+	c.putCommand(command)
+}
+
+// capture returns what the command is pending for.
+func (c *capture) capture(packet []byte, startTime time.Time, connID uint64, session Session, payloadHash string) Pending {
+	if len(packet) == 0 {
+		return PendingNone
+	}
+	c.Lock()
+	if c.status != statusRunning || (payloadHash != "" && !c.cfg.LoadData) {
+		c.Unlock()
+		return PendingNone
+	}
+	if _, ok := c.excludedConns[connID]; ok {
+		c.excludedCmds++
+		c.Unlock()
+		return PendingNone
+	}
+	// Skip the command before initializing the session so that the session is initialized before the first captured command.
+	if !c.cfg.Filter.matchCmd(packet) {
+		c.excludedCmds++
+		c.Unlock()
+		return PendingNone
+	}
 	_, inited := c.conns[connID]
+	var filter CaptureFilter
+	if !inited {
+		filter = c.cfg.Filter
+	}
 	c.Unlock()
 
 	// If this is the first command for this connection, record a `set session_states` statement.
 	if !inited {
 		// Maybe it's quitting, no need to init session.
-		if initSession == nil || len(packet) == 0 || packet[0] == pnet.ComQuit.Byte() {
-			return
+		if session == nil || packet[0] == pnet.ComQuit.Byte() {
+			return PendingNone
 		}
-		// initSession is slow, do not call it in the lock.
-		sql, err := initSession()
+		// The connection was created before the capture started, so check it now.
+		info := session.ConnInfo()
+		if !filter.matchConn(connID, info) {
+			c.Lock()
+			if c.status == statusRunning {
+				c.excludeConn(connID)
+				c.excludedCmds++
+			}
+			c.Unlock()
+			return PendingNone
+		}
+		// InitSession is slow, do not call it in the lock.
+		sql, err := session.InitSession()
 		if err != nil {
 			// Maybe the connection is in transaction or closing.
 			c.lg.Debug("failed to init session", zap.Uint64("connID", connID), zap.Error(err))
-			return
+			return PendingNone
 		}
 		initPacket := make([]byte, 0, len(sql)+1)
 		initPacket = append(initPacket, pnet.ComQuery.Byte())
 		initPacket = append(initPacket, hack.Slice(sql)...)
 		command := cmd.NewCommand(initPacket, startTime, connID)
+		command.User, command.ConnAttrs = info.User, info.Attrs
 		c.Lock()
 		if c.putCommand(command) {
 			c.conns[connID] = struct{}{}
@@ -310,11 +453,30 @@ func (c *capture) Capture(packet []byte, startTime time.Time, connID uint64, ini
 
 	command := cmd.NewCommand(packet, startTime, connID)
 	if command == nil {
-		return
+		return PendingNone
 	}
+	command.LoadDataHash = payloadHash
 	c.Lock()
 	defer c.Unlock()
+	// LOAD DATA LOCAL INFILE with the hash is captured after it finishes and it's not replayed, so it needn't results.
+	if (c.cfg.RecordResult || c.cfg.RecordLatency) && payloadHash == "" && command.Type != pnet.ComQuit {
+		if c.status != statusRunning {
+			return PendingNone
+		}
+		c.pendingCmds[connID] = command
+		if c.cfg.RecordResult {
+			return PendingResult
+		}
+		return PendingDuration
+	}
 	c.putCommand(command)
+	return PendingNone
+}
+
+// excludeConn must be called after holding a lock.
+func (c *capture) excludeConn(connID uint64) {
+	c.excludedConns[connID] = struct{}{}
+	c.excludedConnCnt++
 }
 
 func (c *capture) putCommand(command *cmd.Command) bool {
@@ -329,17 +491,26 @@ func (c *capture) putCommand(command *cmd.Command) bool {
 			// Duplicated quit, ignore it.
 			return false
 		}
+		delete(c.unrecordedConns, command.ConnID)
 	case pnet.ComChangeUser:
 		// COM_CHANGE_USER sends auth data, change it to COM_RESET_CONNECTION.
 		command.Type = pnet.ComResetConnection
 		command.Payload = []byte{pnet.ComResetConnection.Byte()}
 	case pnet.ComQuery:
 		// Avoid password leakage.
-		if lex.IsSensitiveSQL(hack.String(command.Payload[1:])) {
-			c.filteredCmds++
+		sql := hack.String(command.Payload[1:])
+		if command.LoadDataHash == "" && lex.IsSensitiveSQL(sql) {
+			// LOAD DATA LOCAL INFILE is captured by CaptureLoadData after it finishes.
+			if !c.cfg.LoadData || !lex.IsLoadDataLocal(sql) {
+				c.filteredCmds++
+			}
 			return false
 		}
 	}
+	if info, ok := c.unrecordedConns[command.ConnID]; ok {
+		delete(c.unrecordedConns, command.ConnID)
+		command.User, command.ConnAttrs = info.User, info.Attrs
+	}
 	select {
 	case c.cmdCh <- command:
 		return true
@@ -350,8 +521,7 @@ func (c *capture) putCommand(command *cmd.Command) bool {
 	}
 }
 
-func (c *capture) writeMeta(duration time.Duration, cmds, filteredCmds uint64) {
-	meta := store.NewMeta(duration, cmds, filteredCmds, c.cfg.EncryptMethod)
+func (c *capture) writeMeta(meta *store.Meta) {
 	if err := meta.Write(c.cfg.Output); err != nil {
 		c.lg.Error("failed to write meta", zap.Error(err))
 	}
@@ -385,6 +555,9 @@ func (c *capture) stopNoLock(err error) {
 		c.err = err
 	}
 	c.conns = map[uint64]struct{}{}
+	c.excludedConns = map[uint64]struct{}{}
+	c.pendingCmds = map[uint64]*cmd.Command{}
+	c.unrecordedConns = map[uint64]ConnInfo{}
 }
 
 func (c *capture) stop(err error) {
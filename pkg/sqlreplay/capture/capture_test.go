@@ -15,6 +15,7 @@ import (
 	"github.com/pingcap/tiproxy/lib/util/errors"
 	"github.com/pingcap/tiproxy/lib/util/waitgroup"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/store"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -96,7 +97,7 @@ func TestConcurrency(t *testing.T) {
 			case <-ctx.Done():
 				return
 			case <-time.After(100 * time.Microsecond):
-				cpt.InitConn(time.Now(), uint64(i), "abc")
+				cpt.InitConn(time.Now(), uint64(i), ConnInfo{DB: "abc"})
 			}
 		}
 	})
@@ -139,6 +140,11 @@ func TestCaptureCfgError(t *testing.T) {
 			Duration: 10 * time.Second,
 			Output:   path,
 		},
+		{
+			Duration: 10 * time.Second,
+			Output:   dir,
+			MaxSize:  -1,
+		},
 	}
 
 	for i, cfg := range cfgs {
@@ -202,6 +208,35 @@ func TestProgress(t *testing.T) {
 	require.Less(t, m.Duration, 10*time.Second)
 }
 
+func TestMaxSize(t *testing.T) {
+	cpt := NewCapture(zap.NewNop())
+	defer cpt.Close()
+
+	writer := newMockWriter(store.WriterCfg{})
+	cfg := CaptureConfig{
+		Output:         t.TempDir(),
+		Duration:       10 * time.Second,
+		MaxSize:        10,
+		cmdLogger:      writer,
+		flushThreshold: 1,
+	}
+	require.NoError(t, cpt.Start(cfg))
+	packet := append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...)
+	cpt.Capture(packet, time.Now(), 100, mockInitSession)
+	// The capture stops without errors once the size reaches the limit.
+	require.Eventually(t, func() bool {
+		_, _, done, _ := cpt.Progress()
+		return done
+	}, 3*time.Second, 10*time.Millisecond)
+	cpt.wg.Wait()
+	_, _, _, err := cpt.Progress()
+	require.NoError(t, err)
+	// Only the first command is flushed.
+	data := string(writer.getData())
+	require.Contains(t, data, "init session")
+	require.NotContains(t, data, "select 1")
+}
+
 func TestInitConn(t *testing.T) {
 	cpt := NewCapture(zap.NewNop())
 	defer cpt.Close()
@@ -215,16 +250,10 @@ func TestInitConn(t *testing.T) {
 	}
 
 	require.NoError(t, cpt.Start(cfg))
-	cpt.InitConn(time.Now(), 100, "mockDB")
-	cpt.Capture(packet, time.Now(), 100, func() (string, error) {
-		return "init session 100", nil
-	})
-	cpt.Capture(packet, time.Now(), 101, func() (string, error) {
-		return "init session fail 101", errors.New("init session fail 101")
-	})
-	cpt.Capture(packet, time.Now(), 101, func() (string, error) {
-		return "init session 101", nil
-	})
+	cpt.InitConn(time.Now(), 100, ConnInfo{DB: "mockDB"})
+	cpt.Capture(packet, time.Now(), 100, &mockSession{sql: "init session 100"})
+	cpt.Capture(packet, time.Now(), 101, &mockSession{sql: "init session fail 101", err: errors.New("init session fail 101")})
+	cpt.Capture(packet, time.Now(), 101, &mockSession{sql: "init session 101"})
 	cpt.Stop(errors.Errorf("mock error"))
 	data := string(writer.getData())
 	require.Equal(t, 1, strings.Count(data, "mockDB"))
@@ -250,19 +279,11 @@ func TestQuit(t *testing.T) {
 
 	require.NoError(t, cpt.Start(cfg))
 	// 100: quit
-	cpt.Capture(quitPacket, time.Now(), 100, func() (string, error) {
-		return "init session 100", nil
-	})
+	cpt.Capture(quitPacket, time.Now(), 100, &mockSession{sql: "init session 100"})
 	// 101: select + quit + quit
-	cpt.Capture(queryPacket, time.Now(), 101, func() (string, error) {
-		return "init session 101", nil
-	})
-	cpt.Capture(quitPacket, time.Now(), 101, func() (string, error) {
-		return "init session 101", nil
-	})
-	cpt.Capture(quitPacket, time.Now(), 101, func() (string, error) {
-		return "init session 101", nil
-	})
+	cpt.Capture(queryPacket, time.Now(), 101, &mockSession{sql: "init session 101"})
+	cpt.Capture(quitPacket, time.Now(), 101, &mockSession{sql: "init session 101"})
+	cpt.Capture(quitPacket, time.Now(), 101, &mockSession{sql: "init session 101"})
 	cpt.Stop(errors.Errorf("mock error"))
 
 	data := string(writer.getData())
@@ -308,9 +329,7 @@ func TestFilterCmds(t *testing.T) {
 		cfg.cmdLogger = writer
 		removeMeta(dir)
 		require.NoError(t, cpt.Start(cfg))
-		cpt.Capture(test.packet, time.Now(), 100, func() (string, error) {
-			return "init session 100", nil
-		})
+		cpt.Capture(test.packet, time.Now(), 100, &mockSession{sql: "init session 100"})
 		cpt.Stop(nil)
 
 		data := string(writer.getData())
@@ -333,3 +352,197 @@ func TestFilterCmds(t *testing.T) {
 func removeMeta(dir string) {
 	_ = os.Remove(filepath.Join(dir, "meta"))
 }
+
+func TestCaptureLoadData(t *testing.T) {
+	packet := append([]byte{pnet.ComQuery.Byte()}, []byte("LOAD DATA LOCAL INFILE '/tmp/t.csv' INTO TABLE t")...)
+	hash := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	initSession := &mockSession{sql: "init session 100"}
+	dir := t.TempDir()
+	for _, loadData := range []bool{false, true} {
+		cpt := NewCapture(zap.NewNop())
+		writer := newMockWriter(store.WriterCfg{})
+		cfg := CaptureConfig{
+			Output:    dir,
+			Duration:  10 * time.Second,
+			LoadData:  loadData,
+			cmdLogger: writer,
+		}
+		removeMeta(dir)
+		require.NoError(t, cpt.Start(cfg))
+		// The statement is captured before it's executed and then captured with the hash after it finishes.
+		cpt.Capture(packet, time.Now(), 100, initSession)
+		cpt.CaptureLoadData(packet, time.Now(), 100, initSession, hash)
+		cpt.Stop(nil)
+
+		data := string(writer.getData())
+		if loadData {
+			require.Equal(t, 1, strings.Count(data, "LOAD DATA LOCAL INFILE"))
+			require.Equal(t, 1, strings.Count(data, hash))
+			require.Equal(t, uint64(2), cpt.capturedCmds)
+			require.Equal(t, uint64(0), cpt.filteredCmds)
+		} else {
+			require.NotContains(t, data, "LOAD DATA LOCAL INFILE")
+			require.Equal(t, uint64(1), cpt.capturedCmds)
+			require.Equal(t, uint64(1), cpt.filteredCmds)
+		}
+		cpt.Close()
+	}
+}
+
+func TestCaptureFilter(t *testing.T) {
+	cpt := NewCapture(zap.NewNop())
+	defer cpt.Close()
+
+	selectPacket := append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...)
+	insertPacket := append([]byte{pnet.ComQuery.Byte()}, []byte("insert into t values(1)")...)
+	quitPacket := []byte{pnet.ComQuit.Byte()}
+	writer := newMockWriter(store.WriterCfg{})
+	cfg := CaptureConfig{
+		Output:    t.TempDir(),
+		Duration:  10 * time.Second,
+		cmdLogger: writer,
+		Filter: CaptureFilter{
+			Users:    []string{"u1"},
+			ReadOnly: true,
+		},
+	}
+
+	require.NoError(t, cpt.Start(cfg))
+	// 100: created during the capture and captured.
+	cpt.InitConn(time.Now(), 100, ConnInfo{User: "u1", DB: "db100"})
+	cpt.Capture(selectPacket, time.Now(), 100, &mockSession{sql: "init session 100"})
+	cpt.Capture(insertPacket, time.Now(), 100, &mockSession{sql: "init session 100"})
+	// 101: created during the capture and excluded.
+	cpt.InitConn(time.Now(), 101, ConnInfo{User: "u2", DB: "db101"})
+	cpt.Capture(selectPacket, time.Now(), 101, &mockSession{sql: "init session 101"})
+	cpt.Capture(quitPacket, time.Now(), 101, nil)
+	// 102: created before the capture and captured. The session is initialized before the first captured command.
+	session102 := &mockSession{info: ConnInfo{User: "u1"}, sql: "init session 102"}
+	cpt.Capture(insertPacket, time.Now(), 102, session102)
+	cpt.Capture(selectPacket, time.Now(), 102, session102)
+	cpt.Capture(quitPacket, time.Now(), 102, nil)
+	// 103: created before the capture and excluded.
+	session103 := &mockSession{info: ConnInfo{User: "u2"}, sql: "init session 103"}
+	cpt.Capture(selectPacket, time.Now(), 103, session103)
+	cpt.Capture(selectPacket, time.Now(), 103, session103)
+	// The excluded connections are forgotten after they close, with or without COM_QUIT.
+	cpt.CloseConn(time.Now(), 101)
+	cpt.CloseConn(time.Now(), 103)
+	cpt.Lock()
+	require.Empty(t, cpt.excludedConns)
+	cpt.Unlock()
+	cpt.Stop(nil)
+
+	data := string(writer.getData())
+	require.Equal(t, 1, strings.Count(data, "db100"))
+	require.Equal(t, 0, strings.Count(data, "init session 100"))
+	require.Equal(t, 1, strings.Count(data, "init session 102"))
+	require.NotContains(t, data, "db101")
+	require.NotContains(t, data, "init session 101")
+	require.NotContains(t, data, "init session 103")
+	require.NotContains(t, data, "insert")
+	require.Equal(t, 2, strings.Count(data, "select 1"))
+	require.Equal(t, 1, strings.Count(data, "# Cmd_type: Quit"))
+	require.Equal(t, uint64(5), cpt.capturedCmds)
+
+	m := store.Meta{}
+	require.NoError(t, m.Read(cfg.Output))
+	require.Equal(t, uint64(5), m.Cmds)
+	require.Equal(t, "user=u1 read-only=true", m.Filter)
+	require.Equal(t, uint64(2), m.ExcludedConns)
+	require.Equal(t, uint64(6), m.ExcludedCmds)
+}
+
+func TestRecordResult(t *testing.T) {
+	cpt := NewCapture(zap.NewNop())
+	defer cpt.Close()
+
+	writer := newMockWriter(store.WriterCfg{})
+	cfg := CaptureConfig{
+		Output:       t.TempDir(),
+		Duration:     10 * time.Second,
+		RecordResult: true,
+		cmdLogger:    writer,
+	}
+	require.NoError(t, cpt.Start(cfg))
+	selectPacket := append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...)
+	cpt.InitConn(time.Now(), 100, ConnInfo{})
+	require.Equal(t, PendingResult, cpt.Capture(selectPacket, time.Now(), 100, mockInitSession))
+	// The command is written after the result is captured.
+	cpt.Lock()
+	require.Len(t, cpt.pendingCmds, 1)
+	require.Equal(t, uint64(0), cpt.capturedCmds)
+	cpt.Unlock()
+	// The duration is not recorded without RecordLatency.
+	cpt.CaptureResult(100, true, time.Second, &cmd.Result{Rows: 1, Digest: "abc"})
+	// The result of an unknown command is ignored.
+	cpt.CaptureResult(101, true, time.Second, &cmd.Result{AffectedRows: 10})
+	// QUIT has no result.
+	require.Equal(t, PendingNone, cpt.Capture([]byte{pnet.ComQuit.Byte()}, time.Now(), 100, mockInitSession))
+	cpt.Stop(nil)
+
+	data := string(writer.getData())
+	require.Contains(t, data, "# Affected_rows: 0\n# Result_rows: 1\n# Result_digest: abc\n")
+	require.NotContains(t, data, "# Affected_rows: 10")
+	require.NotContains(t, data, "# Query_time")
+	require.Equal(t, uint64(2), cpt.capturedCmds)
+	require.Empty(t, cpt.pendingCmds)
+}
+
+func TestRecordLatency(t *testing.T) {
+	cpt := NewCapture(zap.NewNop())
+	defer cpt.Close()
+
+	writer := newMockWriter(store.WriterCfg{})
+	cfg := CaptureConfig{
+		Output:        t.TempDir(),
+		Duration:      10 * time.Second,
+		RecordLatency: true,
+		cmdLogger:     writer,
+	}
+	require.NoError(t, cpt.Start(cfg))
+	selectPacket := append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...)
+	cpt.InitConn(time.Now(), 100, ConnInfo{})
+	require.Equal(t, PendingDuration, cpt.Capture(selectPacket, time.Now(), 100, mockInitSession))
+	// The result is not recorded without RecordResult.
+	cpt.CaptureResult(100, false, 1500*time.Millisecond, &cmd.Result{Rows: 1})
+	cpt.Stop(nil)
+
+	data := string(writer.getData())
+	require.Contains(t, data, "# Success: false\n# Query_time: 1.500000000\n")
+	require.NotContains(t, data, "# Result_rows")
+	require.Equal(t, uint64(1), cpt.capturedCmds)
+}
+
+func TestRecordUser(t *testing.T) {
+	cpt := NewCapture(zap.NewNop())
+	defer cpt.Close()
+
+	packet := append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...)
+	writer := newMockWriter(store.WriterCfg{})
+	cfg := CaptureConfig{
+		Output:    t.TempDir(),
+		Duration:  10 * time.Second,
+		cmdLogger: writer,
+	}
+	require.NoError(t, cpt.Start(cfg))
+	attrs := map[string]string{"program_name": "app"}
+	// 100: created during the capture with a database, the user is recorded with COM_INIT_DB.
+	cpt.InitConn(time.Now(), 100, ConnInfo{User: "u100", DB: "db100", Attrs: attrs})
+	cpt.Capture(packet, time.Now(), 100, mockInitSession)
+	// 101: created during the capture without a database, the user is recorded with the first command.
+	cpt.InitConn(time.Now(), 101, ConnInfo{User: "u101"})
+	cpt.Capture(append([]byte{pnet.ComQuery.Byte()}, []byte("create user u identified by '123456'")...), time.Now(), 101, mockInitSession)
+	cpt.Capture(packet, time.Now(), 101, mockInitSession)
+	cpt.Capture(packet, time.Now(), 101, mockInitSession)
+	// 102: created before the capture, the user is recorded with the init-session statement.
+	cpt.Capture(packet, time.Now(), 102, &mockSession{info: ConnInfo{User: "u102", Attrs: attrs}, sql: "init session 102"})
+	cpt.Stop(nil)
+
+	data := string(writer.getData())
+	require.Equal(t, 3, strings.Count(data, "# User: "))
+	require.Equal(t, 2, strings.Count(data, "# Conn_attrs: {\"program_name\":\"app\"}\n"))
+	require.Contains(t, data, "# User: u100\n# Conn_attrs: {\"program_name\":\"app\"}\n# Cmd_type: InitDB\n")
+	require.Contains(t, data, "# User: u101\n# Payload_len: 8\nselect 1\n")
+	require.Contains(t, data, "# User: u102\n# Conn_attrs: {\"program_name\":\"app\"}\n# Payload_len: 16\ninit session 102\n")
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package capture
+
+import (
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
+	"github.com/siddontang/go/hack"
+)
+
+// sampleBase is the resolution of the sample rate.
+const sampleBase = 10000
+
+// ConnInfo is the attributes of a connection that the capture filters by and records.
+type ConnInfo struct {
+	User      string
+	DB        string
+	Namespace string
+	// ClientAddr is the address of the client in the form of `ip:port`.
+	ClientAddr string
+	// Attrs are the connection attributes sent by the client. They are recorded so that replay can send them.
+	Attrs map[string]string
+}
+
+// Session is a client connection that may start before the capture.
+type Session interface {
+	// ConnInfo returns the attributes of the connection.
+	ConnInfo() ConnInfo
+	// InitSession returns a statement that restores the session states before the first captured command.
+	InitSession() (string, error)
+}
+
+// CaptureFilter selects the captured traffic. Empty fields match all.
+// The connection attributes are checked once when the connection is captured for the first time,
+// so that the commands of a connection are either all captured or all skipped.
+type CaptureFilter struct {
+	Users      []string
+	DBs        []string
+	Namespaces []string
+	// ClientCIDRs are the CIDRs of client addresses, e.g. `10.0.0.0/8`.
+	ClientCIDRs []string
+	// CmdTypes are the captured command types, e.g. `Query` and `StmtExecute`. QUIT is always captured.
+	CmdTypes []string
+	// ReadOnly only captures read-only COM_QUERY statements. Prepared statements are skipped because the statement
+	// types are unknown when they are executed.
+	ReadOnly bool
+	// SampleRate is the ratio of sampled connections in (0, 1]. 0 means capturing all connections.
+	SampleRate float64
+
+	clientNets []*net.IPNet
+	cmdTypes   []pnet.Command
+}
+
+// Validate checks the filter and parses the CIDRs and command types.
+func (f *CaptureFilter) Validate() error {
+	if f.SampleRate < 0 || f.SampleRate > 1 {
+		return errors.New("sample rate should be in (0, 1]")
+	}
+	f.clientNets = make([]*net.IPNet, 0, len(f.ClientCIDRs))
+	for _, cidr := range f.ClientCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Wrapf(err, "invalid client CIDR %s", cidr)
+		}
+		f.clientNets = append(f.clientNets, ipNet)
+	}
+	f.cmdTypes = make([]pnet.Command, 0, len(f.CmdTypes))
+	for _, cmdType := range f.CmdTypes {
+		cmd := pnet.CommandFromString(cmdType)
+		if cmd == pnet.ComEnd {
+			return errors.Errorf("invalid command type %s", cmdType)
+		}
+		f.cmdTypes = append(f.cmdTypes, cmd)
+	}
+	return nil
+}
+
+// Empty returns true if the filter captures all traffic.
+func (f *CaptureFilter) Empty() bool {
+	return len(f.Users) == 0 && len(f.DBs) == 0 && len(f.Namespaces) == 0 && len(f.ClientCIDRs) == 0 &&
+		len(f.CmdTypes) == 0 && !f.ReadOnly && f.SampleRate == 0
+}
+
+// String describes the filter so that users know the captured traffic is partial.
+func (f *CaptureFilter) String() string {
+	var parts []string
+	addList := func(name string, values []string) {
+		if len(values) > 0 {
+			parts = append(parts, name+"="+strings.Join(values, ","))
+		}
+	}
+	addList("user", f.Users)
+	addList("db", f.DBs)
+	addList("namespace", f.Namespaces)
+	addList("client-cidr", f.ClientCIDRs)
+	addList("cmd-type", f.CmdTypes)
+	if f.ReadOnly {
+		parts = append(parts, "read-only=true")
+	}
+	if f.SampleRate > 0 {
+		parts = append(parts, "sample-rate="+strconv.FormatFloat(f.SampleRate, 'f', -1, 64))
+	}
+	return strings.Join(parts, " ")
+}
+
+// matchConn returns whether the connection is captured.
+func (f *CaptureFilter) matchConn(connID uint64, info ConnInfo) bool {
+	if f.SampleRate > 0 && connID%sampleBase >= uint64(math.Round(f.SampleRate*sampleBase)) {
+		return false
+	}
+	if len(f.Users) > 0 && !slices.Contains(f.Users, info.User) {
+		return false
+	}
+	if len(f.DBs) > 0 && !slices.ContainsFunc(f.DBs, func(db string) bool { return strings.EqualFold(db, info.DB) }) {
+		return false
+	}
+	if len(f.Namespaces) > 0 && !slices.Contains(f.Namespaces, info.Namespace) {
+		return false
+	}
+	if len(f.clientNets) > 0 {
+		host, _, err := net.SplitHostPort(info.ClientAddr)
+		if err != nil {
+			host = info.ClientAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !slices.ContainsFunc(f.clientNets, func(ipNet *net.IPNet) bool { return ipNet.Contains(ip) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchCmd returns whether the command of a captured connection is captured.
+func (f *CaptureFilter) matchCmd(packet []byte) bool {
+	cmd := pnet.Command(packet[0])
+	if cmd == pnet.ComQuit {
+		return true
+	}
+	if len(f.cmdTypes) > 0 && !slices.Contains(f.cmdTypes, cmd) {
+		return false
+	}
+	if f.ReadOnly {
+		switch cmd {
+		case pnet.ComQuery:
+			return lex.IsReadOnly(hack.String(packet[1:]))
+		case pnet.ComStmtPrepare, pnet.ComStmtExecute, pnet.ComStmtSendLongData, pnet.ComStmtClose, pnet.ComStmtReset, pnet.ComStmtFetch:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package capture
+
+import (
+	"testing"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateFilter(t *testing.T) {
+	filters := []CaptureFilter{
+		{SampleRate: -0.1},
+		{SampleRate: 1.1},
+		{ClientCIDRs: []string{"10.0.0.1"}},
+		{CmdTypes: []string{"Select"}},
+	}
+	for i, filter := range filters {
+		require.Error(t, filter.Validate(), "case %d", i)
+	}
+
+	filter := CaptureFilter{}
+	require.NoError(t, filter.Validate())
+	require.True(t, filter.Empty())
+	require.Empty(t, filter.String())
+
+	filter = CaptureFilter{
+		Users:       []string{"u1", "u2"},
+		ClientCIDRs: []string{"10.0.0.0/8"},
+		CmdTypes:    []string{"Query"},
+		ReadOnly:    true,
+		SampleRate:  0.25,
+	}
+	require.NoError(t, filter.Validate())
+	require.False(t, filter.Empty())
+	require.Equal(t, "user=u1,u2 client-cidr=10.0.0.0/8 cmd-type=Query read-only=true sample-rate=0.25", filter.String())
+}
+
+func TestMatchConn(t *testing.T) {
+	info := ConnInfo{User: "u1", DB: "Test", Namespace: "ns1", ClientAddr: "10.0.0.1:3306"}
+	tests := []struct {
+		filter  CaptureFilter
+		connID  uint64
+		matched bool
+	}{
+		{CaptureFilter{}, 1, true},
+		{CaptureFilter{Users: []string{"u1", "u2"}}, 1, true},
+		{CaptureFilter{Users: []string{"u2"}}, 1, false},
+		{CaptureFilter{DBs: []string{"test"}}, 1, true},
+		{CaptureFilter{DBs: []string{"db"}}, 1, false},
+		{CaptureFilter{Namespaces: []string{"ns1"}}, 1, true},
+		{CaptureFilter{Namespaces: []string{"ns2"}}, 1, false},
+		{CaptureFilter{ClientCIDRs: []string{"10.0.0.0/8"}}, 1, true},
+		{CaptureFilter{ClientCIDRs: []string{"192.168.0.0/16", "10.0.0.0/24"}}, 1, true},
+		{CaptureFilter{ClientCIDRs: []string{"192.168.0.0/16"}}, 1, false},
+		{CaptureFilter{SampleRate: 0.1}, 999, true},
+		{CaptureFilter{SampleRate: 0.1}, 1000, false},
+		{CaptureFilter{SampleRate: 0.1}, 10999, true},
+		{CaptureFilter{SampleRate: 1}, 9999, true},
+		{CaptureFilter{Users: []string{"u1"}, Namespaces: []string{"ns2"}}, 1, false},
+	}
+	for i, test := range tests {
+		require.NoError(t, test.filter.Validate(), "case %d", i)
+		require.Equal(t, test.matched, test.filter.matchConn(test.connID, info), "case %d", i)
+	}
+}
+
+func TestMatchCmd(t *testing.T) {
+	query := func(sql string) []byte {
+		return append([]byte{pnet.ComQuery.Byte()}, []byte(sql)...)
+	}
+	tests := []struct {
+		filter  CaptureFilter
+		packet  []byte
+		matched bool
+	}{
+		{CaptureFilter{}, query("insert into t values(1)"), true},
+		{CaptureFilter{CmdTypes: []string{"Query"}}, query("select 1"), true},
+		{CaptureFilter{CmdTypes: []string{"Query"}}, []byte{pnet.ComStmtExecute.Byte()}, false},
+		{CaptureFilter{CmdTypes: []string{"Query"}}, []byte{pnet.ComQuit.Byte()}, true},
+		{CaptureFilter{ReadOnly: true}, query("select 1"), true},
+		{CaptureFilter{ReadOnly: true}, query("insert into t values(1)"), false},
+		{CaptureFilter{ReadOnly: true}, []byte{pnet.ComStmtExecute.Byte()}, false},
+		{CaptureFilter{ReadOnly: true}, []byte{pnet.ComPing.Byte()}, true},
+	}
+	for i, test := range tests {
+		require.NoError(t, test.filter.Validate(), "case %d", i)
+		require.Equal(t, test.matched, test.filter.matchCmd(test.packet), "case %d", i)
+	}
+}
@@ -38,6 +38,20 @@ func (w *mockWriter) Close() error {
 	return nil
 }
 
-func mockInitSession() (string, error) {
-	return "init session", nil
+var _ Session = (*mockSession)(nil)
+
+type mockSession struct {
+	info ConnInfo
+	sql  string
+	err  error
+}
+
+func (s *mockSession) ConnInfo() ConnInfo {
+	return s.info
 }
+
+func (s *mockSession) InitSession() (string, error) {
+	return s.sql, s.err
+}
+
+var mockInitSession = &mockSession{sql: "init session"}
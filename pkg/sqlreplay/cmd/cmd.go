@@ -5,8 +5,10 @@ package cmd
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"strconv"
 	"strings"
 	"time"
@@ -26,6 +28,13 @@ const (
 	keyType         = "# Cmd_type: "
 	keySuccess      = "# Success: "
 	keyPayloadLen   = "# Payload_len: "
+	keyLoadDataHash = "# Load_data_hash: "
+	keyAffectedRows = "# Affected_rows: "
+	keyResultRows   = "# Result_rows: "
+	keyResultDigest = "# Result_digest: "
+	keyQueryTime    = "# Query_time: "
+	keyUser         = "# User: "
+	keyConnAttrs    = "# Conn_attrs: "
 )
 
 type LineReader interface {
@@ -45,6 +54,38 @@ type Command struct {
 	ConnID   uint64
 	Type     pnet.Command
 	Succeess bool
+	// LoadDataHash is the SHA-256 of the file uploaded by LOAD DATA LOCAL INFILE. The file itself is not captured.
+	LoadDataHash string
+	// Result is the result of the command during capture. It's nil if the result is not captured.
+	Result *Result
+	// Duration is the execution duration of the command during capture. It's 0 if the latency is not captured.
+	Duration time.Duration
+	// User and ConnAttrs are the username and the connection attributes of the connection.
+	// They are only recorded in the first command of each connection.
+	User      string
+	ConnAttrs map[string]string
+}
+
+// Result is the metadata of the response of a command, which is compared between capture and replay.
+type Result struct {
+	AffectedRows uint64
+	Rows         uint64
+	// Digest is the digest of the column types and the rows of the result sets. It's empty if there are no result sets.
+	Digest string
+}
+
+func (r *Result) Equal(that *Result) bool {
+	if r == nil || that == nil {
+		return r == that
+	}
+	return *r == *that
+}
+
+func (r *Result) String() string {
+	if r == nil {
+		return ""
+	}
+	return fmt.Sprintf("affected_rows=%d rows=%d digest=%s", r.AffectedRows, r.Rows, r.Digest)
 }
 
 func NewCommand(packet []byte, startTs time.Time, connID uint64) *Command {
@@ -69,6 +110,11 @@ func (c *Command) Equal(that *Command) bool {
 		c.ConnID == that.ConnID &&
 		c.Type == that.Type &&
 		c.Succeess == that.Succeess &&
+		c.LoadDataHash == that.LoadDataHash &&
+		c.Result.Equal(that.Result) &&
+		c.Duration == that.Duration &&
+		c.User == that.User &&
+		maps.Equal(c.ConnAttrs, that.ConnAttrs) &&
 		bytes.Equal(c.Payload, that.Payload)
 }
 
@@ -93,6 +139,20 @@ func (c *Command) Encode(writer *bytes.Buffer) error {
 	if err = writeString(keyConnID, strconv.FormatUint(c.ConnID, 10), writer); err != nil {
 		return err
 	}
+	if c.User != "" {
+		if err = writeString(keyUser, c.User, writer); err != nil {
+			return err
+		}
+	}
+	if len(c.ConnAttrs) > 0 {
+		attrs, err := json.Marshal(c.ConnAttrs)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err = writeString(keyConnAttrs, hack.String(attrs), writer); err != nil {
+			return err
+		}
+	}
 	if c.Type != pnet.ComQuery {
 		if err = writeString(keyType, c.Type.String(), writer); err != nil {
 			return err
@@ -103,6 +163,30 @@ func (c *Command) Encode(writer *bytes.Buffer) error {
 			return err
 		}
 	}
+	if c.LoadDataHash != "" {
+		if err = writeString(keyLoadDataHash, c.LoadDataHash, writer); err != nil {
+			return err
+		}
+	}
+	if c.Result != nil {
+		if err = writeString(keyAffectedRows, strconv.FormatUint(c.Result.AffectedRows, 10), writer); err != nil {
+			return err
+		}
+		if err = writeString(keyResultRows, strconv.FormatUint(c.Result.Rows, 10), writer); err != nil {
+			return err
+		}
+		if c.Result.Digest != "" {
+			if err = writeString(keyResultDigest, c.Result.Digest, writer); err != nil {
+				return err
+			}
+		}
+	}
+	if c.Duration > 0 {
+		// Same as the TiDB slow log, the unit is second.
+		if err = writeString(keyQueryTime, fmt.Sprintf("%d.%09d", c.Duration/time.Second, c.Duration%time.Second), writer); err != nil {
+			return err
+		}
+	}
 	// `Payload_len` doesn't include the command type.
 	if err = writeString(keyPayloadLen, strconv.Itoa(len(c.Payload[1:])), writer); err != nil {
 		return err
@@ -164,6 +248,37 @@ func (c *Command) Decode(reader LineReader) error {
 			c.Type = pnet.CommandFromString(value)
 		case keySuccess:
 			c.Succeess = value == "true"
+		case keyLoadDataHash:
+			c.LoadDataHash = value
+		case keyAffectedRows:
+			if c.Result == nil {
+				c.Result = &Result{}
+			}
+			if c.Result.AffectedRows, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return errors.Errorf("%s, line %d: parsing Affected_rows failed: %s", filename, lineIdx, line)
+			}
+		case keyResultRows:
+			if c.Result == nil {
+				c.Result = &Result{}
+			}
+			if c.Result.Rows, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return errors.Errorf("%s, line %d: parsing Result_rows failed: %s", filename, lineIdx, line)
+			}
+		case keyResultDigest:
+			if c.Result == nil {
+				c.Result = &Result{}
+			}
+			c.Result.Digest = value
+		case keyUser:
+			c.User = value
+		case keyConnAttrs:
+			if err = json.Unmarshal(hack.Slice(value), &c.ConnAttrs); err != nil {
+				return errors.Errorf("%s, line %d: parsing Conn_attrs failed: %s", filename, lineIdx, line)
+			}
+		case keyQueryTime:
+			if c.Duration, err = time.ParseDuration(value + "s"); err != nil {
+				return errors.Errorf("%s, line %d: parsing Query_time failed: %s", filename, lineIdx, line)
+			}
 		case keyPayloadLen:
 			var payloadLen int
 			if payloadLen, err = strconv.Atoi(value); err != nil {
@@ -14,8 +14,13 @@ import (
 
 func TestEncode(t *testing.T) {
 	tests := []struct {
-		payload []byte
-		cmd     pnet.Command
+		payload      []byte
+		cmd          pnet.Command
+		loadDataHash string
+		result       *Result
+		duration     time.Duration
+		user         string
+		attrs        map[string]string
 	}{
 		{
 			cmd:     pnet.ComQuery,
@@ -33,6 +38,37 @@ func TestEncode(t *testing.T) {
 			cmd:     pnet.ComStmtExecute,
 			payload: []byte("1\n2\n"),
 		},
+		{
+			cmd:          pnet.ComQuery,
+			payload:      []byte("load data local infile '/tmp/t.csv' into table t"),
+			loadDataHash: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+		{
+			cmd:     pnet.ComQuery,
+			payload: []byte("insert into t values(1)"),
+			result:  &Result{AffectedRows: 1},
+		},
+		{
+			cmd:     pnet.ComStmtExecute,
+			payload: []byte{0x01, 0x02},
+			result:  &Result{Rows: 3, Digest: "0123456789abcdef0123456789abcdef"},
+		},
+		{
+			cmd:      pnet.ComQuery,
+			payload:  []byte("select 1"),
+			duration: 1234567 * time.Microsecond,
+		},
+		{
+			cmd:      pnet.ComQuery,
+			payload:  []byte("select 1"),
+			duration: time.Nanosecond,
+		},
+		{
+			cmd:     pnet.ComInitDB,
+			payload: []byte("test"),
+			user:    "u1",
+			attrs:   map[string]string{"_client_name": "libmysql", "program_name": "my\napp"},
+		},
 		{
 			cmd: pnet.ComQuit,
 		},
@@ -44,6 +80,11 @@ func TestEncode(t *testing.T) {
 		packet := append([]byte{byte(test.cmd)}, test.payload...)
 		now := time.Now()
 		cmd := NewCommand(packet, now, 100)
+		cmd.LoadDataHash = test.loadDataHash
+		cmd.Result = test.result
+		cmd.Duration = test.duration
+		cmd.User = test.user
+		cmd.ConnAttrs = test.attrs
 		require.NoError(t, cmd.Encode(&buf), "case %d", i)
 		cmds = append(cmds, cmd)
 	}
@@ -114,6 +155,24 @@ select 1
 		`# Time: 2024-08-28T18:51:20.477067+08:00
 # Conn_ID: 100
 # Payload_len: abc
+`,
+		`# Time: 2024-08-28T18:51:20.477067+08:00
+# Conn_ID: 100
+# Affected_rows: abc
+# Payload_len: 8
+select 1
+`,
+		`# Time: 2024-08-28T18:51:20.477067+08:00
+# Conn_ID: 100
+# Query_time: abc
+# Payload_len: 8
+select 1
+`,
+		`# Time: 2024-08-28T18:51:20.477067+08:00
+# Conn_ID: 100
+# Conn_attrs: {"a"
+# Payload_len: 8
+select 1
 `,
 	}
 
@@ -13,13 +13,15 @@ import (
 
 	"github.com/pingcap/tiproxy/pkg/proxy/backend"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 	"github.com/siddontang/go/hack"
 	"go.uber.org/zap"
 )
 
 type BackendConnManager interface {
-	Connect(ctx context.Context, clientIO pnet.PacketIO, frontendTLSConfig, backendTLSConfig *tls.Config, username, password string) error
+	ConnectWithCredential(ctx context.Context, clientIO pnet.PacketIO, backendTLSConfig *tls.Config, cred backend.Credential) error
 	ExecuteCmd(ctx context.Context, request []byte) error
+	LastResult() *cmd.Result
 	ConnectionID() uint64
 	Close() error
 }
@@ -30,6 +32,8 @@ type BackendConn interface {
 	Connect(ctx context.Context) error
 	ConnID() uint64
 	ExecuteCmd(ctx context.Context, request []byte) error
+	// LastResult returns the result of the last command executed by ExecuteCmd. It's nil if it's unknown.
+	LastResult() *cmd.Result
 	Query(ctx context.Context, stmt string) error
 	PrepareStmt(ctx context.Context, stmt string) (stmtID uint32, err error)
 	ExecuteStmt(ctx context.Context, stmtID uint32, args []any) error
@@ -42,8 +46,7 @@ var _ BackendConn = (*backendConn)(nil)
 type backendConn struct {
 	// only stores binary encoded prepared statements
 	preparedStmts    map[uint32]preparedStmt
-	username         string
-	password         string
+	cred             backend.Credential
 	clientIO         *packetIO
 	backendTLSConfig *tls.Config
 	lg               *zap.Logger
@@ -51,11 +54,10 @@ type backendConn struct {
 }
 
 func NewBackendConn(lg *zap.Logger, connID uint64, hsHandler backend.HandshakeHandler, bcConfig *backend.BCConfig,
-	backendTLSConfig *tls.Config, username, password string) *backendConn {
+	backendTLSConfig *tls.Config, cred backend.Credential) *backendConn {
 	return &backendConn{
 		preparedStmts:    make(map[uint32]preparedStmt),
-		username:         username,
-		password:         password,
+		cred:             cred,
 		clientIO:         newPacketIO(),
 		backendTLSConfig: backendTLSConfig,
 		lg:               lg,
@@ -64,7 +66,7 @@ func NewBackendConn(lg *zap.Logger, connID uint64, hsHandler backend.HandshakeHa
 }
 
 func (bc *backendConn) Connect(ctx context.Context) error {
-	err := bc.backendConnMgr.Connect(ctx, bc.clientIO, nil, bc.backendTLSConfig, bc.username, bc.password)
+	err := bc.backendConnMgr.ConnectWithCredential(ctx, bc.clientIO, bc.backendTLSConfig, bc.cred)
 	bc.clientIO.Reset()
 	return err
 }
@@ -82,6 +84,10 @@ func (bc *backendConn) ExecuteCmd(ctx context.Context, request []byte) error {
 	return err
 }
 
+func (bc *backendConn) LastResult() *cmd.Result {
+	return bc.backendConnMgr.LastResult()
+}
+
 func (bc *backendConn) updatePreparedStmts(request, response []byte) {
 	switch request[0] {
 	case pnet.ComStmtPrepare.Byte():
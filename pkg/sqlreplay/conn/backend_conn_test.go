@@ -17,7 +17,7 @@ import (
 )
 
 func TestBackendConn(t *testing.T) {
-	backendConn := NewBackendConn(zap.NewNop(), 1, nil, &backend.BCConfig{}, nil, "u1", "")
+	backendConn := NewBackendConn(zap.NewNop(), 1, nil, &backend.BCConfig{}, nil, backend.Credential{User: "u1"})
 	backendConnMgr := &mockBackendConnMgr{}
 	backendConn.backendConnMgr = backendConnMgr
 	require.NoError(t, backendConn.Connect(context.Background()))
@@ -99,7 +99,7 @@ func TestPreparedStmt(t *testing.T) {
 	}
 
 	lg, _ := logger.CreateLoggerForTest(t)
-	backendConn := NewBackendConn(lg, 1, nil, &backend.BCConfig{}, nil, "u1", "")
+	backendConn := NewBackendConn(lg, 1, nil, &backend.BCConfig{}, nil, backend.Credential{User: "u1"})
 	for i, test := range tests {
 		backendConn.updatePreparedStmts(test.request, test.response)
 		require.Equal(t, test.preparedStmts, backendConn.preparedStmts, "case %d", i)
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	glist "github.com/bahlo/generic-list-go"
 	"github.com/pingcap/tiproxy/pkg/manager/id"
@@ -26,12 +27,24 @@ type ReplayStats struct {
 	PendingCmds atomic.Int64
 	// FilteredCmds is the number of filtered commands.
 	FilteredCmds atomic.Uint64
+	// FailedCmds is the number of executed commands that returned errors.
+	FailedCmds atomic.Uint64
+	// MismatchedCmds is the number of executed commands whose results are different from the captured ones.
+	MismatchedCmds atomic.Uint64
+	// TotalLatency is the total execution time of the executed commands in nanoseconds.
+	TotalLatency atomic.Int64
+	// Latencies compares the latencies of each digest between capture and replay.
+	Latencies LatencyStats
 }
 
 func (s *ReplayStats) Reset() {
 	s.ReplayedCmds.Store(0)
 	s.PendingCmds.Store(0)
 	s.FilteredCmds.Store(0)
+	s.FailedCmds.Store(0)
+	s.MismatchedCmds.Store(0)
+	s.TotalLatency.Store(0)
+	s.Latencies.Reset()
 }
 
 type Conn interface {
@@ -40,7 +53,8 @@ type Conn interface {
 	Stop()
 }
 
-type ConnCreator func(connID uint64) Conn
+// ConnCreator creates a connection with the captured user and connection attributes, which may be empty.
+type ConnCreator func(connID uint64, user string, attrs map[string]string) Conn
 
 var _ Conn = (*conn)(nil)
 
@@ -58,7 +72,7 @@ type conn struct {
 	readonly        bool
 }
 
-func NewConn(lg *zap.Logger, username, password string, backendTLSConfig *tls.Config, hsHandler backend.HandshakeHandler,
+func NewConn(lg *zap.Logger, cred backend.Credential, backendTLSConfig *tls.Config, hsHandler backend.HandshakeHandler,
 	idMgr *id.IDManager, connID uint64, bcConfig *backend.BCConfig, exceptionCh chan<- Exception, closeCh chan<- uint64,
 	readonly bool, replayStats *ReplayStats) *conn {
 	backendConnID := idMgr.NewID()
@@ -70,7 +84,7 @@ func NewConn(lg *zap.Logger, username, password string, backendTLSConfig *tls.Co
 		cmdCh:       make(chan struct{}, 1),
 		exceptionCh: exceptionCh,
 		closeCh:     closeCh,
-		backendConn: NewBackendConn(lg.Named("be"), backendConnID, hsHandler, bcConfig, backendTLSConfig, username, password),
+		backendConn: NewBackendConn(lg.Named("be"), backendConnID, hsHandler, bcConfig, backendTLSConfig, cred),
 		replayStats: replayStats,
 		readonly:    readonly,
 	}
@@ -106,6 +120,11 @@ func (c *conn) Run(ctx context.Context) {
 			if command == nil {
 				break
 			}
+			// The file of LOAD DATA LOCAL INFILE is not captured, so it can't be replayed.
+			if command.Value.LoadDataHash != "" {
+				c.replayStats.FilteredCmds.Add(1)
+				continue
+			}
 			if c.readonly {
 				c.updateCmdForExecuteStmt(command.Value)
 				if !command.Value.ReadOnly() {
@@ -113,7 +132,12 @@ func (c *conn) Run(ctx context.Context) {
 					continue
 				}
 			}
-			if err := c.backendConn.ExecuteCmd(ctx, command.Value.Payload); err != nil {
+			startTime := time.Now()
+			err := c.backendConn.ExecuteCmd(ctx, command.Value.Payload)
+			latency := time.Since(startTime)
+			c.replayStats.TotalLatency.Add(int64(latency))
+			if err != nil {
+				c.replayStats.FailedCmds.Add(1)
 				if pnet.IsDisconnectError(err) {
 					c.exceptionCh <- NewOtherException(err, c.connID)
 					c.lg.Debug("backend connection disconnected", zap.Error(err))
@@ -122,6 +146,14 @@ func (c *conn) Run(ctx context.Context) {
 				if c.updateCmdForExecuteStmt(command.Value) {
 					c.exceptionCh <- NewFailException(err, command.Value)
 				}
+			} else {
+				if command.Value.Result != nil {
+					c.compareResult(command.Value)
+				}
+				// The latency of a failed command is meaningless.
+				if command.Value.Duration > 0 && command.Value.Succeess && c.updateCmdForExecuteStmt(command.Value) {
+					c.replayStats.Latencies.Add(command.Value, latency)
+				}
 			}
 			c.replayStats.ReplayedCmds.Add(1)
 			if command.Value.Type == pnet.ComQuit {
@@ -131,6 +163,18 @@ func (c *conn) Run(ctx context.Context) {
 	}
 }
 
+// compareResult reports a mismatch if the command succeeds but its result is different from the captured one.
+func (c *conn) compareResult(command *cmd.Command) {
+	actual := c.backendConn.LastResult()
+	if actual == nil || (command.Succeess && command.Result.Equal(actual)) {
+		return
+	}
+	c.replayStats.MismatchedCmds.Add(1)
+	if c.updateCmdForExecuteStmt(command) {
+		c.exceptionCh <- NewMismatchException(command, actual)
+	}
+}
+
 // updateCmdForExecuteStmt may be called multiple times, avoid duplicated works.
 func (c *conn) updateCmdForExecuteStmt(command *cmd.Command) bool {
 	if command.PreparedStmt != "" {
@@ -39,7 +39,7 @@ func TestConnectError(t *testing.T) {
 	var wg waitgroup.WaitGroup
 	for i, test := range tests {
 		exceptionCh, closeCh := make(chan Exception, 1), make(chan uint64, 1)
-		conn := NewConn(lg, "u1", "", nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, &ReplayStats{})
+		conn := NewConn(lg, backend.Credential{User: "u1"}, nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, &ReplayStats{})
 		backendConn := newMockBackendConn()
 		backendConn.connErr, backendConn.execErr = test.connErr, test.execErr
 		conn.backendConn = backendConn
@@ -62,7 +62,7 @@ func TestExecuteCmd(t *testing.T) {
 	var wg waitgroup.WaitGroup
 	exceptionCh, closeCh := make(chan Exception, 1), make(chan uint64, 1)
 	stats := &ReplayStats{}
-	conn := NewConn(lg, "u1", "", nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, stats)
+	conn := NewConn(lg, backend.Credential{User: "u1"}, nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, stats)
 	backendConn := newMockBackendConn()
 	conn.backendConn = backendConn
 	childCtx, cancel := context.WithCancel(context.Background())
@@ -85,7 +85,7 @@ func TestStopExecution(t *testing.T) {
 	lg, _ := logger.CreateLoggerForTest(t)
 	var wg waitgroup.WaitGroup
 	exceptionCh, closeCh := make(chan Exception, 1), make(chan uint64, 1)
-	conn := NewConn(lg, "u1", "", nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, &ReplayStats{})
+	conn := NewConn(lg, backend.Credential{User: "u1"}, nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, &ReplayStats{})
 	conn.backendConn = newMockBackendConn()
 	wg.RunWithRecover(func() {
 		conn.Run(context.Background())
@@ -130,7 +130,7 @@ func TestExecuteError(t *testing.T) {
 	lg, _ := logger.CreateLoggerForTest(t)
 	var wg waitgroup.WaitGroup
 	exceptionCh, closeCh := make(chan Exception, 1), make(chan uint64, 1)
-	conn := NewConn(lg, "u1", "", nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, &ReplayStats{})
+	conn := NewConn(lg, backend.Credential{User: "u1"}, nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, &ReplayStats{})
 	backendConn := newMockBackendConn()
 	backendConn.execErr = errors.New("mock error")
 	conn.backendConn = backendConn
@@ -198,7 +198,7 @@ func TestSkipReadOnly(t *testing.T) {
 	var wg waitgroup.WaitGroup
 	exceptionCh, closeCh := make(chan Exception, 1), make(chan uint64, 1)
 	stats := &ReplayStats{}
-	conn := NewConn(lg, "u1", "", nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, true, stats)
+	conn := NewConn(lg, backend.Credential{User: "u1"}, nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, true, stats)
 	conn.backendConn = newMockBackendConn()
 	childCtx, cancel := context.WithCancel(context.Background())
 	wg.RunWithRecover(func() {
@@ -221,3 +221,110 @@ func TestSkipReadOnly(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+func TestSkipLoadData(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	var wg waitgroup.WaitGroup
+	exceptionCh, closeCh := make(chan Exception, 1), make(chan uint64, 1)
+	stats := &ReplayStats{}
+	conn := NewConn(lg, backend.Credential{User: "u1"}, nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, stats)
+	conn.backendConn = newMockBackendConn()
+	childCtx, cancel := context.WithCancel(context.Background())
+	wg.RunWithRecover(func() {
+		conn.Run(childCtx)
+	}, nil, lg)
+	// The file is not captured, so the statement is skipped.
+	conn.ExecuteCmd(&cmd.Command{Type: pnet.ComQuery, Payload: append([]byte{pnet.ComQuery.Byte()}, []byte("load data local infile '/tmp/t.csv' into table t")...),
+		LoadDataHash: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"})
+	conn.ExecuteCmd(&cmd.Command{Type: pnet.ComQuery, Payload: append([]byte{pnet.ComQuery.Byte()}, []byte("insert into t value(1)")...)})
+	require.Eventually(t, func() bool {
+		return stats.ReplayedCmds.Load()+stats.FilteredCmds.Load() == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	require.EqualValues(t, 1, stats.ReplayedCmds.Load())
+	require.EqualValues(t, 1, stats.FilteredCmds.Load())
+	cancel()
+	wg.Wait()
+}
+
+func TestCompareResult(t *testing.T) {
+	result := &cmd.Result{Rows: 1, Digest: "abc"}
+	tests := []struct {
+		captured  *cmd.Result
+		succeeded bool
+		replayed  *cmd.Result
+		mismatch  bool
+	}{
+		{captured: result, succeeded: true, replayed: &cmd.Result{Rows: 1, Digest: "abc"}},
+		{captured: result, succeeded: true, replayed: &cmd.Result{Rows: 1, Digest: "def"}, mismatch: true},
+		{captured: result, succeeded: true, replayed: &cmd.Result{Rows: 2, Digest: "abc"}, mismatch: true},
+		// The command failed during capture but succeeded during replay.
+		{captured: &cmd.Result{}, succeeded: false, replayed: &cmd.Result{}, mismatch: true},
+		// The result is unknown during replay.
+		{captured: result, succeeded: true},
+		// The result is not captured.
+		{succeeded: true, replayed: result},
+	}
+
+	lg, _ := logger.CreateLoggerForTest(t)
+	var wg waitgroup.WaitGroup
+	exceptionCh, closeCh := make(chan Exception, 1), make(chan uint64, 1)
+	stats := &ReplayStats{}
+	conn := NewConn(lg, backend.Credential{User: "u1"}, nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, stats)
+	backendConn := newMockBackendConn()
+	conn.backendConn = backendConn
+	childCtx, cancel := context.WithCancel(context.Background())
+	wg.RunWithRecover(func() {
+		conn.Run(childCtx)
+	}, nil, lg)
+	var mismatches uint64
+	for i, test := range tests {
+		backendConn.result = test.replayed
+		command := cmd.NewCommand(append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...), time.Now(), 100)
+		command.Result, command.Succeess = test.captured, test.succeeded
+		replayedCmds := stats.ReplayedCmds.Load()
+		conn.ExecuteCmd(command)
+		require.Eventually(t, func() bool {
+			return stats.ReplayedCmds.Load() > replayedCmds
+		}, 3*time.Second, time.Millisecond, "case %d", i)
+		if test.mismatch {
+			mismatches++
+			exp := <-exceptionCh
+			require.Equal(t, Mismatch, exp.Type(), "case %d", i)
+			require.Equal(t, test.replayed.String(), exp.(*MismatchException).Actual(), "case %d", i)
+		} else {
+			require.Len(t, exceptionCh, 0, "case %d", i)
+		}
+		require.Equal(t, mismatches, stats.MismatchedCmds.Load(), "case %d", i)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestRecordLatency(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	var wg waitgroup.WaitGroup
+	exceptionCh, closeCh := make(chan Exception, 1), make(chan uint64, 1)
+	stats := &ReplayStats{}
+	conn := NewConn(lg, backend.Credential{User: "u1"}, nil, nil, id.NewIDManager(), 1, &backend.BCConfig{}, exceptionCh, closeCh, false, stats)
+	conn.backendConn = newMockBackendConn()
+	childCtx, cancel := context.WithCancel(context.Background())
+	wg.RunWithRecover(func() {
+		conn.Run(childCtx)
+	}, nil, lg)
+	// The command without the captured duration or failed during capture is not recorded.
+	for i, duration := range []time.Duration{0, time.Millisecond, time.Millisecond} {
+		command := cmd.NewCommand(append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...), time.Now(), 100)
+		command.Duration = duration
+		command.Succeess = i != 1
+		conn.ExecuteCmd(command)
+	}
+	require.Eventually(t, func() bool {
+		return stats.ReplayedCmds.Load() == 3
+	}, 3*time.Second, time.Millisecond)
+	summaries := stats.Latencies.Collect()
+	require.Len(t, summaries, 1)
+	require.Equal(t, uint64(1), summaries[0].Count)
+	require.Equal(t, time.Millisecond, summaries[0].CaptureAvg)
+	cancel()
+	wg.Wait()
+}
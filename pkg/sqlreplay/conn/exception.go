@@ -19,6 +19,8 @@ const (
 	Other ExceptionType = iota
 	// execute error
 	Fail
+	// the result is different from the captured one
+	Mismatch
 	// the error type count
 	Total
 )
@@ -27,6 +29,7 @@ func (t ExceptionType) String() string {
 	return [...]string{
 		"Other",
 		"Fail",
+		"Mismatch",
 	}[t]
 }
 
@@ -83,11 +86,16 @@ type FailException struct {
 }
 
 func NewFailException(err error, command *cmd.Command) *FailException {
-	fail := &FailException{
+	return &FailException{
+		key:     commandKey(command),
 		err:     err,
 		command: command,
 		ts:      time.Now(),
 	}
+}
+
+// commandKey groups the exceptions of the same statement.
+func commandKey(command *cmd.Command) string {
 	var b []byte
 	switch command.Type {
 	case pnet.ComQuery, pnet.ComStmtPrepare, pnet.ComStmtExecute, pnet.ComStmtClose, pnet.ComStmtSendLongData,
@@ -99,8 +107,7 @@ func NewFailException(err error, command *cmd.Command) *FailException {
 	default:
 		b = []byte{command.Type.Byte()}
 	}
-	fail.key = hack.String(b)
-	return fail
+	return hack.String(b)
 }
 
 func (fe *FailException) Type() ExceptionType {
@@ -126,3 +133,53 @@ func (fe *FailException) Command() *cmd.Command {
 func (fe *FailException) Error() string {
 	return fe.err.Error()
 }
+
+// MismatchException means the result of a command during replay is different from the captured one.
+type MismatchException struct {
+	key     string
+	ts      time.Time
+	command *cmd.Command
+	actual  *cmd.Result
+}
+
+func NewMismatchException(command *cmd.Command, actual *cmd.Result) *MismatchException {
+	return &MismatchException{
+		key:     commandKey(command),
+		command: command,
+		actual:  actual,
+		ts:      time.Now(),
+	}
+}
+
+func (me *MismatchException) Type() ExceptionType {
+	return Mismatch
+}
+
+func (me *MismatchException) Key() string {
+	return me.key
+}
+
+func (me *MismatchException) ConnID() uint64 {
+	return me.command.ConnID
+}
+
+func (me *MismatchException) Time() time.Time {
+	return me.ts
+}
+
+func (me *MismatchException) Command() *cmd.Command {
+	return me.command
+}
+
+// Expected returns the captured result.
+func (me *MismatchException) Expected() string {
+	if !me.command.Succeess {
+		return "failed"
+	}
+	return me.command.Result.String()
+}
+
+// Actual returns the replayed result.
+func (me *MismatchException) Actual() string {
+	return me.actual.String()
+}
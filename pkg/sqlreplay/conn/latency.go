@@ -0,0 +1,151 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package conn
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
+)
+
+// bucketsPerDoubling is the number of histogram buckets between x and 2x, so the relative error of the quantiles is
+// less than 2^(1/8)-1, which is about 9%.
+const bucketsPerDoubling = 8
+
+// histogram records durations in exponential buckets so that the memory doesn't grow with the number of commands.
+// The upper bound of the i-th bucket is 2^(i/bucketsPerDoubling) microseconds.
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     time.Duration
+}
+
+func (h *histogram) add(d time.Duration) {
+	idx := 0
+	if us := float64(d) / float64(time.Microsecond); us > 1 {
+		idx = int(math.Ceil(math.Log2(us) * bucketsPerDoubling))
+	}
+	if idx >= len(h.buckets) {
+		h.buckets = append(h.buckets, make([]uint64, idx+1-len(h.buckets))...)
+	}
+	h.buckets[idx]++
+	h.count++
+	h.sum += d
+}
+
+// quantile returns the upper bound of the bucket where the q-quantile is.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	var cnt uint64
+	for i, bucket := range h.buckets {
+		cnt += bucket
+		if cnt >= rank {
+			return time.Duration(math.Pow(2, float64(i)/bucketsPerDoubling) * float64(time.Microsecond))
+		}
+	}
+	return 0
+}
+
+func (h *histogram) avg() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+type digestLatency struct {
+	cmdType    pnet.Command
+	digest     string
+	sampleStmt string
+	capture    histogram
+	replay     histogram
+	// updated is true if the latencies change since the last collection.
+	updated bool
+}
+
+// LatencySummary compares the latencies of the commands of one digest between capture and replay.
+type LatencySummary struct {
+	CmdType    pnet.Command
+	Digest     string
+	SampleStmt string
+	Count      uint64
+	CaptureP50 time.Duration
+	CaptureP99 time.Duration
+	CaptureAvg time.Duration
+	ReplayP50  time.Duration
+	ReplayP99  time.Duration
+	ReplayAvg  time.Duration
+}
+
+// Regressed returns true if the P99 or the average latency of replay exceeds that of capture by the threshold ratio.
+func (s *LatencySummary) Regressed(threshold float64) bool {
+	return float64(s.ReplayP99) > float64(s.CaptureP99)*(1+threshold) ||
+		float64(s.ReplayAvg) > float64(s.CaptureAvg)*(1+threshold)
+}
+
+// LatencyStats records the latencies of each digest during capture and replay.
+type LatencyStats struct {
+	sync.Mutex
+	digests map[string]*digestLatency
+}
+
+func (s *LatencyStats) Reset() {
+	s.Lock()
+	s.digests = nil
+	s.Unlock()
+}
+
+// Add records the latency of a successful command whose captured duration is known.
+func (s *LatencyStats) Add(command *cmd.Command, latency time.Duration) {
+	key := commandKey(command)
+	s.Lock()
+	defer s.Unlock()
+	if s.digests == nil {
+		s.digests = make(map[string]*digestLatency)
+	}
+	dl, ok := s.digests[key]
+	if !ok {
+		dl = &digestLatency{
+			cmdType:    command.Type,
+			digest:     command.Digest(),
+			sampleStmt: command.QueryText(),
+		}
+		s.digests[key] = dl
+	}
+	dl.capture.add(command.Duration)
+	dl.replay.add(latency)
+	dl.updated = true
+}
+
+// Collect returns the cumulative latencies of the digests that are updated since the last collection.
+func (s *LatencyStats) Collect() []LatencySummary {
+	s.Lock()
+	defer s.Unlock()
+	var summaries []LatencySummary
+	for _, dl := range s.digests {
+		if !dl.updated {
+			continue
+		}
+		dl.updated = false
+		summaries = append(summaries, LatencySummary{
+			CmdType:    dl.cmdType,
+			Digest:     dl.digest,
+			SampleStmt: dl.sampleStmt,
+			Count:      dl.replay.count,
+			CaptureP50: dl.capture.quantile(0.5),
+			CaptureP99: dl.capture.quantile(0.99),
+			CaptureAvg: dl.capture.avg(),
+			ReplayP50:  dl.replay.quantile(0.5),
+			ReplayP99:  dl.replay.quantile(0.99),
+			ReplayAvg:  dl.replay.avg(),
+		})
+	}
+	return summaries
+}
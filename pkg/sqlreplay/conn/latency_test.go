@@ -0,0 +1,73 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package conn
+
+import (
+	"testing"
+	"time"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	var h histogram
+	require.Equal(t, time.Duration(0), h.quantile(0.99))
+	require.Equal(t, time.Duration(0), h.avg())
+	for i := 1; i <= 100; i++ {
+		h.add(time.Duration(i) * time.Millisecond)
+	}
+	require.Equal(t, uint64(100), h.count)
+	require.Equal(t, 50500*time.Microsecond, h.avg())
+	// The relative error is less than 10%.
+	for _, q := range []float64{0.01, 0.5, 0.99, 1} {
+		expected := time.Duration(q*100) * time.Millisecond
+		actual := h.quantile(q)
+		require.GreaterOrEqual(t, actual, expected, "quantile %f", q)
+		require.Less(t, float64(actual), float64(expected)*1.1, "quantile %f", q)
+	}
+	h.add(0)
+	require.Equal(t, time.Microsecond, h.quantile(0))
+}
+
+func TestLatencyStats(t *testing.T) {
+	var stats LatencyStats
+	require.Empty(t, stats.Collect())
+	command := func(sql string, duration time.Duration) *cmd.Command {
+		command := cmd.NewCommand(append([]byte{pnet.ComQuery.Byte()}, []byte(sql)...), time.Now(), 1)
+		command.Duration = duration
+		return command
+	}
+	stats.Add(command("select 1", time.Millisecond), 2*time.Millisecond)
+	stats.Add(command("select 2", time.Millisecond), time.Millisecond)
+	stats.Add(command("select * from t", time.Millisecond), time.Millisecond)
+	summaries := stats.Collect()
+	require.Len(t, summaries, 2)
+	for _, s := range summaries {
+		switch s.SampleStmt {
+		case "select 1", "select 2":
+			require.Equal(t, uint64(2), s.Count)
+			require.Equal(t, time.Millisecond, s.CaptureAvg)
+			require.Equal(t, 1500*time.Microsecond, s.ReplayAvg)
+			require.True(t, s.Regressed(0.2))
+			// The P99 latency doubles.
+			require.False(t, s.Regressed(1.1))
+		default:
+			require.Equal(t, "select * from t", s.SampleStmt)
+			require.Equal(t, uint64(1), s.Count)
+			require.False(t, s.Regressed(0))
+		}
+	}
+
+	// Only the updated digests are collected.
+	require.Empty(t, stats.Collect())
+	stats.Add(command("select * from t", time.Millisecond), time.Millisecond)
+	summaries = stats.Collect()
+	require.Len(t, summaries, 1)
+	require.Equal(t, uint64(2), summaries[0].Count)
+
+	stats.Reset()
+	require.Empty(t, stats.Collect())
+}
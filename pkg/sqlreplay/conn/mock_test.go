@@ -8,8 +8,10 @@ import (
 	"crypto/tls"
 	"sync/atomic"
 
+	"github.com/pingcap/tiproxy/pkg/proxy/backend"
 	"github.com/pingcap/tiproxy/pkg/proxy/net"
 	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 )
 
 var _ BackendConnManager = (*mockBackendConnMgr)(nil)
@@ -19,7 +21,7 @@ type mockBackendConnMgr struct {
 	closed   bool
 }
 
-func (m *mockBackendConnMgr) Connect(ctx context.Context, clientIO net.PacketIO, frontendTLSConfig *tls.Config, backendTLSConfig *tls.Config, username string, password string) error {
+func (m *mockBackendConnMgr) ConnectWithCredential(ctx context.Context, clientIO net.PacketIO, backendTLSConfig *tls.Config, cred backend.Credential) error {
 	m.clientIO = clientIO
 	return nil
 }
@@ -32,6 +34,10 @@ func (m *mockBackendConnMgr) ExecuteCmd(ctx context.Context, request []byte) err
 	return nil
 }
 
+func (m *mockBackendConnMgr) LastResult() *cmd.Result {
+	return nil
+}
+
 func (m *mockBackendConnMgr) Close() error {
 	m.closed = true
 	return m.clientIO.Close()
@@ -45,6 +51,7 @@ type mockBackendConn struct {
 	close    atomic.Bool
 	stmtID   uint32
 	prepared map[uint32]*preparedStmt
+	result   *cmd.Result
 }
 
 func newMockBackendConn() *mockBackendConn {
@@ -71,6 +78,10 @@ func (c *mockBackendConn) ExecuteCmd(ctx context.Context, request []byte) (err e
 	return c.execErr
 }
 
+func (c *mockBackendConn) LastResult() *cmd.Result {
+	return c.result
+}
+
 func (c *mockBackendConn) Query(ctx context.Context, stmt string) error {
 	return c.execErr
 }
@@ -144,6 +144,11 @@ func (p *packetIO) SetCompressionAlgorithm(algorithm pnet.CompressAlgorithm, zst
 	return nil
 }
 
+// CompressionStats implements net.PacketIO.
+func (p *packetIO) CompressionStats() pnet.CompressionStats {
+	return pnet.CompressionStats{}
+}
+
 // SetKeepalive implements net.PacketIO.
 func (p *packetIO) SetKeepalive(cfg config.KeepAlive) error {
 	return nil
@@ -0,0 +1,396 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/store"
+	"go.uber.org/zap"
+)
+
+// maxSampleLen is the maximum length of the sample statements in the summary.
+const maxSampleLen = 100
+
+type InspectConfig struct {
+	// Input is the directory of the traffic files, either a local directory or an URI of an object storage.
+	Input string
+	// KeyFile is used to decrypt the traffic files and to encrypt the exported files.
+	KeyFile string
+	// StartTime and EndTime filter the commands by [StartTime, EndTime). Zero values mean no limit.
+	StartTime time.Time
+	EndTime   time.Time
+	// ConnIDs filters the commands by the connection IDs. Empty means all connections.
+	ConnIDs []uint64
+}
+
+func (cfg *InspectConfig) Validate() error {
+	if cfg.Input == "" {
+		return errors.New("input is required")
+	}
+	if !cfg.StartTime.IsZero() && !cfg.EndTime.IsZero() && !cfg.StartTime.Before(cfg.EndTime) {
+		return errors.New("start-time should be before end-time")
+	}
+	return nil
+}
+
+func (cfg *InspectConfig) match(command *cmd.Command) bool {
+	if !cfg.StartTime.IsZero() && command.StartTs.Before(cfg.StartTime) {
+		return false
+	}
+	if !cfg.EndTime.IsZero() && !command.StartTs.Before(cfg.EndTime) {
+		return false
+	}
+	if len(cfg.ConnIDs) > 0 && !slices.Contains(cfg.ConnIDs, command.ConnID) {
+		return false
+	}
+	return true
+}
+
+// ExportConfig is the config to write the filtered commands to a new directory.
+type ExportConfig struct {
+	// Output is the directory of the new traffic files, either a local directory or an URI of an object storage.
+	Output string
+	// EncryptMethod is the encryption method of the new traffic files. Empty means plaintext, which decrypts the files.
+	EncryptMethod string
+	Compress      bool
+}
+
+// inspector reads the traffic files offline without replaying them.
+type inspector struct {
+	cfg  InspectConfig
+	meta store.Meta
+	lg   *zap.Logger
+}
+
+func NewInspector(lg *zap.Logger, cfg InspectConfig) (*inspector, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	i := &inspector{
+		cfg: cfg,
+		lg:  lg,
+	}
+	// The meta may not exist if the capture is still running or is interrupted, then assume it's not encrypted.
+	if err := i.meta.Read(cfg.Input); err != nil {
+		lg.Warn("read meta failed", zap.String("input", store.RedactURI(cfg.Input)), zap.Error(err))
+	}
+	return i, nil
+}
+
+// iterate decodes the commands one by one and calls fn for the commands that match the filter.
+// The prepared statements of ComStmtExecute and the like are filled even if the ComStmtPrepare doesn't match.
+func (i *inspector) iterate(fn func(command *cmd.Command) error) error {
+	reader, err := store.NewReader(i.lg.Named("loader"), store.ReaderCfg{
+		Dir:           i.cfg.Input,
+		EncryptMethod: i.meta.EncryptMethod,
+		KeyFile:       i.cfg.KeyFile,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	conns := make(map[uint64]*connStmts)
+	for {
+		command := &cmd.Command{}
+		if err := command.Decode(reader); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		cs, ok := conns[command.ConnID]
+		if !ok {
+			cs = newConnStmts()
+			conns[command.ConnID] = cs
+		}
+		cs.update(command)
+		if command.Type == pnet.ComQuit {
+			delete(conns, command.ConnID)
+		}
+		if !i.cfg.match(command) {
+			continue
+		}
+		if err := fn(command); err != nil {
+			return err
+		}
+	}
+}
+
+// Print writes the matched commands in a human-readable format, one line for each command.
+func (i *inspector) Print(w io.Writer) error {
+	return i.iterate(func(command *cmd.Command) error {
+		_, err := io.WriteString(w, formatCommand(command))
+		return errors.WithStack(err)
+	})
+}
+
+func formatCommand(command *cmd.Command) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s conn=%d type=%s", command.StartTs.Format(time.RFC3339Nano), command.ConnID, command.Type.String())
+	if command.User != "" {
+		fmt.Fprintf(&sb, " user=%s", command.User)
+	}
+	if len(command.ConnAttrs) > 0 {
+		fmt.Fprintf(&sb, " attrs=%v", command.ConnAttrs)
+	}
+	if !command.Succeess {
+		sb.WriteString(" success=false")
+	}
+	if command.Duration > 0 {
+		fmt.Fprintf(&sb, " duration=%s", command.Duration)
+	}
+	if command.Result != nil {
+		fmt.Fprintf(&sb, " %s", command.Result.String())
+	}
+	if text := commandText(command); text != "" {
+		fmt.Fprintf(&sb, ": %s", text)
+	}
+	sb.WriteByte('\n')
+	return sb.String()
+}
+
+func commandText(command *cmd.Command) string {
+	switch command.Type {
+	case pnet.ComQuery, pnet.ComStmtPrepare, pnet.ComInitDB, pnet.ComCreateDB, pnet.ComDropDB:
+		return string(command.Payload[1:])
+	case pnet.ComStmtExecute, pnet.ComStmtClose, pnet.ComStmtSendLongData, pnet.ComStmtReset, pnet.ComStmtFetch:
+		if command.PreparedStmt != "" {
+			return command.QueryText()
+		}
+		// The ComStmtPrepare is not captured, e.g. the traffic files are rotated.
+		if len(command.Payload) >= 5 {
+			return fmt.Sprintf("stmt_id=%d", binary.LittleEndian.Uint32(command.Payload[1:5]))
+		}
+	}
+	return ""
+}
+
+type typeSummary struct {
+	cmdType pnet.Command
+	count   uint64
+	failed  uint64
+}
+
+type connSummary struct {
+	connID    uint64
+	user      string
+	count     uint64
+	failed    uint64
+	firstTime time.Time
+	lastTime  time.Time
+}
+
+type digestSummary struct {
+	cmdType pnet.Command
+	digest  string
+	sample  string
+	count   uint64
+	failed  uint64
+	// durations are only summed for the commands whose latencies are captured.
+	timed       uint64
+	sumDuration time.Duration
+}
+
+// Summarize writes the statistics of the matched commands grouped by command types, connections and digests.
+func (i *inspector) Summarize(w io.Writer) error {
+	types := make(map[pnet.Command]*typeSummary)
+	conns := make(map[uint64]*connSummary)
+	digests := make(map[string]*digestSummary)
+	var total uint64
+	var firstTime, lastTime time.Time
+	err := i.iterate(func(command *cmd.Command) error {
+		total++
+		if firstTime.IsZero() || command.StartTs.Before(firstTime) {
+			firstTime = command.StartTs
+		}
+		if command.StartTs.After(lastTime) {
+			lastTime = command.StartTs
+		}
+		var failed uint64
+		if !command.Succeess {
+			failed = 1
+		}
+
+		ts, ok := types[command.Type]
+		if !ok {
+			ts = &typeSummary{cmdType: command.Type}
+			types[command.Type] = ts
+		}
+		ts.count++
+		ts.failed += failed
+
+		cs, ok := conns[command.ConnID]
+		if !ok {
+			cs = &connSummary{connID: command.ConnID, firstTime: command.StartTs}
+			conns[command.ConnID] = cs
+		}
+		if command.User != "" {
+			cs.user = command.User
+		}
+		cs.count++
+		cs.failed += failed
+		if command.StartTs.Before(cs.firstTime) {
+			cs.firstTime = command.StartTs
+		}
+		if command.StartTs.After(cs.lastTime) {
+			cs.lastTime = command.StartTs
+		}
+
+		digest := command.Digest()
+		if digest == "" {
+			return nil
+		}
+		key := command.Type.String() + digest
+		ds, ok := digests[key]
+		if !ok {
+			ds = &digestSummary{cmdType: command.Type, digest: digest, sample: sampleText(command)}
+			digests[key] = ds
+		}
+		ds.count++
+		ds.failed += failed
+		if command.Duration > 0 {
+			ds.timed++
+			ds.sumDuration += command.Duration
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Commands: %d, Connections: %d", total, len(conns))
+	if total > 0 {
+		fmt.Fprintf(tw, ", Time: %s ~ %s", firstTime.Format(time.RFC3339Nano), lastTime.Format(time.RFC3339Nano))
+	}
+	fmt.Fprint(tw, "\n\nType\tCount\tFailed\n")
+	typeList := make([]*typeSummary, 0, len(types))
+	for _, ts := range types {
+		typeList = append(typeList, ts)
+	}
+	sort.Slice(typeList, func(i, j int) bool {
+		if typeList[i].count != typeList[j].count {
+			return typeList[i].count > typeList[j].count
+		}
+		return typeList[i].cmdType < typeList[j].cmdType
+	})
+	for _, ts := range typeList {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", ts.cmdType.String(), ts.count, ts.failed)
+	}
+
+	fmt.Fprint(tw, "\nConn_ID\tUser\tCount\tFailed\tFirst_time\tLast_time\n")
+	connList := make([]*connSummary, 0, len(conns))
+	for _, cs := range conns {
+		connList = append(connList, cs)
+	}
+	sort.Slice(connList, func(i, j int) bool {
+		if connList[i].count != connList[j].count {
+			return connList[i].count > connList[j].count
+		}
+		return connList[i].connID < connList[j].connID
+	})
+	for _, cs := range connList {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%s\n", cs.connID, cs.user, cs.count, cs.failed,
+			cs.firstTime.Format(time.RFC3339Nano), cs.lastTime.Format(time.RFC3339Nano))
+	}
+
+	fmt.Fprint(tw, "\nDigest\tType\tCount\tFailed\tAvg_latency\tSample\n")
+	digestList := make([]*digestSummary, 0, len(digests))
+	for _, ds := range digests {
+		digestList = append(digestList, ds)
+	}
+	sort.Slice(digestList, func(i, j int) bool {
+		if digestList[i].count != digestList[j].count {
+			return digestList[i].count > digestList[j].count
+		}
+		if digestList[i].digest != digestList[j].digest {
+			return digestList[i].digest < digestList[j].digest
+		}
+		return digestList[i].cmdType < digestList[j].cmdType
+	})
+	for _, ds := range digestList {
+		avgLatency := "-"
+		if ds.timed > 0 {
+			avgLatency = (ds.sumDuration / time.Duration(ds.timed)).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", ds.digest, ds.cmdType.String(), ds.count, ds.failed, avgLatency, ds.sample)
+	}
+	return errors.WithStack(tw.Flush())
+}
+
+// sampleText returns the statement of the command in one line so that it doesn't break the table.
+func sampleText(command *cmd.Command) string {
+	text := command.PreparedStmt
+	if text == "" {
+		text = command.QueryText()
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > maxSampleLen {
+		text = text[:maxSampleLen] + "..."
+	}
+	return text
+}
+
+// Export writes the matched commands to a new directory in the traffic file format, which can be inspected or
+// replayed later. The files can be decrypted or re-encrypted by setting EncryptMethod.
+// Note that replaying the exported files may fail if the ComStmtPrepare or the session states are filtered out.
+func (i *inspector) Export(cfg ExportConfig) (uint64, error) {
+	if cfg.Output == "" {
+		return 0, errors.New("output is required")
+	}
+	if err := store.PreCheckMeta(cfg.Output); err != nil {
+		return 0, err
+	}
+	writer, err := store.NewWriter(store.WriterCfg{
+		Dir:           cfg.Output,
+		EncryptMethod: cfg.EncryptMethod,
+		KeyFile:       i.cfg.KeyFile,
+		Compress:      cfg.Compress,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var cmds uint64
+	var firstTime, lastTime time.Time
+	var buf bytes.Buffer
+	err = i.iterate(func(command *cmd.Command) error {
+		if firstTime.IsZero() || command.StartTs.Before(firstTime) {
+			firstTime = command.StartTs
+		}
+		if command.StartTs.After(lastTime) {
+			lastTime = command.StartTs
+		}
+		buf.Reset()
+		if err := command.Encode(&buf); err != nil {
+			return err
+		}
+		cmds++
+		return writer.Write(buf.Bytes())
+	})
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = errors.WithStack(closeErr)
+	}
+	if err != nil {
+		return cmds, err
+	}
+
+	// Keep the capture filter because the exported traffic is still a part of the original traffic.
+	meta := store.NewMeta(lastTime.Sub(firstTime), cmds, i.meta.FilteredCmds, cfg.EncryptMethod)
+	meta.Filter, meta.ExcludedConns, meta.ExcludedCmds = i.meta.Filter, i.meta.ExcludedConns, i.meta.ExcludedCmds
+	return cmds, meta.Write(cfg.Output)
+}
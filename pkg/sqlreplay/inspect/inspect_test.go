@@ -0,0 +1,245 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package inspect
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/store"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func writeTraffic(t *testing.T, dir, encryptMethod, keyFile string, commands []*cmd.Command) {
+	writer, err := store.NewWriter(store.WriterCfg{
+		Dir:           dir,
+		EncryptMethod: encryptMethod,
+		KeyFile:       keyFile,
+	})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	for _, command := range commands {
+		require.NoError(t, command.Encode(&buf))
+	}
+	require.NoError(t, writer.Write(buf.Bytes()))
+	require.NoError(t, writer.Close())
+	require.NoError(t, store.NewMeta(time.Minute, uint64(len(commands)), 0, encryptMethod).Write(dir))
+}
+
+func mockCommands(t *testing.T, now time.Time) []*cmd.Command {
+	execute, err := pnet.MakeExecuteStmtRequest(1, []any{int64(10)}, true)
+	require.NoError(t, err)
+	commands := []*cmd.Command{
+		cmd.NewCommand(pnet.MakeQueryPacket("select 1"), now, 1),
+		cmd.NewCommand(pnet.MakePrepareStmtRequest("select ?"), now.Add(time.Second), 1),
+		cmd.NewCommand(execute, now.Add(2*time.Second), 1),
+		cmd.NewCommand(pnet.MakeQueryPacket("select 2"), now.Add(3*time.Second), 2),
+		cmd.NewCommand(pnet.MakeCloseStmtRequest(1), now.Add(4*time.Second), 1),
+		cmd.NewCommand([]byte{pnet.ComQuit.Byte()}, now.Add(5*time.Second), 1),
+	}
+	commands[0].User = "u1"
+	commands[0].ConnAttrs = map[string]string{"_client_name": "test"}
+	commands[2].Duration = 10 * time.Millisecond
+	commands[3].Succeess = false
+	return commands
+}
+
+func TestPrint(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeTraffic(t, dir, "", "", mockCommands(t, now))
+
+	tests := []struct {
+		cfg   InspectConfig
+		lines []string
+	}{
+		{
+			cfg: InspectConfig{Input: dir},
+			lines: []string{
+				"2024-01-01T00:00:00Z conn=1 type=Query user=u1 attrs=map[_client_name:test]: select 1",
+				"2024-01-01T00:00:01Z conn=1 type=StmtPrepare: select ?",
+				"2024-01-01T00:00:02Z conn=1 type=StmtExecute duration=10ms: select ? params=[10]",
+				"2024-01-01T00:00:03Z conn=2 type=Query success=false: select 2",
+				"2024-01-01T00:00:04Z conn=1 type=StmtClose: select ?",
+				"2024-01-01T00:00:05Z conn=1 type=Quit",
+			},
+		},
+		{
+			// The prepared statement is still decoded even if the ComStmtPrepare is filtered out.
+			cfg: InspectConfig{Input: dir, StartTime: now.Add(2 * time.Second), EndTime: now.Add(4 * time.Second)},
+			lines: []string{
+				"2024-01-01T00:00:02Z conn=1 type=StmtExecute duration=10ms: select ? params=[10]",
+				"2024-01-01T00:00:03Z conn=2 type=Query success=false: select 2",
+			},
+		},
+		{
+			cfg: InspectConfig{Input: dir, ConnIDs: []uint64{2, 3}},
+			lines: []string{
+				"2024-01-01T00:00:03Z conn=2 type=Query success=false: select 2",
+			},
+		},
+	}
+	for i, test := range tests {
+		inspector, err := NewInspector(zap.NewNop(), test.cfg)
+		require.NoError(t, err, "case %d", i)
+		var buf bytes.Buffer
+		require.NoError(t, inspector.Print(&buf), "case %d", i)
+		require.Equal(t, strings.Join(test.lines, "\n")+"\n", buf.String(), "case %d", i)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	now := time.Now()
+	cfgs := []InspectConfig{
+		{},
+		{Input: t.TempDir(), StartTime: now, EndTime: now},
+		{Input: t.TempDir(), StartTime: now, EndTime: now.Add(-time.Second)},
+	}
+	for i, cfg := range cfgs {
+		_, err := NewInspector(zap.NewNop(), cfg)
+		require.Error(t, err, "case %d", i)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeTraffic(t, dir, "", "", mockCommands(t, now))
+
+	inspector, err := NewInspector(zap.NewNop(), InspectConfig{Input: dir})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, inspector.Summarize(&buf))
+	output := buf.String()
+	require.Contains(t, output, "Commands: 6, Connections: 2, Time: 2024-01-01T00:00:00Z ~ 2024-01-01T00:00:05Z")
+	lines := strings.Split(output, "\n")
+	contains := func(fields ...string) {
+		for _, line := range lines {
+			if slices.Equal(strings.Fields(line), fields) {
+				return
+			}
+		}
+		require.Fail(t, "line not found", "fields: %v\noutput:\n%s", fields, output)
+	}
+	contains("Query", "2", "1")
+	contains("StmtExecute", "1", "0")
+	contains("1", "u1", "5", "0", "2024-01-01T00:00:00Z", "2024-01-01T00:00:05Z")
+	contains("2", "1", "1", "2024-01-01T00:00:03Z", "2024-01-01T00:00:03Z")
+	digest := cmd.NewCommand(pnet.MakeQueryPacket("select ?"), now, 1).Digest()
+	contains(digest, "StmtExecute", "1", "0", "10ms", "select", "?")
+	contains(digest, "StmtPrepare", "1", "0", "-", "select", "?")
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	require.NoError(t, os.WriteFile(keyFile, key, 0600))
+	input := filepath.Join(dir, "input")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	commands := mockCommands(t, now)
+	writeTraffic(t, input, store.EncryptAes, keyFile, commands)
+
+	// Decrypt the traffic of connection 1.
+	inspector, err := NewInspector(zap.NewNop(), InspectConfig{Input: input, KeyFile: keyFile, ConnIDs: []uint64{1}})
+	require.NoError(t, err)
+	output := filepath.Join(dir, "output")
+	cmds, err := inspector.Export(ExportConfig{Output: output})
+	require.NoError(t, err)
+	require.EqualValues(t, 5, cmds)
+	var meta store.Meta
+	require.NoError(t, meta.Read(output))
+	require.EqualValues(t, 5, meta.Cmds)
+	require.Equal(t, 5*time.Second, meta.Duration)
+	require.Empty(t, meta.EncryptMethod)
+
+	// The exported files can be read without the key.
+	reader, err := store.NewReader(zap.NewNop(), store.ReaderCfg{Dir: output})
+	require.NoError(t, err)
+	defer reader.Close()
+	for _, expected := range commands {
+		if expected.ConnID != 1 {
+			continue
+		}
+		command := &cmd.Command{}
+		require.NoError(t, command.Decode(reader))
+		require.True(t, expected.Equal(command))
+	}
+
+	// Exporting to an existing directory fails.
+	_, err = inspector.Export(ExportConfig{Output: output})
+	require.Error(t, err)
+	_, err = inspector.Export(ExportConfig{})
+	require.Error(t, err)
+
+	// Re-encrypt the traffic.
+	inspector, err = NewInspector(zap.NewNop(), InspectConfig{Input: output, KeyFile: keyFile})
+	require.NoError(t, err)
+	encrypted := filepath.Join(dir, "encrypted")
+	_, err = inspector.Export(ExportConfig{Output: encrypted, EncryptMethod: store.EncryptAes, Compress: true})
+	require.NoError(t, err)
+	inspector, err = NewInspector(zap.NewNop(), InspectConfig{Input: encrypted, KeyFile: keyFile})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, inspector.Print(&buf))
+	require.Contains(t, buf.String(), "select ? params=[10]")
+}
+
+func TestPreparedStmts(t *testing.T) {
+	now := time.Now()
+	cs := newConnStmts()
+	execute := func(stmtID uint32, args []any, newParamBound bool) *cmd.Command {
+		request, err := pnet.MakeExecuteStmtRequest(stmtID, args, newParamBound)
+		require.NoError(t, err)
+		command := cmd.NewCommand(request, now, 1)
+		cs.update(command)
+		return command
+	}
+	prepare := func(stmt string, succeeded bool) {
+		command := cmd.NewCommand(pnet.MakePrepareStmtRequest(stmt), now, 1)
+		command.Succeess = succeeded
+		cs.update(command)
+	}
+
+	// The session states restore the prepared statements and the next statement ID.
+	cs.update(cmd.NewCommand(pnet.MakeQueryPacket(`SET SESSION_STATES '{"prepared-stmt-id":5,"prepared-stmts":{"3":{"text":"select ?","types":"CAA="}}}'`), now, 1))
+	command := execute(3, []any{int64(1)}, false)
+	require.Equal(t, "select ?", command.PreparedStmt)
+	require.Equal(t, []any{int64(1)}, command.Params)
+
+	// Failed ComStmtPrepare doesn't allocate IDs.
+	prepare("select ? from", false)
+	prepare("select ?, ?", true)
+	command = execute(6, []any{int64(1), "a"}, true)
+	require.Equal(t, "select ?, ?", command.PreparedStmt)
+	require.Equal(t, []any{int64(1), "a"}, command.Params)
+	// Following ComStmtExecute reuses the param types of the first one.
+	command = execute(6, []any{int64(2), "b"}, false)
+	require.Equal(t, []any{int64(2), "b"}, command.Params)
+
+	// The IDs restart after resetting the connection.
+	cs.update(cmd.NewCommand([]byte{pnet.ComResetConnection.Byte()}, now, 1))
+	command = execute(6, []any{int64(1), "a"}, true)
+	require.Empty(t, command.PreparedStmt)
+	prepare("select 1", true)
+	command = execute(1, nil, true)
+	require.Equal(t, "select 1", command.PreparedStmt)
+
+	// ComStmtClose removes the statement.
+	cs.update(cmd.NewCommand(pnet.MakeCloseStmtRequest(1), now, 1))
+	command = execute(1, nil, true)
+	require.Empty(t, command.PreparedStmt)
+	require.Equal(t, "stmt_id=1", commandText(command))
+}
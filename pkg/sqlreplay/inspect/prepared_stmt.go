@@ -0,0 +1,111 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package inspect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
+	"github.com/pingcap/tiproxy/pkg/util/lex"
+	"github.com/siddontang/go/hack"
+)
+
+const setSessionStates = "SET SESSION_STATES "
+
+// Session states of the connections that are captured in the middle. Used for unmarshal.
+type sessionStates struct {
+	PreparedStmtID uint32                       `json:"prepared-stmt-id,omitempty"`
+	PreparedStmts  map[uint32]*preparedStmtInfo `json:"prepared-stmts,omitempty"`
+}
+
+// Prepared stmt in the session states. Used for unmarshal.
+type preparedStmtInfo struct {
+	StmtText   string `json:"text"`
+	ParamTypes []byte `json:"types,omitempty"`
+}
+
+type preparedStmt struct {
+	text       string
+	paramTypes []byte
+	paramNum   int
+}
+
+// connStmts tracks the prepared statements of a connection to decode the statement IDs in the commands.
+// The responses of ComStmtPrepare are not captured, but TiDB allocates the statement IDs incrementally in each session,
+// so the IDs can be inferred from the sequence of successful ComStmtPrepare.
+type connStmts struct {
+	lastStmtID uint32
+	stmts      map[uint32]*preparedStmt
+}
+
+func newConnStmts() *connStmts {
+	return &connStmts{
+		stmts: make(map[uint32]*preparedStmt),
+	}
+}
+
+// update fills the prepared statement and the parameters of the command and updates the prepared statements.
+func (cs *connStmts) update(command *cmd.Command) {
+	switch command.Type {
+	case pnet.ComStmtPrepare:
+		if command.Succeess {
+			cs.lastStmtID++
+			text := string(command.Payload[1:])
+			cs.stmts[cs.lastStmtID] = &preparedStmt{text: text, paramNum: lex.CountParamMarkers(text)}
+		}
+	case pnet.ComStmtExecute, pnet.ComStmtClose, pnet.ComStmtSendLongData, pnet.ComStmtReset, pnet.ComStmtFetch:
+		if len(command.Payload) < 5 {
+			return
+		}
+		stmtID := binary.LittleEndian.Uint32(command.Payload[1:5])
+		ps, ok := cs.stmts[stmtID]
+		if !ok {
+			return
+		}
+		command.PreparedStmt = ps.text
+		switch command.Type {
+		case pnet.ComStmtExecute:
+			// paramTypes is contained in the first ComStmtExecute and following ones may reuse it.
+			_, args, paramTypes, err := pnet.ParseExecuteStmtRequest(command.Payload, ps.paramNum, ps.paramTypes)
+			if err == nil {
+				command.Params = args
+				if len(ps.paramTypes) == 0 {
+					ps.paramTypes = paramTypes
+				}
+			}
+		case pnet.ComStmtClose:
+			delete(cs.stmts, stmtID)
+		}
+	case pnet.ComChangeUser, pnet.ComResetConnection:
+		// TiDB creates a new session, so the statement IDs restart.
+		cs.lastStmtID = 0
+		cs.stmts = make(map[uint32]*preparedStmt)
+	case pnet.ComQuery:
+		request := command.Payload
+		if len(request[1:]) > len(setSessionStates) && strings.EqualFold(hack.String(request[1:len(setSessionStates)+1]), setSessionStates) {
+			query := request[len(setSessionStates)+1:]
+			query = bytes.TrimSpace(query)
+			query = bytes.Trim(query, "'\"")
+			query = bytes.ReplaceAll(query, []byte("\\\\"), []byte("\\"))
+			query = bytes.ReplaceAll(query, []byte("\\'"), []byte("'"))
+			var states sessionStates
+			if err := json.Unmarshal(query, &states); err != nil {
+				return
+			}
+			for stmtID, stmt := range states.PreparedStmts {
+				cs.stmts[stmtID] = &preparedStmt{text: stmt.StmtText, paramNum: len(stmt.ParamTypes) >> 1, paramTypes: stmt.ParamTypes}
+				if stmtID > cs.lastStmtID {
+					cs.lastStmtID = stmtID
+				}
+			}
+			if states.PreparedStmtID > cs.lastStmtID {
+				cs.lastStmtID = states.PreparedStmtID
+			}
+		}
+	}
+}
@@ -0,0 +1,434 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/cron"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/lib/util/waitgroup"
+	"github.com/pingcap/tiproxy/pkg/balance/metricsreader"
+	"github.com/pingcap/tiproxy/pkg/metrics"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"go.uber.org/zap"
+)
+
+const (
+	autoCaptureCheckInterval = 15 * time.Second
+	// The triggered captures are in the sub-directories named by the prefix and the start time,
+	// so that they are sorted by time and other files in the output directory are never removed.
+	autoCaptureDirPrefix = "auto-"
+	autoCaptureDirFormat = "20060102-150405"
+	// Ignore the error rate and latency if there are too few queries in a check interval.
+	autoCaptureMinQueries = 100
+	autoCaptureCPUKey     = "auto_capture_cpu"
+	// If the CPU usage has not been updated for a long time (maybe Prometheus is unavailable), ignore it.
+	autoCaptureCPUExpDuration = 2 * time.Minute
+)
+
+var (
+	autoCaptureCPUExpr = metricsreader.QueryExpr{
+		PromQL:   `irate(process_cpu_seconds_total{%s="tidb"}[30s])/tidb_server_maxprocs`,
+		HasLabel: true,
+	}
+	autoCaptureCPURule = metricsreader.QueryRule{
+		Names:     []string{"process_cpu_seconds_total", "tidb_server_maxprocs"},
+		Retention: 1 * time.Minute,
+		Metric2Value: func(mfs map[string]*dto.MetricFamily) model.SampleValue {
+			cpuTotal := mfs["process_cpu_seconds_total"].Metric[0].Untyped
+			maxProcs := mfs["tidb_server_maxprocs"].Metric[0].Untyped
+			if cpuTotal == nil || maxProcs == nil {
+				return model.SampleValue(math.NaN())
+			}
+			return model.SampleValue(*cpuTotal.Value / *maxProcs.Value)
+		},
+		Range2Value: func(pairs []model.SamplePair) model.SampleValue {
+			if len(pairs) < 2 {
+				return model.SampleValue(math.NaN())
+			}
+			first, last := pairs[0], pairs[len(pairs)-1]
+			seconds := float64(last.Timestamp-first.Timestamp) / 1000.0
+			// Maybe the backend just rebooted.
+			if seconds < 1 || first.Value > last.Value {
+				return model.SampleValue(math.NaN())
+			}
+			return (last.Value - first.Value) / model.SampleValue(seconds)
+		},
+		ResultType: model.ValVector,
+	}
+)
+
+// MetricsReader reads the metrics of backends, which is implemented by metricsreader.MetricsReader.
+type MetricsReader interface {
+	AddQueryExpr(key string, queryExpr metricsreader.QueryExpr, queryRule metricsreader.QueryRule)
+	RemoveQueryExpr(key string)
+	GetQueryResult(key string) metricsreader.QueryResult
+}
+
+// queryStats are the cumulative query statistics observed by this TiProxy.
+type queryStats struct {
+	queries uint64
+	errors  uint64
+	// buckets are the cumulative counts of the query duration histogram, keyed by the upper bounds in seconds.
+	buckets map[float64]uint64
+}
+
+// readQueryStats reads the query statistics from the metrics of all backends.
+func readQueryStats() (queryStats, error) {
+	stats := queryStats{buckets: make(map[float64]uint64)}
+	mts, err := metrics.Collect(metrics.QueryTotalCounter)
+	if err != nil {
+		return stats, err
+	}
+	for _, mt := range mts {
+		stats.queries += uint64(mt.GetCounter().GetValue())
+	}
+	if mts, err = metrics.Collect(metrics.QueryErrorCounter); err != nil {
+		return stats, err
+	}
+	for _, mt := range mts {
+		stats.errors += uint64(mt.GetCounter().GetValue())
+	}
+	if mts, err = metrics.Collect(metrics.QueryDurationHistogram); err != nil {
+		return stats, err
+	}
+	for _, mt := range mts {
+		for _, bucket := range mt.GetHistogram().GetBucket() {
+			stats.buckets[bucket.GetUpperBound()] += bucket.GetCumulativeCount()
+		}
+	}
+	return stats, nil
+}
+
+// sub returns the statistics during the interval. It returns false if the counters are reset, e.g. a backend is removed.
+func (qs queryStats) sub(last queryStats) (queryStats, bool) {
+	if qs.queries < last.queries || qs.errors < last.errors {
+		return queryStats{}, false
+	}
+	diff := queryStats{
+		queries: qs.queries - last.queries,
+		errors:  qs.errors - last.errors,
+		buckets: make(map[float64]uint64, len(qs.buckets)),
+	}
+	for bound, count := range qs.buckets {
+		if count < last.buckets[bound] {
+			return queryStats{}, false
+		}
+		diff.buckets[bound] = count - last.buckets[bound]
+	}
+	return diff, true
+}
+
+// quantile estimates the quantile of the query duration like `histogram_quantile` in Prometheus.
+func (qs queryStats) quantile(q float64) time.Duration {
+	bounds := make([]float64, 0, len(qs.buckets))
+	for bound := range qs.buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+	if len(bounds) == 0 || qs.buckets[bounds[len(bounds)-1]] == 0 {
+		return 0
+	}
+	total := qs.buckets[bounds[len(bounds)-1]]
+	rank := q * float64(total)
+	lowerBound, lowerCount := 0.0, uint64(0)
+	for _, bound := range bounds {
+		count := qs.buckets[bound]
+		if float64(count) >= rank {
+			// Interpolate linearly inside the bucket.
+			upper := bound
+			if count > lowerCount {
+				upper = lowerBound + (bound-lowerBound)*(rank-float64(lowerCount))/float64(count-lowerCount)
+			}
+			return time.Duration(upper * float64(time.Second))
+		}
+		lowerBound, lowerCount = bound, count
+	}
+	return time.Duration(lowerBound * float64(time.Second))
+}
+
+// autoCapture checks the rules periodically and starts a capture when any rule is triggered.
+type autoCapture struct {
+	jm        *jobManager
+	cfgGetter config.ConfigGetter
+	mr        MetricsReader
+	// readStats is replaced in tests.
+	readStats func() (queryStats, error)
+	lastStats queryStats
+	lastCheck time.Time
+	// lastTriggers are the trigger time of each rule, which is used for the cooldown.
+	lastTriggers map[string]time.Time
+	cpuQueried   bool
+	wg           waitgroup.WaitGroup
+	cancel       context.CancelFunc
+	lg           *zap.Logger
+}
+
+func newAutoCapture(lg *zap.Logger, jm *jobManager, cfgGetter config.ConfigGetter, mr MetricsReader) *autoCapture {
+	return &autoCapture{
+		lg:           lg,
+		jm:           jm,
+		cfgGetter:    cfgGetter,
+		mr:           mr,
+		readStats:    readQueryStats,
+		lastTriggers: make(map[string]time.Time),
+	}
+}
+
+func (ac *autoCapture) Start() {
+	childCtx, cancel := context.WithCancel(context.Background())
+	ac.cancel = cancel
+	ac.wg.RunWithRecover(func() {
+		ticker := time.NewTicker(autoCaptureCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-childCtx.Done():
+				return
+			case now := <-ticker.C:
+				ac.check(now)
+			}
+		}
+	}, nil, ac.lg)
+}
+
+func (ac *autoCapture) check(now time.Time) {
+	globalCfg := ac.cfgGetter.GetConfig()
+	cfg := globalCfg.AutoCapture
+	// Only query the CPU usage when it's needed because it costs a query to Prometheus or backends.
+	ac.updateCPUQuery(cfg.Rules)
+	stats, err := ac.readStats()
+	if err != nil {
+		ac.lg.Warn("read query statistics failed", zap.Error(err))
+		return
+	}
+	interval, valid := stats.sub(ac.lastStats)
+	valid = valid && !ac.lastCheck.IsZero()
+	lastCheck := ac.lastCheck
+	ac.lastStats, ac.lastCheck = stats, now
+	if len(cfg.Rules) == 0 || ac.jm.hasRunningJob() {
+		return
+	}
+
+	for _, rule := range cfg.Rules {
+		if lastTrigger, ok := ac.lastTriggers[rule.Name]; ok && now.Sub(lastTrigger) < time.Duration(cfg.Cooldown)*time.Minute {
+			continue
+		}
+		reason := ac.matchRule(rule, interval, valid, lastCheck, now)
+		if len(reason) == 0 {
+			continue
+		}
+		ac.lastTriggers[rule.Name] = now
+		if err := ac.jm.startAutoCapture(cfg, globalCfg.Security.Encryption, rule, reason, now); err != nil {
+			ac.lg.Warn("start auto-capture failed", zap.String("reason", reason), zap.Error(err))
+		}
+		return
+	}
+}
+
+// matchRule returns the triggering reason if the rule is matched, otherwise returns an empty string.
+func (ac *autoCapture) matchRule(rule config.CaptureRule, interval queryStats, valid bool, lastCheck, now time.Time) string {
+	if rule.CPUUsage > 0 {
+		if usage, ok := ac.maxCPUUsage(); ok && usage*100 >= rule.CPUUsage {
+			return fmt.Sprintf("rule %s: max backend CPU usage %.1f%% exceeds %.1f%%", rule.Name, usage*100, rule.CPUUsage)
+		}
+	}
+	if valid && interval.queries >= autoCaptureMinQueries {
+		if rule.ErrorRate > 0 {
+			if errorRate := float64(interval.errors) / float64(interval.queries) * 100; errorRate >= rule.ErrorRate {
+				return fmt.Sprintf("rule %s: error rate %.2f%% exceeds %.2f%%", rule.Name, errorRate, rule.ErrorRate)
+			}
+		}
+		if rule.P99Latency > 0 {
+			threshold := time.Duration(rule.P99Latency) * time.Millisecond
+			if p99 := interval.quantile(0.99); p99 >= threshold {
+				return fmt.Sprintf("rule %s: p99 latency %s exceeds %s", rule.Name, p99.Round(time.Millisecond), threshold)
+			}
+		}
+	}
+	if rule.Cron != "" {
+		schedule, err := cron.Parse(rule.Cron)
+		if err != nil {
+			ac.lg.Warn("invalid cron expression", zap.String("rule", rule.Name), zap.Error(err))
+			return ""
+		}
+		// Check every minute since the last check so that no minute is missed or matched twice.
+		end := now.Truncate(time.Minute)
+		start := end
+		if !lastCheck.IsZero() {
+			start = lastCheck.Truncate(time.Minute).Add(time.Minute)
+		}
+		for minute := start; !minute.After(end); minute = minute.Add(time.Minute) {
+			if schedule.Match(minute) {
+				return fmt.Sprintf("rule %s: cron schedule `%s` at %s", rule.Name, rule.Cron, minute.Format(time.DateTime))
+			}
+		}
+	}
+	return ""
+}
+
+func (ac *autoCapture) updateCPUQuery(rules []config.CaptureRule) {
+	if ac.mr == nil {
+		return
+	}
+	needCPU := false
+	for _, rule := range rules {
+		if rule.CPUUsage > 0 {
+			needCPU = true
+			break
+		}
+	}
+	if needCPU && !ac.cpuQueried {
+		ac.mr.AddQueryExpr(autoCaptureCPUKey, autoCaptureCPUExpr, autoCaptureCPURule)
+	} else if !needCPU && ac.cpuQueried {
+		ac.mr.RemoveQueryExpr(autoCaptureCPUKey)
+	}
+	ac.cpuQueried = needCPU
+}
+
+// maxCPUUsage returns the max CPU usage of all backends, which is in [0, 1].
+func (ac *autoCapture) maxCPUUsage() (float64, bool) {
+	if ac.mr == nil {
+		return 0, false
+	}
+	qr := ac.mr.GetQueryResult(autoCaptureCPUKey)
+	if qr.Empty() || time.Since(qr.UpdateTime) > autoCaptureCPUExpDuration {
+		return 0, false
+	}
+	vector, ok := qr.Value.(model.Vector)
+	if !ok {
+		return 0, false
+	}
+	usage, found := 0.0, false
+	for _, sample := range vector {
+		value := float64(sample.Value)
+		if math.IsNaN(value) {
+			continue
+		}
+		if !found || value > usage {
+			usage, found = value, true
+		}
+	}
+	return usage, found
+}
+
+func (ac *autoCapture) Close() {
+	if ac.cancel != nil {
+		ac.cancel()
+	}
+	ac.wg.Wait()
+	if ac.mr != nil && ac.cpuQueried {
+		ac.mr.RemoveQueryExpr(autoCaptureCPUKey)
+	}
+}
+
+// StartAutoCapture starts checking the auto-capture rules in the background.
+func (jm *jobManager) StartAutoCapture(cfgGetter config.ConfigGetter, mr MetricsReader) {
+	jm.autoCapture = newAutoCapture(jm.lg.Named("auto_capture"), jm, cfgGetter, mr)
+	jm.autoCapture.Start()
+}
+
+func (jm *jobManager) hasRunningJob() bool {
+	jm.Lock()
+	defer jm.Unlock()
+	return jm.runningJob() != nil
+}
+
+// startAutoCapture removes the old triggered captures and starts a new one in a sub-directory of the output.
+// The new capture stops when the total size of the captures reaches the max size.
+func (jm *jobManager) startAutoCapture(cfg config.AutoCapture, encryption config.Encryption, rule config.CaptureRule, reason string, now time.Time) error {
+	jm.Lock()
+	defer jm.Unlock()
+	if running := jm.runningJob(); running != nil {
+		return errors.Errorf("a job is running: %s", running.String())
+	}
+	keptSize, err := pruneAutoCaptures(cfg)
+	if err != nil {
+		jm.lg.Warn("remove old auto-captures failed", zap.Error(err))
+	}
+	var maxSize int64
+	if cfg.MaxSize > 0 {
+		if maxSize = int64(cfg.MaxSize)<<20 - keptSize; maxSize <= 0 {
+			return errors.Errorf("the kept auto-captures exceed the max size %dMB", cfg.MaxSize)
+		}
+	}
+	return jm.startCapture(capture.CaptureConfig{
+		Output:        filepath.Join(cfg.Output, autoCaptureDirPrefix+now.Format(autoCaptureDirFormat)),
+		Duration:      time.Duration(rule.Duration) * time.Minute,
+		Compress:      true,
+		EncryptMethod: cfg.EncryptMethod,
+		KeyFile:       encryption.KeyPath,
+		MaxSize:       maxSize,
+	}, reason)
+}
+
+// pruneAutoCaptures removes the oldest triggered captures so that there is room for a new one.
+// It returns the total size of the kept captures.
+func pruneAutoCaptures(cfg config.AutoCapture) (int64, error) {
+	entries, err := os.ReadDir(cfg.Output)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.WithStack(err)
+	}
+	// The entries are sorted by names, which are also sorted by time.
+	var dirs []string
+	var sizes []int64
+	var totalSize int64
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), autoCaptureDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(cfg.Output, entry.Name())
+		size, err := dirSize(dir)
+		if err != nil {
+			return totalSize, err
+		}
+		dirs = append(dirs, dir)
+		sizes = append(sizes, size)
+		totalSize += size
+	}
+	maxSize := int64(cfg.MaxSize) << 20
+	for i := range dirs {
+		if len(dirs)-i < cfg.MaxCount && (maxSize == 0 || totalSize < maxSize) {
+			break
+		}
+		if err := os.RemoveAll(dirs[i]); err != nil {
+			return totalSize, errors.WithStack(err)
+		}
+		totalSize -= sizes[i]
+	}
+	return totalSize, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, errors.WithStack(err)
+}
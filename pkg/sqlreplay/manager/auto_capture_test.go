@@ -0,0 +1,191 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/pkg/balance/metricsreader"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestQueryStats(t *testing.T) {
+	last := queryStats{queries: 100, errors: 1, buckets: map[float64]uint64{0.1: 90, 0.2: 100}}
+	cur := queryStats{queries: 300, errors: 11, buckets: map[float64]uint64{0.1: 190, 0.2: 200, 0.4: 300}}
+	diff, ok := cur.sub(last)
+	require.True(t, ok)
+	require.Equal(t, uint64(200), diff.queries)
+	require.Equal(t, uint64(10), diff.errors)
+	require.Equal(t, map[float64]uint64{0.1: 100, 0.2: 100, 0.4: 300}, diff.buckets)
+	// 99% of 300 is 297, which is in the bucket (0.2, 0.4].
+	require.Equal(t, 397*time.Millisecond, diff.quantile(0.99).Round(time.Millisecond))
+	require.Equal(t, 90*time.Millisecond, diff.quantile(0.3).Round(time.Millisecond))
+	require.Equal(t, time.Duration(0), queryStats{}.quantile(0.99))
+
+	// The counters are reset.
+	_, ok = last.sub(cur)
+	require.False(t, ok)
+}
+
+func TestAutoCaptureRules(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{AutoCapture: config.AutoCapture{
+		Output:        dir,
+		MaxSize:       1,
+		EncryptMethod: "aes256-ctr",
+		Rules: []config.CaptureRule{
+			{Name: "cpu", CPUUsage: 80},
+			{Name: "error", ErrorRate: 5},
+			{Name: "latency", P99Latency: 500},
+			{Name: "cron", Cron: "0 9 * * *"},
+		},
+	}}
+	cfg.Security.Encryption.KeyPath = "/tmp/key"
+	require.NoError(t, cfg.AutoCapture.Check())
+	mr := &mockMetricsReader{exprs: make(map[string]metricsreader.QueryExpr)}
+	mgr := NewJobManager(zap.NewNop(), &config.Config{}, &mockCertMgr{}, nil, nil)
+	defer mgr.Close()
+	cpt := &mockCapture{}
+	mgr.capture = cpt
+	ac := newAutoCapture(zap.NewNop(), mgr, &mockConfigGetter{cfg: cfg}, mr)
+	var stats queryStats
+	ac.readStats = func() (queryStats, error) {
+		return stats, nil
+	}
+
+	tests := []struct {
+		prepare func()
+		reason  string
+	}{
+		{
+			// The first check only records the statistics.
+			prepare: func() {
+				stats = queryStats{queries: 1000, errors: 100, buckets: map[float64]uint64{1: 1000}}
+			},
+		},
+		{
+			// Too few queries.
+			prepare: func() {
+				stats = queryStats{queries: 1010, errors: 110, buckets: map[float64]uint64{1: 1010}}
+			},
+		},
+		{
+			prepare: func() {
+				mr.result = metricsreader.QueryResult{
+					UpdateTime: time.Now(),
+					Value:      model.Vector{{Value: 0.5}, {Value: 0.9}},
+				}
+			},
+			reason: "rule cpu: max backend CPU usage 90.0% exceeds 80.0%",
+		},
+		{
+			// The CPU rule is in cooldown.
+			prepare: func() {
+				stats = queryStats{queries: 2010, errors: 210, buckets: map[float64]uint64{0.1: 1010, 1: 2010}}
+			},
+			reason: "rule error: error rate 10.00% exceeds 5.00%",
+		},
+		{
+			prepare: func() {
+				stats = queryStats{queries: 3010, errors: 210, buckets: map[float64]uint64{0.1: 1010, 1: 3010}}
+			},
+			reason: "rule latency: p99 latency 991ms exceeds 500ms",
+		},
+		{
+			// No rules are triggered.
+			prepare: func() {
+				mr.result = metricsreader.QueryResult{}
+				stats = queryStats{queries: 4010, errors: 210, buckets: map[float64]uint64{0.1: 2010, 1: 4010}}
+			},
+		},
+	}
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.Local)
+	for i, test := range tests {
+		now = now.Add(autoCaptureCheckInterval)
+		test.prepare()
+		ac.check(now)
+		require.Contains(t, mr.exprs, autoCaptureCPUKey)
+		if len(test.reason) == 0 {
+			require.True(t, len(mgr.jobHistory) == 0 || !mgr.jobHistory[len(mgr.jobHistory)-1].IsRunning(), "case %d", i)
+			continue
+		}
+		jobCount := len(mgr.jobHistory)
+		job := mgr.jobHistory[jobCount-1]
+		require.True(t, job.IsRunning(), "case %d", i)
+		require.Equal(t, test.reason, job.(*captureJob).reason, "case %d", i)
+		require.True(t, strings.HasPrefix(cpt.cfg.Output, filepath.Join(dir, autoCaptureDirPrefix)), "case %d", i)
+		require.Equal(t, 10*time.Minute, cpt.cfg.Duration, "case %d", i)
+		// The encryption and the size limit are applied to the running capture.
+		require.Equal(t, "aes256-ctr", cpt.cfg.EncryptMethod, "case %d", i)
+		require.Equal(t, "/tmp/key", cpt.cfg.KeyFile, "case %d", i)
+		require.EqualValues(t, 1<<20, cpt.cfg.MaxSize, "case %d", i)
+		// No rules are checked while a job is running.
+		ac.check(now.Add(time.Second))
+		require.Len(t, mgr.jobHistory, jobCount, "case %d", i)
+		require.Contains(t, mgr.Stop(), "stopped")
+	}
+
+	// The cron rule is matched once at 9:00, even if the check is not at the beginning of the minute.
+	ac.check(time.Date(2024, 1, 1, 8, 59, 50, 0, time.Local))
+	require.Len(t, mgr.jobHistory, 3)
+	ac.check(time.Date(2024, 1, 1, 9, 0, 5, 0, time.Local))
+	require.Len(t, mgr.jobHistory, 4)
+	require.Equal(t, "rule cron: cron schedule `0 9 * * *` at 2024-01-01 09:00:00", mgr.jobHistory[3].(*captureJob).reason)
+	require.Contains(t, mgr.Stop(), "stopped")
+	ac.check(time.Date(2024, 1, 1, 9, 0, 20, 0, time.Local))
+	require.Len(t, mgr.jobHistory, 4)
+
+	// The reason is shown in the job history.
+	var jobs []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(mgr.Jobs()), &jobs))
+	require.Equal(t, "rule cpu: max backend CPU usage 90.0% exceeds 80.0%", jobs[0]["reason"])
+
+	// The CPU usage is not queried if no rules need it.
+	cfg.AutoCapture.Rules = nil
+	ac.check(time.Date(2024, 1, 1, 9, 1, 0, 0, time.Local))
+	require.NotContains(t, mr.exprs, autoCaptureCPUKey)
+}
+
+func TestPruneAutoCaptures(t *testing.T) {
+	dir := t.TempDir()
+	// Other files are never removed.
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "manual"), 0755))
+	for i := 1; i <= 4; i++ {
+		sub := filepath.Join(dir, autoCaptureDirPrefix+time.Date(2024, 1, i, 0, 0, 0, 0, time.Local).Format(autoCaptureDirFormat))
+		require.NoError(t, os.Mkdir(sub, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sub, "traffic.log"), make([]byte, 512<<10), 0600))
+	}
+	listDirs := func() []string {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	// Keep 2 old captures so that there are 3 with the new one.
+	size, err := pruneAutoCaptures(config.AutoCapture{Output: dir, MaxCount: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{"auto-20240103-000000", "auto-20240104-000000", "manual"}, listDirs())
+	require.EqualValues(t, 1<<20, size)
+	// The total size should be less than 1MB.
+	size, err = pruneAutoCaptures(config.AutoCapture{Output: dir, MaxCount: 3, MaxSize: 1})
+	require.NoError(t, err)
+	require.Equal(t, []string{"auto-20240104-000000", "manual"}, listDirs())
+	require.EqualValues(t, 512<<10, size)
+	// The output does not exist.
+	size, err = pruneAutoCaptures(config.AutoCapture{Output: filepath.Join(dir, "none"), MaxCount: 3})
+	require.NoError(t, err)
+	require.Zero(t, size)
+}
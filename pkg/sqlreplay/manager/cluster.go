@@ -0,0 +1,334 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/lib/util/waitgroup"
+	"github.com/pingcap/tiproxy/pkg/manager/elect"
+	"github.com/pingcap/tiproxy/pkg/manager/infosync"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/store"
+	httputil "github.com/pingcap/tiproxy/pkg/util/http"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// clusterOwnerKey is the key in etcd for the owner election of cluster-wide traffic jobs.
+	clusterOwnerKey = "/tiproxy/traffic/owner"
+	// clusterSessionTTL is the session's TTL in seconds for the owner election.
+	clusterSessionTTL = 15
+	// clusterRequestTimeout is the timeout of each request sent to other TiProxy instances.
+	clusterRequestTimeout = 30 * time.Second
+	clusterRetryIntvl     = 500 * time.Millisecond
+	clusterRetryCnt       = 3
+
+	capturePath = "/api/traffic/capture"
+	replayPath  = "/api/traffic/replay"
+	cancelPath  = "/api/traffic/cancel"
+	showPath    = "/api/traffic/show"
+
+	// FormCluster is the form key to run the job on all TiProxy instances.
+	FormCluster = "cluster"
+	// FormClusterIndex and FormClusterCount are the form keys that the owner sends to each instance for replay.
+	FormClusterIndex = "cluster-index"
+	FormClusterCount = "cluster-count"
+)
+
+type TopologyFetcher interface {
+	GetTiProxyTopology(ctx context.Context) (map[string]*infosync.TopologyInfo, error)
+}
+
+// ClusterManager runs capture and replay jobs on all TiProxy instances in the cluster.
+// The requests are forwarded to the elected owner, and the owner fans them out to all instances through the HTTP API.
+type ClusterManager interface {
+	Capture(ctx context.Context, form url.Values) (string, error)
+	Replay(ctx context.Context, form url.Values) (string, error)
+	Cancel(ctx context.Context) (string, error)
+	Show(ctx context.Context) (string, error)
+	Close()
+}
+
+// InstanceJobs is the jobs of one TiProxy instance in the cluster.
+type InstanceJobs struct {
+	Instance string          `json:"instance"`
+	Jobs     json.RawMessage `json:"jobs,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// instance is a TiProxy instance in the cluster.
+type instance struct {
+	// addr is the SQL address, which names the capture directory.
+	addr string
+	// statusAddr is the address of the HTTP API.
+	statusAddr string
+}
+
+// instanceResult is the response of a request sent to an instance.
+type instanceResult struct {
+	resp []byte
+	err  error
+}
+
+var _ ClusterManager = (*clusterManager)(nil)
+
+type clusterManager struct {
+	election elect.Election
+	etcdCli  *clientv3.Client
+	fetcher  TopologyFetcher
+	httpCli  *httputil.Client
+	lg       *zap.Logger
+}
+
+func NewClusterManager(lg *zap.Logger, etcdCli *clientv3.Client, fetcher TopologyFetcher, httpCli *httputil.Client) *clusterManager {
+	return &clusterManager{
+		lg:      lg,
+		etcdCli: etcdCli,
+		fetcher: fetcher,
+		httpCli: httpCli,
+	}
+}
+
+func (cm *clusterManager) Start(ctx context.Context, cfg *config.Config) error {
+	ip, _, statusPort, err := cfg.GetIPPort()
+	if err != nil {
+		return err
+	}
+	// Use the status address as the ID so that other members can forward requests to the owner.
+	id := net.JoinHostPort(ip, statusPort)
+	cm.election = elect.NewElection(cm.lg.Named("elect"), cm.etcdCli, elect.DefaultElectionConfig(clusterSessionTTL), id, clusterOwnerKey, cm)
+	cm.election.Start(ctx)
+	return nil
+}
+
+func (cm *clusterManager) OnElected() {
+	cm.lg.Info("become the owner of cluster traffic jobs")
+}
+
+func (cm *clusterManager) OnRetired() {
+	cm.lg.Info("retire from the owner of cluster traffic jobs")
+}
+
+// ownerAddr returns the status address of the owner, or an empty string if this instance is the owner.
+func (cm *clusterManager) ownerAddr(ctx context.Context) (string, error) {
+	owner, err := cm.election.GetOwnerID(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "get the owner of cluster traffic jobs failed")
+	}
+	if owner == cm.election.ID() {
+		return "", nil
+	}
+	return owner, nil
+}
+
+// instances returns all the TiProxy instances sorted by the SQL address.
+func (cm *clusterManager) instances(ctx context.Context) ([]instance, error) {
+	topology, err := cm.fetcher.GetTiProxyTopology(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(topology) == 0 {
+		return nil, errors.New("no TiProxy instances found")
+	}
+	instances := make([]instance, 0, len(topology))
+	for addr, info := range topology {
+		instances = append(instances, instance{addr: addr, statusAddr: net.JoinHostPort(info.IP, info.StatusPort)})
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].addr < instances[j].addr
+	})
+	return instances, nil
+}
+
+func (cm *clusterManager) backoff(ctx context.Context) backoff.BackOff {
+	return backoff.WithContext(backoff.WithMaxRetries(backoff.NewConstantBackOff(clusterRetryIntvl), clusterRetryCnt), ctx)
+}
+
+// noRetry is used for starting jobs, which are not idempotent. If a start times out but actually succeeds, a retry
+// fails because the job is running.
+func (cm *clusterManager) noRetry(ctx context.Context) backoff.BackOff {
+	return backoff.WithContext(&backoff.StopBackOff{}, ctx)
+}
+
+// fanOut sends the requests to all instances concurrently.
+func (cm *clusterManager) fanOut(instances []instance, send func(i int, inst instance) ([]byte, error)) []instanceResult {
+	results := make([]instanceResult, len(instances))
+	var wg waitgroup.WaitGroup
+	for i, inst := range instances {
+		wg.RunWithRecover(func() {
+			results[i].resp, results[i].err = send(i, inst)
+		}, nil, cm.lg)
+	}
+	wg.Wait()
+	return results
+}
+
+func (cm *clusterManager) Capture(ctx context.Context, form url.Values) (string, error) {
+	owner, err := cm.ownerAddr(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(owner) > 0 {
+		resp, err := cm.httpCli.PostForm(owner, capturePath, form, cm.noRetry(ctx), clusterRequestTimeout)
+		return string(resp), err
+	}
+	instances, err := cm.instances(ctx)
+	if err != nil {
+		return "", err
+	}
+	output := form.Get("output")
+	results := cm.fanOut(instances, func(_ int, inst instance) ([]byte, error) {
+		instForm := cloneForm(form)
+		// Each instance writes to the object storage in its own directory. Local directories may be on a shared file
+		// system, so each instance also writes to its own directory.
+		if store.IsLocal(output) {
+			instForm.Set("output", store.JoinURI(output, inst.addr))
+		}
+		return cm.httpCli.PostForm(inst.statusAddr, capturePath, instForm, cm.noRetry(ctx), clusterRequestTimeout)
+	})
+	return cm.checkStarted(ctx, "capture", instances, results)
+}
+
+func (cm *clusterManager) Replay(ctx context.Context, form url.Values) (string, error) {
+	owner, err := cm.ownerAddr(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(owner) > 0 {
+		resp, err := cm.httpCli.PostForm(owner, replayPath, form, cm.noRetry(ctx), clusterRequestTimeout)
+		return string(resp), err
+	}
+	instances, err := cm.instances(ctx)
+	if err != nil {
+		return "", err
+	}
+	results := cm.fanOut(instances, func(i int, inst instance) ([]byte, error) {
+		instForm := cloneForm(form)
+		instForm.Set(FormClusterIndex, strconv.Itoa(i))
+		instForm.Set(FormClusterCount, strconv.Itoa(len(instances)))
+		return cm.httpCli.PostForm(inst.statusAddr, replayPath, instForm, cm.noRetry(ctx), clusterRequestTimeout)
+	})
+	return cm.checkStarted(ctx, "replay", instances, results)
+}
+
+// checkStarted checks whether the job starts on all instances. If any instance fails, the job is cancelled on the
+// instances where this request started it so that the cluster is in a consistent state. The failed instances are not
+// cancelled because they may be running other jobs.
+func (cm *clusterManager) checkStarted(ctx context.Context, job string, instances []instance, results []instanceResult) (string, error) {
+	var failures []string
+	started := make([]instance, 0, len(instances))
+	for i, result := range results {
+		if result.err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", instances[i].addr, result.err.Error()))
+		} else {
+			started = append(started, instances[i])
+		}
+	}
+	if len(failures) == 0 {
+		cm.lg.Info("started cluster "+job, zap.Int("instances", len(instances)))
+		return fmt.Sprintf("%s started on %d instances", job, len(instances)), nil
+	}
+	cm.lg.Warn("start cluster "+job+" failed, cancel it", zap.Strings("failures", failures))
+	if len(started) > 0 {
+		_, _ = cm.cancel(ctx, started)
+	}
+	return "", errors.Errorf("start %s failed on %d instances: %s", job, len(failures), strings.Join(failures, "; "))
+}
+
+func (cm *clusterManager) Cancel(ctx context.Context) (string, error) {
+	owner, err := cm.ownerAddr(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(owner) > 0 {
+		resp, err := cm.httpCli.PostForm(owner, cancelPath, url.Values{FormCluster: {"true"}}, cm.backoff(ctx), clusterRequestTimeout)
+		return string(resp), err
+	}
+	instances, err := cm.instances(ctx)
+	if err != nil {
+		return "", err
+	}
+	return cm.cancel(ctx, instances)
+}
+
+func (cm *clusterManager) cancel(ctx context.Context, instances []instance) (string, error) {
+	results := cm.fanOut(instances, func(_ int, inst instance) ([]byte, error) {
+		return cm.httpCli.PostForm(inst.statusAddr, cancelPath, nil, cm.backoff(ctx), clusterRequestTimeout)
+	})
+	lines := make([]string, 0, len(instances))
+	for i, result := range results {
+		msg := string(result.resp)
+		if result.err != nil {
+			msg = result.err.Error()
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", instances[i].addr, msg))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Show returns the jobs of all instances in JSON.
+func (cm *clusterManager) Show(ctx context.Context) (string, error) {
+	owner, err := cm.ownerAddr(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(owner) > 0 {
+		resp, err := cm.httpCli.Get(owner, showPath+"?"+FormCluster+"=true", cm.backoff(ctx), clusterRequestTimeout)
+		return string(resp), err
+	}
+	instances, err := cm.instances(ctx)
+	if err != nil {
+		return "", err
+	}
+	results := cm.fanOut(instances, func(_ int, inst instance) ([]byte, error) {
+		return cm.httpCli.Get(inst.statusAddr, showPath, cm.backoff(ctx), clusterRequestTimeout)
+	})
+	jobs := make([]InstanceJobs, 0, len(instances))
+	for i, result := range results {
+		instJobs := InstanceJobs{Instance: instances[i].addr}
+		switch {
+		case result.err != nil:
+			instJobs.Error = result.err.Error()
+		case !json.Valid(result.resp):
+			instJobs.Error = string(result.resp)
+		default:
+			instJobs.Jobs = result.resp
+		}
+		jobs = append(jobs, instJobs)
+	}
+	b, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(b), nil
+}
+
+func (cm *clusterManager) Close() {
+	if cm.election != nil {
+		cm.election.Close()
+	}
+}
+
+// cloneForm copies the form without the cluster flag so that the instances start local jobs.
+func cloneForm(form url.Values) url.Values {
+	cloned := make(url.Values, len(form))
+	for key, values := range form {
+		if key != FormCluster {
+			cloned[key] = append([]string(nil), values...)
+		}
+	}
+	return cloned
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/pingcap/tiproxy/pkg/manager/infosync"
+	httputil "github.com/pingcap/tiproxy/pkg/util/http"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newClusterManagerForTest(t *testing.T, instances []*mockInstance) *clusterManager {
+	infos := make(map[string]*infosync.TopologyInfo, len(instances))
+	for i, inst := range instances {
+		ip, port, err := net.SplitHostPort(inst.addr)
+		require.NoError(t, err)
+		infos[fmt.Sprintf("10.0.0.%d:6000", i)] = &infosync.TopologyInfo{IP: ip, StatusPort: port}
+	}
+	httpCli := httputil.NewHTTPClient(func() *tls.Config { return nil })
+	cm := NewClusterManager(zap.NewNop(), nil, &mockTopologyFetcher{infos: infos}, httpCli)
+	cm.election = &mockElection{id: instances[0].addr, owner: instances[0].addr}
+	return cm
+}
+
+func TestClusterFanOut(t *testing.T) {
+	instances := []*mockInstance{newMockInstance(t, ""), newMockInstance(t, ""), newMockInstance(t, "")}
+	cm := newClusterManagerForTest(t, instances)
+	ctx := context.Background()
+
+	// Each instance captures to its own directory.
+	result, err := cm.Capture(ctx, url.Values{"output": {"/tmp/traffic"}, "duration": {"1m"}, FormCluster: {"true"}})
+	require.NoError(t, err)
+	require.Equal(t, "capture started on 3 instances", result)
+	for i, inst := range instances {
+		form := inst.form(capturePath)
+		require.Equal(t, fmt.Sprintf("/tmp/traffic/10.0.0.%d:6000", i), form.Get("output"))
+		require.Equal(t, "1m", form.Get("duration"))
+		require.False(t, form.Has(FormCluster))
+	}
+
+	// The object storage URI is unchanged because each instance appends its address.
+	_, err = cm.Capture(ctx, url.Values{"output": {"s3://bucket/prefix"}})
+	require.NoError(t, err)
+	require.Equal(t, "s3://bucket/prefix", instances[1].form(capturePath).Get("output"))
+
+	// Each instance replays a part of the traffic.
+	_, err = cm.Replay(ctx, url.Values{"input": {"s3://bucket/prefix"}, "username": {"u1"}})
+	require.NoError(t, err)
+	for i, inst := range instances {
+		form := inst.form(replayPath)
+		require.Equal(t, "s3://bucket/prefix", form.Get("input"))
+		require.Equal(t, fmt.Sprint(i), form.Get(FormClusterIndex))
+		require.Equal(t, "3", form.Get(FormClusterCount))
+	}
+
+	result, err = cm.Cancel(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.0:6000: ok\n10.0.0.1:6000: ok\n10.0.0.2:6000: ok", result)
+
+	result, err = cm.Show(ctx)
+	require.NoError(t, err)
+	var jobs []InstanceJobs
+	require.NoError(t, json.Unmarshal([]byte(result), &jobs))
+	require.Len(t, jobs, 3)
+	for i, job := range jobs {
+		require.Equal(t, fmt.Sprintf("10.0.0.%d:6000", i), job.Instance)
+		require.JSONEq(t, `[{"type":"capture"}]`, string(job.Jobs))
+		require.Empty(t, job.Error)
+	}
+}
+
+func TestClusterStartFailed(t *testing.T) {
+	instances := []*mockInstance{newMockInstance(t, ""), newMockInstance(t, replayPath)}
+	cm := newClusterManagerForTest(t, instances)
+
+	// If any instance fails, the job is cancelled on the instances where it started.
+	_, err := cm.Replay(context.Background(), url.Values{"input": {"/tmp/traffic"}})
+	require.ErrorContains(t, err, "10.0.0.1:6000: http status 500: mock error")
+	require.NotNil(t, instances[0].form(cancelPath))
+	require.Nil(t, instances[1].form(cancelPath))
+
+	// The start requests are not retried because they are not idempotent.
+	instances[1].closePath = capturePath
+	_, err = cm.Capture(context.Background(), url.Values{"output": {"/tmp/traffic"}})
+	require.ErrorContains(t, err, "10.0.0.1:6000")
+	require.Equal(t, 1, instances[1].count(capturePath))
+	require.Equal(t, 2, instances[0].count(cancelPath))
+	require.Nil(t, instances[1].form(cancelPath))
+}
+
+func TestClusterForwardToOwner(t *testing.T) {
+	instances := []*mockInstance{newMockInstance(t, ""), newMockInstance(t, "")}
+	cm := newClusterManagerForTest(t, instances)
+	cm.election = &mockElection{id: instances[0].addr, owner: instances[1].addr}
+	ctx := context.Background()
+
+	// Only the owner receives the requests and it fans out them.
+	_, err := cm.Capture(ctx, url.Values{"output": {"/tmp/traffic"}, FormCluster: {"true"}})
+	require.NoError(t, err)
+	require.Nil(t, instances[0].form(capturePath))
+	form := instances[1].form(capturePath)
+	require.Equal(t, "/tmp/traffic", form.Get("output"))
+	require.Equal(t, "true", form.Get(FormCluster))
+
+	_, err = cm.Cancel(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "true", instances[1].form(cancelPath).Get(FormCluster))
+	_, err = cm.Show(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "true", instances[1].form(showPath).Get(FormCluster))
+	require.Nil(t, instances[0].form(showPath))
+}
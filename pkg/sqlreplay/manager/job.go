@@ -6,10 +6,12 @@ package manager
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/replay"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/store"
 	"github.com/siddontang/go/hack"
 )
 
@@ -48,6 +50,21 @@ type job4Marshal struct {
 	Speed     float64 `json:"speed,omitempty"`
 	Progress  string  `json:"progress"`
 	Err       string  `json:"error,omitempty"`
+	// Reason is why the capture is triggered if it's started by auto-capture.
+	Reason string `json:"reason,omitempty"`
+	// Filter describes the capture filter if only part of the traffic is captured.
+	Filter string `json:"filter,omitempty"`
+	// Steps are the statistics of each speed if the speed has changed during replay.
+	Steps []speedStep4Marshal `json:"steps,omitempty"`
+}
+
+type speedStep4Marshal struct {
+	Speed      float64 `json:"speed"`
+	StartTime  string  `json:"start_time"`
+	Duration   string  `json:"duration"`
+	QPS        float64 `json:"qps"`
+	AvgLatency string  `json:"avg_latency"`
+	ErrorRate  string  `json:"error_rate"`
 }
 
 func (job *job) IsRunning() bool {
@@ -86,7 +103,8 @@ var _ Job = (*captureJob)(nil)
 
 type captureJob struct {
 	job
-	cfg capture.CaptureConfig
+	cfg    capture.CaptureConfig
+	reason string
 }
 
 func (job *captureJob) Type() jobType {
@@ -96,8 +114,10 @@ func (job *captureJob) Type() jobType {
 func (job *captureJob) MarshalJSON() ([]byte, error) {
 	job4Marshal := job.getJob4Marshal()
 	job4Marshal.Type = "capture"
-	job4Marshal.Output = job.cfg.Output
+	job4Marshal.Output = store.RedactURI(job.cfg.Output)
 	job4Marshal.Duration = job.cfg.Duration.String()
+	job4Marshal.Reason = job.reason
+	job4Marshal.Filter = job.cfg.Filter.String()
 	return json.Marshal(job4Marshal)
 }
 
@@ -113,7 +133,8 @@ var _ Job = (*replayJob)(nil)
 
 type replayJob struct {
 	job
-	cfg replay.ReplayConfig
+	cfg   replay.ReplayConfig
+	steps []replay.SpeedStep
 }
 
 func (job *replayJob) Type() jobType {
@@ -123,12 +144,22 @@ func (job *replayJob) Type() jobType {
 func (job *replayJob) MarshalJSON() ([]byte, error) {
 	job4Marshal := job.getJob4Marshal()
 	job4Marshal.Type = "replay"
-	job4Marshal.Input = job.cfg.Input
+	job4Marshal.Input = store.RedactURI(job.cfg.Input)
 	job4Marshal.Username = job.cfg.Username
 	job4Marshal.Speed = job.cfg.Speed
 	if job4Marshal.Speed == 0 {
 		job4Marshal.Speed = 1
 	}
+	for _, step := range job.steps {
+		job4Marshal.Steps = append(job4Marshal.Steps, speedStep4Marshal{
+			Speed:      step.Speed,
+			StartTime:  step.StartTime.String(),
+			Duration:   step.Duration.Round(time.Millisecond).String(),
+			QPS:        math.Round(step.QPS*100) / 100,
+			AvgLatency: step.AvgLatency.String(),
+			ErrorRate:  fmt.Sprintf("%.2f%%", step.ErrorRate*100),
+		})
+	}
 	return json.Marshal(job4Marshal)
 }
 
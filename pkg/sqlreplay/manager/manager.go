@@ -6,6 +6,8 @@ package manager
 import (
 	"crypto/tls"
 	"encoding/json"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/pingcap/tiproxy/lib/config"
@@ -14,6 +16,7 @@ import (
 	"github.com/pingcap/tiproxy/pkg/proxy/backend"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/replay"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/store"
 	"github.com/siddontang/go/hack"
 	"go.uber.org/zap"
 )
@@ -29,6 +32,7 @@ type CertManager interface {
 type JobManager interface {
 	StartCapture(capture.CaptureConfig) error
 	StartReplay(replay.ReplayConfig) error
+	SetReplaySpeed(speed float64) error
 	GetCapture() capture.Capture
 	Stop() string
 	Jobs() string
@@ -38,11 +42,14 @@ type JobManager interface {
 var _ JobManager = (*jobManager)(nil)
 
 type jobManager struct {
+	// The jobs may be started by the API and auto-capture concurrently.
+	sync.Mutex
 	jobHistory  []Job
 	capture     capture.Capture
 	replay      replay.Replay
 	hsHandler   backend.HandshakeHandler
 	certManager CertManager
+	autoCapture *autoCapture
 	cfg         *config.Config
 	lg          *zap.Logger
 }
@@ -71,6 +78,9 @@ func (jm *jobManager) updateProgress() {
 		case Replay:
 			progress, endTime, done, err := jm.replay.Progress()
 			job.SetProgress(progress, endTime, done, err)
+			rj := job.(*replayJob)
+			rj.cfg.Speed = jm.replay.Speed()
+			rj.steps = jm.replay.Steps()
 		}
 	}
 }
@@ -88,10 +98,25 @@ func (jm *jobManager) runningJob() Job {
 }
 
 func (jm *jobManager) StartCapture(cfg capture.CaptureConfig) error {
+	jm.Lock()
+	defer jm.Unlock()
+	return jm.startCapture(cfg, "")
+}
+
+// startCapture starts a capture job. reason is the triggering reason if it's started by auto-capture.
+func (jm *jobManager) startCapture(cfg capture.CaptureConfig, reason string) error {
 	running := jm.runningJob()
 	if running != nil {
 		return errors.Errorf("a job is running: %s", running.String())
 	}
+	// Multiple TiProxy instances may capture to the same object storage, so each one writes to its own directory.
+	if !store.IsLocal(cfg.Output) {
+		addr, err := jm.instanceAddr()
+		if err != nil {
+			return errors.Wrapf(err, "start capture failed")
+		}
+		cfg.Output = store.JoinURI(cfg.Output, addr)
+	}
 	if err := jm.capture.Start(cfg); err != nil {
 		jm.lg.Warn("start capture failed", zap.Error(err))
 		return errors.Wrapf(err, "start capture failed")
@@ -100,7 +125,8 @@ func (jm *jobManager) StartCapture(cfg capture.CaptureConfig) error {
 		job: job{
 			startTime: time.Now(),
 		},
-		cfg: cfg,
+		cfg:    cfg,
+		reason: reason,
 	}
 	jm.lg.Info("start capture", zap.String("job", newJob.String()))
 	jm.addToHistory(newJob)
@@ -108,6 +134,8 @@ func (jm *jobManager) StartCapture(cfg capture.CaptureConfig) error {
 }
 
 func (jm *jobManager) StartReplay(cfg replay.ReplayConfig) error {
+	jm.Lock()
+	defer jm.Unlock()
 	running := jm.runningJob()
 	if running != nil {
 		return errors.Errorf("a job is running: %s", running.String())
@@ -135,6 +163,30 @@ func (jm *jobManager) StartReplay(cfg replay.ReplayConfig) error {
 	return nil
 }
 
+// SetReplaySpeed changes the speed of the running replay job.
+func (jm *jobManager) SetReplaySpeed(speed float64) error {
+	jm.Lock()
+	defer jm.Unlock()
+	job := jm.runningJob()
+	if job == nil || job.Type() != Replay {
+		return errors.New("no replay job running")
+	}
+	if err := jm.replay.SetSpeed(speed); err != nil {
+		return err
+	}
+	jm.updateProgress()
+	return nil
+}
+
+// instanceAddr returns the address of this TiProxy, which is used to name the capture directory.
+func (jm *jobManager) instanceAddr() (string, error) {
+	ip, port, _, err := jm.cfg.GetIPPort()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
 func (jm *jobManager) addToHistory(newJob Job) {
 	if len(jm.jobHistory) >= maxJobHistoryCount {
 		copy(jm.jobHistory, jm.jobHistory[1:])
@@ -149,6 +201,8 @@ func (jm *jobManager) GetCapture() capture.Capture {
 }
 
 func (jm *jobManager) Jobs() string {
+	jm.Lock()
+	defer jm.Unlock()
 	jm.updateProgress()
 	b, err := json.MarshalIndent(jm.jobHistory, "", "  ")
 	if err != nil {
@@ -158,6 +212,8 @@ func (jm *jobManager) Jobs() string {
 }
 
 func (jm *jobManager) Stop() string {
+	jm.Lock()
+	defer jm.Unlock()
 	job := jm.runningJob()
 	if job == nil {
 		return "no job running"
@@ -173,6 +229,10 @@ func (jm *jobManager) Stop() string {
 }
 
 func (jm *jobManager) Close() {
+	// Stop auto-capture first so that it won't start new jobs.
+	if jm.autoCapture != nil {
+		jm.autoCapture.Close()
+	}
 	if jm.capture != nil {
 		jm.capture.Close()
 	}
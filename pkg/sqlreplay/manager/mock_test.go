@@ -4,11 +4,23 @@
 package manager
 
 import (
+	"context"
 	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
 	"time"
 
+	"github.com/pingcap/tiproxy/lib/config"
+	"github.com/pingcap/tiproxy/pkg/balance/metricsreader"
+	"github.com/pingcap/tiproxy/pkg/manager/elect"
+	"github.com/pingcap/tiproxy/pkg/manager/infosync"
 	"github.com/pingcap/tiproxy/pkg/proxy/backend"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/capture"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/replay"
 )
 
@@ -24,15 +36,26 @@ func (mockCertMgr) SQLTLS() *tls.Config {
 var _ capture.Capture = (*mockCapture)(nil)
 
 type mockCapture struct {
+	cfg      capture.CaptureConfig
 	progress float64
 	err      error
 	done     bool
 }
 
-func (m *mockCapture) InitConn(startTime time.Time, connID uint64, db string) {
+func (m *mockCapture) InitConn(startTime time.Time, connID uint64, info capture.ConnInfo) {
 }
 
-func (m *mockCapture) Capture(packet []byte, startTime time.Time, connID uint64, initSession func() (string, error)) {
+func (m *mockCapture) CloseConn(startTime time.Time, connID uint64) {
+}
+
+func (m *mockCapture) Capture(packet []byte, startTime time.Time, connID uint64, session capture.Session) capture.Pending {
+	return capture.PendingNone
+}
+
+func (m *mockCapture) CaptureResult(connID uint64, succeeded bool, duration time.Duration, result *cmd.Result) {
+}
+
+func (m *mockCapture) CaptureLoadData(packet []byte, startTime time.Time, connID uint64, session capture.Session, payloadHash string) {
 }
 
 func (m *mockCapture) Close() {
@@ -46,7 +69,8 @@ func (m *mockCapture) Stop(err error) {
 	m.err = err
 }
 
-func (m *mockCapture) Start(capture.CaptureConfig) error {
+func (m *mockCapture) Start(cfg capture.CaptureConfig) error {
+	m.cfg = cfg
 	m.progress = 0
 	m.err = nil
 	m.done = false
@@ -59,6 +83,8 @@ type mockReplay struct {
 	progress float64
 	err      error
 	done     bool
+	speed    float64
+	steps    []replay.SpeedStep
 }
 
 func (m *mockReplay) Close() {
@@ -69,6 +95,8 @@ func (m *mockReplay) Progress() (float64, time.Time, bool, error) {
 }
 
 func (m *mockReplay) Start(cfg replay.ReplayConfig, backendTLSConfig *tls.Config, hsHandler backend.HandshakeHandler, bcConfig *backend.BCConfig) error {
+	m.speed = cfg.Speed
+	m.steps = nil
 	m.progress = 0
 	m.err = nil
 	m.done = false
@@ -78,3 +106,136 @@ func (m *mockReplay) Start(cfg replay.ReplayConfig, backendTLSConfig *tls.Config
 func (m *mockReplay) Stop(err error) {
 	m.err = err
 }
+
+func (m *mockReplay) SetSpeed(speed float64) error {
+	m.speed = speed
+	m.steps = append(m.steps, replay.SpeedStep{Speed: speed})
+	return nil
+}
+
+func (m *mockReplay) Speed() float64 {
+	return m.speed
+}
+
+func (m *mockReplay) Steps() []replay.SpeedStep {
+	return m.steps
+}
+
+var _ elect.Election = (*mockElection)(nil)
+
+type mockElection struct {
+	id    string
+	owner string
+}
+
+func (m *mockElection) Start(context.Context) {
+}
+
+func (m *mockElection) ID() string {
+	return m.id
+}
+
+func (m *mockElection) GetOwnerID(context.Context) (string, error) {
+	return m.owner, nil
+}
+
+func (m *mockElection) Close() {
+}
+
+var _ TopologyFetcher = (*mockTopologyFetcher)(nil)
+
+type mockTopologyFetcher struct {
+	infos map[string]*infosync.TopologyInfo
+}
+
+func (m *mockTopologyFetcher) GetTiProxyTopology(context.Context) (map[string]*infosync.TopologyInfo, error) {
+	return m.infos, nil
+}
+
+// mockInstance is the HTTP API of a TiProxy instance that records the received requests.
+type mockInstance struct {
+	sync.Mutex
+	addr     string
+	forms    map[string]url.Values
+	counts   map[string]int
+	failPath string
+	// closePath closes the connection without a response, which looks like a timeout to the client.
+	closePath string
+}
+
+func newMockInstance(t *testing.T, failPath string) *mockInstance {
+	m := &mockInstance{
+		forms:    make(map[string]url.Values),
+		counts:   make(map[string]int),
+		failPath: failPath,
+	}
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	m.addr = strings.TrimPrefix(srv.URL, "http://")
+	return m
+}
+
+func (m *mockInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+	_ = r.ParseForm()
+	m.forms[r.URL.Path] = r.Form
+	m.counts[r.URL.Path]++
+	if r.URL.Path == m.closePath {
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			_ = conn.Close()
+		}
+		return
+	}
+	if r.URL.Path == m.failPath {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("mock error"))
+		return
+	}
+	if r.URL.Path == showPath {
+		_, _ = w.Write([]byte(`[{"type":"capture"}]`))
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+func (m *mockInstance) form(path string) url.Values {
+	m.Lock()
+	defer m.Unlock()
+	return m.forms[path]
+}
+
+func (m *mockInstance) count(path string) int {
+	m.Lock()
+	defer m.Unlock()
+	return m.counts[path]
+}
+
+var _ config.ConfigGetter = (*mockConfigGetter)(nil)
+
+type mockConfigGetter struct {
+	cfg *config.Config
+}
+
+func (m *mockConfigGetter) GetConfig() *config.Config {
+	return m.cfg
+}
+
+var _ MetricsReader = (*mockMetricsReader)(nil)
+
+type mockMetricsReader struct {
+	exprs  map[string]metricsreader.QueryExpr
+	result metricsreader.QueryResult
+}
+
+func (m *mockMetricsReader) AddQueryExpr(key string, queryExpr metricsreader.QueryExpr, _ metricsreader.QueryRule) {
+	m.exprs[key] = queryExpr
+}
+
+func (m *mockMetricsReader) RemoveQueryExpr(key string) {
+	delete(m.exprs, key)
+}
+
+func (m *mockMetricsReader) GetQueryResult(string) metricsreader.QueryResult {
+	return m.result
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package replay
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/pkg/proxy/backend"
+)
+
+// sessionTokenLifetime is the lifetime of the signed session tokens. The token is used right after it's signed,
+// so it needn't be long.
+const sessionTokenLifetime = 15 * time.Minute
+
+// credentialEntry is an entry of the credential file, which is a JSON array of entries.
+// ReplayUser is the user to log in with during replay and it's the same as User if it's empty.
+type credentialEntry struct {
+	User       string `json:"user"`
+	ReplayUser string `json:"replay-user,omitempty"`
+	Password   string `json:"password"`
+}
+
+// credentialResolver decides the credential to replay each connection.
+// Connections are replayed with the mapped users in the credential file first, then the original users by signing
+// session tokens, and finally the default user.
+type credentialResolver struct {
+	defaultCred backend.Credential
+	users       map[string]backend.Credential
+	signer      *tokenSigner
+}
+
+func newCredentialResolver(cfg *ReplayConfig) (*credentialResolver, error) {
+	cr := &credentialResolver{
+		defaultCred: backend.Credential{User: cfg.Username, Password: cfg.Password},
+	}
+	if cfg.CredentialFile != "" {
+		users, err := readCredentialFile(cfg.CredentialFile)
+		if err != nil {
+			return nil, err
+		}
+		cr.users = users
+	}
+	if cfg.SessionTokenSigningCert != "" || cfg.SessionTokenSigningKey != "" {
+		signer, err := newTokenSigner(cfg.SessionTokenSigningCert, cfg.SessionTokenSigningKey)
+		if err != nil {
+			return nil, err
+		}
+		cr.signer = signer
+	}
+	return cr, nil
+}
+
+func readCredentialFile(file string) (map[string]backend.Credential, error) {
+	// The errors don't contain the file name or the content because they are returned to the API caller.
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.New("read credential file failed")
+	}
+	var entries []credentialEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, errors.New("parse credential file failed")
+	}
+	users := make(map[string]backend.Credential, len(entries))
+	for _, entry := range entries {
+		if entry.User == "" {
+			return nil, errors.New("user is required in credential file")
+		}
+		replayUser := entry.ReplayUser
+		if replayUser == "" {
+			replayUser = entry.User
+		}
+		users[entry.User] = backend.Credential{User: replayUser, Password: entry.Password}
+	}
+	return users, nil
+}
+
+// resolve returns the credential of a connection that was captured with the user and connection attributes.
+// The user may be empty if the traffic was captured by an older version.
+func (cr *credentialResolver) resolve(user string, attrs map[string]string) backend.Credential {
+	var cred backend.Credential
+	if c, ok := cr.users[user]; ok {
+		cred = c
+	} else if user != "" && cr.signer != nil {
+		// If signing fails, fall back to the default user and the connection will report the error if it fails.
+		if token, err := cr.signer.sign(user, time.Now()); err == nil {
+			cred = backend.Credential{User: user, SessionToken: token}
+		} else {
+			cred = cr.defaultCred
+		}
+	} else {
+		cred = cr.defaultCred
+	}
+	cred.Attrs = attrs
+	return cred
+}
+
+// sessionToken is the session token that TiDB verifies with the same certificate when the auth plugin is
+// tidb_session_token. It must be compatible with TiDB.
+type sessionToken struct {
+	Username   string    `json:"username"`
+	SignTime   time.Time `json:"sign-time"`
+	ExpireTime time.Time `json:"expire-time"`
+	Signature  []byte    `json:"signature,omitempty"`
+}
+
+// tokenSigner signs session tokens so that TiProxy can log in as any user without passwords, just like what it does
+// during session migration. The certificate must be the same as `session-token-signing-cert` of TiDB.
+type tokenSigner struct {
+	cert   *x509.Certificate
+	signer crypto.Signer
+}
+
+func newTokenSigner(certFile, keyFile string) (*tokenSigner, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("session-token-signing-cert and session-token-signing-key should be both set")
+	}
+	tlsCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.New("load session token signing cert failed")
+	}
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		return nil, errors.New("parse session token signing cert failed")
+	}
+	signer, ok := tlsCert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type %T", tlsCert.PrivateKey)
+	}
+	return &tokenSigner{cert: cert, signer: signer}, nil
+}
+
+func (ts *tokenSigner) sign(user string, now time.Time) (string, error) {
+	token := sessionToken{
+		Username:   user,
+		SignTime:   now,
+		ExpireTime: now.Add(sessionTokenLifetime),
+	}
+	content, err := json.Marshal(&token)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if token.Signature, err = ts.signContent(content); err != nil {
+		return "", err
+	}
+	tokenBytes, err := json.Marshal(&token)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(tokenBytes), nil
+}
+
+// signContent signs the content with the same algorithm as the certificate so that TiDB can verify it with
+// x509.Certificate.CheckSignature.
+func (ts *tokenSigner) signContent(content []byte) ([]byte, error) {
+	var hash crypto.Hash
+	var opts crypto.SignerOpts
+	switch ts.cert.SignatureAlgorithm {
+	case x509.SHA256WithRSA, x509.ECDSAWithSHA256:
+		hash = crypto.SHA256
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512:
+		hash = crypto.SHA512
+	case x509.SHA256WithRSAPSS:
+		hash = crypto.SHA256
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case x509.SHA384WithRSAPSS:
+		hash = crypto.SHA384
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case x509.SHA512WithRSAPSS:
+		hash = crypto.SHA512
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case x509.PureEd25519:
+		// Ed25519 signs the message itself instead of its digest.
+		signature, err := ts.signer.Sign(rand.Reader, content, crypto.Hash(0))
+		return signature, errors.WithStack(err)
+	default:
+		return nil, errors.Errorf("unsupported signature algorithm %s", ts.cert.SignatureAlgorithm.String())
+	}
+	if opts == nil {
+		opts = hash
+	}
+	h := hash.New()
+	h.Write(content)
+	signature, err := ts.signer.Sign(rand.Reader, h.Sum(nil), opts)
+	return signature, errors.WithStack(err)
+}
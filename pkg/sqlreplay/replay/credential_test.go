@@ -0,0 +1,125 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package replay
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tiproxy/lib/util/security"
+	"github.com/pingcap/tiproxy/pkg/proxy/backend"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReadCredentialFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		content string
+		users   map[string]backend.Credential
+		hasErr  bool
+	}{
+		{
+			content: `[{"user":"u1","password":"p1"},{"user":"u2","replay-user":"u3","password":"p2"}]`,
+			users: map[string]backend.Credential{
+				"u1": {User: "u1", Password: "p1"},
+				"u2": {User: "u3", Password: "p2"},
+			},
+		},
+		{
+			content: `[]`,
+			users:   map[string]backend.Credential{},
+		},
+		{
+			content: `[{"password":"p1"}]`,
+			hasErr:  true,
+		},
+		{
+			content: `{"user":"u1"}`,
+			hasErr:  true,
+		},
+	}
+	for i, test := range tests {
+		file := filepath.Join(dir, "credential.json")
+		require.NoError(t, os.WriteFile(file, []byte(test.content), 0600))
+		users, err := readCredentialFile(file)
+		if test.hasErr {
+			require.Error(t, err, "case %d", i)
+			// The error is returned to the API caller, so it doesn't reveal the file.
+			require.NotContains(t, err.Error(), file, "case %d", i)
+			require.NotContains(t, err.Error(), "u1", "case %d", i)
+			continue
+		}
+		require.NoError(t, err, "case %d", i)
+		require.Equal(t, test.users, users, "case %d", i)
+	}
+	_, err := readCredentialFile(filepath.Join(dir, "not_exist"))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "not_exist")
+}
+
+func TestResolveCredential(t *testing.T) {
+	dir := t.TempDir()
+	credFile := filepath.Join(dir, "credential.json")
+	require.NoError(t, os.WriteFile(credFile, []byte(`[{"user":"u1","replay-user":"u2","password":"p1"}]`), 0600))
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, security.CreateTLSCertificates(zap.NewNop(), certFile, keyFile, "", 0, security.DefaultCertExpiration))
+	attrs := map[string]string{"_client_name": "test"}
+
+	// Without the signer, unmapped users fall back to the default user.
+	cr, err := newCredentialResolver(&ReplayConfig{Username: "root", Password: "123", CredentialFile: credFile})
+	require.NoError(t, err)
+	require.Equal(t, backend.Credential{User: "u2", Password: "p1", Attrs: attrs}, cr.resolve("u1", attrs))
+	require.Equal(t, backend.Credential{User: "root", Password: "123", Attrs: attrs}, cr.resolve("u3", attrs))
+	require.Equal(t, backend.Credential{User: "root", Password: "123"}, cr.resolve("", nil))
+
+	// With the signer, unmapped users log in with session tokens.
+	cr, err = newCredentialResolver(&ReplayConfig{Username: "root", CredentialFile: credFile,
+		SessionTokenSigningCert: certFile, SessionTokenSigningKey: keyFile})
+	require.NoError(t, err)
+	require.Equal(t, backend.Credential{User: "u2", Password: "p1", Attrs: attrs}, cr.resolve("u1", attrs))
+	cred := cr.resolve("u3", attrs)
+	require.Equal(t, "u3", cred.User)
+	require.Empty(t, cred.Password)
+	require.NotEmpty(t, cred.SessionToken)
+	require.Equal(t, attrs, cred.Attrs)
+	require.Equal(t, backend.Credential{User: "root"}, cr.resolve("", nil))
+
+	// Invalid configs.
+	_, err = newCredentialResolver(&ReplayConfig{Username: "root", SessionTokenSigningCert: certFile})
+	require.Error(t, err)
+	_, err = newCredentialResolver(&ReplayConfig{Username: "root", SessionTokenSigningCert: keyFile, SessionTokenSigningKey: certFile})
+	require.Error(t, err)
+	_, err = newCredentialResolver(&ReplayConfig{Username: "root", CredentialFile: filepath.Join(dir, "not_exist")})
+	require.Error(t, err)
+}
+
+func TestSignSessionToken(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, security.CreateTLSCertificates(zap.NewNop(), certFile, keyFile, "", 0, security.DefaultCertExpiration))
+	signer, err := newTokenSigner(certFile, keyFile)
+	require.NoError(t, err)
+
+	now := time.Now()
+	tokenStr, err := signer.sign("u1", now)
+	require.NoError(t, err)
+	var token sessionToken
+	require.NoError(t, json.Unmarshal([]byte(tokenStr), &token))
+	require.Equal(t, "u1", token.Username)
+	require.True(t, token.SignTime.Equal(now))
+	require.True(t, token.ExpireTime.Equal(now.Add(sessionTokenLifetime)))
+
+	// Verify the signature in the same way as TiDB.
+	signature := token.Signature
+	token.Signature = nil
+	content, err := json.Marshal(&token)
+	require.NoError(t, err)
+	require.NoError(t, signer.cert.CheckSignature(signer.cert.SignatureAlgorithm, content, signature))
+	content[0] = ' '
+	require.Error(t, signer.cert.CheckSignature(signer.cert.SignatureAlgorithm, content, signature))
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/tls"
 	"io"
+	"math"
 	"os"
 	"reflect"
 	"sync"
@@ -45,6 +46,12 @@ type Replay interface {
 	Stop(err error)
 	// Progress returns the progress of the replay job
 	Progress() (float64, time.Time, bool, error)
+	// SetSpeed changes the speed of the running replay
+	SetSpeed(speed float64) error
+	// Speed returns the current speed
+	Speed() float64
+	// Steps returns the statistics of each speed if the speed has changed
+	Steps() []SpeedStep
 	// Close closes the replay
 	Close()
 }
@@ -56,6 +63,25 @@ type ReplayConfig struct {
 	KeyFile  string
 	Speed    float64
 	ReadOnly bool
+	// ClusterIndex and ClusterCount are set when the replay is coordinated across all TiProxy instances.
+	// The input contains the traffic of all captured instances and this instance replays the ClusterIndex-th part.
+	ClusterIndex int
+	ClusterCount int
+	// RampStep and RampInterval enable the ramp mode, which increases the speed by RampStep every RampInterval
+	// until the maximum speed so that users can find the capacity of the cluster in one replay.
+	RampStep     float64
+	RampInterval time.Duration
+	// RegressionThreshold is the ratio by which the replay latency of a digest exceeds the captured one to be reported
+	// as a regression. It only works when the latencies are captured.
+	RegressionThreshold float64
+	// CredentialFile maps the captured users to the users and passwords to replay with. They are read from
+	// security.replay-auth of the config.
+	// SessionTokenSigningCert and SessionTokenSigningKey are the same as the ones of TiDB so that the connections
+	// whose users are not mapped can log in as the original users with session tokens.
+	// Connections are replayed with the default user if neither works.
+	CredentialFile          string
+	SessionTokenSigningCert string
+	SessionTokenSigningKey  string
 	// the following fields are for testing
 	reader            cmd.LineReader
 	report            report.Report
@@ -69,22 +95,35 @@ func (cfg *ReplayConfig) Validate() error {
 	if cfg.Input == "" {
 		return errors.New("input is required")
 	}
-	st, err := os.Stat(cfg.Input)
-	if err == nil {
-		if !st.IsDir() {
-			return errors.New("output should be a directory")
+	if store.IsLocal(cfg.Input) {
+		st, err := os.Stat(cfg.Input)
+		if err == nil {
+			if !st.IsDir() {
+				return errors.New("output should be a directory")
+			}
+		} else {
+			return errors.WithStack(err)
 		}
-	} else {
-		return errors.WithStack(err)
+	} else if _, err := store.NewStorage(cfg.Input); err != nil {
+		return err
 	}
 	if cfg.Username == "" {
 		return errors.New("username is required")
 	}
+	if cfg.ClusterCount < 0 || (cfg.ClusterCount > 0 && (cfg.ClusterIndex < 0 || cfg.ClusterIndex >= cfg.ClusterCount)) {
+		return errors.Errorf("invalid cluster index %d of %d instances", cfg.ClusterIndex, cfg.ClusterCount)
+	}
 	if cfg.Speed == 0 {
 		cfg.Speed = 1
 	} else if cfg.Speed < minSpeed || cfg.Speed > maxSpeed {
 		return errors.Errorf("speed should be between %f and %f", minSpeed, maxSpeed)
 	}
+	if cfg.RampInterval < 0 || cfg.RampStep < 0 || (cfg.RampInterval > 0) != (cfg.RampStep > 0) {
+		return errors.New("ramp-step and ramp-interval should be both positive")
+	}
+	if cfg.RegressionThreshold < 0 {
+		return errors.New("regression-threshold should be positive")
+	}
 	if cfg.abortThreshold == 0 {
 		cfg.abortThreshold = abortThreshold
 	}
@@ -97,23 +136,49 @@ func (cfg *ReplayConfig) Validate() error {
 	return nil
 }
 
+// SpeedStep is the statistics of replaying at one speed.
+type SpeedStep struct {
+	Speed      float64
+	StartTime  time.Time
+	Duration   time.Duration
+	QPS        float64
+	AvgLatency time.Duration
+	ErrorRate  float64
+}
+
+// stepStats is the snapshot of the replay stats at the start of a step.
+type stepStats struct {
+	startTime    time.Time
+	replayedCmds uint64
+	failedCmds   uint64
+	totalLatency int64
+}
+
 type replay struct {
 	sync.Mutex
-	cfg              ReplayConfig
-	meta             store.Meta
-	replayStats      conn.ReplayStats
-	idMgr            *id.IDManager
-	exceptionCh      chan conn.Exception
-	closeCh          chan uint64
-	wg               waitgroup.WaitGroup
-	cancel           context.CancelFunc
-	connCreator      conn.ConnCreator
-	report           report.Report
-	err              error
-	startTime        time.Time
-	endTime          time.Time
-	progress         float64
-	decodedCmds      atomic.Uint64
+	cfg         ReplayConfig
+	meta        store.Meta
+	inputs      []replayInput
+	partitioned bool
+	replayStats conn.ReplayStats
+	idMgr       *id.IDManager
+	exceptionCh chan conn.Exception
+	closeCh     chan uint64
+	wg          waitgroup.WaitGroup
+	cancel      context.CancelFunc
+	connCreator conn.ConnCreator
+	report      report.Report
+	err         error
+	startTime   time.Time
+	endTime     time.Time
+	progress    float64
+	decodedCmds atomic.Uint64
+	// speed is the bits of the current float64 speed, which may change during replay.
+	speed atomic.Uint64
+	// speedCh notifies readCommands to wake up when the speed changes.
+	speedCh          chan struct{}
+	steps            []SpeedStep
+	stepStats        stepStats
 	backendTLSConfig *tls.Config
 	lg               *zap.Logger
 }
@@ -129,10 +194,21 @@ func (r *replay) Start(cfg ReplayConfig, backendTLSConfig *tls.Config, hsHandler
 	if err := cfg.Validate(); err != nil {
 		return err
 	}
+	inputs, partitioned, err := clusterInputs(&cfg)
+	if err != nil {
+		return err
+	}
+
+	credResolver, err := newCredentialResolver(&cfg)
+	if err != nil {
+		return err
+	}
 
 	r.Lock()
 	defer r.Unlock()
 	r.cfg = cfg
+	r.inputs = inputs
+	r.partitioned = partitioned
 	r.meta = *r.readMeta()
 	r.startTime = time.Now()
 	r.endTime = time.Time{}
@@ -140,55 +216,192 @@ func (r *replay) Start(cfg ReplayConfig, backendTLSConfig *tls.Config, hsHandler
 	r.decodedCmds.Store(0)
 	r.err = nil
 	r.replayStats.Reset()
+	r.speed.Store(math.Float64bits(cfg.Speed))
+	r.speedCh = make(chan struct{}, 1)
+	r.steps = nil
+	r.stepStats = stepStats{startTime: r.startTime}
 	r.exceptionCh = make(chan conn.Exception, maxPendingExceptions)
 	r.closeCh = make(chan uint64, maxPendingExceptions)
 	hsHandler = NewHandshakeHandler(hsHandler)
 	r.connCreator = cfg.connCreator
 	if r.connCreator == nil {
-		r.connCreator = func(connID uint64) conn.Conn {
-			return conn.NewConn(r.lg.Named("conn"), r.cfg.Username, r.cfg.Password, backendTLSConfig, hsHandler, r.idMgr,
-				connID, bcConfig, r.exceptionCh, r.closeCh, cfg.ReadOnly, &r.replayStats)
+		// The results are compared with the captured ones, while the connections of the report needn't them.
+		connBCConfig := *bcConfig
+		connBCConfig.DigestResult = true
+		r.connCreator = func(connID uint64, user string, attrs map[string]string) conn.Conn {
+			return conn.NewConn(r.lg.Named("conn"), credResolver.resolve(user, attrs), backendTLSConfig, hsHandler, r.idMgr,
+				connID, &connBCConfig, r.exceptionCh, r.closeCh, cfg.ReadOnly, &r.replayStats)
 		}
 	}
 	r.report = cfg.report
 	if r.report == nil {
 		backendConnCreator := func() conn.BackendConn {
-			return conn.NewBackendConn(r.lg.Named("be"), r.idMgr.NewID(), hsHandler, bcConfig, backendTLSConfig, r.cfg.Username, r.cfg.Password)
+			return conn.NewBackendConn(r.lg.Named("be"), r.idMgr.NewID(), hsHandler, bcConfig, backendTLSConfig,
+				backend.Credential{User: r.cfg.Username, Password: r.cfg.Password})
 		}
-		r.report = report.NewReport(r.lg.Named("report"), r.exceptionCh, backendConnCreator)
+		r.report = report.NewReport(r.lg.Named("report"), r.exceptionCh, &r.replayStats.Latencies, backendConnCreator)
 	}
 
 	childCtx, cancel := context.WithCancel(context.Background())
 	r.cancel = cancel
 	if err := r.report.Start(childCtx, report.ReportConfig{
-		TlsConfig: r.backendTLSConfig,
+		TlsConfig:           r.backendTLSConfig,
+		RegressionThreshold: cfg.RegressionThreshold,
+		ClusterIndex:        cfg.ClusterIndex,
 	}); err != nil {
 		return err
 	}
 	r.wg.RunWithRecover(func() {
 		r.readCommands(childCtx)
 	}, nil, r.lg)
+	if cfg.RampInterval > 0 {
+		r.wg.RunWithRecover(func() {
+			r.rampSpeed(childCtx)
+		}, nil, r.lg)
+	}
+	return nil
+}
+
+// rampSpeed increases the speed every RampInterval and records the statistics of each speed.
+func (r *replay) rampSpeed(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.RampInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.Lock()
+		if r.startTime.IsZero() {
+			r.Unlock()
+			continue
+		}
+		speed := math.Min(r.Speed()+r.cfg.RampStep, maxSpeed)
+		if speed != r.Speed() {
+			r.lg.Info("ramp up replay speed", zap.Float64("speed", speed))
+			r.changeSpeed(speed)
+		}
+		r.Unlock()
+		// Stop ramping once the maximum speed is reached so that no more steps are recorded.
+		if speed >= maxSpeed {
+			return
+		}
+	}
+}
+
+// SetSpeed changes the speed of the running replay. The timing is recomputed from the current command.
+func (r *replay) SetSpeed(speed float64) error {
+	if speed < minSpeed || speed > maxSpeed {
+		return errors.Errorf("speed should be between %f and %f", minSpeed, maxSpeed)
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.startTime.IsZero() {
+		return errors.New("no replay is running")
+	}
+	if speed != r.Speed() {
+		r.lg.Info("update replay speed", zap.Float64("speed", speed))
+		r.changeSpeed(speed)
+	}
 	return nil
 }
 
+func (r *replay) Speed() float64 {
+	return math.Float64frombits(r.speed.Load())
+}
+
+func (r *replay) Steps() []SpeedStep {
+	r.Lock()
+	defer r.Unlock()
+	return append([]SpeedStep(nil), r.steps...)
+}
+
+// changeSpeed records the statistics of the current speed and then changes it. It's called with the lock held.
+func (r *replay) changeSpeed(speed float64) {
+	r.recordStep(time.Now())
+	r.speed.Store(math.Float64bits(speed))
+	select {
+	case r.speedCh <- struct{}{}:
+	default:
+	}
+}
+
+// recordStep records the statistics since the last step. It's called with the lock held.
+func (r *replay) recordStep(now time.Time) {
+	cur := stepStats{
+		startTime:    now,
+		replayedCmds: r.replayStats.ReplayedCmds.Load(),
+		failedCmds:   r.replayStats.FailedCmds.Load(),
+		totalLatency: r.replayStats.TotalLatency.Load(),
+	}
+	step := SpeedStep{
+		Speed:     r.Speed(),
+		StartTime: r.stepStats.startTime,
+		Duration:  now.Sub(r.stepStats.startTime),
+	}
+	if cmds := cur.replayedCmds - r.stepStats.replayedCmds; cmds > 0 {
+		step.QPS = float64(cmds) / step.Duration.Seconds()
+		step.AvgLatency = time.Duration((cur.totalLatency - r.stepStats.totalLatency) / int64(cmds))
+		step.ErrorRate = float64(cur.failedCmds-r.stepStats.failedCmds) / float64(cmds)
+	}
+	r.steps = append(r.steps, step)
+	r.stepStats = cur
+}
+
 func (r *replay) readCommands(ctx context.Context) {
 	// cfg.reader is set in tests
-	reader := r.cfg.reader
-	if reader == nil {
-		var err error
-		reader, err = store.NewReader(r.lg.Named("loader"), store.ReaderCfg{
-			Dir:           r.cfg.Input,
-			KeyFile:       r.cfg.KeyFile,
-			EncryptMethod: r.meta.EncryptMethod,
-		})
-		if err != nil {
-			r.stop(err)
-			return
+	var readers []cmd.LineReader
+	names := make([]string, 0, len(r.inputs))
+	if r.cfg.reader != nil {
+		readers = append(readers, r.cfg.reader)
+		names = append(names, "")
+	} else {
+		for _, input := range r.inputs {
+			reader, err := store.NewReader(r.lg.Named("loader"), store.ReaderCfg{
+				Dir:           input.uri,
+				KeyFile:       r.cfg.KeyFile,
+				EncryptMethod: r.meta.EncryptMethod,
+			})
+			if err != nil {
+				for _, reader := range readers {
+					reader.Close()
+				}
+				r.stop(err)
+				return
+			}
+			readers = append(readers, reader)
+			names = append(names, input.name)
 		}
 	}
-	defer reader.Close()
+	source := newMergedSource(names, readers, r.partitioned, r.cfg.ClusterIndex, r.cfg.ClusterCount)
+	defer source.Close()
 
 	var captureStartTs, replayStartTs time.Time
+	speed := r.Speed()
+	// If the speed changes, recompute the timing from the current point so that the replayed commands are not affected.
+	updateSpeed := func() {
+		newSpeed := r.Speed()
+		if newSpeed == speed || captureStartTs.IsZero() {
+			return
+		}
+		now := time.Now()
+		captureStartTs = captureStartTs.Add(time.Duration(float64(now.Sub(replayStartTs)) * speed))
+		replayStartTs, speed = now, newSpeed
+	}
+	untilCmd := func(command *cmd.Command) time.Duration {
+		// Do not use calculate the wait time by the duration since last command because the go scheduler
+		// may wait a little bit longer than expected, and then the difference becomes larger and larger.
+		expectedInterval := command.StartTs.Sub(captureStartTs)
+		if speed != 1 {
+			expectedInterval = time.Duration(float64(expectedInterval) / speed)
+		}
+		expectedInterval = time.Until(replayStartTs.Add(expectedInterval))
+		if expectedInterval < 0 {
+			expectedInterval = 0
+		}
+		return expectedInterval
+	}
 	conns := make(map[uint64]conn.Conn) // both alive and dead connections
 	connCount := 0                      // alive connection count
 	var err error
@@ -204,10 +417,10 @@ func (r *replay) readCommands(ctx context.Context) {
 			}
 		}
 
-		command := &cmd.Command{}
-		if err = command.Decode(reader); err != nil {
+		var command *cmd.Command
+		if command, err = source.next(); err != nil {
 			if errors.Is(err, io.EOF) {
-				r.lg.Info("replay reads EOF", zap.String("reader", reader.String()))
+				r.lg.Info("replay reads EOF", zap.String("reader", source.String()))
 				err = nil
 			}
 			break
@@ -217,6 +430,7 @@ func (r *replay) readCommands(ctx context.Context) {
 			captureStartTs = command.StartTs
 			replayStartTs = time.Now()
 		} else {
+			updateSpeed()
 			pendingCmds := r.replayStats.PendingCmds.Load()
 			if pendingCmds > maxPendingCmds {
 				maxPendingCmds = pendingCmds
@@ -229,32 +443,42 @@ func (r *replay) readCommands(ctx context.Context) {
 				break
 			}
 
-			// Do not use calculate the wait time by the duration since last command because the go scheduler
-			// may wait a little bit longer than expected, and then the difference becomes larger and larger.
-			expectedInterval := command.StartTs.Sub(captureStartTs)
-			if r.cfg.Speed != 1 {
-				expectedInterval = time.Duration(float64(expectedInterval) / r.cfg.Speed)
-			}
-			expectedInterval = time.Until(replayStartTs.Add(expectedInterval))
-			if expectedInterval < 0 {
-				expectedInterval = 0
-			}
 			// If there are too many pending commands, slow it down to reduce memory usage.
+			var extraWait time.Duration
 			if pendingCmds > r.cfg.slowDownThreshold {
-				extraWait := time.Duration(pendingCmds-r.cfg.slowDownThreshold) * r.cfg.slowDownFactor
+				extraWait = time.Duration(pendingCmds-r.cfg.slowDownThreshold) * r.cfg.slowDownFactor
 				totalWaitTime += extraWait
-				expectedInterval += extraWait
 				metrics.ReplayWaitTime.Set(float64(totalWaitTime.Nanoseconds()))
 			}
-			if expectedInterval > time.Microsecond {
+			for {
+				scheduledWait := untilCmd(command)
+				expectedInterval := scheduledWait + extraWait
+				if expectedInterval <= time.Microsecond {
+					break
+				}
+				waitStart := time.Now()
 				select {
 				case <-ctx.Done():
 				case <-time.After(expectedInterval):
+				case <-r.speedCh:
+					// The extra wait is applied after the scheduled time, so only the part waited beyond
+					// the scheduled time is consumed. The rest is kept after the timing is rescaled.
+					if waited := time.Since(waitStart) - scheduledWait; waited > 0 {
+						extraWait = max(extraWait-waited, 0)
+					}
+					updateSpeed()
+					continue
 				}
+				break
 			}
 		}
 		if ctx.Err() == nil {
-			r.executeCmd(ctx, command, conns, &connCount)
+			// In a partitioned cluster replay, the command may be replayed by another instance.
+			if source.accept(command) {
+				r.executeCmd(ctx, command, conns, &connCount)
+			} else {
+				r.decodedCmds.Add(1)
+			}
 		}
 	}
 	r.lg.Info("finished decoding commands, draining connections", zap.Int64("max_pending_cmds", maxPendingCmds),
@@ -279,7 +503,7 @@ func (r *replay) readCommands(ctx context.Context) {
 func (r *replay) executeCmd(ctx context.Context, command *cmd.Command, conns map[uint64]conn.Conn, connCount *int) {
 	conn, ok := conns[command.ConnID]
 	if !ok {
-		conn = r.connCreator(command.ConnID)
+		conn = r.connCreator(command.ConnID, command.User, command.ConnAttrs)
 		conns[command.ConnID] = conn
 		*connCount++
 		r.wg.RunWithRecover(func() {
@@ -311,10 +535,21 @@ func (r *replay) Progress() (float64, time.Time, bool, error) {
 	return r.progress, r.endTime, r.startTime.IsZero(), r.err
 }
 
+// readMeta reads the meta of all inputs and sums up the command counts.
 func (r *replay) readMeta() *store.Meta {
 	m := new(store.Meta)
-	if err := m.Read(r.cfg.Input); err != nil {
-		r.lg.Error("read meta failed", zap.Error(err))
+	for _, input := range r.inputs {
+		var im store.Meta
+		if err := im.Read(input.uri); err != nil {
+			r.lg.Error("read meta failed", zap.String("input", store.RedactURI(input.uri)), zap.Error(err))
+			continue
+		}
+		if len(m.Version) == 0 {
+			*m = im
+		} else {
+			m.Cmds += im.Cmds
+			m.FilteredCmds += im.FilteredCmds
+		}
 	}
 	return m
 }
@@ -343,6 +578,12 @@ func (r *replay) stop(err error) {
 		zap.Uint64("decoded_cmds", decodedCmds),
 		zap.Uint64("replayed_cmds", replayedCmds),
 		zap.Uint64("filtered_cmds", filteredCmds),
+		zap.Uint64("failed_cmds", r.replayStats.FailedCmds.Load()),
+		zap.Uint64("mismatched_cmds", r.replayStats.MismatchedCmds.Load()),
+	}
+	// Record the last speed if the speed ever changed.
+	if len(r.steps) > 0 || r.cfg.RampInterval > 0 {
+		r.recordStep(r.endTime)
 	}
 	if r.meta.Cmds > 0 {
 		r.progress = float64(decodedCmds) / float64(r.meta.Cmds)
@@ -4,13 +4,19 @@
 package replay
 
 import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/pingcap/tiproxy/lib/util/logger"
 	"github.com/pingcap/tiproxy/pkg/manager/id"
 	"github.com/pingcap/tiproxy/pkg/proxy/backend"
+	pnet "github.com/pingcap/tiproxy/pkg/proxy/net"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/conn"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/store"
@@ -28,7 +34,7 @@ func TestManageConns(t *testing.T) {
 		Input:    t.TempDir(),
 		Username: "u1",
 		reader:   loader,
-		connCreator: func(connID uint64) conn.Conn {
+		connCreator: func(connID uint64, _ string, _ map[string]string) conn.Conn {
 			connCount++
 			return &mockConn{
 				connID:  connID,
@@ -89,6 +95,11 @@ func TestValidateCfg(t *testing.T) {
 			Username: "u1",
 			Speed:    100,
 		},
+		{
+			Input:               dir,
+			Username:            "u1",
+			RegressionThreshold: -1,
+		},
 	}
 
 	for i, cfg := range cfgs {
@@ -110,7 +121,7 @@ func TestReplaySpeed(t *testing.T) {
 			Speed:    speed,
 			reader:   loader,
 			report:   newMockReport(replay.exceptionCh),
-			connCreator: func(connID uint64) conn.Conn {
+			connCreator: func(connID uint64, _ string, _ map[string]string) conn.Conn {
 				return &mockConn{
 					connID:  connID,
 					cmdCh:   cmdCh,
@@ -170,7 +181,7 @@ func TestProgress(t *testing.T) {
 		Username: "u1",
 		reader:   loader,
 		report:   newMockReport(replay.exceptionCh),
-		connCreator: func(connID uint64) conn.Conn {
+		connCreator: func(connID uint64, _ string, _ map[string]string) conn.Conn {
 			return &mockConn{
 				connID:  connID,
 				cmdCh:   cmdCh,
@@ -215,7 +226,7 @@ func TestPendingCmds(t *testing.T) {
 		Username: "u1",
 		reader:   loader,
 		report:   newMockReport(replay.exceptionCh),
-		connCreator: func(connID uint64) conn.Conn {
+		connCreator: func(connID uint64, _ string, _ map[string]string) conn.Conn {
 			return &mockPendingConn{
 				connID:  connID,
 				closeCh: replay.closeCh,
@@ -248,3 +259,189 @@ func TestPendingCmds(t *testing.T) {
 	require.Contains(t, logs, `"total_wait_time": "150ms"`)
 	require.Contains(t, logs, "too many pending commands")
 }
+
+func TestClusterReplay(t *testing.T) {
+	// 2 TiProxy instances captured the traffic and each one has 5 connections.
+	dir := t.TempDir()
+	now := time.Now()
+	captured := make(map[string]struct{})
+	for _, addr := range []string{"10.0.0.1:6000", "10.0.0.2:6000"} {
+		subDir := filepath.Join(dir, addr)
+		require.NoError(t, os.Mkdir(subDir, 0750))
+		writer, err := store.NewWriter(store.WriterCfg{Dir: subDir, FileSize: 1})
+		require.NoError(t, err)
+		for i := 0; i < 20; i++ {
+			command := newMockCommand(uint64(i%5 + 1))
+			command.StartTs = now.Add(time.Duration(i) * time.Millisecond)
+			query := fmt.Sprintf("%s %d %d", addr, command.ConnID, i)
+			command.Payload = append([]byte{pnet.ComQuery.Byte()}, []byte(query)...)
+			captured[query] = struct{}{}
+			var buf bytes.Buffer
+			require.NoError(t, command.Encode(&buf))
+			require.NoError(t, writer.Write(buf.Bytes()))
+		}
+		require.NoError(t, writer.Close())
+		require.NoError(t, store.NewMeta(time.Second, 20, 0, "").Write(subDir))
+	}
+
+	// The replaying instance count equals or differs from the captured instance count.
+	for _, count := range []int{2, 3} {
+		replayed := make(map[string]struct{})
+		for index := 0; index < count; index++ {
+			replay := NewReplay(zap.NewNop(), id.NewIDManager())
+			cmdCh := make(chan *cmd.Command, len(captured))
+			// captured connection -> replayed connection
+			connMap := make(map[string]uint64)
+			cfg := ReplayConfig{
+				Input:        dir,
+				Username:     "u1",
+				ClusterIndex: index,
+				ClusterCount: count,
+				report:       newMockReport(replay.exceptionCh),
+				connCreator: func(connID uint64, _ string, _ map[string]string) conn.Conn {
+					return &mockConn{
+						connID:  connID,
+						cmdCh:   cmdCh,
+						closeCh: replay.closeCh,
+						closed:  make(chan struct{}),
+					}
+				},
+			}
+			require.NoError(t, replay.Start(cfg, nil, nil, &backend.BCConfig{}))
+			require.Eventually(t, func() bool {
+				replay.Lock()
+				defer replay.Unlock()
+				return replay.startTime.IsZero()
+			}, 3*time.Second, 10*time.Millisecond)
+			progress, _, done, err := replay.Progress()
+			require.NoError(t, err)
+			require.True(t, done)
+			require.EqualValues(t, 1, progress, "count: %d, index: %d", count, index)
+			close(cmdCh)
+			for command := range cmdCh {
+				query := command.QueryText()
+				require.NotContains(t, replayed, query)
+				replayed[query] = struct{}{}
+				// All commands of a captured connection are replayed on the same connection.
+				fields := strings.Fields(query)
+				conn := fields[0] + fields[1]
+				if connID, ok := connMap[conn]; ok {
+					require.Equal(t, connID, command.ConnID)
+				} else {
+					connMap[conn] = command.ConnID
+				}
+			}
+			replay.Close()
+		}
+		require.Equal(t, captured, replayed, "count: %d", count)
+	}
+}
+
+func TestSetSpeed(t *testing.T) {
+	replay := NewReplay(zap.NewNop(), id.NewIDManager())
+	defer replay.Close()
+	require.Error(t, replay.SetSpeed(2))
+
+	cmdCh := make(chan *cmd.Command, 10)
+	loader := newMockNormalLoader()
+	defer loader.Close()
+	cfg := ReplayConfig{
+		Input:    t.TempDir(),
+		Username: "u1",
+		Speed:    0.1,
+		reader:   loader,
+		report:   newMockReport(replay.exceptionCh),
+		connCreator: func(connID uint64, _ string, _ map[string]string) conn.Conn {
+			return &mockConn{
+				connID:  connID,
+				cmdCh:   cmdCh,
+				closeCh: replay.closeCh,
+				closed:  make(chan struct{}),
+			}
+		},
+	}
+	// It takes 10s to replay the commands at the speed 0.1.
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		command := newMockCommand(1)
+		command.StartTs = now.Add(time.Duration(i*100) * time.Millisecond)
+		loader.writeCommand(command)
+	}
+	require.NoError(t, replay.Start(cfg, nil, nil, &backend.BCConfig{}))
+	<-cmdCh
+	require.Error(t, replay.SetSpeed(100))
+	require.NoError(t, replay.SetSpeed(10))
+	require.Equal(t, 10.0, replay.Speed())
+	startTime := time.Now()
+	for i := 1; i < 10; i++ {
+		<-cmdCh
+	}
+	require.Less(t, time.Since(startTime), 5*time.Second)
+	require.Eventually(t, func() bool {
+		_, _, done, _ := replay.Progress()
+		return done
+	}, 3*time.Second, 10*time.Millisecond)
+	// One step for each speed.
+	steps := replay.Steps()
+	require.Len(t, steps, 2)
+	require.Equal(t, 0.1, steps[0].Speed)
+	require.Equal(t, 10.0, steps[1].Speed)
+}
+
+func TestRampSpeed(t *testing.T) {
+	replay := NewReplay(zap.NewNop(), id.NewIDManager())
+	defer replay.Close()
+	cmdCh := make(chan *cmd.Command, 10)
+	loader := newMockNormalLoader()
+	defer loader.Close()
+	cfg := ReplayConfig{
+		Input:        t.TempDir(),
+		Username:     "u1",
+		RampStep:     3,
+		RampInterval: 100 * time.Millisecond,
+		reader:       loader,
+		report:       newMockReport(replay.exceptionCh),
+		connCreator: func(connID uint64, _ string, _ map[string]string) conn.Conn {
+			return &mockConn{
+				connID:  connID,
+				cmdCh:   cmdCh,
+				closeCh: replay.closeCh,
+				closed:  make(chan struct{}),
+			}
+		},
+	}
+	// It takes 10s to replay the commands at the speed 1.
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		command := newMockCommand(1)
+		command.StartTs = now.Add(time.Duration(i) * time.Second)
+		loader.writeCommand(command)
+	}
+	require.NoError(t, replay.Start(cfg, nil, nil, &backend.BCConfig{}))
+	for i := 0; i < 10; i++ {
+		<-cmdCh
+	}
+	require.Eventually(t, func() bool {
+		_, _, done, _ := replay.Progress()
+		return done
+	}, 3*time.Second, 10*time.Millisecond)
+	// The speed increases until the maximum speed and then the ramp stops.
+	steps := replay.Steps()
+	require.Len(t, steps, 4)
+	for i, speed := range []float64{1, 4, 7, 10} {
+		require.Equal(t, speed, steps[i].Speed)
+	}
+	require.Equal(t, maxSpeed, replay.Speed())
+}
+
+func TestRecordStep(t *testing.T) {
+	replay := NewReplay(zap.NewNop(), id.NewIDManager())
+	now := time.Now()
+	replay.speed.Store(math.Float64bits(2))
+	replay.stepStats = stepStats{startTime: now}
+	replay.replayStats.ReplayedCmds.Store(100)
+	replay.replayStats.FailedCmds.Store(5)
+	replay.replayStats.TotalLatency.Store(int64(100 * time.Millisecond))
+	replay.recordStep(now.Add(10 * time.Second))
+	require.Equal(t, []SpeedStep{{Speed: 2, StartTime: now, Duration: 10 * time.Second, QPS: 10, AvgLatency: time.Millisecond, ErrorRate: 0.05}}, replay.steps)
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package replay
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/store"
+)
+
+// replayInput is a directory of the captured traffic.
+type replayInput struct {
+	// name is the name of the sub-directory, which is the address of the captured TiProxy in a cluster replay.
+	// It's empty if it's not a cluster replay.
+	name string
+	uri  string
+}
+
+// clusterInputs returns the inputs that this instance replays and whether the connections need to be partitioned.
+// In a cluster replay, the input contains a sub-directory for each captured TiProxy.
+// If the numbers of captured and replaying instances are equal, each instance replays one directory.
+// Otherwise, each instance reads all the directories and replays the connections that hash to it.
+func clusterInputs(cfg *ReplayConfig) ([]replayInput, bool, error) {
+	if cfg.ClusterCount == 0 {
+		return []replayInput{{uri: cfg.Input}}, false, nil
+	}
+	storage, err := store.NewStorage(cfg.Input)
+	if err != nil {
+		return nil, false, err
+	}
+	dirs, err := storage.ListDirs(context.Background())
+	if err != nil {
+		return nil, false, err
+	}
+	if len(dirs) == 0 {
+		return nil, false, errors.Errorf("no captured traffic in %s", store.RedactURI(cfg.Input))
+	}
+	sort.Strings(dirs)
+	if len(dirs) == cfg.ClusterCount {
+		return []replayInput{{name: dirs[cfg.ClusterIndex], uri: store.JoinURI(cfg.Input, dirs[cfg.ClusterIndex])}}, false, nil
+	}
+	inputs := make([]replayInput, 0, len(dirs))
+	for _, dir := range dirs {
+		inputs = append(inputs, replayInput{name: dir, uri: store.JoinURI(cfg.Input, dir)})
+	}
+	return inputs, true, nil
+}
+
+type connKey struct {
+	source int
+	connID uint64
+}
+
+type commandSource struct {
+	name    string
+	reader  cmd.LineReader
+	command *cmd.Command
+	eof     bool
+}
+
+// mergedSource merges the commands of multiple captured instances in the order of StartTs.
+type mergedSource struct {
+	sources []*commandSource
+	// the index of the source of the last returned command
+	last        int
+	partitioned bool
+	index       int
+	count       int
+	// Connection IDs of different captured instances may conflict, so they are mapped to new IDs.
+	connIDs    map[connKey]uint64
+	nextConnID uint64
+}
+
+func newMergedSource(names []string, readers []cmd.LineReader, partitioned bool, index, count int) *mergedSource {
+	sources := make([]*commandSource, 0, len(readers))
+	for i, reader := range readers {
+		sources = append(sources, &commandSource{name: names[i], reader: reader})
+	}
+	return &mergedSource{
+		sources:     sources,
+		last:        -1,
+		partitioned: partitioned,
+		index:       index,
+		count:       count,
+		connIDs:     make(map[connKey]uint64),
+	}
+}
+
+// next returns the earliest command of all sources. It returns io.EOF when all sources are drained.
+func (m *mergedSource) next() (*cmd.Command, error) {
+	for _, source := range m.sources {
+		if source.command != nil || source.eof {
+			continue
+		}
+		command := &cmd.Command{}
+		if err := command.Decode(source.reader); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			source.eof = true
+			continue
+		}
+		source.command = command
+	}
+	m.last = -1
+	for i, source := range m.sources {
+		if source.command == nil {
+			continue
+		}
+		if m.last < 0 || source.command.StartTs.Before(m.sources[m.last].command.StartTs) {
+			m.last = i
+		}
+	}
+	if m.last < 0 {
+		return nil, io.EOF
+	}
+	command := m.sources[m.last].command
+	m.sources[m.last].command = nil
+	return command, nil
+}
+
+// accept returns whether this instance replays the last returned command.
+// It also assigns a unique connection ID to the command if there are multiple sources.
+func (m *mergedSource) accept(command *cmd.Command) bool {
+	if m.partitioned && hashConn(m.sources[m.last].name, command.ConnID)%uint64(m.count) != uint64(m.index) {
+		return false
+	}
+	if len(m.sources) <= 1 {
+		return true
+	}
+	key := connKey{source: m.last, connID: command.ConnID}
+	connID, ok := m.connIDs[key]
+	if !ok {
+		m.nextConnID++
+		connID = m.nextConnID
+		m.connIDs[key] = connID
+	}
+	command.ConnID = connID
+	return true
+}
+
+func (m *mergedSource) String() string {
+	names := make([]string, 0, len(m.sources))
+	for _, source := range m.sources {
+		names = append(names, source.reader.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func (m *mergedSource) Close() {
+	for _, source := range m.sources {
+		source.reader.Close()
+	}
+}
+
+// hashConn hashes the captured instance address and the connection ID so that the commands of a connection are
+// always replayed by the same instance.
+func hashConn(addr string, connID uint64) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(addr))
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], connID)
+	_, _ = h.Write(b[:])
+	return h.Sum64()
+}
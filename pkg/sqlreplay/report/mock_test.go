@@ -7,6 +7,7 @@ import (
 	"context"
 	"sync"
 
+	"github.com/pingcap/tiproxy/pkg/sqlreplay/cmd"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/conn"
 )
 
@@ -15,12 +16,14 @@ var _ ReportDB = (*mockReportDB)(nil)
 type mockReportDB struct {
 	sync.Mutex
 	exceptions map[conn.ExceptionType]map[string]*expCollection
+	latencies  map[string]conn.LatencySummary
+	regressed  map[string]bool
 }
 
 func (db *mockReportDB) Close() {
 }
 
-func (db *mockReportDB) Init(ctx context.Context) error {
+func (db *mockReportDB) Init(ctx context.Context, clusterIndex int) error {
 	db.clear()
 	return nil
 }
@@ -33,6 +36,8 @@ func (db *mockReportDB) clear() {
 		exceptions[conn.ExceptionType(i)] = make(map[string]*expCollection)
 	}
 	db.exceptions = exceptions
+	db.latencies = make(map[string]conn.LatencySummary)
+	db.regressed = make(map[string]bool)
 }
 
 func (db *mockReportDB) InsertExceptions(tp conn.ExceptionType, m map[string]*expCollection) error {
@@ -49,6 +54,16 @@ func (db *mockReportDB) InsertExceptions(tp conn.ExceptionType, m map[string]*ex
 	return nil
 }
 
+func (db *mockReportDB) InsertLatencies(summaries []conn.LatencySummary, threshold float64, clusterIndex int) error {
+	db.Lock()
+	defer db.Unlock()
+	for _, s := range summaries {
+		db.latencies[s.Digest] = s
+		db.regressed[s.Digest] = s.Regressed(threshold)
+	}
+	return nil
+}
+
 var _ conn.BackendConn = (*mockBackendConn)(nil)
 
 type mockBackendConn struct {
@@ -57,6 +72,7 @@ type mockBackendConn struct {
 	curID   uint32
 	stmtID  []uint32
 	args    [][]any
+	queries []string
 }
 
 func (c *mockBackendConn) Connect(ctx context.Context) error {
@@ -71,7 +87,12 @@ func (c *mockBackendConn) ExecuteCmd(ctx context.Context, request []byte) (err e
 	return c.execErr
 }
 
+func (c *mockBackendConn) LastResult() *cmd.Result {
+	return nil
+}
+
 func (c *mockBackendConn) Query(ctx context.Context, stmt string) error {
+	c.queries = append(c.queries, stmt)
 	return c.execErr
 }
 
@@ -8,6 +8,7 @@ import (
 	"crypto/tls"
 	"time"
 
+	"github.com/pingcap/tiproxy/lib/util/errors"
 	"github.com/pingcap/tiproxy/lib/util/waitgroup"
 	"github.com/pingcap/tiproxy/pkg/sqlreplay/conn"
 	"go.uber.org/zap"
@@ -15,6 +16,8 @@ import (
 
 const (
 	flushInterval = 10 * time.Second
+	// regressionThreshold is the default ratio by which the replay latency exceeds the captured one to be a regression.
+	regressionThreshold = 0.2
 )
 
 type expCollection struct {
@@ -34,11 +37,21 @@ func (c *expCollection) inc() {
 }
 
 type ReportConfig struct {
-	TlsConfig     *tls.Config
+	TlsConfig *tls.Config
+	// RegressionThreshold is the ratio by which the replay latency exceeds the captured one to be a regression.
+	RegressionThreshold float64
+	// ClusterIndex is the index of this TiProxy instance in a cluster replay.
+	ClusterIndex  int
 	flushInterval time.Duration
 }
 
 func (cfg *ReportConfig) Validate() error {
+	if cfg.RegressionThreshold < 0 {
+		return errors.New("regression threshold should be positive")
+	}
+	if cfg.RegressionThreshold == 0 {
+		cfg.RegressionThreshold = regressionThreshold
+	}
 	if cfg.flushInterval == 0 {
 		cfg.flushInterval = flushInterval
 	}
@@ -56,13 +69,14 @@ type report struct {
 	cfg         ReportConfig
 	exceptions  map[conn.ExceptionType]map[string]*expCollection
 	exceptionCh chan conn.Exception
+	latencies   *conn.LatencyStats
 	wg          waitgroup.WaitGroup
 	cancel      context.CancelFunc
 	db          ReportDB
 	lg          *zap.Logger
 }
 
-func NewReport(lg *zap.Logger, exceptionCh chan conn.Exception, connCreator BackendConnCreator) *report {
+func NewReport(lg *zap.Logger, exceptionCh chan conn.Exception, latencies *conn.LatencyStats, connCreator BackendConnCreator) *report {
 	exceptions := make(map[conn.ExceptionType]map[string]*expCollection, conn.Total)
 	for i := 0; i < int(conn.Total); i++ {
 		exceptions[conn.ExceptionType(i)] = make(map[string]*expCollection)
@@ -72,6 +86,7 @@ func NewReport(lg *zap.Logger, exceptionCh chan conn.Exception, connCreator Back
 		lg:          lg,
 		exceptions:  exceptions,
 		exceptionCh: exceptionCh,
+		latencies:   latencies,
 	}
 }
 
@@ -81,7 +96,7 @@ func (r *report) Start(ctx context.Context, cfg ReportConfig) error {
 	}
 	r.cfg = cfg
 
-	if err := r.db.Init(ctx); err != nil {
+	if err := r.db.Init(ctx, cfg.ClusterIndex); err != nil {
 		return err
 	}
 
@@ -127,6 +142,11 @@ func (r *report) flush() {
 			delete(m, k)
 		}
 	}
+	if summaries := r.latencies.Collect(); len(summaries) > 0 {
+		if err := r.db.InsertLatencies(summaries, r.cfg.RegressionThreshold, r.cfg.ClusterIndex); err != nil {
+			r.lg.Error("insert latencies failed", zap.Int("digests", len(summaries)), zap.Error(err))
+		}
+	}
 }
 
 func (r *report) Close() {
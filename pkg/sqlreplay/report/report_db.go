@@ -5,6 +5,7 @@ package report
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -19,18 +20,21 @@ import (
 type BackendConnCreator func() conn.BackendConn
 
 type ReportDB interface {
-	Init(ctx context.Context) error
+	// Init creates the tables and clears the latencies of the previous replay of this instance.
+	Init(ctx context.Context, clusterIndex int) error
 	InsertExceptions(tp conn.ExceptionType, m map[string]*expCollection) error
+	InsertLatencies(summaries []conn.LatencySummary, threshold float64, clusterIndex int) error
 	Close()
 }
 
 var _ ReportDB = (*reportDB)(nil)
 
 type reportDB struct {
-	stmtIDs     map[conn.ExceptionType]uint32
-	connCreator BackendConnCreator
-	conn        conn.BackendConn
-	lg          *zap.Logger
+	stmtIDs       map[conn.ExceptionType]uint32
+	latencyStmtID uint32
+	connCreator   BackendConnCreator
+	conn          conn.BackendConn
+	lg            *zap.Logger
 }
 
 func NewReportDB(lg *zap.Logger, connCreator BackendConnCreator) *reportDB {
@@ -40,11 +44,11 @@ func NewReportDB(lg *zap.Logger, connCreator BackendConnCreator) *reportDB {
 	}
 }
 
-func (rdb *reportDB) Init(ctx context.Context) error {
+func (rdb *reportDB) Init(ctx context.Context, clusterIndex int) error {
 	if err := rdb.connect(ctx); err != nil {
 		return err
 	}
-	if err := rdb.initTables(ctx); err != nil {
+	if err := rdb.initTables(ctx, clusterIndex); err != nil {
 		return err
 	}
 	return rdb.initStmts(ctx)
@@ -69,13 +73,17 @@ func (rdb *reportDB) connect(ctx context.Context) error {
 	return rdb.conn.ExecuteCmd(ctx, append([]byte{pnet.ComQuery.Byte()}, hack.Slice("set sql_mode=''")...))
 }
 
-func (rdb *reportDB) initTables(ctx context.Context) error {
+func (rdb *reportDB) initTables(ctx context.Context, clusterIndex int) error {
 	// Do not truncate database in case that multiple TiProxy instances are running.
-	for _, stmt := range []string{createDatabase, createFailTable, createOtherTable} {
+	for _, stmt := range []string{createDatabase, createFailTable, createMismatchTable, createOtherTable, createLatencyTable} {
 		if err := rdb.conn.Query(ctx, stmt); err != nil {
 			return errors.Wrapf(err, "initialize report database and tables failed")
 		}
 	}
+	// The latencies are overwritten rather than accumulated, so only remove the rows written by this instance.
+	if err := rdb.conn.Query(ctx, fmt.Sprintf(clearLatencyTable, clusterIndex)); err != nil {
+		return errors.Wrapf(err, "clear latency table failed")
+	}
 	return nil
 }
 
@@ -87,6 +95,12 @@ func (rdb *reportDB) initStmts(ctx context.Context) (err error) {
 	if rdb.stmtIDs[conn.Other], err = rdb.conn.PrepareStmt(ctx, insertOtherTable); err != nil {
 		return err
 	}
+	if rdb.stmtIDs[conn.Mismatch], err = rdb.conn.PrepareStmt(ctx, insertMismatchTable); err != nil {
+		return err
+	}
+	if rdb.latencyStmtID, err = rdb.conn.PrepareStmt(ctx, insertLatencyTable); err != nil {
+		return err
+	}
 	return
 }
 
@@ -99,33 +113,56 @@ func (rdb *reportDB) InsertExceptions(tp conn.ExceptionType, m map[string]*expCo
 			command := sample.Command()
 			args = []any{command.Type.String(), command.Digest(), command.QueryText(), sample.Error(), sample.ConnID(),
 				command.StartTs.String(), sample.Time().String(), value.count, value.count}
+		case conn.Mismatch:
+			sample := value.sample.(*conn.MismatchException)
+			command := sample.Command()
+			args = []any{command.Type.String(), command.Digest(), command.QueryText(), sample.Expected(), sample.Actual(),
+				sample.ConnID(), command.StartTs.String(), sample.Time().String(), value.count, value.count}
 		case conn.Other:
 			sample := value.sample.(*conn.OtherException)
 			args = []any{sample.Key(), sample.Error(), sample.Time().String(), value.count, value.count}
 		default:
 			return errors.WithStack(errors.New("unknown exception type"))
 		}
-		// retry in case of disconnection
-		ctx := context.Background()
-		err := retry.Retry(func() error {
-			err := rdb.conn.ExecuteStmt(ctx, rdb.stmtIDs[tp], args)
-			if err == nil {
-				return nil
-			}
-			if pnet.IsDisconnectError(err) {
-				if err := rdb.reconnect(ctx); err != nil {
-					return backoff.Permanent(err)
-				}
-			}
+		if err := rdb.executeStmt(func() uint32 { return rdb.stmtIDs[tp] }, args); err != nil {
 			return err
-		}, ctx, 100*time.Millisecond, 3)
-		if err != nil {
+		}
+	}
+	return nil
+}
+
+// InsertLatencies overwrites the latencies of the digests and marks the regressions that exceed the threshold.
+func (rdb *reportDB) InsertLatencies(summaries []conn.LatencySummary, threshold float64, clusterIndex int) error {
+	toMs := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	for _, s := range summaries {
+		args := []any{s.CmdType.String(), s.Digest, clusterIndex, s.SampleStmt, s.Count, toMs(s.CaptureP50), toMs(s.CaptureP99),
+			toMs(s.CaptureAvg), toMs(s.ReplayP50), toMs(s.ReplayP99), toMs(s.ReplayAvg), s.Regressed(threshold)}
+		if err := rdb.executeStmt(func() uint32 { return rdb.latencyStmtID }, args); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// executeStmt retries in case of disconnection. The statement ID changes after reconnection.
+func (rdb *reportDB) executeStmt(stmtID func() uint32, args []any) error {
+	ctx := context.Background()
+	return retry.Retry(func() error {
+		err := rdb.conn.ExecuteStmt(ctx, stmtID(), args)
+		if err == nil {
+			return nil
+		}
+		if pnet.IsDisconnectError(err) {
+			if err := rdb.reconnect(ctx); err != nil {
+				return backoff.Permanent(err)
+			}
+		}
+		return err
+	}, ctx, 100*time.Millisecond, 3)
+}
+
 func (rdb *reportDB) Close() {
 	if rdb.conn != nil {
 		rdb.conn.Close()
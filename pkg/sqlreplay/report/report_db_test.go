@@ -43,7 +43,7 @@ func TestInitDB(t *testing.T) {
 				require.NoErrorf(t, err, "case %d", i)
 			}
 		}
-		err := db.Init(context.Background())
+		err := db.Init(context.Background(), 0)
 		checkErr(err)
 		err = db.reconnect(context.Background())
 		checkErr(err)
@@ -58,6 +58,9 @@ func TestInsertExceptions(t *testing.T) {
 	otherSample1 := conn.NewOtherException(errors.Wrapf(errors.New("mock error"), "wrap"), 1)
 	otherSample2 := conn.NewOtherException(errors.New("mock error"), 1)
 	otherSample3 := conn.NewOtherException(errors.New("another error"), 2)
+	mismatchCmd := cmd.NewCommand(append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...), now, 1)
+	mismatchCmd.Result = &cmd.Result{Rows: 1, Digest: "abc"}
+	mismatchSample := conn.NewMismatchException(mismatchCmd, &cmd.Result{Rows: 2, Digest: "def"})
 	tests := []struct {
 		tp     conn.ExceptionType
 		colls  map[string]*expCollection
@@ -103,6 +106,19 @@ func TestInsertExceptions(t *testing.T) {
 			args: [][]any{{"Query", "e1c71d1661ae46e09b7aaec1c390957f0d6260410df4e4bc71b9c8d681021471", "select 1", "mock error",
 				uint64(1), now.String(), failSample.Time().String(), uint64(1), uint64(1)}},
 		},
+		{
+			tp: conn.Mismatch,
+			colls: map[string]*expCollection{
+				"\x03e1c71d1661ae46e09b7aaec1c390957f0d6260410df4e4bc71b9c8d681021471": {
+					count:  2,
+					sample: mismatchSample,
+				},
+			},
+			stmtID: []uint32{3},
+			args: [][]any{{"Query", "e1c71d1661ae46e09b7aaec1c390957f0d6260410df4e4bc71b9c8d681021471", "select 1",
+				"affected_rows=0 rows=1 digest=abc", "affected_rows=0 rows=2 digest=def", uint64(1), now.String(),
+				mismatchSample.Time().String(), uint64(2), uint64(2)}},
+		},
 	}
 
 	lg, _ := logger.CreateLoggerForTest(t)
@@ -112,7 +128,7 @@ func TestInsertExceptions(t *testing.T) {
 			return cn
 		}
 		db := NewReportDB(lg, connCreator)
-		err := db.Init(context.Background())
+		err := db.Init(context.Background(), 0)
 		require.NoErrorf(t, err, "case %d", i)
 		cn.clear()
 		err = db.InsertExceptions(test.tp, test.colls)
@@ -126,3 +142,33 @@ func TestInsertExceptions(t *testing.T) {
 		db.Close()
 	}
 }
+
+func TestInsertLatencies(t *testing.T) {
+	summaries := []conn.LatencySummary{
+		{
+			CmdType:    pnet.ComQuery,
+			Digest:     "abc",
+			SampleStmt: "select 1",
+			Count:      10,
+			CaptureP50: time.Millisecond,
+			CaptureP99: 2 * time.Millisecond,
+			CaptureAvg: time.Millisecond,
+			ReplayP50:  time.Millisecond,
+			ReplayP99:  3 * time.Millisecond,
+			ReplayAvg:  1500 * time.Microsecond,
+		},
+	}
+	lg, _ := logger.CreateLoggerForTest(t)
+	cn := &mockBackendConn{}
+	db := NewReportDB(lg, func() conn.BackendConn {
+		return cn
+	})
+	require.NoError(t, db.Init(context.Background(), 1))
+	// The latencies of the previous replay of this instance are cleared.
+	require.Equal(t, "delete from tiproxy_traffic_replay.latency where cluster_index = 1", cn.queries[len(cn.queries)-1])
+	cn.clear()
+	require.NoError(t, db.InsertLatencies(summaries, 0.2, 1))
+	require.Equal(t, []uint32{4}, cn.stmtID)
+	require.Equal(t, [][]any{{"Query", "abc", 1, "select 1", uint64(10), 1.0, 2.0, 1.0, 1.0, 3.0, 1.5, true}}, cn.args)
+	db.Close()
+}
@@ -38,7 +38,8 @@ func TestReadExceptions(t *testing.T) {
 						sample: otherSample1,
 					},
 				},
-				conn.Fail: {},
+				conn.Fail:     {},
+				conn.Mismatch: {},
 			},
 		},
 		{
@@ -53,7 +54,8 @@ func TestReadExceptions(t *testing.T) {
 						sample: otherSample1,
 					},
 				},
-				conn.Fail: {},
+				conn.Fail:     {},
+				conn.Mismatch: {},
 			},
 		},
 		{
@@ -71,7 +73,8 @@ func TestReadExceptions(t *testing.T) {
 						sample: otherSample2,
 					},
 				},
-				conn.Fail: {},
+				conn.Fail:     {},
+				conn.Mismatch: {},
 			},
 		},
 		{
@@ -95,13 +98,14 @@ func TestReadExceptions(t *testing.T) {
 						sample: failSample,
 					},
 				},
+				conn.Mismatch: {},
 			},
 		},
 	}
 
 	lg, _ := logger.CreateLoggerForTest(t)
 	exceptionCh := make(chan conn.Exception, 1)
-	report := NewReport(lg, exceptionCh, nil)
+	report := NewReport(lg, exceptionCh, &conn.LatencyStats{}, nil)
 	db := &mockReportDB{}
 	report.db = db
 	defer report.Close()
@@ -118,3 +122,33 @@ func TestReadExceptions(t *testing.T) {
 		}, 3*time.Second, 10*time.Millisecond, "case %d", i)
 	}
 }
+
+func TestReportLatencies(t *testing.T) {
+	lg, _ := logger.CreateLoggerForTest(t)
+	latencies := &conn.LatencyStats{}
+	report := NewReport(lg, make(chan conn.Exception, 1), latencies, nil)
+	db := &mockReportDB{}
+	report.db = db
+	defer report.Close()
+	require.NoError(t, report.Start(context.Background(), ReportConfig{flushInterval: 10 * time.Millisecond, RegressionThreshold: 0.5}))
+
+	command := cmd.NewCommand(append([]byte{pnet.ComQuery.Byte()}, []byte("select 1")...), time.Now(), 1)
+	command.Duration = 10 * time.Millisecond
+	latencies.Add(command, 12*time.Millisecond)
+	require.Eventually(t, func() bool {
+		db.Lock()
+		defer db.Unlock()
+		return db.latencies[command.Digest()].Count == 1
+	}, 3*time.Second, 10*time.Millisecond)
+	db.Lock()
+	require.False(t, db.regressed[command.Digest()])
+	db.Unlock()
+
+	// The latencies are cumulative.
+	latencies.Add(command, 100*time.Millisecond)
+	require.Eventually(t, func() bool {
+		db.Lock()
+		defer db.Unlock()
+		return db.latencies[command.Digest()].Count == 2 && db.regressed[command.Digest()]
+	}, 3*time.Second, 10*time.Millisecond)
+}
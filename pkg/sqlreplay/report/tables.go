@@ -20,6 +20,47 @@ const (
 	cmd_type, digest, sample_stmt, sample_err_msg, sample_conn_id, sample_capture_time, sample_replay_time, count)
 	values(?, ?, ?, ?, ?, ?, ?, ?) on duplicate key update count = count + ?`
 
+	createMismatchTable = `create table if not exists tiproxy_traffic_replay.result_mismatch(
+    cmd_type varchar(32),
+    digest varchar(128),
+    sample_stmt text,
+    sample_capture_result text,
+    sample_replay_result text,
+    sample_conn_id bigint,
+    sample_capture_time timestamp,
+    sample_replay_time timestamp,
+    count bigint,
+    primary key(cmd_type, digest))`
+	insertMismatchTable = `insert into tiproxy_traffic_replay.result_mismatch(
+	cmd_type, digest, sample_stmt, sample_capture_result, sample_replay_result, sample_conn_id, sample_capture_time, sample_replay_time, count)
+	values(?, ?, ?, ?, ?, ?, ?, ?, ?) on duplicate key update count = count + ?`
+
+	// The latencies are cumulative so the rows are overwritten. Each TiProxy instance of a cluster replay writes its own rows
+	// and clears them when the replay starts, so that the digests of earlier replays are not compared.
+	createLatencyTable = `create table if not exists tiproxy_traffic_replay.latency(
+    cmd_type varchar(32),
+    digest varchar(128),
+    cluster_index int,
+    sample_stmt text,
+    count bigint,
+    capture_p50_ms double,
+    capture_p99_ms double,
+    capture_avg_ms double,
+    replay_p50_ms double,
+    replay_p99_ms double,
+    replay_avg_ms double,
+    regression boolean,
+    primary key(cmd_type, digest, cluster_index))`
+	insertLatencyTable = `insert into tiproxy_traffic_replay.latency(
+	cmd_type, digest, cluster_index, sample_stmt, count, capture_p50_ms, capture_p99_ms, capture_avg_ms,
+	replay_p50_ms, replay_p99_ms, replay_avg_ms, regression)
+	values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) on duplicate key update count = values(count),
+	capture_p50_ms = values(capture_p50_ms), capture_p99_ms = values(capture_p99_ms), capture_avg_ms = values(capture_avg_ms),
+	replay_p50_ms = values(replay_p50_ms), replay_p99_ms = values(replay_p99_ms), replay_avg_ms = values(replay_avg_ms),
+	regression = values(regression)`
+
+	clearLatencyTable = `delete from tiproxy_traffic_replay.latency where cluster_index = %d`
+
 	createOtherTable = `create table if not exists tiproxy_traffic_replay.other_errors(
     err_type varchar(256) primary key,
     sample_err_msg text,
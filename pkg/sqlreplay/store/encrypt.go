@@ -9,7 +9,6 @@ import (
 	"crypto/rand"
 	"io"
 	"os"
-	"reflect"
 
 	"github.com/pingcap/tiproxy/lib/util/errors"
 )
@@ -82,7 +81,7 @@ func newAESCTRReader(reader Reader, keyFile string) (*aesCTRReader, error) {
 }
 
 func (ctr *aesCTRReader) Read(data []byte) (int, error) {
-	if ctr.stream == nil || reflect.ValueOf(ctr.stream).IsNil() {
+	if ctr.stream == nil {
 		if err := ctr.init(); err != nil {
 			return 0, err
 		}
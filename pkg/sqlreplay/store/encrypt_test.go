@@ -26,7 +26,7 @@ func TestAes256(t *testing.T) {
 	require.NoError(t, aesWriter.Write([]byte("test")))
 	require.NoError(t, aesWriter.Close())
 
-	rotateReader := newRotateReader(zap.NewNop(), dir)
+	rotateReader := newRotateReader(zap.NewNop(), newLocalStorage(dir))
 	aesReader, err := newAESCTRReader(rotateReader, keyFile)
 	require.NoError(t, err)
 	data := make([]byte, 100)
@@ -48,7 +48,7 @@ func TestAes256Error(t *testing.T) {
 	_, err := newAESCTRWriter(rotateWriter, keyFile)
 	require.Error(t, err)
 
-	rotateReader := newRotateReader(zap.NewNop(), dir)
+	rotateReader := newRotateReader(zap.NewNop(), newLocalStorage(dir))
 	_, err = newAESCTRReader(rotateReader, keyFile)
 	require.Error(t, err)
 }
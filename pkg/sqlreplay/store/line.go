@@ -25,8 +25,18 @@ type WriterCfg struct {
 	Compress      bool
 }
 
+// NewWriter creates a writer of the traffic files. cfg.Dir is either a local directory or an URI of an object storage.
 func NewWriter(cfg WriterCfg) (Writer, error) {
-	rotateWriter := newRotateWriter(cfg)
+	var rotateWriter Writer
+	if IsLocal(cfg.Dir) {
+		rotateWriter = newRotateWriter(cfg)
+	} else {
+		storage, err := NewStorage(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		rotateWriter = newObjectRotateWriter(storage, cfg)
+	}
 	encryptMethod := strings.ToLower(cfg.EncryptMethod)
 	switch encryptMethod {
 	case "", EncryptPlain:
@@ -55,14 +65,18 @@ type loader struct {
 	lg          *zap.Logger
 }
 
+// NewReader creates a reader of the traffic files. cfg.Dir is either a local directory or an URI of an object storage.
 func NewReader(lg *zap.Logger, cfg ReaderCfg) (*loader, error) {
+	storage, err := NewStorage(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
 	var reader Reader
-	reader = newRotateReader(lg, cfg.Dir)
+	reader = newRotateReader(lg, storage)
 	encryptMethod := strings.ToLower(cfg.EncryptMethod)
 	switch encryptMethod {
 	case "", EncryptPlain:
 	case EncryptAes:
-		var err error
 		reader, err = newAESCTRReader(reader, cfg.KeyFile)
 		if err != nil {
 			return nil, err
@@ -4,9 +4,8 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/pingcap/tiproxy/lib/util/errors"
@@ -23,6 +22,11 @@ type Meta struct {
 	Cmds          uint64
 	FilteredCmds  uint64 `json:"FilteredCmds,omitempty"`
 	EncryptMethod string `json:"EncryptMethod,omitempty"`
+	// Filter describes the capture filter if only part of the traffic is captured.
+	Filter string `json:"Filter,omitempty"`
+	// ExcludedConns and ExcludedCmds are the numbers of connections and commands skipped by the filter.
+	ExcludedConns uint64 `json:"ExcludedConns,omitempty"`
+	ExcludedCmds  uint64 `json:"ExcludedCmds,omitempty"`
 }
 
 func NewMeta(duration time.Duration, cmds, filteredCmds uint64, EncryptMethod string) *Meta {
@@ -35,23 +39,27 @@ func NewMeta(duration time.Duration, cmds, filteredCmds uint64, EncryptMethod st
 	}
 }
 
+// Write writes the meta to the storage, which is either a local directory or an URI of an object storage.
 func (m *Meta) Write(path string) error {
-	filePath := filepath.Join(path, metaFile)
-	b, err := json.Marshal(m)
+	storage, err := NewStorage(path)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
-	if err = os.WriteFile(filePath, b, 0600); err != nil {
+	b, err := json.Marshal(m)
+	if err != nil {
 		return errors.WithStack(err)
 	}
-	return nil
+	return storage.WriteFile(context.Background(), metaFile, b)
 }
 
 func (m *Meta) Read(path string) error {
-	filePath := filepath.Join(path, metaFile)
-	b, err := os.ReadFile(filePath)
+	storage, err := NewStorage(path)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
+	}
+	b, err := storage.ReadFile(context.Background(), metaFile)
+	if err != nil {
+		return err
 	}
 	if err = json.Unmarshal(b, m); err != nil {
 		return errors.WithStack(err)
@@ -60,10 +68,16 @@ func (m *Meta) Read(path string) error {
 }
 
 func PreCheckMeta(path string) error {
-	filePath := filepath.Join(path, metaFile)
-	_, err := os.Stat(filePath)
-	if errors.Is(err, os.ErrNotExist) {
+	storage, err := NewStorage(path)
+	if err != nil {
+		return err
+	}
+	exists, err := storage.FileExists(context.Background(), metaFile)
+	if err != nil {
+		return err
+	}
+	if !exists {
 		return nil
 	}
-	return errors.Errorf("file %s already exists, please remove it before capture", filePath)
+	return errors.Errorf("file %s already exists, please remove it before capture", storage.URI(metaFile))
 }
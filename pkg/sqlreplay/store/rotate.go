@@ -6,9 +6,9 @@ package store
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"io"
 	"math"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -52,6 +52,96 @@ func (w *rotateWriter) Close() error {
 	return w.lg.Close()
 }
 
+var _ Writer = (*objectRotateWriter)(nil)
+
+// objectRotateWriter writes the traffic to an object storage, where files can not be renamed or appended.
+// Unlike rotateWriter, each file is named with its creation time when it's created and compressed on the fly.
+type objectRotateWriter struct {
+	storage  ExternalStorage
+	fileSize int
+	compress bool
+	writer   io.WriteCloser
+	gz       *gzip.Writer
+	written  int
+	lastTs   time.Time
+}
+
+func newObjectRotateWriter(storage ExternalStorage, cfg WriterCfg) *objectRotateWriter {
+	if cfg.FileSize == 0 {
+		cfg.FileSize = fileSize
+	}
+	return &objectRotateWriter{
+		storage:  storage,
+		fileSize: cfg.FileSize * 1024 * 1024,
+		compress: cfg.Compress,
+	}
+}
+
+func (w *objectRotateWriter) Write(data []byte) error {
+	if w.writer == nil {
+		if err := w.openFile(); err != nil {
+			return err
+		}
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(data)
+	} else {
+		_, err = w.writer.Write(data)
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	w.written += len(data)
+	if w.written >= w.fileSize {
+		return w.closeFile()
+	}
+	return nil
+}
+
+func (w *objectRotateWriter) openFile() error {
+	// The reader sorts the files by the time in the names, so the time must be increasing.
+	ts := time.Now().Truncate(time.Millisecond)
+	if !ts.After(w.lastTs) {
+		ts = w.lastTs.Add(time.Millisecond)
+	}
+	w.lastTs = ts
+	name := fileNamePrefix + "-" + ts.Format(fileTsLayout) + fileNameSuffix
+	if w.compress {
+		name += fileCompressFormat
+	}
+	writer, err := w.storage.Create(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	w.writer = writer
+	if w.compress {
+		w.gz = gzip.NewWriter(writer)
+	}
+	w.written = 0
+	return nil
+}
+
+func (w *objectRotateWriter) closeFile() error {
+	if w.writer == nil {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		err = errors.WithStack(w.gz.Close())
+		w.gz = nil
+	}
+	if closeErr := w.writer.Close(); err == nil {
+		err = closeErr
+	}
+	w.writer = nil
+	return err
+}
+
+func (w *objectRotateWriter) Close() error {
+	return w.closeFile()
+}
+
 type Reader interface {
 	Read([]byte) (int, error)
 	CurFile() string
@@ -61,19 +151,19 @@ type Reader interface {
 var _ Reader = (*rotateReader)(nil)
 
 type rotateReader struct {
-	dir string
+	storage ExternalStorage
 	// the time in the file name
 	curFileName string
 	curFileTs   int64
-	curFile     *os.File
+	curFile     io.ReadCloser
 	reader      *bufio.Reader
 	lg          *zap.Logger
 }
 
-func newRotateReader(lg *zap.Logger, dir string) *rotateReader {
+func newRotateReader(lg *zap.Logger, storage ExternalStorage) *rotateReader {
 	return &rotateReader{
-		dir: dir,
-		lg:  lg,
+		storage: storage,
+		lg:      lg,
 	}
 }
 
@@ -108,7 +198,7 @@ func (r *rotateReader) CurFile() string {
 func (r *rotateReader) Close() {
 	if r.curFile != nil {
 		if err := r.curFile.Close(); err != nil {
-			r.lg.Warn("failed to close file", zap.String("filename", r.curFile.Name()), zap.Error(err))
+			r.lg.Warn("failed to close file", zap.String("filename", r.storage.URI(r.curFileName)), zap.Error(err))
 		}
 		r.curFile = nil
 	}
@@ -119,17 +209,13 @@ func (r *rotateReader) nextReader() error {
 	if r.curFileTs == math.MaxInt64 {
 		return io.EOF
 	}
-	files, err := os.ReadDir(r.dir)
+	files, err := r.storage.ListFiles(context.Background())
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 	var minFileTs int64
 	var minFileName string
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		name := file.Name()
+	for _, name := range files {
 		if !strings.HasPrefix(name, fileNamePrefix) {
 			continue
 		}
@@ -158,13 +244,13 @@ func (r *rotateReader) nextReader() error {
 	if minFileName == "" {
 		return io.EOF
 	}
-	fileReader, err := os.Open(filepath.Join(r.dir, minFileName))
+	fileReader, err := r.storage.Open(context.Background(), minFileName)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 	if r.curFile != nil {
 		if err := r.curFile.Close(); err != nil {
-			r.lg.Warn("failed to close file", zap.String("filename", r.curFile.Name()), zap.Error(err))
+			r.lg.Warn("failed to close file", zap.String("filename", r.storage.URI(r.curFileName)), zap.Error(err))
 		}
 	}
 	r.curFile = fileReader
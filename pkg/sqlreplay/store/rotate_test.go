@@ -172,7 +172,7 @@ func TestIterateFiles(t *testing.T) {
 			}
 			require.NoError(t, f.Close())
 		}
-		l := newRotateReader(lg, dir)
+		l := newRotateReader(lg, newLocalStorage(dir))
 		fileOrder := make([]string, 0, len(test.order))
 		for {
 			if err := l.nextReader(); err != nil {
@@ -224,7 +224,7 @@ func TestReadGZip(t *testing.T) {
 		require.NoError(t, writer.Close())
 
 		lg, _ := logger.CreateLoggerForTest(t)
-		l := newRotateReader(lg, tmpDir)
+		l := newRotateReader(lg, newLocalStorage(tmpDir))
 		for i := 0; i < 11; i++ {
 			data = make([]byte, 100*1024)
 			_, err := io.ReadFull(l, data)
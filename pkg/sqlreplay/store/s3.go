@@ -0,0 +1,260 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/pingcap/tiproxy/lib/util/waitgroup"
+)
+
+const (
+	// The options in the query of the S3 URI. They are consistent with BR.
+	s3Endpoint       = "endpoint"
+	s3Region         = "region"
+	s3ForcePathStyle = "force-path-style"
+	// The credentials are not accepted in the URI, but they are still redacted if users pass them.
+	s3AccessKey       = "access-key"
+	s3SecretAccessKey = "secret-access-key"
+	s3SessionToken    = "session-token"
+
+	defaultS3Region = "us-east-1"
+	// s3MaxAttempts is the max attempts of each request, which are retried with backoff on throttling and 5xx errors.
+	s3MaxAttempts = 10
+	// S3 requires that each part except the last one is at least 5MB.
+	minPartSize = manager.MinUploadPartSize
+)
+
+var _ ExternalStorage = (*s3Storage)(nil)
+
+// s3Storage stores the files in AWS S3 or S3-compatible storages such as MinIO.
+// The credentials are loaded by the standard credential chain of the AWS SDK, such as the environment variables,
+// the shared profile, the web identity and the instance role.
+type s3Storage struct {
+	client   *s3.Client
+	bucket   string
+	prefix   string
+	partSize int64
+	redacted string
+}
+
+func newS3Storage(u *url.URL) (*s3Storage, error) {
+	if len(u.Host) == 0 {
+		return nil, errors.Errorf("bucket is required in %s", RedactURI(u.String()))
+	}
+	query := u.Query()
+	for _, key := range []string{s3AccessKey, s3SecretAccessKey, s3SessionToken} {
+		if query.Has(key) {
+			return nil, errors.Errorf("%s in the URI is not supported, set the credentials in the environment or the instance role instead", key)
+		}
+	}
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRetryMaxAttempts(s3MaxAttempts)}
+	if region := query.Get(s3Region); len(region) > 0 {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(cfg.Region) == 0 {
+		cfg.Region = defaultS3Region
+	}
+	// Some S3-compatible storages don't support the checksums that are enabled by default.
+	cfg.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+	cfg.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+
+	// Use the virtual-hosted style for AWS and the path style for other endpoints by default.
+	endpoint := query.Get(s3Endpoint)
+	pathStyle := len(endpoint) > 0
+	if len(endpoint) > 0 {
+		eu, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(eu.Scheme) == 0 || len(eu.Host) == 0 {
+			return nil, errors.Errorf("invalid endpoint %s", endpoint)
+		}
+	}
+	if v := query.Get(s3ForcePathStyle); len(v) > 0 {
+		if pathStyle, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", s3ForcePathStyle)
+		}
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if len(endpoint) > 0 {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = pathStyle
+	})
+	return &s3Storage{
+		client:   client,
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		partSize: minPartSize,
+		redacted: RedactURI(u.String()),
+	}, nil
+}
+
+func (s *s3Storage) key(name string) string {
+	if len(s.prefix) == 0 {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3Storage) URI(name string) string {
+	u, err := url.Parse(s.redacted)
+	if err != nil {
+		return s.redacted
+	}
+	u.Path = path.Join("/", u.Path, name)
+	return u.String()
+}
+
+// wrapErr adds the file to the error and wraps os.ErrNotExist if the file doesn't exist.
+func (s *s3Storage) wrapErr(err error, name string) error {
+	if err == nil {
+		return nil
+	}
+	err = errors.Wrapf(err, "access %s", s.URI(name))
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		err = errors.Wrap(err, os.ErrNotExist)
+	}
+	return err
+}
+
+func (s *s3Storage) WriteFile(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   bytes.NewReader(data),
+	})
+	return s.wrapErr(err, name)
+}
+
+func (s *s3Storage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	rc, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	return b, errors.WithStack(err)
+}
+
+func (s *s3Storage) FileExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err = s.wrapErr(err, name); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *s3Storage) ListFiles(ctx context.Context) ([]string, error) {
+	files, _, err := s.list(ctx)
+	return files, err
+}
+
+func (s *s3Storage) ListDirs(ctx context.Context) ([]string, error) {
+	_, dirs, err := s.list(ctx)
+	return dirs, err
+}
+
+// list returns the names of the files and the sub-directories directly under the prefix.
+func (s *s3Storage) list(ctx context.Context) (files, dirs []string, err error) {
+	prefix := s.key("")
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, nil, s.wrapErr(err, "")
+		}
+		for _, content := range page.Contents {
+			if name := strings.TrimPrefix(aws.ToString(content.Key), prefix); len(name) > 0 && !strings.Contains(name, "/") {
+				files = append(files, name)
+			}
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			if name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(commonPrefix.Prefix), prefix), "/"); len(name) > 0 {
+				dirs = append(dirs, name)
+			}
+		}
+	}
+	return files, dirs, nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, s.wrapErr(err, name)
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Storage) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	uploader := manager.NewUploader(s.client, func(u *manager.Uploader) {
+		u.PartSize = s.partSize
+	})
+	pr, pw := io.Pipe()
+	w := &s3Writer{pw: pw}
+	w.wg.Run(func() {
+		_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key(name)),
+			Body:   pr,
+		})
+		w.err = s.wrapErr(err, name)
+		// Unblock the writer if the upload fails.
+		_ = pr.CloseWithError(w.err)
+	})
+	return w, nil
+}
+
+// s3Writer streams the data to the upload manager of the SDK, which uploads small files with a single PUT and large
+// files with multipart upload. The multipart upload is aborted if it fails.
+type s3Writer struct {
+	pw  *io.PipeWriter
+	wg  waitgroup.WaitGroup
+	err error
+}
+
+func (w *s3Writer) Write(data []byte) (int, error) {
+	n, err := w.pw.Write(data)
+	return n, errors.WithStack(err)
+}
+
+// Close finishes the upload and returns the error of it.
+func (w *s3Writer) Close() error {
+	_ = w.pw.Close()
+	w.wg.Wait()
+	return w.err
+}
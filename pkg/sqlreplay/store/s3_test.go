@@ -0,0 +1,343 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// mockS3 is a minimal S3-compatible server in the path style, which stands in for MinIO in tests.
+type mockS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	// the number of completed and aborted multipart uploads
+	multiparts int
+	aborts     int
+	// failures is the number of the next requests that fail with 503.
+	failures int
+	// failPart fails uploading the part with 400.
+	failPart int
+	authErr  bool
+}
+
+func newMockS3(t *testing.T) (*mockS3, string) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "ak")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "sk")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	m := &mockS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+	return m, srv.URL
+}
+
+func (m *mockS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=ak/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+		m.authErr = true
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>"))
+		return
+	}
+	bucketKey := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	key := ""
+	if len(bucketKey) > 1 {
+		key = bucketKey[1]
+	}
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	if m.failures > 0 {
+		m.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>"))
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+		keys := make([]string, 0, len(m.objects))
+		prefixes := make(map[string]struct{})
+		for k := range m.objects {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			if idx := strings.Index(k[len(prefix):], delimiter); len(delimiter) > 0 && idx >= 0 {
+				prefixes[k[:len(prefix)+idx+1]] = struct{}{}
+			} else {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		var sb strings.Builder
+		sb.WriteString("<ListBucketResult>")
+		for _, k := range keys {
+			fmt.Fprintf(&sb, "<Contents><Key>%s</Key></Contents>", k)
+		}
+		for p := range prefixes {
+			fmt.Fprintf(&sb, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", p)
+		}
+		sb.WriteString("<IsTruncated>false</IsTruncated></ListBucketResult>")
+		_, _ = w.Write([]byte(sb.String()))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		uploadID := strconv.Itoa(len(m.uploads) + 1)
+		m.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		if partNumber == m.failPart {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("<Error><Code>InvalidPart</Code><Message>Invalid part.</Message></Error>"))
+			return
+		}
+		m.uploads[query.Get("uploadId")][partNumber] = body
+		w.Header().Set("ETag", fmt.Sprintf("\"etag%d\"", partNumber))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		parts := m.uploads[query.Get("uploadId")]
+		var data []byte
+		for _, part := range complete.Parts {
+			data = append(data, parts[part.PartNumber]...)
+		}
+		m.objects[key] = data
+		m.multiparts++
+		delete(m.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(m.uploads, query.Get("uploadId"))
+		m.aborts++
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		m.objects[key] = body
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := m.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>"))
+			return
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func s3URI(endpoint, path string) string {
+	return fmt.Sprintf("s3://bucket/%s?endpoint=%s", path, url.QueryEscape(endpoint))
+}
+
+func TestS3Storage(t *testing.T) {
+	m, endpoint := newMockS3(t)
+	storage, err := NewStorage(s3URI(endpoint, "prefix/sub"))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	exists, err := storage.FileExists(ctx, "f1")
+	require.NoError(t, err)
+	require.False(t, exists)
+	_, err = storage.ReadFile(ctx, "f1")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, storage.WriteFile(ctx, "f1", []byte("hello")))
+	exists, err = storage.FileExists(ctx, "f1")
+	require.NoError(t, err)
+	require.True(t, exists)
+	data, err := storage.ReadFile(ctx, "f1")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	// Files in other directories are not listed.
+	require.NoError(t, storage.WriteFile(ctx, "dir/f2", []byte("world")))
+	m.Lock()
+	m.objects["prefix/other"] = []byte("other")
+	m.Unlock()
+	names, err := storage.ListFiles(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"f1"}, names)
+	dirs, err := storage.ListDirs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir"}, dirs)
+	require.False(t, m.authErr)
+
+	// Throttled requests are retried.
+	m.Lock()
+	m.failures = 2
+	m.Unlock()
+	require.NoError(t, storage.WriteFile(ctx, "f3", []byte("retry")))
+	data, err = storage.ReadFile(ctx, "f3")
+	require.NoError(t, err)
+	require.Equal(t, "retry", string(data))
+
+	// The credentials are read from the environment rather than the URI.
+	_, err = NewStorage(fmt.Sprintf("s3://bucket/prefix?endpoint=%s&access-key=ak&secret-access-key=secret", url.QueryEscape(endpoint)))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "secret=")
+	t.Setenv("AWS_ACCESS_KEY_ID", "wrong")
+	storage, err = NewStorage(s3URI(endpoint, "prefix"))
+	require.NoError(t, err)
+	err = storage.WriteFile(ctx, "f1", []byte("hello"))
+	require.ErrorContains(t, err, "AccessDenied")
+}
+
+func TestS3Multipart(t *testing.T) {
+	m, endpoint := newMockS3(t)
+	storage, err := NewStorage(s3URI(endpoint, "prefix"))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Small files are uploaded in one request.
+	writer, err := storage.Create(ctx, "small")
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("12345"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	data, err := storage.ReadFile(ctx, "small")
+	require.NoError(t, err)
+	require.Equal(t, "12345", string(data))
+	require.Equal(t, 0, m.multiparts)
+
+	// Write 3 parts.
+	writeLarge := func(name string) ([]byte, error) {
+		writer, err := storage.Create(ctx, name)
+		require.NoError(t, err)
+		var expected bytes.Buffer
+		for i := 0; i < 11; i++ {
+			line := bytes.Repeat([]byte{byte('a' + i)}, 1024*1024)
+			expected.Write(line)
+			if _, err = writer.Write(line); err != nil {
+				break
+			}
+		}
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+		return expected.Bytes(), err
+	}
+	expected, err := writeLarge("large")
+	require.NoError(t, err)
+	data, err = storage.ReadFile(ctx, "large")
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+	require.Equal(t, 1, m.multiparts)
+
+	// The multipart upload is aborted if a part fails.
+	m.Lock()
+	m.failPart = 2
+	m.Unlock()
+	_, err = writeLarge("failed")
+	require.ErrorContains(t, err, "InvalidPart")
+	exists, err := storage.FileExists(ctx, "failed")
+	require.NoError(t, err)
+	require.False(t, exists)
+	m.Lock()
+	require.Equal(t, 1, m.aborts)
+	require.Empty(t, m.uploads)
+	m.Unlock()
+}
+
+func TestObjectStorageWriteAndRead(t *testing.T) {
+	_, endpoint := newMockS3(t)
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	keyFile := filepath.Join(dir, "key")
+	require.NoError(t, os.WriteFile(keyFile, key, 0600))
+
+	for i, method := range []string{EncryptPlain, EncryptAes} {
+		for j, compress := range []bool{false, true} {
+			uri := s3URI(endpoint, fmt.Sprintf("traffic%d%d", i, j))
+			require.NoError(t, PreCheckMeta(uri))
+			writer, err := NewWriter(WriterCfg{
+				Dir:           uri,
+				EncryptMethod: method,
+				KeyFile:       keyFile,
+				FileSize:      1,
+				Compress:      compress,
+			})
+			require.NoError(t, err)
+			// Write more than 2 files.
+			line := bytes.Repeat([]byte{'a'}, 1023)
+			line = append(line, '\n')
+			for k := 0; k < 2500; k++ {
+				require.NoError(t, writer.Write(append([]byte(nil), line...)))
+			}
+			require.NoError(t, writer.Close())
+			meta := NewMeta(0, 2500, 0, method)
+			require.NoError(t, meta.Write(uri))
+			require.Error(t, PreCheckMeta(uri))
+
+			storage, err := NewStorage(uri)
+			require.NoError(t, err)
+			names, err := storage.ListFiles(context.Background())
+			require.NoError(t, err)
+			require.Len(t, names, 4, "method: %s, compress: %v", method, compress)
+
+			m := Meta{}
+			require.NoError(t, m.Read(uri))
+			require.Equal(t, *meta, m)
+			reader, err := NewReader(zap.NewNop(), ReaderCfg{Dir: uri, EncryptMethod: method, KeyFile: keyFile})
+			require.NoError(t, err)
+			data := make([]byte, len(line))
+			for k := 0; k < 2500; k++ {
+				_, _, err = reader.Read(data)
+				require.NoError(t, err)
+				require.Equal(t, line, data)
+			}
+			_, _, err = reader.Read(data)
+			require.True(t, errors.Is(err, io.EOF))
+			reader.Close()
+		}
+	}
+}
+
+func TestStorageURI(t *testing.T) {
+	require.True(t, IsLocal("/tmp/traffic"))
+	require.False(t, IsLocal("s3://bucket/prefix"))
+	require.Equal(t, "/tmp/traffic/127.0.0.1:6000", JoinURI("/tmp/traffic", "127.0.0.1:6000"))
+	require.Equal(t, "s3://bucket/prefix/127.0.0.1:6000?region=us-west-2", JoinURI("s3://bucket/prefix?region=us-west-2", "127.0.0.1:6000"))
+	require.Equal(t, "s3://bucket/127.0.0.1:6000", JoinURI("s3://bucket", "127.0.0.1:6000"))
+	redacted := RedactURI("s3://bucket/prefix?access-key=ak&secret-access-key=sk&region=us-west-2")
+	require.NotContains(t, redacted, "ak&")
+	require.NotContains(t, redacted, "=sk")
+	require.Contains(t, redacted, "region=us-west-2")
+
+	_, err := NewStorage("gcs://bucket/prefix")
+	require.Error(t, err)
+	_, err = NewStorage("s3:///prefix")
+	require.Error(t, err)
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 PingCAP, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pingcap/tiproxy/lib/util/errors"
+)
+
+const (
+	schemeS3 = "s3"
+)
+
+// ExternalStorage is where the traffic files are stored, either a local directory or an object storage.
+// All the file names are relative to the root of the storage and the storage is flat.
+type ExternalStorage interface {
+	WriteFile(ctx context.Context, name string, data []byte) error
+	ReadFile(ctx context.Context, name string) ([]byte, error)
+	FileExists(ctx context.Context, name string) (bool, error)
+	// ListFiles returns the names of the files directly under the root.
+	ListFiles(ctx context.Context) ([]string, error)
+	// ListDirs returns the names of the sub-directories directly under the root.
+	ListDirs(ctx context.Context) ([]string, error)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Create returns a writer of the file. The file may be invisible until the writer is closed.
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	// URI returns the path of the file for logging. The credentials are redacted.
+	URI(name string) string
+}
+
+// IsLocal returns true if the path is a local directory rather than an URI like `s3://bucket/prefix`.
+func IsLocal(uri string) bool {
+	return !strings.Contains(uri, "://")
+}
+
+// NewStorage creates the storage from a local directory or an URI like
+// `s3://bucket/prefix?endpoint=http://127.0.0.1:9000&region=us-east-1`. The S3 credentials are loaded from the
+// environment, the shared profile or the instance role rather than the URI.
+func NewStorage(uri string) (ExternalStorage, error) {
+	if IsLocal(uri) {
+		return newLocalStorage(uri), nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case schemeS3:
+		return newS3Storage(u)
+	default:
+		return nil, errors.Errorf("unsupported storage scheme: %s", u.Scheme)
+	}
+}
+
+// JoinURI appends the sub-directory to the path of the storage URI. The query is kept.
+func JoinURI(uri, dir string) string {
+	if IsLocal(uri) {
+		return filepath.Join(uri, dir)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	u.Path = path.Join("/", u.Path, dir)
+	return u.String()
+}
+
+// RedactURI removes the credentials from the storage URI so that it can be shown to users.
+func RedactURI(uri string) string {
+	if IsLocal(uri) {
+		return uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := u.Query()
+	for _, key := range []string{s3AccessKey, s3SecretAccessKey, s3SessionToken} {
+		if query.Has(key) {
+			query.Set(key, "xxxxx")
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+var _ ExternalStorage = (*localStorage)(nil)
+
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) *localStorage {
+	return &localStorage{dir: dir}
+}
+
+func (s *localStorage) WriteFile(_ context.Context, name string, data []byte) error {
+	return errors.WithStack(os.WriteFile(filepath.Join(s.dir, name), data, 0600))
+}
+
+func (s *localStorage) ReadFile(_ context.Context, name string) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(s.dir, name))
+	return b, errors.WithStack(err)
+}
+
+func (s *localStorage) FileExists(_ context.Context, name string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, errors.WithStack(err)
+}
+
+func (s *localStorage) ListFiles(_ context.Context) ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *localStorage) ListDirs(_ context.Context) ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *localStorage) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+func (s *localStorage) Create(_ context.Context, name string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+func (s *localStorage) URI(name string) string {
+	return filepath.Join(s.dir, name)
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -32,6 +34,15 @@ func NewHTTPClient(getTLSConfig func() *tls.Config) *Client {
 }
 
 func (client *Client) Get(addr, path string, b backoff.BackOff, timeout time.Duration) ([]byte, error) {
+	return client.do(http.MethodGet, addr, path, nil, b, timeout)
+}
+
+// PostForm posts the form to the address and returns the response body.
+func (client *Client) PostForm(addr, path string, form url.Values, b backoff.BackOff, timeout time.Duration) ([]byte, error) {
+	return client.do(http.MethodPost, addr, path, form, b, timeout)
+}
+
+func (client *Client) do(method, addr, path string, form url.Values, b backoff.BackOff, timeout time.Duration) ([]byte, error) {
 	// http cli is shared in the server, copy cli is to avoid concurrently setting http request timeout
 	cli := *client.cli
 	cli.Timeout = timeout
@@ -46,14 +57,21 @@ func (client *Client) Get(addr, path string, b backoff.BackOff, timeout time.Dur
 	url := fmt.Sprintf("%s://%s%s", schema, addr, path)
 	var body []byte
 	err := ConnectWithRetry(func() error {
-		resp, err := cli.Get(url)
+		var resp *http.Response
+		var err error
+		if method == http.MethodPost {
+			resp, err = cli.PostForm(url, form)
+		} else {
+			resp, err = cli.Get(url)
+		}
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return backoff.Permanent(errors.Errorf("http status %d", resp.StatusCode))
+			msg, _ := io.ReadAll(resp.Body)
+			return backoff.Permanent(errors.Errorf("http status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg))))
 		}
 		body, err = io.ReadAll(resp.Body)
 		if err != nil {
@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/tls"
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
@@ -17,6 +18,34 @@ import (
 	"go.uber.org/atomic"
 )
 
+func TestHTTPPostForm(t *testing.T) {
+	httpHandler := &mockHttpHandler{
+		t: t,
+	}
+	httpHandler.setHTTPResp(true)
+	httpHandler.setHTTPRespBody("hello")
+	statusListener, statusAddr := testkit.StartListener(t, "")
+	statusServer := &http.Server{Addr: statusAddr, Handler: httpHandler}
+	var wg waitgroup.WaitGroup
+	wg.Run(func() {
+		_ = statusServer.Serve(statusListener)
+	})
+	httpCli := NewHTTPClient(func() *tls.Config { return nil })
+	b := backoff.WithContext(backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), uint64(2)), context.Background())
+
+	resp, err := httpCli.PostForm(statusAddr, "", url.Values{"key": {"value"}}, b, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(resp))
+	require.Equal(t, "value", httpHandler.form.Load())
+
+	httpHandler.setHTTPResp(false)
+	_, err = httpCli.PostForm(statusAddr, "", nil, b, time.Second)
+	require.ErrorContains(t, err, "failed")
+
+	require.NoError(t, statusServer.Close())
+	wg.Wait()
+}
+
 func TestHTTPGet(t *testing.T) {
 	httpHandler := &mockHttpHandler{
 		t: t,
@@ -57,6 +86,7 @@ type mockHttpHandler struct {
 	httpOK   atomic.Bool
 	respBody atomic.String
 	wait     atomic.Int64
+	form     atomic.String
 }
 
 func (handler *mockHttpHandler) setHTTPResp(succeed bool) {
@@ -76,10 +106,14 @@ func (handler *mockHttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request
 	if wait > 0 {
 		time.Sleep(time.Duration(wait))
 	}
+	if r.Method == http.MethodPost {
+		handler.form.Store(r.PostFormValue("key"))
+	}
 	if handler.httpOK.Load() {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(handler.respBody.Load()))
 	} else {
 		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("failed"))
 	}
 }
@@ -3,6 +3,11 @@
 
 package lex
 
+import (
+	"slices"
+	"strings"
+)
+
 var sensitiveKeywords = [][]string{
 	// contain passwords
 	{
@@ -33,6 +38,25 @@ var sensitiveKeywords = [][]string{
 	},
 }
 
+// sessionStatesKeywords are the statements that change session variables, prepared statements or the current DB.
+var sessionStatesKeywords = [][]string{
+	{
+		"SET",
+	},
+	{
+		"USE",
+	},
+	{
+		"PREPARE",
+	},
+	{
+		"DEALLOCATE",
+	},
+	{
+		"DROP", "PREPARE",
+	},
+}
+
 // ignore prepared statements in text-protocol
 // ignore EXPLAIN (including EXPLAIN ANALYZE) and TRACE
 // include SELECT FOR UPDATE because it releases locks immediately in auto-commit transactions
@@ -41,13 +65,50 @@ var readOnlyKeywords = []string{
 	"SELECT", "SHOW", "WITH", "SET", "USE", "DESC", "DESCRIBE", "TABLE", "DO",
 }
 
+// sideEffectKeywords make a read statement unsafe to execute twice: INTO writes variables or files, and the functions
+// change sequences, take locks or wait.
+var sideEffectKeywords = map[string]struct{}{
+	"INTO": {}, "NEXTVAL": {}, "LASTVAL": {}, "SETVAL": {}, "NEXT": {}, "SLEEP": {}, "BENCHMARK": {},
+	"GET_LOCK": {}, "RELEASE_LOCK": {}, "RELEASE_ALL_LOCKS": {}, "LAST_INSERT_ID": {},
+}
+
+// nonDeterministicKeywords are the functions that may return different results each time.
+var nonDeterministicKeywords = map[string]struct{}{
+	"NOW": {}, "SYSDATE": {}, "CURDATE": {}, "CURTIME": {}, "CURRENT_DATE": {}, "CURRENT_TIME": {},
+	"CURRENT_TIMESTAMP": {}, "LOCALTIME": {}, "LOCALTIMESTAMP": {}, "UNIX_TIMESTAMP": {}, "UTC_DATE": {},
+	"UTC_TIME": {}, "UTC_TIMESTAMP": {}, "RAND": {}, "RANDOM_BYTES": {}, "UUID": {}, "UUID_SHORT": {},
+	"CONNECTION_ID": {}, "ROW_COUNT": {}, "FOUND_ROWS": {}, "TIDB_CURRENT_TSO": {},
+}
+
+// resultVarKeywords map the keywords in SET statements to the session variables that change the result sets.
+var resultVarKeywords = map[string][]string{
+	"TIME_ZONE":                {"TIME_ZONE"},
+	"SQL_MODE":                 {"SQL_MODE"},
+	"CHARACTER_SET_RESULTS":    {"CHARACTER_SET_RESULTS"},
+	"CHARACTER_SET_CONNECTION": {"COLLATION_CONNECTION"},
+	"COLLATION_CONNECTION":     {"COLLATION_CONNECTION"},
+	"NAMES":                    {"CHARACTER_SET_RESULTS", "COLLATION_CONNECTION"},
+	"CHARSET":                  {"CHARACTER_SET_RESULTS", "COLLATION_CONNECTION"},
+	"CHARACTER":                {"CHARACTER_SET_RESULTS", "COLLATION_CONNECTION"},
+}
+
 func IsSensitiveSQL(sql string) bool {
+	return matchKeywords(sql, sensitiveKeywords)
+}
+
+// ChangesSessionStates returns true if the statement may change the session states, such as session variables,
+// prepared statements or the current DB.
+func ChangesSessionStates(sql string) bool {
+	return matchKeywords(sql, sessionStatesKeywords)
+}
+
+func matchKeywords(sql string, keywords [][]string) bool {
 	lexer := NewLexer(sql)
 	keyword := lexer.NextToken()
 	if len(keyword) == 0 {
 		return false
 	}
-	for _, kw := range sensitiveKeywords {
+	for _, kw := range keywords {
 		if keyword != kw[0] {
 			continue
 		}
@@ -75,3 +136,177 @@ func IsReadOnly(sql string) bool {
 	}
 	return false
 }
+
+// IsIdempotentRead returns true if the statement is a plain SELECT or SHOW that can be executed again without side
+// effects. Statements with INTO, locking reads, multiple statements, executable comments and side-effect functions
+// are excluded.
+func IsIdempotentRead(sql string) bool {
+	return isPlainRead(sql, sideEffectKeywords)
+}
+
+// IsDeterministicRead returns true if the statement is an idempotent read that calls no non-deterministic functions,
+// so its result can be reused.
+func IsDeterministicRead(sql string) bool {
+	return isPlainRead(sql, sideEffectKeywords, nonDeterministicKeywords)
+}
+
+func isPlainRead(sql string, excluded ...map[string]struct{}) bool {
+	lexer := NewLexer(sql)
+	switch lexer.NextToken() {
+	case "SELECT", "SHOW":
+	default:
+		return false
+	}
+	prev := ""
+	for token := lexer.NextToken(); token != ""; token = lexer.NextToken() {
+		for _, keywords := range excluded {
+			if _, ok := keywords[token]; ok {
+				return false
+			}
+		}
+		// FOR UPDATE, FOR SHARE and LOCK IN SHARE MODE
+		if (prev == "FOR" && (token == "UPDATE" || token == "SHARE")) || (prev == "LOCK" && token == "IN") {
+			return false
+		}
+		prev = token
+	}
+	// The contents of executable comments may write data, lock rows or wait, but they are not visible to the lexer.
+	return !HasMultiStatements(sql) && !HasExecutableComment(sql)
+}
+
+// IsLoadDataLocal returns true if the statement is LOAD DATA LOCAL INFILE, which uploads a file from the client.
+func IsLoadDataLocal(sql string) bool {
+	lexer := NewLexer(sql)
+	if lexer.NextToken() != "LOAD" || lexer.NextToken() != "DATA" {
+		return false
+	}
+	// LOAD DATA [LOW_PRIORITY | CONCURRENT] LOCAL INFILE
+	for i := 0; i < 2; i++ {
+		if lexer.NextToken() == "LOCAL" {
+			return true
+		}
+	}
+	return false
+}
+
+// UseDB returns the DB name of a USE statement. It returns false if it's not a USE statement.
+func UseDB(sql string) (string, bool) {
+	lexer := NewLexer(sql)
+	if lexer.NextToken() != "USE" || lexer.curIdx > len(sql)-1 {
+		return "", false
+	}
+	// The lexer skips the character after the keyword, which may be a backquote.
+	db := strings.TrimSpace(sql[lexer.curIdx-1:])
+	if strings.HasPrefix(db, "`") {
+		var sb strings.Builder
+		for i := 1; i < len(db); i++ {
+			if db[i] == '`' {
+				// Two backquotes are an escaped backquote.
+				if i+1 < len(db) && db[i+1] == '`' {
+					sb.WriteByte('`')
+					i++
+					continue
+				}
+				return sb.String(), true
+			}
+			sb.WriteByte(db[i])
+		}
+		return "", false
+	}
+	if idx := strings.IndexAny(db, " \t\r\n;"); idx >= 0 {
+		db = db[:idx]
+	}
+	return db, len(db) > 0
+}
+
+// ResultVars returns the session variables that change the result sets and are set by the SET statement.
+// fixed is false if the values refer to user variables, whose values are unknown.
+func ResultVars(sql string) (vars []string, fixed bool) {
+	lexer := NewLexer(sql)
+	if lexer.NextToken() != "SET" {
+		return nil, true
+	}
+	for token := lexer.NextToken(); token != ""; token = lexer.NextToken() {
+		for _, v := range resultVarKeywords[token] {
+			if !slices.Contains(vars, v) {
+				vars = append(vars, v)
+			}
+		}
+	}
+	return vars, len(vars) == 0 || !strings.Contains(strings.ReplaceAll(sql, "@@", ""), "@")
+}
+
+// NormalizeText removes the comments and the redundant spaces out of quotes so that the statements that only differ in
+// them are the same. Unlike the digest, the literals and the case of identifiers are kept because they decide the
+// result. The executable comments and hints are kept.
+func NormalizeText(sql string) string {
+	var sb strings.Builder
+	sb.Grow(len(sql))
+	var quote byte
+	space := false
+	for i := 0; i < len(sql); i++ {
+		char := sql[i]
+		switch {
+		case quote != 0:
+			sb.WriteByte(char)
+			if char == '\\' && quote != '`' && i+1 < len(sql) {
+				i++
+				sb.WriteByte(sql[i])
+			} else if char == quote {
+				quote = 0
+			}
+			continue
+		case char == '-' && i+2 < len(sql) && sql[i+1] == '-' && isSpace(sql[i+2]), char == '#':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case char == '/' && i+2 < len(sql) && sql[i+1] == '*' && sql[i+2] != '!' && sql[i+2] != '+' &&
+			!strings.HasPrefix(sql[i+2:], "T!"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 3
+			}
+			space = true
+			continue
+		case isSpace(char):
+			space = true
+			continue
+		case char == '\'', char == '"', char == '`':
+			quote = char
+		}
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteByte(char)
+	}
+	return strings.TrimRight(sb.String(), "; ")
+}
+
+func isSpace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\r' || char == '\n'
+}
+
+// HasOrderBy returns true if the statement contains ORDER BY, so the row order of its result is defined.
+func HasOrderBy(sql string) bool {
+	lexer := NewLexer(sql)
+	prev := ""
+	for token := lexer.NextToken(); token != ""; token = lexer.NextToken() {
+		if prev == "ORDER" && token == "BY" {
+			return true
+		}
+		prev = token
+	}
+	return false
+}
+
+// IsShowTiProxyProcessList returns true if the statement is SHOW TIPROXY PROCESSLIST, which is served by TiProxy.
+func IsShowTiProxyProcessList(sql string) bool {
+	lexer := NewLexer(sql)
+	return lexer.NextToken() == "SHOW" && lexer.NextToken() == "TIPROXY" && lexer.NextToken() == "PROCESSLIST" &&
+		lexer.NextToken() == ""
+}
@@ -60,3 +60,178 @@ func TestReadOnlySQL(t *testing.T) {
 		require.Equal(t, test.readOnly, IsReadOnly(test.sql), "case %d", i)
 	}
 }
+
+func TestSessionStatesSQL(t *testing.T) {
+	tests := []struct {
+		sql     string
+		changed bool
+	}{
+		{`SELECT * FROM table_name`, false},
+		{`set @@session.sql_mode = ''`, true},
+		{`/* hint */ SET NAMES utf8mb4`, true},
+		{`use db`, true},
+		{`PREPARE stmt FROM 'select 1'`, true},
+		{`deallocate prepare stmt`, true},
+		{`drop prepare stmt`, true},
+		{`drop table t`, false},
+		{`insert into t values (1)`, false},
+		{``, false},
+	}
+
+	for i, test := range tests {
+		require.Equal(t, test.changed, ChangesSessionStates(test.sql), "case %d", i)
+	}
+}
+
+func TestLoadDataLocalSQL(t *testing.T) {
+	tests := []struct {
+		sql   string
+		local bool
+	}{
+		{`LOAD DATA LOCAL INFILE '/tmp/t.csv' INTO TABLE t`, true},
+		{`/* hint */ load data low_priority local infile 'f' into table t`, true},
+		{`LOAD DATA INFILE 's3://bucket/t.csv' INTO TABLE t`, false},
+		{`SELECT 'LOAD DATA LOCAL'`, false},
+		{`load`, false},
+	}
+
+	for i, test := range tests {
+		require.Equal(t, test.local, IsLoadDataLocal(test.sql), "case %d", i)
+	}
+}
+
+func TestUseDB(t *testing.T) {
+	tests := []struct {
+		sql string
+		db  string
+		ok  bool
+	}{
+		{`USE test`, "test", true},
+		{`/* comment */ use db1;`, "db1", true},
+		{"use `my db`", "my db", true},
+		{"USE`a``b`", "a`b", true},
+		{"use `db", "", false},
+		{`use`, "", false},
+		{`select 'use db'`, "", false},
+	}
+
+	for i, test := range tests {
+		db, ok := UseDB(test.sql)
+		require.Equal(t, test.ok, ok, "case %d", i)
+		require.Equal(t, test.db, db, "case %d", i)
+	}
+}
+
+func TestIsShowTiProxyProcessList(t *testing.T) {
+	tests := []struct {
+		sql string
+		ok  bool
+	}{
+		{`SHOW TIPROXY PROCESSLIST`, true},
+		{`/* comment */ show tiproxy processlist;`, true},
+		{"show\ttiproxy\nprocesslist", true},
+		{`show processlist`, false},
+		{`show tiproxy`, false},
+		{`show tiproxy processlist where id = 1`, false},
+		{`select 'show tiproxy processlist'`, false},
+	}
+
+	for i, test := range tests {
+		require.Equal(t, test.ok, IsShowTiProxyProcessList(test.sql), "case %d", i)
+	}
+}
+
+func TestIdempotentRead(t *testing.T) {
+	tests := []struct {
+		sql           string
+		idempotent    bool
+		deterministic bool
+	}{
+		{`SELECT * FROM t`, true, true},
+		{`/* comment */ select a from t where b = 'into';`, true, true},
+		{`(select 1) union (select 2)`, true, true},
+		{`show tables`, true, true},
+		{`select now()`, true, false},
+		{`SELECT RAND() FROM t`, true, false},
+		{`select * from t for update`, false, false},
+		{`select * from t for share`, false, false},
+		{`select * from t lock in share mode`, false, false},
+		{`select a into @a from t`, false, false},
+		{`select * from t into outfile '/tmp/t'`, false, false},
+		{`select nextval(seq)`, false, false},
+		{`select next value for seq`, false, false},
+		{`select sleep(1)`, false, false},
+		{`select get_lock('a', 1)`, false, false},
+		{`select 1; delete from t`, false, false},
+		{`set @a = 1`, false, false},
+		{`do sleep(1)`, false, false},
+		{`with cte as (select 1) select * from cte`, false, false},
+		{`insert into t values (1)`, false, false},
+		{`/*!50000 DELETE FROM t */ SELECT 1`, false, false},
+		{`SELECT 1 /*!50000 INTO @a */`, false, false},
+		{`SELECT 1 /*!50000 FOR UPDATE */`, false, false},
+	}
+
+	for i, test := range tests {
+		require.Equal(t, test.idempotent, IsIdempotentRead(test.sql), "case %d", i)
+		require.Equal(t, test.deterministic, IsDeterministicRead(test.sql), "case %d", i)
+	}
+}
+
+func TestResultVars(t *testing.T) {
+	tests := []struct {
+		sql   string
+		vars  []string
+		fixed bool
+	}{
+		{`select @@time_zone`, nil, true},
+		{`set @a = 1`, nil, true},
+		{`set autocommit = 0`, nil, true},
+		{`SET time_zone = '+08:00'`, []string{"TIME_ZONE"}, true},
+		{`set @@session.sql_mode = '', @@time_zone = 'UTC'`, []string{"SQL_MODE", "TIME_ZONE"}, true},
+		{`set names utf8mb4 collate utf8mb4_bin`, []string{"CHARACTER_SET_RESULTS", "COLLATION_CONNECTION"}, true},
+		{`set character set gbk`, []string{"CHARACTER_SET_RESULTS", "COLLATION_CONNECTION"}, true},
+		{`set time_zone = @tz`, []string{"TIME_ZONE"}, false},
+	}
+	for i, test := range tests {
+		vars, fixed := ResultVars(test.sql)
+		require.Equal(t, test.vars, vars, "case %d", i)
+		require.Equal(t, test.fixed, fixed, "case %d", i)
+	}
+}
+
+func TestHasOrderBy(t *testing.T) {
+	tests := []struct {
+		sql     string
+		ordered bool
+	}{
+		{`select * from t`, false},
+		{`select * from t order by a`, true},
+		{`SELECT * FROM t ORDER  BY a DESC LIMIT 10`, true},
+		{`select * from t where a = 'order by'`, false},
+		{`select * from t /* order by a */`, false},
+		{`select * from orders`, false},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.ordered, HasOrderBy(test.sql), "case %d", i)
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		sql        string
+		normalized string
+	}{
+		{"select 1", "select 1"},
+		{"  select\t1 ;\n", "select 1"},
+		{"select /* comment */ a  from t -- comment\nwhere b = 1", "select a from t where b = 1"},
+		{"select a from t # comment", "select a from t"},
+		{"select 'a  /* b */  c', \"d\\\"  e\", `f  g` from t", "select 'a  /* b */  c', \"d\\\"  e\", `f  g` from t"},
+		{"select /*+ use_index(t, a) */ A from T", "select /*+ use_index(t, a) */ A from T"},
+		{"select /*T![clustered_index] 1 */ 2", "select /*T![clustered_index] 1 */ 2"},
+		{"select 1--1", "select 1--1"},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.normalized, NormalizeText(test.sql), "case %d", i)
+	}
+}
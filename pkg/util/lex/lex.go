@@ -3,10 +3,14 @@
 
 package lex
 
+import "strings"
+
 type Lexer struct {
 	sql      string
 	curToken []byte
 	curIdx   int
+	// inExecutableComment is true when the lexer is reading the contents of a /*! */ or /*T! */ comment.
+	inExecutableComment bool
 }
 
 func NewLexer(sql string) *Lexer {
@@ -18,6 +22,7 @@ func NewLexer(sql string) *Lexer {
 
 // It only returns uppercased identifiers and keywords. It's used to search for some specified keywords.
 // It doesn't need to strict but it needs to be fast enough.
+// The contents of executable comments are returned as tokens because MySQL and TiDB execute them.
 func (l *Lexer) NextToken() string {
 	l.curToken = l.curToken[:0]
 	inSingleLineComment, inMultiLineComment, inSingleQuote, inDoubleQuote := false, false, false, false
@@ -51,8 +56,30 @@ func (l *Lexer) NextToken() string {
 			l.curIdx++
 			inSingleLineComment = true
 		case char == '/' && l.curIdx+1 < len(l.sql) && l.sql[l.curIdx+1] == '*':
+			if rest := l.sql[l.curIdx+2:]; strings.HasPrefix(rest, "!") {
+				// The version number after '!' is not a token.
+				l.curIdx += 2
+				l.inExecutableComment = true
+			} else if strings.HasPrefix(rest, "T!") {
+				// Skip the feature ID, such as /*T![clustered_index] */.
+				l.curIdx += 3
+				if l.curIdx+1 < len(l.sql) && l.sql[l.curIdx+1] == '[' {
+					if end := strings.IndexByte(l.sql[l.curIdx+1:], ']'); end >= 0 {
+						l.curIdx += end + 1
+					}
+				}
+				l.inExecutableComment = true
+			} else {
+				l.curIdx++
+				inMultiLineComment = true
+			}
+		case l.inExecutableComment && char == '*' && l.curIdx+1 < len(l.sql) && l.sql[l.curIdx+1] == '/':
+			// The end of the comment also ends the token, and it's skipped in the next call.
+			if len(l.curToken) > 0 {
+				return string(l.curToken)
+			}
 			l.curIdx++
-			inMultiLineComment = true
+			l.inExecutableComment = false
 		case char == '\'':
 			inSingleQuote = true
 		case char == '"':
@@ -74,3 +101,119 @@ func (l *Lexer) NextToken() string {
 	}
 	return ""
 }
+
+// CountParamMarkers returns the number of parameter markers '?' in a prepared statement, which is the number of
+// parameters of the statement. The markers in comments, strings and quoted identifiers are skipped.
+func CountParamMarkers(sql string) int {
+	count := 0
+	inSingleLineComment, inMultiLineComment := false, false
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		char := sql[i]
+		switch {
+		case inSingleLineComment:
+			if char == '\n' {
+				inSingleLineComment = false
+			}
+		case inMultiLineComment:
+			if char == '*' && i+1 < len(sql) && sql[i+1] == '/' {
+				inMultiLineComment = false
+				i++
+			}
+		case quote != 0:
+			if char == '\\' && quote != '`' {
+				i++
+			} else if char == quote {
+				quote = 0
+			}
+		case char == '-' && i+1 < len(sql) && sql[i+1] == '-', char == '#':
+			inSingleLineComment = true
+		case char == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			i++
+			inMultiLineComment = true
+		case char == '\'', char == '"', char == '`':
+			quote = char
+		case char == '?':
+			count++
+		}
+	}
+	return count
+}
+
+// HasMultiStatements returns true if the SQL contains more than one statement. The semicolons in comments, strings
+// and quoted identifiers and the trailing ones are skipped.
+func HasMultiStatements(sql string) bool {
+	inSingleLineComment, inMultiLineComment, ended := false, false, false
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		char := sql[i]
+		switch {
+		case inSingleLineComment:
+			if char == '\n' {
+				inSingleLineComment = false
+			}
+		case inMultiLineComment:
+			if char == '*' && i+1 < len(sql) && sql[i+1] == '/' {
+				inMultiLineComment = false
+				i++
+			}
+		case quote != 0:
+			if char == '\\' && quote != '`' {
+				i++
+			} else if char == quote {
+				quote = 0
+			}
+		case char == '-' && i+1 < len(sql) && sql[i+1] == '-', char == '#':
+			inSingleLineComment = true
+		case char == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			i++
+			inMultiLineComment = true
+		case char == ';':
+			ended = true
+		case char == ' ' || char == '\t' || char == '\r' || char == '\n':
+		case ended:
+			return true
+		case char == '\'', char == '"', char == '`':
+			quote = char
+		}
+	}
+	return false
+}
+
+// HasExecutableComment returns true if the SQL contains `/*! */` or `/*T! */` comments, whose contents are executed
+// by TiDB but skipped by the lexer. The comments in strings and quoted identifiers are skipped.
+func HasExecutableComment(sql string) bool {
+	inSingleLineComment, inMultiLineComment := false, false
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		char := sql[i]
+		switch {
+		case inSingleLineComment:
+			if char == '\n' {
+				inSingleLineComment = false
+			}
+		case inMultiLineComment:
+			if char == '*' && i+1 < len(sql) && sql[i+1] == '/' {
+				inMultiLineComment = false
+				i++
+			}
+		case quote != 0:
+			if char == '\\' && quote != '`' {
+				i++
+			} else if char == quote {
+				quote = 0
+			}
+		case char == '-' && i+1 < len(sql) && sql[i+1] == '-', char == '#':
+			inSingleLineComment = true
+		case char == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			if rest := sql[i+2:]; strings.HasPrefix(rest, "!") || strings.HasPrefix(rest, "T!") {
+				return true
+			}
+			i++
+			inMultiLineComment = true
+		case char == '\'', char == '"', char == '`':
+			quote = char
+		}
+	}
+	return false
+}
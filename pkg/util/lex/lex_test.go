@@ -42,6 +42,14 @@ func TestNextToken(t *testing.T) {
 			sql:    `sEleCt ** from; t5ble_name`,
 			tokens: []string{"SELECT", "FROM", "T", "BLE_NAME"},
 		},
+		{
+			sql:    `/*!50000 DROP */ TABLE /* comment */ t`,
+			tokens: []string{"DROP", "TABLE", "T"},
+		},
+		{
+			sql:    `/*T![clustered_index] DROP*/TABLE t /*!*/`,
+			tokens: []string{"DROP", "TABLE", "T"},
+		},
 	}
 
 	for i, test := range tests {
@@ -57,3 +65,69 @@ func TestNextToken(t *testing.T) {
 		require.Equal(t, test.tokens, tokens, "case %d", i)
 	}
 }
+
+func TestCountParamMarkers(t *testing.T) {
+	tests := []struct {
+		sql   string
+		count int
+	}{
+		{
+			sql:   `SELECT * FROM t`,
+			count: 0,
+		},
+		{
+			sql:   `SELECT * FROM t WHERE a = ? AND b IN (?, ?)`,
+			count: 3,
+		},
+		{
+			sql:   `INSERT INTO t VALUES(?,?)`,
+			count: 2,
+		},
+		{
+			sql: `SELECT /* ? */ '?', "?", '\'?', ` + "`?`" + `, ? -- ?
+			FROM t # ?
+			WHERE a = ?`,
+			count: 2,
+		},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.count, CountParamMarkers(test.sql), "case %d", i)
+	}
+}
+
+func TestHasMultiStatements(t *testing.T) {
+	tests := []struct {
+		sql   string
+		multi bool
+	}{
+		{`select 1`, false},
+		{`select 1;`, false},
+		{"select 1; \n -- comment", false},
+		{`select ';' from t`, false},
+		{"select `a;b` from t", false},
+		{`select 1 /* ; */`, false},
+		{`select 1; select 2`, true},
+		{`select 1;'a'`, true},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.multi, HasMultiStatements(test.sql), "case %d", i)
+	}
+}
+
+func TestHasExecutableComment(t *testing.T) {
+	tests := []struct {
+		sql        string
+		executable bool
+	}{
+		{`select 1`, false},
+		{`select 1 /* comment */`, false},
+		{`select /*+ use_index(t, a) */ * from t`, false},
+		{`select '/*!50000 delete from t */'`, false},
+		{"select 1 -- /*! for update */", false},
+		{`/*!50000 DELETE FROM t */ SELECT 1`, true},
+		{`select 1 /*T![clustered_index] for update */`, true},
+	}
+	for i, test := range tests {
+		require.Equal(t, test.executable, HasExecutableComment(test.sql), "case %d", i)
+	}
+}